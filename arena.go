@@ -0,0 +1,90 @@
+package nickel
+
+/*
+#include <nickel_lang.h>
+*/
+import "C"
+
+import "sync"
+
+// SetExplicitClose turns explicit memory management on or off, for the
+// results of the evaluations started from now on.
+//
+// Normally, every Expr has a finalizer that frees its native memory once
+// it's no longer used. For programs that look at millions of values, like
+// traversals of very large outputs, the finalizers are a significant cost.
+// With explicit close, the Exprs don't have finalizers: each evaluation
+// result owns the Exprs taken from it (its fields, elements, and their own
+// parts, evaluated or not), and they're all freed together by Close.
+//
+// A result that isn't closed is never freed. Results of the transformations
+// (like Expr.SetPath) and of Expr.EvalDeep are new results, to close
+// separately. Concurrent evaluations of the same program aren't coalesced
+// into one while explicit close is on (see SetCoalescing), so that every
+// caller gets its own result to close, except for EvalFileCached, whose
+// results are shared and must not be closed.
+func (ctx *Context) SetExplicitClose(enabled bool) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.explicitClose = enabled
+}
+
+func (ctx *Context) explicitCloseEnabled() bool {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.explicitClose
+}
+
+// exprArena holds the native expressions of an evaluation result, in
+// explicit close mode.
+type exprArena struct {
+	mu     sync.Mutex
+	ptrs   []*C.nickel_expr
+	closed bool
+}
+
+func (a *exprArena) alloc() *C.nickel_expr {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		panic("nickel: use of an Expr after Close")
+	}
+	ptr := allocExpr()
+	a.ptrs = append(a.ptrs, ptr)
+	return ptr
+}
+
+// adopt adds a native expression allocated elsewhere to the arena.
+func (a *exprArena) adopt(ptr *C.nickel_expr) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		C.nickel_expr_free(ptr)
+		panic("nickel: use of an Expr after Close")
+	}
+	a.ptrs = append(a.ptrs, ptr)
+	liveExprs.Add(1)
+}
+
+// Close frees the evaluation result that expr belongs to, with every Expr
+// taken from it, if expr comes from a context with explicit close turned on
+// (see Context.SetExplicitClose). Otherwise, it does nothing.
+//
+// None of the Exprs of the result can be used after Close, whichever one it
+// was called on. Closing a result more than once does nothing.
+func (expr *Expr) Close() {
+	a := expr.arena
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return
+	}
+	for _, ptr := range a.ptrs {
+		freeExpr(ptr)
+	}
+	a.ptrs = nil
+	a.closed = true
+}
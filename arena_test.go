@@ -0,0 +1,96 @@
+package nickel
+
+import "testing"
+
+func TestExplicitClose(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetExplicitClose(true)
+	expr, err := ctx.EvalShallow(`{ list = [1, { a = "x" }], lazy = 1 + 1 }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if expr.arena == nil {
+		t.Fatal("expected the result to own its parts")
+	}
+
+	record, _ := expr.ToRecord()
+	lazy, err := record["lazy"].EvalShallow()
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	list, err := record["list"].EvalShallow()
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	elem, err := list.Index(1)
+	if err != nil {
+		t.Fatalf("index error: %v", err)
+	}
+	for _, part := range []*Expr{record["list"], lazy, list, elem} {
+		if part.arena != expr.arena {
+			t.Fatalf("%#v doesn't belong to the result", part)
+		}
+	}
+	if got := lazy.String(); got != "2" {
+		t.Fatalf("unexpected value %s", got)
+	}
+
+	// Deep evaluation makes a new result.
+	deep, err := expr.EvalDeep()
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if deep.arena == nil || deep.arena == expr.arena {
+		t.Fatal("expected a separate result")
+	}
+
+	elem.Close()
+	if !expr.arena.closed || len(expr.arena.ptrs) != 0 {
+		t.Fatal("expected the whole result to be freed")
+	}
+	expr.Close()
+	if got := deep.String(); got != `{ lazy = 2, list = [1, { a = "x" }] }` {
+		t.Fatalf("unexpected value %s", got)
+	}
+	deep.Close()
+
+	ctx.SetExplicitClose(false)
+	expr, err = ctx.EvalDeep("[1]")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if expr.arena != nil {
+		t.Fatal("expected a finalized result")
+	}
+	// Close does nothing without explicit close.
+	expr.Close()
+	if got := expr.String(); got != "[1]" {
+		t.Fatalf("unexpected value %s", got)
+	}
+}
+
+func BenchmarkTraverse(b *testing.B) {
+	for _, explicit := range []bool{false, true} {
+		name := "finalizers"
+		if explicit {
+			name = "explicit-close"
+		}
+		b.Run(name, func(b *testing.B) {
+			ctx := NewContext()
+			ctx.SetExplicitClose(explicit)
+			expr, err := ctx.EvalDeep(`std.array.generate (fun i => { id = i, tags = ["a", "b"] }) 2000`)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer expr.Close()
+			b.ReportAllocs()
+			for b.Loop() {
+				rows, _ := expr.ToArray()
+				for _, row := range rows {
+					fields, _ := row.ToRecord()
+					fields["tags"].ToArray()
+				}
+			}
+		})
+	}
+}
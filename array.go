@@ -0,0 +1,86 @@
+package nickel
+
+/*
+#include <nickel_lang.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrOutOfRange is returned (wrapped) when accessing an array outside of its
+// bounds.
+var ErrOutOfRange = errors.New("index out of range")
+
+// Len returns the number of elements of an array, or the number of fields of
+// a record. It returns 0 for other kinds of expressions.
+func (expr *Expr) Len() int {
+	switch expr.kind {
+	case KindArray:
+		return int(C.nickel_array_len(C.nickel_expr_as_array(expr.ptr)))
+	case KindRecord:
+		return int(C.nickel_record_len(C.nickel_expr_as_record(expr.ptr)))
+	default:
+		return 0
+	}
+}
+
+// Index returns the element of an array at index i.
+//
+// Only that element is retrieved, so unlike ToArray this is cheap on large
+// arrays. The element of a shallowly evaluated array may not have been
+// evaluated yet.
+func (expr *Expr) Index(i int) (*Expr, error) {
+	if expr.kind != KindArray {
+		return nil, &KindError{Want: KindArray, Got: expr.kind}
+	}
+
+	ptr := C.nickel_expr_as_array(expr.ptr)
+	len := int(C.nickel_array_len(ptr))
+	if i < 0 || i >= len {
+		return nil, fmt.Errorf("%w: index %d of array with length %d", ErrOutOfRange, i, len)
+	}
+
+	if prefetched := expr.prefetchedChildren(); prefetched != nil {
+		return prefetched[i], nil
+	}
+	value := new_child(expr)
+	C.nickel_array_get(ptr, C.uintptr_t(i), value.ptr)
+	value.load()
+	value.inherit(expr)
+	value.origin = expr.origin.elem(i)
+	return value, nil
+}
+
+// Slice returns the elements of an array with indices in [start, end).
+//
+// Only the requested elements are retrieved, so this is much cheaper than
+// ToArray for taking a small part of a large array. As with ToArray, the
+// elements of a shallowly evaluated array may not have been evaluated yet.
+func (expr *Expr) Slice(start, end int) ([]*Expr, error) {
+	if expr.kind != KindArray {
+		return nil, &KindError{Want: KindArray, Got: expr.kind}
+	}
+
+	ptr := C.nickel_expr_as_array(expr.ptr)
+	len := int(C.nickel_array_len(ptr))
+	if start < 0 || end < start || end > len {
+		return nil, fmt.Errorf("%w: slice [%d:%d] of array with length %d", ErrOutOfRange, start, end, len)
+	}
+
+	if prefetched := expr.prefetchedChildren(); prefetched != nil {
+		return slices.Clone(prefetched[start:end]), nil
+	}
+	ret := make([]*Expr, end-start)
+	for i := range ret {
+		value := new_child(expr)
+		C.nickel_array_get(ptr, C.uintptr_t(start+i), value.ptr)
+		ret[i] = value.load()
+		ret[i].inherit(expr)
+		ret[i].origin = expr.origin.elem(start + i)
+	}
+	return ret, nil
+}
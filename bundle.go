@@ -0,0 +1,388 @@
+package nickel
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// BundleManifestName is the name of the manifest in a bundle written by
+// Bundle.WriteDir or Bundle.WriteArchive.
+const BundleManifestName = "nickel-bundle.json"
+
+// A Bundle is a Nickel program together with all the files it imports, to
+// ship configurations as self-contained artifacts. See Context.Pack.
+type Bundle struct {
+	Manifest BundleManifest
+	// Files holds the contents of the files, by their slash-separated path
+	// in the bundle.
+	Files map[string][]byte
+}
+
+// BundleManifest describes the files of a Bundle.
+type BundleManifest struct {
+	// Main is the path of the program in the bundle.
+	Main string `json:"main"`
+	// Files holds the hashes of the files, by their path in the bundle, as
+	// "sha256:" followed by the hexadecimal SHA-256 of their contents.
+	Files map[string]string `json:"files"`
+}
+
+// Pack reads the Nickel program in the file filename, and the files it
+// imports, directly or not, into a Bundle.
+//
+// The imports of the program are resolved like for EvalFile, through the
+// import paths, the import file system, the registered sources, the bundled
+// library and the import resolver, and the imports of the files it imports
+// relative to them. The files are laid out in the bundle by their path
+// relative to the innermost directory containing all of them, and their
+// imports are rewritten to relative paths where needed, so that the bundle
+// can be evaluated from anywhere. Only the imports of Nickel files are
+// followed, and only the imports of plain string literals can be found:
+// packing a program that imports a path with interpolations makes a bundle
+// without that file.
+func (ctx *Context) Pack(filename string) (*Bundle, error) {
+	main, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	src, err := os.ReadFile(main)
+	if err != nil {
+		return nil, err
+	}
+	files, err := ctx.importClosure(string(src), main)
+	if err != nil {
+		return nil, err
+	}
+
+	root := filepath.Dir(main)
+	for _, file := range files[1:] {
+		for !withinDir(root, file.path) {
+			parent := filepath.Dir(root)
+			if parent == root {
+				return nil, fmt.Errorf("can't pack %s with %s: they're on different volumes", main, file.path)
+			}
+			root = parent
+		}
+	}
+	name := func(abs string) string {
+		rel, _ := filepath.Rel(root, abs)
+		return filepath.ToSlash(rel)
+	}
+
+	b := &Bundle{
+		Manifest: BundleManifest{Main: name(main), Files: map[string]string{}},
+		Files:    map[string][]byte{},
+	}
+	for _, file := range files {
+		data := file.src
+		if len(file.imports) > 0 {
+			var rewritten []byte
+			last := 0
+			for i, m := range file.imports {
+				rel := relativeImport(name(file.path), name(files[file.targets[i]].path))
+				if path.Clean(unquoteImport(string(data[m[2]:m[3]]))) == rel {
+					continue
+				}
+				rewritten = append(rewritten, data[last:m[2]-1]...)
+				rewritten = append(rewritten, QuoteString(rel)...)
+				last = m[3] + 1
+			}
+			if last > 0 {
+				data = append(rewritten, data[last:]...)
+			}
+		}
+		if name(file.path) == BundleManifestName {
+			return nil, fmt.Errorf("can't pack %s: its name is reserved for the manifest", file.path)
+		}
+		b.Files[name(file.path)] = data
+		b.Manifest.Files[name(file.path)] = fileHash(data)
+	}
+	return b, nil
+}
+
+// importedFile is a file of the import closure of a program.
+type importedFile struct {
+	// The absolute path of the file.
+	path string
+	src  []byte
+	// The submatch indices of importExpr for the imports of the file, and
+	// the indices of the imported files in the closure.
+	imports [][]int
+	targets []int
+}
+
+// importClosure returns the program src, with the absolute path main, and
+// the files it imports, directly or not, in the order they're found. The
+// imports of the program are resolved like for an evaluation, and the
+// source of the program has them rewritten (see resolveImports).
+func (ctx *Context) importClosure(src string, main string) ([]*importedFile, error) {
+	resolved, _, err := ctx.resolveImports(src, main)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []*importedFile{{path: main, src: []byte(resolved)}}
+	seen := map[string]int{main: 0}
+	for f := 0; f < len(files); f++ {
+		file := files[f]
+		if !isNickelFile(file.path) {
+			continue
+		}
+		for m := range findImportLiterals(string(file.src)) {
+			target := filepath.FromSlash(unquoteImport(string(file.src[m[2]:m[3]])))
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(file.path), target)
+			}
+			index, ok := seen[target]
+			if !ok {
+				data, err := os.ReadFile(target)
+				if err != nil {
+					return nil, fmt.Errorf("reading import of %s: %w", file.path, err)
+				}
+				index = len(files)
+				seen[target] = index
+				files = append(files, &importedFile{path: target, src: data})
+			}
+			file.imports = append(file.imports, m)
+			file.targets = append(file.targets, index)
+		}
+	}
+	return files, nil
+}
+
+// isNickelFile reports whether Nickel parses the file at path as a Nickel
+// program, rather than as data or text, going by its extension.
+func isNickelFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".yaml", ".yml", ".toml", ".txt":
+		return false
+	}
+	return true
+}
+
+// withinDir reports whether path is in dir or in one of its subdirectories.
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// relativeImport returns the path to import the file target from the file
+// from, both slash-separated paths in the same directory tree.
+func relativeImport(from, target string) string {
+	dir := path.Dir(from)
+	up := ""
+	for dir != "." && !strings.HasPrefix(target, dir+"/") {
+		dir = path.Dir(dir)
+		up += "../"
+	}
+	if dir == "." {
+		return up + target
+	}
+	return up + strings.TrimPrefix(target, dir+"/")
+}
+
+// fileHash returns the hash of a file in a BundleManifest.
+func fileHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// WriteDir writes the files of the bundle and its manifest, named
+// BundleManifestName, to the directory dir, creating it if needed.
+func (b *Bundle) WriteDir(dir string) error {
+	manifest, err := json.MarshalIndent(b.Manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(b.Files)) {
+		dest := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dest, b.Files[name], 0o644); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(filepath.Join(dir, BundleManifestName), append(manifest, '\n'), 0o644)
+}
+
+// WriteArchive writes the files of the bundle and its manifest, named
+// BundleManifestName, to w as a tar archive. The archive only depends on
+// the bundle: the files are in order, and have no modification time.
+func (b *Bundle) WriteArchive(w io.Writer) error {
+	manifest, err := json.MarshalIndent(b.Manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	write := func(name string, data []byte) error {
+		hdr := &tar.Header{
+			Name:     name,
+			Mode:     0o644,
+			Size:     int64(len(data)),
+			Typeflag: tar.TypeReg,
+			Format:   tar.FormatPAX,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := write(BundleManifestName, append(manifest, '\n')); err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(b.Files)) {
+		if err := write(name, b.Files[name]); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// ErrBundleMismatch is returned (wrapped) when the files of a bundle don't
+// match its manifest.
+var ErrBundleMismatch = errors.New("bundle doesn't match its manifest")
+
+// ReadBundle reads a bundle written by Bundle.WriteDir from fsys, which is
+// usually os.DirFS of the directory. Only the files listed in the manifest
+// are read.
+func ReadBundle(fsys fs.FS) (*Bundle, error) {
+	data, err := fs.ReadFile(fsys, BundleManifestName)
+	if err != nil {
+		return nil, err
+	}
+	b := &Bundle{Files: map[string][]byte{}}
+	if err := json.Unmarshal(data, &b.Manifest); err != nil {
+		return nil, fmt.Errorf("reading bundle manifest: %w", err)
+	}
+	for name := range b.Manifest.Files {
+		if !fs.ValidPath(name) {
+			return nil, fmt.Errorf("%w: invalid file name %q", ErrBundleMismatch, name)
+		}
+		if b.Files[name], err = fs.ReadFile(fsys, name); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// ReadBundleArchive reads a bundle written by Bundle.WriteArchive from r.
+func ReadBundleArchive(r io.Reader) (*Bundle, error) {
+	b := &Bundle{Files: map[string][]byte{}}
+	var manifest []byte
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Name == BundleManifestName {
+			manifest = data
+		} else {
+			b.Files[hdr.Name] = data
+		}
+	}
+	if manifest == nil {
+		return nil, fmt.Errorf("bundle archive has no %s", BundleManifestName)
+	}
+	if err := json.Unmarshal(manifest, &b.Manifest); err != nil {
+		return nil, fmt.Errorf("reading bundle manifest: %w", err)
+	}
+	return b, nil
+}
+
+// Verify checks that the bundle has the files listed in its manifest, with
+// the right hashes, and no others, and that the imports of its Nickel files
+// only refer to files of the bundle. Verify returns an error wrapping
+// ErrBundleMismatch otherwise.
+//
+// Imports are checked like Pack finds them, so Verify refuses the imports
+// of paths with interpolations, which can't be checked before evaluation,
+// as well as absolute paths, and relative paths leaving the bundle.
+func (b *Bundle) Verify() error {
+	if _, ok := b.Manifest.Files[b.Manifest.Main]; !ok {
+		return fmt.Errorf("%w: main program %q isn't in the bundle", ErrBundleMismatch, b.Manifest.Main)
+	}
+	for _, name := range slices.Sorted(maps.Keys(b.Files)) {
+		if _, ok := b.Manifest.Files[name]; !ok {
+			return fmt.Errorf("%w: %s isn't in the manifest", ErrBundleMismatch, name)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(b.Manifest.Files)) {
+		data, ok := b.Files[name]
+		if !ok {
+			return fmt.Errorf("%w: %s is missing", ErrBundleMismatch, name)
+		}
+		if !fs.ValidPath(name) || name == BundleManifestName {
+			return fmt.Errorf("%w: invalid file name %q", ErrBundleMismatch, name)
+		}
+		if fileHash(data) != b.Manifest.Files[name] {
+			return fmt.Errorf("%w: %s was modified", ErrBundleMismatch, name)
+		}
+		if !isNickelFile(name) {
+			continue
+		}
+		src := string(data)
+		for i := range findImports(src) {
+			m := importExpr.FindStringSubmatchIndex(src[i:])
+			if m == nil || m[0] != 0 {
+				return fmt.Errorf("%w: %s imports a path that isn't a plain string", ErrBundleMismatch, name)
+			}
+			imported := unquoteImport(src[i+m[2] : i+m[3]])
+			target := path.Join(path.Dir(name), imported)
+			if _, ok := b.Files[target]; !ok || path.IsAbs(imported) || filepath.IsAbs(imported) {
+				return fmt.Errorf("%w: %s imports %q, which isn't in the bundle", ErrBundleMismatch, name, imported)
+			}
+		}
+	}
+	return nil
+}
+
+// EvalBundle verifies the bundle (see Bundle.Verify), and evaluates its
+// program deeply, like EvalFile.
+//
+// The program and the files it imports all come from the bundle: the
+// import paths, the import file system, the registered sources and the
+// import resolver aren't used. The Nickel library only reads files from the
+// operating system, so the files are written to a temporary directory for
+// the evaluation, which appears in error messages. The other settings of
+// the context apply: for example, sandbox mode refuses bundles whose
+// program imports files, and the host capabilities stay available.
+func (ctx *Context) EvalBundle(b *Bundle) (*Expr, error) {
+	if err := b.Verify(); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "nickel-bundle-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if err := b.WriteDir(dir); err != nil {
+		return nil, err
+	}
+	main := filepath.Join(dir, filepath.FromSlash(b.Manifest.Main))
+	return ctx.evalDeep(string(b.Files[b.Manifest.Main]), evalOptions{name: main})
+}
@@ -0,0 +1,222 @@
+package nickel
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPack(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"app/main.ncl":     `{ a = import "lib/a.ncl", b = import "../shared/b.ncl", c = import "c.ncl" }`,
+		"app/lib/a.ncl":    `{ data = import "../../shared/data.json" }`,
+		"shared/b.ncl":     `import "data.json"`,
+		"shared/data.json": `{"x": 1}`,
+		"vendor/c.ncl":     `"vendored"`,
+		"app/unused.ncl":   `"not imported"`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := NewContext()
+	if err := ctx.AddImportPath(filepath.Join(dir, "vendor")); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ctx.EvalFile(filepath.Join(dir, "app/main.ncl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle, err := ctx.Pack(filepath.Join(dir, "app/main.ncl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Manifest.Main != "app/main.ncl" {
+		t.Errorf("unexpected main: %q", bundle.Manifest.Main)
+	}
+	names := []string{"app/lib/a.ncl", "app/main.ncl", "shared/b.ncl", "shared/data.json", "vendor/c.ncl"}
+	if got := slices.Sorted(maps.Keys(bundle.Files)); !slices.Equal(got, names) {
+		t.Errorf("unexpected files: %q", got)
+	}
+	if got := slices.Sorted(maps.Keys(bundle.Manifest.Files)); !slices.Equal(got, names) {
+		t.Errorf("unexpected manifest: %q", got)
+	}
+	// The import found through the import path is made relative, and the
+	// others are kept.
+	if got := string(bundle.Files["app/main.ncl"]); got != `{ a = import "lib/a.ncl", b = import "../shared/b.ncl", c = import "../vendor/c.ncl" }` {
+		t.Errorf("unexpected main program: %s", got)
+	}
+	if got := bundle.Manifest.Files["shared/data.json"]; got != fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(`{"x": 1}`))) {
+		t.Errorf("unexpected hash: %s", got)
+	}
+
+	// The bundle evaluates the same without the import path.
+	out := t.TempDir()
+	if err := bundle.WriteDir(out); err != nil {
+		t.Fatal(err)
+	}
+	expr, err := NewContext().EvalFile(filepath.Join(out, "app/main.ncl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expr.String() != expected.String() {
+		t.Errorf("bundle evaluates to %s, expected %s", expr, expected)
+	}
+	data, err := os.ReadFile(filepath.Join(out, BundleManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest BundleManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(manifest.Files, bundle.Manifest.Files) {
+		t.Errorf("unexpected manifest: %s", data)
+	}
+
+	var archive, again bytes.Buffer
+	if err := bundle.WriteArchive(&archive); err != nil {
+		t.Fatal(err)
+	}
+	if err := bundle.WriteArchive(&again); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(archive.Bytes(), again.Bytes()) {
+		t.Error("expected the same archive every time")
+	}
+	var entries []string
+	tr := tar.NewReader(&archive)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, hdr.Name)
+	}
+	if !slices.Equal(entries, append([]string{BundleManifestName}, names...)) {
+		t.Errorf("unexpected archive entries: %q", entries)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "app/broken.ncl"), []byte(`import "missing.ncl"`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.Pack(filepath.Join(dir, "app/broken.ncl")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected an error for a missing import, got %v", err)
+	}
+}
+
+func TestEvalBundle(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"main.ncl":         `let { Port, .. } = import "go-nickel/contracts.ncl" in { port | Port = (import "ports.json").http } & (import "lib/defaults.ncl")`,
+		"lib/defaults.ncl": `{ host | default = "localhost" }`,
+		"ports.json":       `{"http": 8080}`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := NewContext()
+	bundle, err := ctx.Pack(filepath.Join(dir, "main.ncl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{ host = "localhost", port = 8080 }`
+
+	expr, err := ctx.EvalBundle(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := expr.String(); got != expected {
+		t.Errorf("unexpected result: %s", got)
+	}
+
+	out := t.TempDir()
+	if err := bundle.WriteDir(out); err != nil {
+		t.Fatal(err)
+	}
+	fromDir, err := ReadBundle(os.DirFS(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	if err := bundle.WriteArchive(&archive); err != nil {
+		t.Fatal(err)
+	}
+	fromArchive, err := ReadBundleArchive(&archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, read := range []*Bundle{fromDir, fromArchive} {
+		expr, err := ctx.EvalBundle(read)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := expr.String(); got != expected {
+			t.Errorf("unexpected result: %s", got)
+		}
+	}
+
+	// A modified file is refused. The bundle has the bundled library too,
+	// so the program isn't at its root.
+	ports := path.Join(path.Dir(bundle.Manifest.Main), "ports.json")
+	if err := os.WriteFile(filepath.Join(out, filepath.FromSlash(ports)), []byte(`{"http": 80}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modified, err := ReadBundle(os.DirFS(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.EvalBundle(modified); !errors.Is(err, ErrBundleMismatch) {
+		t.Errorf("expected a mismatch for a modified file, got %v", err)
+	}
+
+	// Bundles can't import files from outside.
+	outside := filepath.Join(dir, "lib/defaults.ncl")
+	for _, src := range []string{
+		`import ` + QuoteString(outside),
+		`import "../lib/defaults.ncl"`,
+		`import "lib/%{"defaults"}.ncl"`,
+		`import "missing.ncl"`,
+	} {
+		b := &Bundle{
+			Manifest: BundleManifest{Main: "main.ncl", Files: map[string]string{"main.ncl": fileHash([]byte(src))}},
+			Files:    map[string][]byte{"main.ncl": []byte(src)},
+		}
+		if _, err := ctx.EvalBundle(b); !errors.Is(err, ErrBundleMismatch) {
+			t.Errorf("%s: expected a mismatch, got %v", src, err)
+		}
+	}
+
+	// Files that aren't in the manifest are refused.
+	bundle.Files["extra.ncl"] = []byte(`1`)
+	if err := bundle.Verify(); !errors.Is(err, ErrBundleMismatch) {
+		t.Errorf("expected a mismatch for an extra file, got %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package nickel
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Check evaluates a Nickel program deeply, like EvalDeep, but reports every
+// value that fails to evaluate rather than only the first one, so that all
+// the problems of a configuration can be shown at once. It returns nil if
+// the program evaluates.
+//
+// Nickel stops at the first error it finds, so when the program fails,
+// Check evaluates each of its fields and array elements on its own, and
+// narrows down the failures to the innermost values that fail. This
+// evaluates the program again for every value it looks at, so it's much
+// slower than EvalDeep on programs with errors. The errors of the failing
+// values are joined (see errors.Join), each prefixed by its path; an error
+// found through several paths, like a broken field that other fields refer
+// to, is only reported once. For the diagnostics of all of them, see
+// Diagnostics. The messages show the program between parentheses, but the
+// positions in the diagnostics are the program's.
+//
+// Fields whose values refer to each other in a loop fail with an infinite
+// recursion. Check follows the references from the failing fields to find
+// the loop, and reports it as a *CycleError.
+//
+// Syntax errors come all at once from the Nickel library already, while
+// type checking happens before evaluation and stops at the first error, so
+// for programs that fail to parse or to type check, Check returns the same
+// error as EvalDeep.
+func (ctx *Context) Check(src string) error {
+	// The values are selected by appending to the program, so that
+	// positions in it stay the same:
+	//
+	//   (<src>
+	//   ) |> std.record.get "a" |> std.array.at 1
+	//
+	// The opening parenthesis goes in front of the program like the
+	// bindings of the globals, so that Diagnostics leaves it out.
+	src += "\n)"
+	_, err := ctx.evalDeep(src, evalOptions{scope: "("})
+	if err == nil {
+		return nil
+	}
+	shape, shallowErr := ctx.EvalShallow(strings.TrimSuffix(src, "\n)"))
+	if shallowErr != nil {
+		return err
+	}
+
+	c := checker{ctx: ctx, src: src}
+	c.check(nil, true, src, shape, err)
+	return errors.Join(c.report()...)
+}
+
+type checker struct {
+	ctx *Context
+	// The program, as given to evalDeep.
+	src string
+	// The innermost values that fail to evaluate, in order.
+	failures []checkFailure
+	// The field that each failure refers to first, once found (see
+	// successor).
+	successors map[int]int
+}
+
+type checkFailure struct {
+	path []string
+	// Whether the path only goes through record fields, rather than array
+	// elements.
+	recordPath bool
+	err        error
+}
+
+// check finds the failures in the value at path, selected from the program
+// by src, which fails to evaluate with err. The shape is the value as
+// evaluated shallowly from its parent: it gives the fields and elements to
+// look at, but it may not have been checked by the contracts on it.
+func (c *checker) check(path []string, recordPath bool, src string, shape *Expr, err error) {
+	found := false
+	check := func(name string, field bool, childSrc string, child *Expr) {
+		if _, err := c.ctx.evalDeep(childSrc, evalOptions{scope: "("}); err != nil {
+			found = true
+			c.check(append(path, name), recordPath && field, childSrc, child, err)
+		}
+	}
+
+	if shape, shapeErr := shape.force(); shapeErr == nil {
+		switch shape.kind {
+		case KindRecord:
+			fields, _ := shape.ToRecord()
+			for _, name := range sortedKeys(fields) {
+				if fields[name] != nil {
+					check(name, true, src+" |> std.record.get "+QuoteString(name), fields[name])
+				}
+			}
+		case KindArray:
+			elems, _ := shape.ToArray()
+			for i, elem := range elems {
+				check(strconv.Itoa(i), false, src+" |> std.array.at "+strconv.Itoa(i), elem)
+			}
+		}
+	}
+	if !found {
+		c.failures = append(c.failures, checkFailure{path: slices.Clone(path), recordPath: recordPath, err: err})
+	}
+}
+
+// report returns the errors of the failures, each prefixed by its path. An
+// error found through several paths is only reported once, and so is a
+// cycle.
+func (c *checker) report() []error {
+	var errs []error
+	seen := map[string]bool{}
+	for i, failure := range c.failures {
+		err := failure.err
+		if cycle := c.cycle(i); cycle != nil {
+			err = cycle
+		}
+		if msg := err.Error(); !seen[msg] {
+			seen[msg] = true
+			if _, ok := err.(*CycleError); !ok && len(failure.path) > 0 {
+				err = fmt.Errorf("%s: %w", FormatPath(failure.path), err)
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// Diagnostics returns the diagnostics (see Error.Diagnostics) of all the
+// Nickel errors in err's tree, as returned by Check, in order.
+func Diagnostics(err error) []Diagnostic {
+	var ret []Diagnostic
+	switch err := err.(type) {
+	case nil:
+	case *Error:
+		ret = append(ret, err.Diagnostics()...)
+	case interface{ Unwrap() []error }:
+		for _, err := range err.Unwrap() {
+			ret = append(ret, Diagnostics(err)...)
+		}
+	case interface{ Unwrap() error }:
+		ret = Diagnostics(err.Unwrap())
+	}
+	return ret
+}
@@ -0,0 +1,89 @@
+package nickel
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	ctx := NewContext()
+	if err := ctx.Check("{ a = 1, b = [1, 2] }"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err := ctx.Check(`{
+		port | Number = "80",
+		name | String = 1,
+		replicas = port + 1,
+		servers = [{ host = "a" }, { host | String = false }],
+	}`)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("expected joined errors, got %v", err)
+	}
+	var paths []string
+	for _, err := range joined.Unwrap() {
+		path, _, _ := strings.Cut(err.Error(), ": ")
+		paths = append(paths, path)
+		var nickelErr *Error
+		if !errors.As(err, &nickelErr) {
+			t.Errorf("expected a Nickel error, got %v", err)
+		}
+	}
+	// replicas fails because of port, which is only reported once.
+	if got := strings.Join(paths, " "); got != "name port servers.1.host" {
+		t.Errorf("unexpected failing paths: %s", got)
+	}
+	if diags := Diagnostics(err); len(diags) != 3 {
+		t.Errorf("expected 3 diagnostics, got %d", len(diags))
+	}
+
+	// Syntax errors come all at once.
+	err = ctx.Check("{ a = 1 +, b = let in 2 }")
+	var nickelErr *Error
+	if !errors.As(err, &nickelErr) || len(Diagnostics(err)) != 2 {
+		t.Errorf("expected a Nickel error with 2 diagnostics, got %v", err)
+	}
+}
+
+func TestCheckCycles(t *testing.T) {
+	ctx := NewContext()
+	for _, c := range []struct {
+		src      string
+		expected []string
+	}{
+		{`{ x = x, y = 1 }`, []string{"cycle: x → x"}},
+		{`{ a = b, b = c, c = a, d = a, e = 1 }`, []string{"cycle: a → b → c → a"}},
+		{`{ a.b = c.d, c.d = a.b }`, []string{"cycle: a.b → c.d → a.b"}},
+		{`{ z = w, w = z, ok = true, bad | Number = "s" }`, []string{"bad: error: contract broken by the value of `bad`", "cycle: w → z → w"}},
+	} {
+		err := ctx.Check(c.src)
+		if err == nil {
+			t.Errorf("%s: expected an error", c.src)
+			continue
+		}
+		errs := err.(interface{ Unwrap() []error }).Unwrap()
+		if len(errs) != len(c.expected) {
+			t.Errorf("%s: expected %d errors, got %v", c.src, len(c.expected), err)
+			continue
+		}
+		for i, err := range errs {
+			if !strings.HasPrefix(err.Error(), c.expected[i]) {
+				t.Errorf("%s: expected %q, got %q", c.src, c.expected[i], err)
+			}
+		}
+		var cycle *CycleError
+		if !errors.As(err, &cycle) {
+			t.Errorf("%s: expected a *CycleError", c.src)
+			continue
+		}
+		var nickelErr *Error
+		if !errors.As(cycle, &nickelErr) || len(Diagnostics(cycle)) == 0 {
+			t.Errorf("%s: expected the cycle to wrap the Nickel error", c.src)
+		}
+	}
+}
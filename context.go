@@ -12,12 +12,36 @@ uintptr_t traceCallbackTrampoline(void* context, const uint8_t* buf, uintptr_t l
 */
 import "C"
 import (
+	"errors"
+	"fmt"
 	"io"
+	"os"
 	"runtime"
+	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 	"unsafe"
 )
 
+// ErrInvalidSource is returned (wrapped) when asked to evaluate source text
+// that can't be handed to the Nickel library: text that isn't valid UTF-8, or
+// that contains a NUL byte.
+var ErrInvalidSource = errors.New("invalid Nickel source")
+
+// checkSource makes sure that src can be passed to the Nickel library as a
+// null-terminated UTF-8 string. The library aborts the process on invalid
+// UTF-8, and would silently truncate the source at a NUL byte.
+func checkSource(src string) error {
+	if !utf8.ValidString(src) {
+		return fmt.Errorf("%w: not valid UTF-8", ErrInvalidSource)
+	}
+	if i := strings.IndexByte(src, 0); i >= 0 {
+		return fmt.Errorf("%w: NUL byte at offset %d", ErrInvalidSource, i)
+	}
+	return nil
+}
+
 var (
 	// A map from `nickel_context*` to the configured trace callback for that context.
 	// The finalizer for `Context` both deallocates the `nickel_context*` and removes
@@ -30,19 +54,101 @@ var (
 //
 // It allows you to customize various aspects of the Nickel interpreter, such
 // as the path used to search for imported files.
+//
+// A Context is safe for concurrent use: calls into the Nickel library that
+// go through the same context (including evaluating and serializing the
+// Exprs it created) are serialized.
 type Context struct {
 	ptr *C.nickel_context
+	// The native context isn't thread-safe, so every C call that takes
+	// `ptr` needs to hold this lock (see lockNative). It's a channel, so
+	// that waiting for it can time out.
+	native chan struct{}
+	// The round of evalWithHost that is running natively, if any. It's
+	// only accessed with the native lock held.
+	traceRounds *traceRounds
+	// mu protects the Go-side settings below. It's never held during a C
+	// call that evaluates, so that the settings can be read while an
+	// evaluation that timed out is still running.
+	mu sync.Mutex
+
+	logRedactor  func(path []string) bool
+	contracts    []namedContract
+	traceWriter  io.Writer
+	traceFraming TraceFraming
+	// The buffer in front of traceWriter, if traceFraming isn't TraceChunks.
+	tracer *traceFramer
+	host   hostSettings
+	// Directories to resolve imports against, see AddImportPath, and the
+	// copy of the import file system, see SetImportFS.
+	importPaths []string
+	importFSDir string
+	// The directory holding the sources registered with RegisterSource.
+	sourcesDir string
+	// The resolver for the other imports, see SetImportResolver, and the
+	// directory holding the files it resolved.
+	resolver    ImportResolver
+	resolvedDir string
+	// The copy of the bundled library, see LibraryFS.
+	libraryDir string
+
+	// The source binding the globals, see SetGlobals.
+	globals string
+
+	// Where evaluations are recorded, see SetEvalRecorder.
+	recorder *EvalRecorder
+
+	// Whether imports are refused, see SetSandbox.
+	sandbox bool
+	// The bound on the duration of evaluations, see SetEvalTimeout.
+	evalTimeout time.Duration
+	limits      SizeLimits
+	// See SetDecodeHooks.
+	decodeHooks []DecodeHook
+	// See SetExplicitClose.
+	explicitClose bool
+	// See SetStrictNumbers.
+	strictNumbers bool
+	extensions    []string
+
+	// Evaluating files doesn't need to hold mu, so the cache has its own
+	// lock.
+	files fileCache
+
+	// See SetCoalescing.
+	coalescing bool
+	// Evaluations in progress, keyed by what they evaluate.
+	flights flightGroup
 }
 
 // NewContext creates a new Context for storing global Nickel settings.
 func NewContext() *Context {
 	ctx := &Context{
-		ptr: C.nickel_context_alloc(),
+		ptr:    C.nickel_context_alloc(),
+		native: make(chan struct{}, 1),
+		// The tracer has its own pointer to it, and mustn't keep the
+		// Context alive.
+		traceRounds: &traceRounds{},
 	}
+	liveContexts.Add(1)
+	// The callback discards the output until there's a trace writer, like
+	// the Nickel library does without a callback. Setting it once here
+	// means that changing the writer doesn't need the native lock.
+	C.nickel_context_set_trace_callback(ctx.ptr, C.nickel_write_callback(C.traceCallbackTrampoline), nil, unsafe.Pointer(ctx.ptr))
 
 	runtime.SetFinalizer(ctx, func(ctx *Context) {
-		C.nickel_context_free(ctx.ptr)
+		// Forget the tracer first: once the native context is freed, a new
+		// one can have the same address.
+		contextTracerMutex.Lock()
 		delete(contextTracer, unsafe.Pointer(ctx.ptr))
+		contextTracerMutex.Unlock()
+		C.nickel_context_free(ctx.ptr)
+		liveContexts.Add(-1)
+		for _, dir := range []string{ctx.importFSDir, ctx.sourcesDir, ctx.resolvedDir, ctx.libraryDir} {
+			if dir != "" {
+				os.RemoveAll(dir)
+			}
+		}
 	})
 
 	return ctx
@@ -57,6 +163,9 @@ func traceCallback(data unsafe.Pointer, buf *C.uint8_t, len C.uintptr_t) C.uintp
 	contextTracerMutex.RLock()
 	w := contextTracer[data]
 	contextTracerMutex.RUnlock()
+	if w == nil {
+		return len
+	}
 
 	// Swallow the error if the write callback fails, since it's just for tracing.
 	n, _ := w.Write(bytes)
@@ -66,52 +175,234 @@ func traceCallback(data unsafe.Pointer, buf *C.uint8_t, len C.uintptr_t) C.uintp
 // SetTraceWriter provides a "trace" callback to the Nickel evaluator.
 //
 // When evaluating Nickel code that calls the `std.trace` function, the
-// resulting trace outputs will be written to the writer w.
+// resulting trace outputs will be written to the writer w. By default, each
+// trace is written in several pieces; see SetTraceFraming.
 func (ctx *Context) SetTraceWriter(w io.Writer) {
-	contextTracerMutex.Lock()
-	contextTracer[unsafe.Pointer(ctx.ptr)] = w
-	contextTracerMutex.Unlock()
-	C.nickel_context_set_trace_callback(ctx.ptr, C.nickel_write_callback(C.traceCallbackTrampoline), nil, unsafe.Pointer(ctx.ptr))
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.traceWriter = w
+	ctx.installTracer()
 }
 
 // EvalDeep evaluates a Nickel program deeply.
 //
 // "Deeply" means that we recursively evaluate records and arrays. For
 // an alternative, see EvalShallow.
+//
+// Invalid programs are reported as errors. One known exception is source
+// with extremely deep syntactic nesting (on the order of a thousand nested
+// brackets or operators), which can overflow the stack of the Nickel parser.
+// If you evaluate untrusted source, bound its size.
+//
+// Concurrent calls evaluating the same source can be coalesced into a single
+// evaluation, see SetCoalescing.
 func (ctx *Context) EvalDeep(src string) (*Expr, error) {
+	return ctx.evalDeep(src, evalOptions{})
+}
+
+// EvalExport evaluates a Nickel program deeply, like EvalDeep, but for
+// export, like the nickel export command: the fields marked not_exported
+// are left out of the result, so that ToRecord and ConvertTo see the same
+// fields as MarshalJSON and the CLI. Optional fields without a value are
+// left out either way.
+func (ctx *Context) EvalExport(src string) (*Expr, error) {
+	return ctx.evalDeep(src, evalOptions{export: true})
+}
+
+// The name that the Nickel library gives the main program by default.
+const defaultSourceName = "<source>"
+
+// evalOptions are the variations on deep evaluation.
+type evalOptions struct {
+	// If not empty, the source name of the program for the duration of the
+	// evaluation.
+	name string
+	// Whether to evaluate for export, leaving out the fields that wouldn't
+	// be exported.
+	export bool
+	// Whether the program is data generated by this package, rather than
+	// something written by a user, so that it doesn't need the host
+	// capabilities.
+	data bool
+	// Source to put between the globals and the program, like the
+	// bindings of EvalInScope.
+	scope string
+	// The length of the bindings that evalDeep put in front of the
+	// program.
+	prelude int
+	// The round of evalWithHost that the evaluation is, if any.
+	round *hostRound
+	// The imports of the program that resolveImports rewrote.
+	imports map[string]string
+}
+
+// evalDeep implements EvalDeep.
+func (ctx *Context) evalDeep(src string, opts evalOptions) (*Expr, error) {
+	if err := checkSource(src); err != nil {
+		return nil, err
+	}
+	program := opts.scope + src
+	var recorder *EvalRecorder
+	prelude := ""
+	if !opts.data {
+		if err := ctx.checkSandbox(src); err != nil {
+			return nil, err
+		}
+		resolved, imports, err := ctx.resolveImports(src, opts.name)
+		if err != nil {
+			return nil, err
+		}
+		opts.imports = imports
+		prelude = ctx.withPrelude(opts.scope, nil)
+		src = resolved
+		recorder = ctx.evalRecorder()
+	}
+	eval := func(round *hostRound) (*Expr, error) {
+		opts := opts
+		opts.prelude = len(round.prelude)
+		opts.round = round
+		return ctx.evalDeepNative(round.prelude+src, opts)
+	}
+
+	key := fmt.Sprintf("deep\x00%s\x00%t\x00%s", opts.name, opts.export, prelude+src)
+	return ctx.coalesce(key, func() (*Expr, error) {
+		var expr *Expr
+		var err error
+		if opts.data {
+			expr, err = ctx.evalDeepNative(src, opts)
+		} else {
+			expr, err = ctx.evalWithHost(prelude, opts.scope, eval)
+		}
+		if err == nil {
+			err = ctx.checkSize(expr)
+		}
+		if recorder != nil {
+			recorder.recordProgram(opts.name, program, true, expr, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return expr, nil
+	})
+}
+
+func (ctx *Context) evalDeepNative(src string, opts evalOptions) (*Expr, error) {
 	// This is a little silly, because eventually the Rust library converts
 	// the null-terminated C string into a length-delimited Rust string.
 	// We could avoid some extra copying by having the C API work with
 	// length-delimited strings, but then it's a weird API for C users...
-	csrc := C.CString(src)
+	deadline, timeout := ctx.deadline()
+	if opts.round != nil {
+		deadline, timeout = opts.round.deadline, opts.round.timeout
+	}
 	out_expr := new_expr(ctx)
 	out_err := new_err()
-	result := C.nickel_context_eval_deep(ctx.ptr, csrc, out_expr.ptr, out_err.ptr)
-	C.free(unsafe.Pointer(csrc))
+	result, err := runNativeUntil(ctx, deadline, timeout, func() C.nickel_result {
+		csrc := C.CString(src)
+		defer C.free(unsafe.Pointer(csrc))
+		if opts.name != "" {
+			ctx.setSourceName(opts.name)
+			defer ctx.setSourceName(defaultSourceName)
+		}
+		ctx.traceRounds.start(opts.round)
+		defer ctx.traceRounds.start(nil)
+		defer ctx.flushTrace()
+		if opts.export {
+			return C.nickel_context_eval_deep_for_export(ctx.ptr, csrc, out_expr.ptr, out_err.ptr)
+		}
+		return C.nickel_context_eval_deep(ctx.ptr, csrc, out_expr.ptr, out_err.ptr)
+	}, func(C.nickel_result) { out_expr.Close() })
+	if err != nil {
+		return nil, err
+	}
 
 	if result == C.NICKEL_RESULT_OK {
+		out_expr.load().evaluated()
+		out_expr.deep = true
+		out_expr.exported = opts.export
 		return out_expr, nil
 	} else {
+		out_err.mainName, out_err.mainSrc, out_err.mainPrelude = opts.name, src, opts.prelude
+		out_err.imports = opts.imports
 		return nil, out_err
 	}
 }
 
+// setSourceName sets the name of the main program. The native lock must be
+// held.
+func (ctx *Context) setSourceName(name string) {
+	cname := C.CString(name)
+	C.nickel_context_set_source_name(ctx.ptr, cname)
+	C.free(unsafe.Pointer(cname))
+}
+
 // Evaluate a Nickel program shallowly.
 //
 // The result of this evaluation is a null, bool, number, string,
 // enum, record, or array. In case it's a record, array, or enum
 // variant, the payload (record values, array elements, or enum
 // payloads) will be left unevaluated.
+//
+// See EvalDeep for the limitations on what source can be evaluated safely,
+// and SetCoalescing for coalescing concurrent calls.
 func (ctx *Context) EvalShallow(src string) (*Expr, error) {
-	csrc := C.CString(src)
+	if err := checkSource(src); err != nil {
+		return nil, err
+	}
+	if err := ctx.checkSandbox(src); err != nil {
+		return nil, err
+	}
+	program := src
+	resolved, imports, err := ctx.resolveImports(src, "")
+	if err != nil {
+		return nil, err
+	}
+	prelude := ctx.withPrelude("", nil)
+	recorder := ctx.evalRecorder()
+
+	return ctx.coalesce("shallow\x00"+prelude+resolved, func() (*Expr, error) {
+		expr, err := ctx.evalWithHost(prelude, "", func(round *hostRound) (*Expr, error) {
+			expr, err := ctx.evalShallowNative(resolved, round)
+			if err, ok := err.(*Error); ok {
+				err.imports = imports
+			}
+			return expr, err
+		})
+		if err == nil {
+			err = ctx.checkSize(expr)
+		}
+		if recorder != nil {
+			recorder.recordProgram("", program, false, expr, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return expr, nil
+	})
+}
+
+// evalShallowNative evaluates src, with the bindings of round in front of
+// it.
+func (ctx *Context) evalShallowNative(src string, round *hostRound) (*Expr, error) {
+	src = round.prelude + src
 	out_expr := new_expr(ctx)
 	out_err := new_err()
-	result := C.nickel_context_eval_shallow(ctx.ptr, csrc, out_expr.ptr, out_err.ptr)
-	C.free(unsafe.Pointer(csrc))
+	result, err := runNativeUntil(ctx, round.deadline, round.timeout, func() C.nickel_result {
+		csrc := C.CString(src)
+		defer C.free(unsafe.Pointer(csrc))
+		ctx.traceRounds.start(round)
+		defer ctx.traceRounds.start(nil)
+		defer ctx.flushTrace()
+		return C.nickel_context_eval_shallow(ctx.ptr, csrc, out_expr.ptr, out_err.ptr)
+	}, func(C.nickel_result) { out_expr.Close() })
+	if err != nil {
+		return nil, err
+	}
 
 	if result == C.NICKEL_RESULT_OK {
-		return out_expr, nil
+		return out_expr.load().evaluated(), nil
 	} else {
+		out_err.mainSrc, out_err.mainPrelude = src, len(round.prelude)
 		return nil, out_err
 	}
 }
@@ -0,0 +1,238 @@
+package nickel
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ContractError is returned by ApplyContract, Validate and
+// DecodeWithContract when the contract is broken. The errors.As function
+// also finds one in the other errors of CategoryContract (see
+// Error.Category), without GoFields.
+//
+// Nickel reports contract violations by the name of the offending field,
+// without the path leading to it, so GoFields lists every field of the
+// decoding target that the violation could refer to.
+type ContractError struct {
+	// Field is the name of the Nickel record field that broke the contract.
+	// It is empty if the error doesn't name one.
+	Field string
+
+	// GoFields are the paths of the fields in the decoding target (for
+	// DecodeWithContract) that correspond to Field, such as "Server.Port" or "Servers[].Port".
+	GoFields []string
+
+	// Err is the underlying Nickel error.
+	Err *Error
+}
+
+func (e *ContractError) Error() string {
+	msg := e.Err.Error()
+	if len(e.GoFields) > 0 {
+		msg = strings.TrimRight(msg, "\n") + "\n(Go field " + strings.Join(e.GoFields, " or ") + ")\n"
+	}
+	return msg
+}
+
+func (e *ContractError) Unwrap() error {
+	return e.Err
+}
+
+// The messages that Nickel uses for contract violations, with the name of
+// the field in the first group.
+var blamePatterns = []*regexp.Regexp{
+	regexp.MustCompile("^contract broken by the value of `([^`]*)`"),
+	regexp.MustCompile("^missing definition for `([^`]*)`"),
+	regexp.MustCompile("^contract broken by a value\\s+extra field `([^`]*)`"),
+	regexp.MustCompile("^contract broken by a value"),
+}
+
+type namedContract struct {
+	name string
+	src  string
+}
+
+// RegisterContractSource makes the contract in src available under name to
+// the contract sources passed to ApplyContract, Validate and
+// DecodeWithContract, so that they can refer to it like any other Nickel
+// variable.
+//
+// The name must be a plain Nickel identifier. A contract's source can refer
+// to the contracts that were registered before it. Registering a name again
+// replaces its contract.
+func (ctx *Context) RegisterContractSource(name string, src string) error {
+	if !isIdent(name) {
+		return fmt.Errorf("invalid contract name %q", name)
+	}
+
+	ctx.mu.Lock()
+	i := slices.IndexFunc(ctx.contracts, func(c namedContract) bool { return c.name == name })
+	if i < 0 {
+		i = len(ctx.contracts)
+	}
+	prelude := contractPrelude(ctx.contracts[:i])
+	ctx.mu.Unlock()
+
+	// Catch syntax errors and unbound variables now rather than every
+	// time the contract is used.
+	if _, err := ctx.EvalShallow(prelude + "(" + src + "\n)"); err != nil {
+		return err
+	}
+
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	c := namedContract{name: name, src: src}
+	if i := slices.IndexFunc(ctx.contracts, func(c namedContract) bool { return c.name == name }); i >= 0 {
+		ctx.contracts[i] = c
+	} else {
+		ctx.contracts = append(ctx.contracts, c)
+	}
+	return nil
+}
+
+// contractPrelude binds the given contracts to their names, for prepending
+// to a program.
+func contractPrelude(contracts []namedContract) string {
+	var b strings.Builder
+	for _, c := range contracts {
+		// The newline protects against the source ending with a comment.
+		b.WriteString("let " + c.name + " = (" + c.src + "\n) in\n")
+	}
+	return b.String()
+}
+
+// ApplyContract evaluates src deeply with the contract in contractSrc
+// applied to it.
+//
+// contractSrc is Nickel source for a contract, like `{ port | Number }`, and
+// can refer to the contracts registered with RegisterContractSource. If the
+// contract is broken, the error is a *ContractError.
+func (ctx *Context) ApplyContract(src string, contractSrc string) (*Expr, error) {
+	return ctx.applyContract(src, contractSrc, nil)
+}
+
+// Validate checks that the result of evaluating src satisfies the contract in
+// contractSrc. See ApplyContract.
+func (ctx *Context) Validate(src string, contractSrc string) error {
+	_, err := ctx.ApplyContract(src, contractSrc)
+	return err
+}
+
+// DecodeWithContract evaluates src deeply with the contract in contractSrc
+// applied to it (see Context.ApplyContract), and converts the result to a T
+// (as in Expr.ConvertTo).
+func DecodeWithContract[T any](ctx *Context, src string, contractSrc string) (T, error) {
+	var ret T
+
+	expr, err := ctx.applyContract(src, contractSrc, reflect.TypeFor[T]())
+	if err != nil {
+		return ret, err
+	}
+
+	err = expr.ConvertTo(&ret)
+	return ret, err
+}
+
+// applyContract implements ApplyContract. If target isn't nil, contract
+// errors are resolved to the fields of target.
+func (ctx *Context) applyContract(src string, contractSrc string, target reflect.Type) (*Expr, error) {
+	ctx.mu.Lock()
+	prelude := contractPrelude(ctx.contracts)
+	ctx.mu.Unlock()
+
+	// The contract goes in its own scope, so that the registered names
+	// don't shadow anything in src. The newlines protect against the
+	// sources ending with comments. Decoding doesn't need the fields that
+	// aren't exported, and can take shortcuts without them.
+	program := "(" + src + "\n) | (" + prelude + "(" + contractSrc + "\n))"
+	expr, err := ctx.evalDeep(program, evalOptions{export: target != nil})
+	if err != nil {
+		return nil, blameError(err, target)
+	}
+	return expr, nil
+}
+
+// blameError wraps a Nickel error in a ContractError if it is a contract
+// violation.
+func blameError(err error, target reflect.Type) error {
+	var nickelErr *Error
+	if !errors.As(err, &nickelErr) {
+		return err
+	}
+
+	msg := nickelErr.message()
+	for _, pattern := range blamePatterns {
+		m := pattern.FindStringSubmatch(msg)
+		if m == nil {
+			continue
+		}
+
+		ret := &ContractError{Err: nickelErr}
+		if len(m) > 1 {
+			ret.Field = m[1]
+			if target != nil {
+				ret.GoFields = goFieldsNamed(target, m[1])
+			}
+		}
+		return ret
+	}
+	return err
+}
+
+// goFieldsNamed finds the paths of the struct fields reachable from t that
+// encoding/json would decode a field called name into.
+func goFieldsNamed(t reflect.Type, name string) []string {
+	var ret []string
+	var walk func(t reflect.Type, prefix string, seen map[reflect.Type]bool)
+	walk = func(t reflect.Type, prefix string, seen map[reflect.Type]bool) {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+			walk(t.Elem(), prefix+"[]", seen)
+			return
+		case reflect.Struct:
+		default:
+			return
+		}
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		for _, field := range reflect.VisibleFields(t) {
+			if !field.IsExported() || len(field.Index) > 1 {
+				continue
+			}
+			jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if jsonName == "-" {
+				continue
+			}
+			if field.Anonymous && jsonName == "" {
+				// Embedded struct fields are promoted by encoding/json.
+				walk(field.Type, prefix, seen)
+				continue
+			}
+			if jsonName == "" {
+				jsonName = field.Name
+			}
+
+			path := field.Name
+			if prefix != "" {
+				path = prefix + "." + field.Name
+			}
+			if strings.EqualFold(jsonName, name) {
+				ret = append(ret, path)
+			}
+			walk(field.Type, path, seen)
+		}
+	}
+	walk(t, "", map[reflect.Type]bool{})
+	return ret
+}
@@ -0,0 +1,116 @@
+package nickel
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+type serverConfig struct {
+	Name    string         `json:"name"`
+	Servers []serverEntry  `json:"servers"`
+	Limits  map[string]int `json:"limits"`
+}
+
+type serverEntry struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+const serverContract = `{
+	name | String,
+	servers | Array { host | String, port | std.number.Nat },
+	limits | { _ : Number },
+}`
+
+func TestDecodeWithContract(t *testing.T) {
+	ctx := NewContext()
+	config, err := DecodeWithContract[serverConfig](ctx, `{
+		name = "web",
+		servers = [{ host = "a", port = 80 }],
+		limits = { cpu = 2 },
+	}`, serverContract)
+	if err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if config.Name != "web" || len(config.Servers) != 1 || config.Servers[0].Port != 80 || config.Limits["cpu"] != 2 {
+		t.Fatalf("unexpected config: %+v", config)
+	}
+
+	_, err = DecodeWithContract[serverConfig](ctx, `{
+		name = "web",
+		servers = [{ host = "a", port = -1 }],
+		limits = {},
+	}`, serverContract)
+	var contractErr *ContractError
+	if !errors.As(err, &contractErr) {
+		t.Fatalf("expected a contract error, got %v", err)
+	}
+	if contractErr.Field != "port" || !slices.Equal(contractErr.GoFields, []string{"Servers[].Port"}) {
+		t.Fatalf("unexpected contract error: %q %q", contractErr.Field, contractErr.GoFields)
+	}
+
+	_, err = DecodeWithContract[serverConfig](ctx, `{ servers = [], limits = {} }`, serverContract)
+	if !errors.As(err, &contractErr) || contractErr.Field != "name" || !slices.Equal(contractErr.GoFields, []string{"Name"}) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = DecodeWithContract[serverConfig](ctx, `{ name = 1 + }`, serverContract)
+	if err == nil || errors.As(err, &contractErr) {
+		t.Fatalf("expected a non-contract error, got %v", err)
+	}
+}
+
+func TestRegisterContractSource(t *testing.T) {
+	ctx := NewContext()
+	if err := ctx.RegisterContractSource("Server", `{ host | String, port | std.number.Nat }`); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.RegisterContractSource("Config", `{ name | String, servers | Array Server, limits | { _ : Number } }`); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.RegisterContractSource("Broken", `{ x | Unknown }`); err == nil {
+		t.Error("expected an error for an unbound contract")
+	}
+	if err := ctx.RegisterContractSource("not a name", `Number`); err == nil {
+		t.Error("expected an error for an invalid name")
+	}
+
+	if err := ctx.Validate(`{ host = "a", port = 1 }`, "Server"); err != nil {
+		t.Errorf("validate error: %v", err)
+	}
+	var contractErr *ContractError
+	err := ctx.Validate(`{ host = 1, port = 1 }`, "Server")
+	if !errors.As(err, &contractErr) || contractErr.Field != "host" {
+		t.Errorf("expected a contract error for host, got %v", err)
+	}
+
+	// Registered names don't shadow the program's own variables.
+	expr, err := ctx.ApplyContract(`let Server = 1 in { host = "a", port = Server }`, "Server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := expr.EvalFieldDeep("port")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := port.ToInt64(); n != 1 {
+		t.Errorf("expected port 1, got %v", port)
+	}
+
+	config, err := DecodeWithContract[serverConfig](ctx, `{ name = "web", servers = [{ host = "a", port = 8 }], limits = {} }`, "Config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Servers[0].Port != 8 {
+		t.Errorf("unexpected config: %+v", config)
+	}
+
+	// Re-registering replaces the contract.
+	if err := ctx.RegisterContractSource("Server", `{ host | String, port | Number }`); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.Validate(`{ host = "a", port = -1 }`, "Server"); err != nil {
+		t.Errorf("validate error: %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package nickel
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CycleError reports fields whose values refer to each other in a loop, like
+// a.b in { a.b = c.d, c.d = a.b }, which Nickel reports as an infinite
+// recursion without saying which fields are involved. Check finds the
+// cycles of the programs it checks.
+type CycleError struct {
+	// Cycle lists the paths of the fields in the loop, each one referring
+	// to the next, and the last one to the first.
+	Cycle [][]string
+	// Err is the error from Nickel.
+	Err error
+}
+
+func (e *CycleError) Error() string {
+	paths := make([]string, 0, len(e.Cycle)+1)
+	for _, path := range e.Cycle {
+		paths = append(paths, FormatPath(path))
+	}
+	paths = append(paths, paths[0])
+	return "cycle: " + strings.Join(paths, " → ")
+}
+
+func (e *CycleError) Unwrap() error {
+	return e.Err
+}
+
+// isInfiniteRecursion reports whether err is Nickel's error for a value that
+// refers to itself.
+func isInfiniteRecursion(err error) bool {
+	var nickelErr *Error
+	if !errors.As(err, &nickelErr) {
+		return false
+	}
+	diagnostics := nickelErr.Diagnostics()
+	return len(diagnostics) > 0 && diagnostics[0].Message == "infinite recursion"
+}
+
+// The message of the values that successor puts in place of the fields.
+var cycleProbe = regexp.MustCompile(`go-nickel cycle probe (\d+)`)
+
+// cycle returns the cycle that failure i runs into, or nil if it isn't an
+// infinite recursion through record fields.
+//
+// The cycle is found by following the references from the failing field
+// (see successor) until a field comes up again.
+func (c *checker) cycle(i int) *CycleError {
+	var visited []int
+	for {
+		if j := slices.Index(visited, i); j >= 0 {
+			cycle := &CycleError{Err: c.failures[visited[0]].err}
+			for _, k := range visited[j:] {
+				cycle.Cycle = append(cycle.Cycle, c.failures[k].path)
+			}
+			// Start the cycle at the same field whichever way it was
+			// reached, so that it's reported once.
+			first := 0
+			for k, path := range cycle.Cycle {
+				if slices.Compare(path, cycle.Cycle[first]) < 0 {
+					first = k
+				}
+			}
+			cycle.Cycle = append(cycle.Cycle[first:], cycle.Cycle[:first]...)
+			return cycle
+		}
+		visited = append(visited, i)
+		next, ok := c.successor(i)
+		if !ok {
+			return nil
+		}
+		i = next
+	}
+}
+
+// successor returns the failure whose field the value of failure i refers
+// to first, among the fields that fail with an infinite recursion, or i
+// itself if it refers to itself first.
+//
+// The value is evaluated with the other fields replaced by a failure naming
+// them, by merging with force priority:
+//
+//	((<src>
+//	) & { b | force = std.fail_with "go-nickel cycle probe 1", ... }) |> std.record.get "a"
+//
+// so that the first one it refers to shows in the error.
+func (c *checker) successor(i int) (int, bool) {
+	if next, ok := c.successors[i]; ok {
+		return next, next >= 0
+	}
+	next := c.probe(i)
+	if c.successors == nil {
+		c.successors = map[int]int{}
+	}
+	c.successors[i] = next
+	return next, next >= 0
+}
+
+func (c *checker) probe(i int) int {
+	failure := c.failures[i]
+	if len(failure.path) == 0 || !failure.recordPath || !isInfiniteRecursion(failure.err) {
+		return -1
+	}
+
+	var b strings.Builder
+	b.WriteString(c.src)
+	b.WriteString(" & {")
+	for j, other := range c.failures {
+		if j == i || len(other.path) == 0 || !other.recordPath || !isInfiniteRecursion(other.err) {
+			continue
+		}
+		b.WriteString(" ")
+		for k, field := range other.path {
+			if k > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(QuoteIdent(field))
+		}
+		fmt.Fprintf(&b, " | force = std.fail_with \"go-nickel cycle probe %d\",", j)
+	}
+	b.WriteString(" })")
+	for _, field := range failure.path {
+		b.WriteString(" |> std.record.get ")
+		b.WriteString(QuoteString(field))
+	}
+
+	_, err := c.ctx.evalDeep(b.String(), evalOptions{scope: "(("})
+	if err == nil {
+		return -1
+	}
+	if isInfiniteRecursion(err) {
+		return i
+	}
+	m := cycleProbe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return -1
+	}
+	j, _ := strconv.Atoi(m[1])
+	return j
+}
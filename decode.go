@@ -0,0 +1,189 @@
+package nickel
+
+/*
+#include <nickel_lang.h>
+
+typedef struct {
+	int kind;
+	int b;
+	int is_i64;
+	int64_t i64;
+	double f64;
+	const char* str;
+	uintptr_t len;
+} plainInfo;
+
+void plainInfoOf(const nickel_expr* expr, plainInfo* info);
+int plainChild(const nickel_expr* expr, int kind, uintptr_t i, nickel_expr* out_expr,
+	const char** out_key, uintptr_t* out_key_len, plainInfo* info);
+*/
+import "C"
+
+import "unsafe"
+
+// convertPlain is the fast path of ConvertTo for the targets that
+// encoding/json would fill with plain maps, slices and scalars. It walks the
+// expression directly, and reports whether it handled the conversion.
+//
+// This is only correct for expressions that were evaluated for export,
+// since the others can have fields that JSON would leave out. Anything
+// that doesn't convert in the obvious way (like enum variants, which can't
+// be represented in JSON) is left to the slow path, so that errors are the
+// same either way.
+func convertPlain(expr *Expr, target any) bool {
+	if !expr.exported {
+		return false
+	}
+
+	switch target := target.(type) {
+	case *any:
+		if *target != nil {
+			// encoding/json would decode into the existing value.
+			return false
+		}
+		value, ok := plainValue(expr)
+		if ok {
+			*target = value
+		}
+		return ok
+	case *map[string]any:
+		if expr.kind == KindNull {
+			*target = nil
+			return true
+		}
+		if expr.kind != KindRecord {
+			return false
+		}
+		value, ok := plainValue(expr)
+		if !ok {
+			return false
+		}
+		if *target == nil {
+			*target = value.(map[string]any)
+			return true
+		}
+		// encoding/json adds to an existing map.
+		for k, v := range value.(map[string]any) {
+			(*target)[k] = v
+		}
+		return true
+	case *[]any:
+		if expr.kind == KindNull {
+			*target = nil
+			return true
+		}
+		if expr.kind != KindArray {
+			return false
+		}
+		value, ok := plainValue(expr)
+		if ok {
+			*target = value.([]any)
+		}
+		return ok
+	}
+	return false
+}
+
+// plainValue converts expr to the value that encoding/json would decode its
+// JSON serialization to. It returns false if there's no such value.
+func plainValue(expr *Expr) (any, bool) {
+	// Allocating an Expr for every value would be slower than going
+	// through JSON, so the walk uses one scratch native expression per
+	// level of nesting instead, and crosses into C once per value.
+	var w plainWalker
+	defer w.free()
+
+	var info C.plainInfo
+	C.plainInfoOf(expr.ptr, &info)
+	return w.value(expr.ptr, &info, 0)
+}
+
+type plainWalker struct {
+	scratch []*C.nickel_expr
+
+	// Record keys seen so far. Arrays of records usually repeat the same
+	// keys, and sharing the Go strings saves allocating them again for
+	// every record.
+	keys map[string]string
+}
+
+// At most this many keys are interned, so that records used as big maps
+// don't fill the table with keys that never repeat.
+const maxInternedKeys = 1024
+
+// key returns a Go string for a record key.
+func (w *plainWalker) key(ptr *C.char, n C.uintptr_t) string {
+	b := unsafe.Slice((*byte)(unsafe.Pointer(ptr)), int(n))
+	// This lookup doesn't allocate.
+	if key, ok := w.keys[string(b)]; ok {
+		return key
+	}
+	key := string(b)
+	if w.keys == nil {
+		w.keys = map[string]string{}
+	}
+	if len(w.keys) < maxInternedKeys {
+		w.keys[key] = key
+	}
+	return key
+}
+
+func (w *plainWalker) free() {
+	for _, ptr := range w.scratch {
+		freeExpr(ptr)
+	}
+}
+
+func (w *plainWalker) at(depth int) *C.nickel_expr {
+	if depth == len(w.scratch) {
+		w.scratch = append(w.scratch, allocExpr())
+	}
+	return w.scratch[depth]
+}
+
+func (w *plainWalker) value(ptr *C.nickel_expr, info *C.plainInfo, depth int) (any, bool) {
+	switch Kind(info.kind) {
+	case KindNull:
+		return nil, true
+	case KindBool:
+		return info.b != 0, true
+	case KindNumber:
+		if info.is_i64 != 0 {
+			return float64(info.i64), true
+		}
+		return float64(info.f64), true
+	case KindString, KindEnumTag:
+		return C.GoStringN(info.str, C.int(info.len)), true
+	case KindRecord:
+		ret := make(map[string]any, int(info.len))
+		child := w.at(depth)
+		var childInfo C.plainInfo
+		for i := range info.len {
+			var key *C.char
+			var keyLen C.uintptr_t
+			if C.plainChild(ptr, info.kind, i, child, &key, &keyLen, &childInfo) == 0 {
+				continue
+			}
+			value, ok := w.value(child, &childInfo, depth+1)
+			if !ok {
+				return nil, false
+			}
+			ret[w.key(key, keyLen)] = value
+		}
+		return ret, true
+	case KindArray:
+		ret := make([]any, int(info.len))
+		child := w.at(depth)
+		var childInfo C.plainInfo
+		for i := range info.len {
+			C.plainChild(ptr, info.kind, i, child, nil, nil, &childInfo)
+			value, ok := w.value(child, &childInfo, depth+1)
+			if !ok {
+				return nil, false
+			}
+			ret[i] = value
+		}
+		return ret, true
+	}
+	return nil, false
+}
@@ -0,0 +1,132 @@
+package nickel
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+func TestConvertPlain(t *testing.T) {
+	ctx := NewContext()
+	sources := []string{
+		`null`,
+		`true`,
+		`1 / 3`,
+		`12345678901234567890`,
+		`"x"`,
+		`'Foo`,
+		`[1, "a", [null], { b = 'Bar }]`,
+		`{ a | not_exported = 1, b = { c | not_exported = 2, d = 3 }, e | optional, f = [] }`,
+		`{ a = 'Foo 1 }`,
+	}
+
+	for _, src := range sources {
+		expr, err := ctx.evalDeep(src, evalOptions{export: true})
+		if err != nil {
+			t.Fatalf("%s: eval error: %v", src, err)
+		}
+		data, jsonErr := expr.MarshalJSON()
+
+		targets := []func() any{
+			func() any { return new(any) },
+			func() any { return new(map[string]any) },
+			func() any { return new([]any) },
+			func() any { return &map[string]any{"existing": true} },
+		}
+		for _, target := range targets {
+			want := target()
+			wantErr := jsonErr
+			if wantErr == nil {
+				wantErr = json.Unmarshal(data, want)
+			}
+			got := target()
+			err := expr.ConvertTo(got)
+			if (err != nil) != (wantErr != nil) {
+				t.Errorf("%s: got error %v, want %v", src, err, wantErr)
+				continue
+			}
+			if err == nil && !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: got %#v, want %#v", src, got, want)
+			}
+		}
+	}
+}
+
+func TestDecodeNotExported(t *testing.T) {
+	record, err := Decode[map[string]any](`{ a | not_exported = 1, b = 2 }`)
+	if err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if !reflect.DeepEqual(record, map[string]any{"b": 2.0}) {
+		t.Errorf("unexpected record: %v", record)
+	}
+}
+
+func TestConvertPlainInternsKeys(t *testing.T) {
+	expr, err := DefaultContext().evalDeep(`std.array.generate (fun i => { id = i, name = "row" }) 3`, evalOptions{export: true})
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	var rows []any
+	if err := expr.ConvertTo(&rows); err != nil {
+		t.Fatalf("convert error: %v", err)
+	}
+
+	data := map[string]*byte{}
+	for _, row := range rows {
+		for key := range row.(map[string]any) {
+			if ptr, ok := data[key]; ok && ptr != unsafe.StringData(key) {
+				t.Errorf("key %q was allocated again", key)
+			}
+			data[key] = unsafe.StringData(key)
+		}
+	}
+	if len(data) != 2 {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+}
+
+func BenchmarkDecodeMap(b *testing.B) {
+	src := `std.array.generate (fun i => { name = "item %{std.to_string i}", values = std.array.range 0 20 }) 200`
+	expr, err := DefaultContext().evalDeep(src, evalOptions{export: true})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("direct", func(b *testing.B) {
+		for b.Loop() {
+			var v any
+			if err := expr.ConvertTo(&v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("json", func(b *testing.B) {
+		for b.Loop() {
+			var v any
+			data, err := expr.MarshalJSON()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := json.Unmarshal(data, &v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkDecodeRows(b *testing.B) {
+	src := `std.array.generate (fun i => { id = i, name = "row", score = i / 2, active = true, region = "eu", tags = [] }) 10000`
+	expr, err := DefaultContext().evalDeep(src, evalOptions{export: true})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		var v []any
+		if err := expr.ConvertTo(&v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
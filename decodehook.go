@@ -0,0 +1,272 @@
+package nickel
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A DecodeHook rewrites a value being converted by ConvertTo (or Decode,
+// DecodeWithContract and the like) into a form that encoding/json can decode
+// into the Go type target, so that configurations can follow conventions of
+// their own, like durations written as "10s". See Context.SetDecodeHooks.
+//
+// The value is given as encoding/json decodes JSON with UseNumber: nil, a
+// bool, a json.Number, a string, an []any or a map[string]any. A hook
+// returns the value to decode instead, or the value itself if it doesn't
+// apply.
+type DecodeHook func(target reflect.Type, value any) (any, error)
+
+// SetDecodeHooks sets the hooks that ConvertTo applies to the values it
+// converts, in order, replacing the ones set before. The hooks are given
+// every value that converts to a Go type known from the target: the fields
+// of structs, and the elements of slices, arrays and maps, but not the parts
+// of values that convert to interfaces, or to types that implement
+// json.Unmarshaler or encoding.TextUnmarshaler themselves.
+//
+// For example, SetDecodeHooks(DurationHook, ByteSizeHook) decodes "10s" into
+// a time.Duration field, and "512Mi" into an int64 field.
+//
+// Conversions with hooks always go through JSON, so they're slower than
+// conversions without them.
+func (ctx *Context) SetDecodeHooks(hooks ...DecodeHook) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.decodeHooks = slices.Clone(hooks)
+}
+
+func (ctx *Context) decodeHookList() []DecodeHook {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.decodeHooks
+}
+
+// DurationHook is a DecodeHook that decodes strings like "10s" or "1h30m",
+// in the format of time.ParseDuration, into time.Duration values. Numbers
+// are decoded as nanoseconds, as without the hook.
+func DurationHook(target reflect.Type, value any) (any, error) {
+	s, ok := value.(string)
+	if !ok || target != reflect.TypeFor[time.Duration]() {
+		return value, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, err
+	}
+	return json.Number(fmt.Sprint(int64(d))), nil
+}
+
+// ByteSizeHook is a DecodeHook that decodes byte sizes like "512Mi" or "2GB"
+// into integer types (other than time.Duration) as numbers of bytes.
+//
+// A size is a number followed by an optional unit, possibly separated by a
+// space. The units are B for bytes, the decimal units k (or K), M, G, T, P
+// and E, which are powers of 1000, and the binary units Ki, Mi, Gi, Ti, Pi
+// and Ei, which are powers of 1024, all with an optional B suffix. The
+// number can have a fractional part, as in "1.5Gi", if the size is a whole
+// number of bytes.
+func ByteSizeHook(target reflect.Type, value any) (any, error) {
+	s, ok := value.(string)
+	if !ok || target == reflect.TypeFor[time.Duration]() {
+		return value, nil
+	}
+	switch target.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+	default:
+		return value, nil
+	}
+	n, err := parseByteSize(s)
+	if err != nil {
+		return nil, err
+	}
+	return json.Number(n.String()), nil
+}
+
+var byteSizeExpr = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?) ?([kKMGTPE]i?)?B?$`)
+
+// parseByteSize parses a size for ByteSizeHook.
+func parseByteSize(s string) (*big.Int, error) {
+	m := byteSizeExpr.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("invalid byte size %q", s)
+	}
+	size, _ := new(big.Rat).SetString(m[1])
+	if unit := m[2]; unit != "" {
+		base := int64(1000)
+		if strings.HasSuffix(unit, "i") {
+			base = 1024
+		}
+		exp := int64(strings.IndexByte("KMGTPE", strings.ToUpper(unit)[0]) + 1)
+		scale := new(big.Int).Exp(big.NewInt(base), big.NewInt(exp), nil)
+		size.Mul(size, new(big.Rat).SetInt(scale))
+	}
+	if !size.IsInt() {
+		return nil, fmt.Errorf("byte size %q isn't a whole number of bytes", s)
+	}
+	return size.Num(), nil
+}
+
+// convertWithHooks implements ConvertTo for contexts with decode hooks.
+func (expr *Expr) convertWithHooks(target any, hooks []DecodeHook) error {
+	data, err := expr.serialize(serializeJSON)
+	if err != nil {
+		return err
+	}
+	value, err := decodeExported(data)
+	if err != nil {
+		return err
+	}
+	t := reflect.TypeOf(target)
+	if t == nil || t.Kind() != reflect.Pointer {
+		// Leave the error to encoding/json.
+		return json.Unmarshal(data, target)
+	}
+	value, err = applyDecodeHooks(t.Elem(), value, hooks, nil)
+	if err != nil {
+		return err
+	}
+	if data, err = json.Marshal(value); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// applyDecodeHooks applies the hooks to value, which is decoded into a t,
+// and to its parts. path is the path to value, for errors.
+func applyDecodeHooks(t reflect.Type, value any, hooks []DecodeHook, path []string) (any, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	for _, hook := range hooks {
+		var err error
+		if value, err = hook(t, value); err != nil {
+			if len(path) == 0 {
+				return nil, err
+			}
+			return nil, fmt.Errorf("decoding %s: %w", FormatPath(path), err)
+		}
+	}
+	ptr := reflect.PointerTo(t)
+	if ptr.Implements(jsonUnmarshalerType) || ptr.Implements(textUnmarshalerType) {
+		return value, nil
+	}
+
+	var err error
+	child := func(t reflect.Type, key string, value any) any {
+		if err != nil {
+			return value
+		}
+		var ret any
+		ret, err = applyDecodeHooks(t, value, hooks, append(path[:len(path):len(path)], key))
+		return ret
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return value, nil
+		}
+		fields := jsonFields(t)
+		ret := make(map[string]any, len(obj))
+		for key, v := range obj {
+			if field, ok := fields.lookup(key); ok {
+				v = child(field, key, v)
+			}
+			ret[key] = v
+		}
+		return ret, err
+	case reflect.Map:
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return value, nil
+		}
+		ret := make(map[string]any, len(obj))
+		for key, v := range obj {
+			ret[key] = child(t.Elem(), key, v)
+		}
+		return ret, err
+	case reflect.Slice, reflect.Array:
+		arr, ok := value.([]any)
+		if !ok {
+			return value, nil
+		}
+		ret := make([]any, len(arr))
+		for i, v := range arr {
+			ret[i] = child(t.Elem(), fmt.Sprint(i), v)
+		}
+		return ret, err
+	default:
+		return value, nil
+	}
+}
+
+// fieldTypes are the types of the fields of a struct, by the names that
+// encoding/json decodes them from.
+type fieldTypes map[string]reflect.Type
+
+// lookup finds the field that encoding/json decodes key into: the one with
+// that name, or else one whose name matches it ignoring case.
+func (fields fieldTypes) lookup(key string) (reflect.Type, bool) {
+	if t, ok := fields[key]; ok {
+		return t, true
+	}
+	for name, t := range fields {
+		if strings.EqualFold(name, key) {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+var jsonFieldCache sync.Map // reflect.Type -> fieldTypes
+
+func jsonFields(t reflect.Type) fieldTypes {
+	if fields, ok := jsonFieldCache.Load(t); ok {
+		return fields.(fieldTypes)
+	}
+	fields := fieldTypes{}
+	// Like in Go, shallower fields take precedence over deeper ones.
+	depths := map[string]int{}
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			// The fields of embedded structs are promoted, and visible
+			// on their own.
+			ft := field.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				continue
+			}
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if depth, ok := depths[name]; !ok || len(field.Index) < depth {
+			fields[name] = field.Type
+			depths[name] = len(field.Index)
+		}
+	}
+	jsonFieldCache.Store(t, fields)
+	return fields
+}
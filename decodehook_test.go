@@ -0,0 +1,120 @@
+package nickel
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeHooks(t *testing.T) {
+	type Limits struct {
+		Memory int64 `json:"memory"`
+		Disk   uint64
+	}
+	type Service struct {
+		Timeout  time.Duration            `json:"timeout"`
+		Retry    *time.Duration           `json:"retry"`
+		Backoffs []time.Duration          `json:"backoffs"`
+		Stages   map[string]time.Duration `json:"stages"`
+		Limits
+		Raw any `json:"raw"`
+	}
+
+	ctx := NewContext()
+	ctx.SetDecodeHooks(DurationHook, ByteSizeHook)
+	expr, err := ctx.EvalDeep(`{
+		timeout = "1m30s",
+		retry = "250ms",
+		backoffs = ["1s", 2000000000],
+		stages = { build = "5m" },
+		memory = "512Mi",
+		disk = "2GB",
+		raw = "10s",
+	}`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	var svc Service
+	if err := expr.ConvertTo(&svc); err != nil {
+		t.Fatalf("convert error: %v", err)
+	}
+	retry := 250 * time.Millisecond
+	want := Service{
+		Timeout:  90 * time.Second,
+		Retry:    &retry,
+		Backoffs: []time.Duration{time.Second, 2 * time.Second},
+		Stages:   map[string]time.Duration{"build": 5 * time.Minute},
+		Limits:   Limits{Memory: 512 << 20, Disk: 2_000_000_000},
+		// Hooks don't apply to interfaces.
+		Raw: "10s",
+	}
+	if !reflect.DeepEqual(svc, want) {
+		t.Errorf("expected %+v, got %+v", want, svc)
+	}
+
+	expr, _ = ctx.EvalDeep(`{ stages = { build = "5 minutes" } }`)
+	if err := expr.ConvertTo(&svc); err == nil || !strings.Contains(err.Error(), "stages.build") {
+		t.Errorf("expected an error naming the field, got %v", err)
+	}
+
+	// Without hooks, strings don't decode into numbers.
+	expr, _ = NewContext().EvalDeep(`{ timeout = "1s" }`)
+	if err := expr.ConvertTo(&svc); err == nil {
+		t.Error("expected an error without hooks")
+	}
+}
+
+func TestByteSizeHook(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"512", 512},
+		{"512B", 512},
+		{"1k", 1000},
+		{"1KB", 1000},
+		{"1Ki", 1024},
+		{"512Mi", 512 << 20},
+		{"1.5Gi", 3 << 29},
+		{"2 GB", 2_000_000_000},
+		{"1EiB", 1 << 60},
+	}
+	target := reflect.TypeFor[int64]()
+	for _, tt := range tests {
+		got, err := ByteSizeHook(target, tt.in)
+		if err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+		if got, _ := got.(json.Number).Int64(); got != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.in, tt.want, got)
+		}
+	}
+
+	for _, in := range []string{"", "1.5B", "-1Mi", "1mb", "1 Mi B", "Mi"} {
+		if _, err := ByteSizeHook(target, in); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+
+	// Other types and values are left alone.
+	if got, err := ByteSizeHook(reflect.TypeFor[string](), "1Ki"); err != nil || got != "1Ki" {
+		t.Errorf("expected strings to be left alone, got %v (%v)", got, err)
+	}
+	if got, err := ByteSizeHook(reflect.TypeFor[time.Duration](), "1Ki"); err != nil || got != "1Ki" {
+		t.Errorf("expected durations to be left alone, got %v (%v)", got, err)
+	}
+
+	ctx := NewContext()
+	ctx.SetDecodeHooks(ByteSizeHook)
+	expr, _ := ctx.EvalDeep(`"1Ki"`)
+	var small int8
+	var typeErr *json.UnmarshalTypeError
+	if err := expr.ConvertTo(&small); !errors.As(err, &typeErr) {
+		t.Errorf("expected an overflow error, got %v", err)
+	}
+}
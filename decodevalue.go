@@ -0,0 +1,632 @@
+package nickel
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Decode converts an Expr into target, which must be a non-nil pointer, like
+// ConvertTo, but without going through JSON: the value is read through the
+// C API and stored into target directly, which saves serializing and
+// parsing it. It follows the rules of encoding/json, and gives the same
+// results, except that:
+//
+//   - struct fields are named after their nickel tag, like
+//     `nickel:"name"`, if they have one, and otherwise after their json
+//     tag. A nickel tag of "-" skips the field. Options after the name, like
+//     omitempty, are accepted and ignored,
+//   - integers are decoded exactly, and so are the numbers decoded into a
+//     big.Int (which must be integers) or a big.Rat, rather than rounded
+//     to a float64 first,
+//   - *Expr fields get the part of the value they correspond to as is,
+//     rather than a copy in the default context,
+//   - unevaluated parts of the value, which ConvertTo can't serialize, are
+//     evaluated as needed,
+//   - fields marked not_exported are decoded like the others, unless the
+//     value was evaluated for export (see Context.EvalExport),
+//   - decoding stops at the first value that doesn't fit its target.
+//
+// Types that implement json.Unmarshaler are given their part of the value as
+// JSON. The context's decode hooks (see Context.SetDecodeHooks) are given
+// the values as JSON values too, but not null, and a value that a hook
+// rewrites is decoded from JSON, with its struct fields named after their
+// json tags.
+//
+// Record fields are matched to struct fields whose names only differ in
+// case if none match exactly, and record fields that don't match any struct
+// field are ignored. See DecodeWithOptions to change that.
+func (expr *Expr) Decode(target any) error {
+	return expr.DecodeWithOptions(target, DecodeOptions{CaseInsensitive: true})
+}
+
+// DecodeOptions customize Expr.DecodeWithOptions.
+type DecodeOptions struct {
+	// CaseInsensitive matches record fields to the struct fields whose
+	// names only differ in case, when none match exactly, like
+	// encoding/json does.
+	CaseInsensitive bool
+
+	// ErrorOnUnknownFields makes decoding fail on record fields that don't
+	// match any struct field, rather than ignoring them, to catch typos in
+	// configurations.
+	ErrorOnUnknownFields bool
+}
+
+// DecodeWithOptions is like Decode, with options. Decode uses the zero
+// options, except for CaseInsensitive.
+func (expr *Expr) DecodeWithOptions(target any, opts DecodeOptions) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("can't decode into %T: it isn't a non-nil pointer", target)
+	}
+	if err := expr.checkNumbers(); err != nil {
+		return err
+	}
+	d := decoder{opts: opts, hooks: expr.ctx.decodeHookList()}
+	return d.decode(nil, expr, v.Elem())
+}
+
+var (
+	exprType    = reflect.TypeFor[*Expr]()
+	bigIntType  = reflect.TypeFor[big.Int]()
+	bigRatType  = reflect.TypeFor[big.Rat]()
+	jsonNumType = reflect.TypeFor[json.Number]()
+)
+
+// decoder implements DecodeWithOptions.
+type decoder struct {
+	opts  DecodeOptions
+	hooks []DecodeHook
+}
+
+// decode decodes the value expr at path into the settable v.
+func (d *decoder) decode(path []string, expr *Expr, v reflect.Value) error {
+	expr, err := expr.force()
+	if err != nil {
+		return pathError(path, err)
+	}
+	if expr.kind == KindFunction {
+		return pathError(path, fmt.Errorf("can't decode a function"))
+	}
+
+	if expr.kind == KindNull {
+		switch v.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+			v.SetZero()
+		}
+		return nil
+	}
+	if v.Type() == exprType {
+		v.Set(reflect.ValueOf(expr))
+		return nil
+	}
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decode(path, expr, v.Elem())
+	}
+	if len(d.hooks) > 0 {
+		if done, err := d.applyHooks(path, expr, v); done || err != nil {
+			return err
+		}
+	}
+
+	switch v.Type() {
+	case bigIntType, bigRatType:
+		if expr.kind != KindNumber {
+			return kindError(path, KindNumber, expr, v.Type())
+		}
+		r, _ := new(big.Rat).SetString(expr.rational())
+		if v.Type() == bigRatType {
+			v.Set(reflect.ValueOf(r).Elem())
+			return nil
+		}
+		if !r.IsInt() {
+			return pathError(path, fmt.Errorf("can't decode %s into %s: it isn't an integer", r.RatString(), v.Type()))
+		}
+		v.Set(reflect.ValueOf(r.Num()).Elem())
+		return nil
+	case jsonNumType:
+		if expr.kind != KindNumber {
+			return kindError(path, KindNumber, expr, v.Type())
+		}
+		data, err := expr.serialize(serializeJSON)
+		if err != nil {
+			return pathError(path, err)
+		}
+		v.SetString(string(data))
+		return nil
+	}
+
+	if v.CanAddr() && v.Addr().Type().Implements(jsonUnmarshalerType) {
+		data, err := expr.serialize(serializeJSON)
+		if err != nil {
+			return pathError(path, err)
+		}
+		if err := v.Addr().Interface().(json.Unmarshaler).UnmarshalJSON(data); err != nil {
+			return pathError(path, err)
+		}
+		return nil
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		s, ok := decodeString(expr)
+		if !ok {
+			return kindError(path, KindString, expr, v.Type())
+		}
+		if err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return pathError(path, err)
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.NumMethod() > 0 {
+			return pathError(path, fmt.Errorf("can't decode into %s", v.Type()))
+		}
+		value, err := anyValue(path, expr, false)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			v.SetZero()
+		} else {
+			v.Set(reflect.ValueOf(value))
+		}
+		return nil
+	case reflect.Bool:
+		b, ok := expr.ToBool()
+		if !ok {
+			return kindError(path, KindBool, expr, v.Type())
+		}
+		v.SetBool(b)
+		return nil
+	case reflect.String:
+		s, ok := decodeString(expr)
+		if !ok {
+			return kindError(path, KindString, expr, v.Type())
+		}
+		v.SetString(s)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := decodeInteger(path, expr, v.Type())
+		if err != nil {
+			return err
+		}
+		if !n.IsInt64() || v.OverflowInt(n.Int64()) {
+			return pathError(path, fmt.Errorf("can't decode %s into %s: it's out of range", n, v.Type()))
+		}
+		v.SetInt(n.Int64())
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := decodeInteger(path, expr, v.Type())
+		if err != nil {
+			return err
+		}
+		if !n.IsUint64() || v.OverflowUint(n.Uint64()) {
+			return pathError(path, fmt.Errorf("can't decode %s into %s: it's out of range", n, v.Type()))
+		}
+		v.SetUint(n.Uint64())
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, ok := expr.ToFloat64()
+		if !ok {
+			return kindError(path, KindNumber, expr, v.Type())
+		}
+		if v.OverflowFloat(f) {
+			return pathError(path, fmt.Errorf("can't decode %v into %s: it's out of range", f, v.Type()))
+		}
+		v.SetFloat(f)
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 && expr.kind == KindString {
+			// Like encoding/json, byte slices are decoded from base64.
+			s, _ := expr.ToString()
+			data, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return pathError(path, err)
+			}
+			v.SetBytes(data)
+			return nil
+		}
+		elems, ok := expr.ToArray()
+		if !ok {
+			return kindError(path, KindArray, expr, v.Type())
+		}
+		if v.Cap() >= len(elems) {
+			v.SetLen(len(elems))
+		} else {
+			v.Set(reflect.MakeSlice(v.Type(), len(elems), len(elems)))
+		}
+		for i, elem := range elems {
+			if err := d.decode(append(path, strconv.Itoa(i)), elem, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Array:
+		elems, ok := expr.ToArray()
+		if !ok {
+			return kindError(path, KindArray, expr, v.Type())
+		}
+		for i := range v.Len() {
+			if i >= len(elems) {
+				v.Index(i).SetZero()
+				continue
+			}
+			if err := d.decode(append(path, strconv.Itoa(i)), elems[i], v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		fields, ok := expr.ToRecord()
+		if !ok {
+			return kindError(path, KindRecord, expr, v.Type())
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(v.Type(), len(fields)))
+		}
+		for _, name := range sortedKeys(fields) {
+			if fields[name] == nil {
+				continue
+			}
+			key, err := mapKey(name, v.Type().Key())
+			if err != nil {
+				return pathError(append(path, name), err)
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := d.decode(append(path, name), fields[name], elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+		return nil
+	case reflect.Struct:
+		fields, ok := expr.ToRecord()
+		if !ok {
+			return kindError(path, KindRecord, expr, v.Type())
+		}
+		goFields := structFields(v.Type())
+		if d.opts.ErrorOnUnknownFields {
+			for _, name := range sortedKeys(fields) {
+				if _, ok := goFields.lookup(name, d.opts.CaseInsensitive); !ok {
+					return pathError(path, fmt.Errorf("unknown field %s for %s", FormatPath([]string{name}), v.Type()))
+				}
+			}
+		}
+		for _, name := range sortedKeys(fields) {
+			if fields[name] == nil {
+				continue
+			}
+			field, ok := goFields.lookup(name, d.opts.CaseInsensitive)
+			if !ok {
+				continue
+			}
+			dest, err := fieldByIndex(v, field.index)
+			if err != nil {
+				return pathError(append(path, name), err)
+			}
+			if err := d.decode(append(path, name), fields[name], dest); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return pathError(path, fmt.Errorf("can't decode into %s", v.Type()))
+}
+
+// applyHooks gives the value expr at path to the decode hooks, for decoding
+// into v. If a hook rewrites it, or fails, applyHooks decodes the value into
+// v from JSON instead, after applying the hooks to it and its parts like
+// ConvertTo does, and reports that it's done.
+func (d *decoder) applyHooks(path []string, expr *Expr, v reflect.Value) (bool, error) {
+	value, err := anyValue(path, expr, true)
+	if err != nil {
+		return false, err
+	}
+	rewritten := value
+	for _, hook := range d.hooks {
+		if rewritten, err = hook(v.Type(), rewritten); err != nil {
+			break
+		}
+	}
+	if err == nil && reflect.DeepEqual(rewritten, value) {
+		return false, nil
+	}
+
+	if value, err = applyDecodeHooks(v.Type(), value, d.hooks, path); err != nil {
+		return false, err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false, pathError(path, err)
+	}
+	if err := json.Unmarshal(data, v.Addr().Interface()); err != nil {
+		return false, pathError(path, err)
+	}
+	return true, nil
+}
+
+// pathError adds the path of the value being decoded to err.
+func pathError(path []string, err error) error {
+	if len(path) == 0 {
+		return err
+	}
+	return fmt.Errorf("%s: %w", FormatPath(path), err)
+}
+
+// kindError reports that expr doesn't have the kind want needed to decode
+// it into t.
+func kindError(path []string, want Kind, expr *Expr, t reflect.Type) error {
+	return pathError(path, fmt.Errorf("can't decode into %s: %w", t, &KindError{Want: want, Got: expr.kind}))
+}
+
+// decodeString returns the string that expr is exported as, if any: enum
+// tags are exported as strings.
+func decodeString(expr *Expr) (string, bool) {
+	if s, ok := expr.ToString(); ok {
+		return s, true
+	}
+	return expr.ToEnumTag()
+}
+
+// decodeInteger returns the value of expr, which must be an integer, to
+// decode it into t.
+func decodeInteger(path []string, expr *Expr, t reflect.Type) (*big.Int, error) {
+	if i, ok := expr.ToInt64(); ok {
+		return big.NewInt(i), nil
+	}
+	if expr.kind != KindNumber {
+		return nil, kindError(path, KindNumber, expr, t)
+	}
+	r, _ := new(big.Rat).SetString(expr.rational())
+	if !r.IsInt() {
+		return nil, pathError(path, fmt.Errorf("can't decode %s into %s: it isn't an integer", r.RatString(), t))
+	}
+	return r.Num(), nil
+}
+
+// anyValue returns the value that encoding/json would decode the JSON of
+// expr into, as an any, with numbers as json.Number if useNumber is set.
+func anyValue(path []string, expr *Expr, useNumber bool) (any, error) {
+	expr, err := expr.force()
+	if err != nil {
+		return nil, pathError(path, err)
+	}
+	switch expr.kind {
+	case KindNull:
+		return nil, nil
+	case KindBool:
+		b, _ := expr.ToBool()
+		return b, nil
+	case KindNumber:
+		if useNumber {
+			data, err := expr.serialize(serializeJSON)
+			if err != nil {
+				return nil, pathError(path, err)
+			}
+			return json.Number(data), nil
+		}
+		f, _ := expr.ToFloat64()
+		return f, nil
+	case KindString, KindEnumTag:
+		s, _ := decodeString(expr)
+		return s, nil
+	case KindArray:
+		elems, _ := expr.ToArray()
+		ret := make([]any, len(elems))
+		for i, elem := range elems {
+			if ret[i], err = anyValue(append(path, strconv.Itoa(i)), elem, useNumber); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+	case KindRecord:
+		fields, _ := expr.ToRecord()
+		ret := make(map[string]any, len(fields))
+		for name, field := range fields {
+			if field == nil {
+				continue
+			}
+			if ret[name], err = anyValue(append(path, name), field, useNumber); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+	case KindEnumVariant:
+		return nil, pathError(path, fmt.Errorf("can't decode an enum variant"))
+	}
+	return nil, pathError(path, fmt.Errorf("can't decode a function"))
+}
+
+// mapKey converts a record field name to a key of type t, like
+// encoding/json does for the keys of objects.
+func mapKey(name string, t reflect.Type) (reflect.Value, error) {
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		key := reflect.New(t)
+		if err := key.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(name)); err != nil {
+			return reflect.Value{}, err
+		}
+		return key.Elem(), nil
+	}
+	key := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		key.SetString(name)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(name, 10, 64)
+		if err != nil || key.OverflowInt(n) {
+			return reflect.Value{}, fmt.Errorf("can't decode key %q into %s", name, t)
+		}
+		key.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(name, 10, 64)
+		if err != nil || key.OverflowUint(n) {
+			return reflect.Value{}, fmt.Errorf("can't decode key %q into %s", name, t)
+		}
+		key.SetUint(n)
+	default:
+		return reflect.Value{}, fmt.Errorf("can't decode into a map with %s keys", t)
+	}
+	return key, nil
+}
+
+// fieldByIndex returns the field of the struct v with the given index,
+// allocating the embedded structs it goes through if needed.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("can't set embedded pointer to unexported struct %s", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+// goField is a struct field that record fields decode into.
+type goField struct {
+	name  string
+	index []int
+}
+
+// goFields are the fields of a struct type, in the order of the struct.
+type goFields []goField
+
+// lookup returns the field that a field called name decodes into: the one
+// with that name, or else, if fold is set, the first one whose name only
+// differs in case, as with encoding/json.
+func (fields goFields) lookup(name string, fold bool) (goField, bool) {
+	for _, field := range fields {
+		if field.name == name {
+			return field, true
+		}
+	}
+	if !fold {
+		return goField{}, false
+	}
+	for _, field := range fields {
+		if strings.EqualFold(field.name, name) {
+			return field, true
+		}
+	}
+	return goField{}, false
+}
+
+var structFieldCache sync.Map // map[reflect.Type]goFields
+
+// structFields returns the fields of the struct type t that can be decoded
+// into, following the rules of encoding/json: fields are named after their
+// nickel or json tag, the fields of embedded structs are promoted, and of
+// several fields with the same name, the least nested one wins, or the one
+// with a tag among those, or none of them.
+func structFields(t reflect.Type) goFields {
+	if cached, ok := structFieldCache.Load(t); ok {
+		return cached.(goFields)
+	}
+
+	type candidate struct {
+		goField
+		depth  int
+		tagged bool
+	}
+	var candidates []candidate
+	var walk func(t reflect.Type, index []int, seen map[reflect.Type]bool)
+	walk = func(t reflect.Type, index []int, seen map[reflect.Type]bool) {
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		for i := range t.NumField() {
+			field := t.Field(i)
+			tag, ok := field.Tag.Lookup("nickel")
+			if !ok {
+				tag = field.Tag.Get("json")
+			}
+			if tag == "-" {
+				continue
+			}
+			name, _, _ := strings.Cut(tag, ",")
+			fieldIndex := append(index[:len(index):len(index)], i)
+			if field.Anonymous && name == "" {
+				embedded := field.Type
+				if embedded.Kind() == reflect.Pointer {
+					embedded = embedded.Elem()
+				}
+				if embedded.Kind() == reflect.Struct {
+					// The exported fields of unexported embedded structs
+					// are promoted too.
+					walk(embedded, fieldIndex, seen)
+					continue
+				}
+			}
+			if !field.IsExported() {
+				continue
+			}
+			tagged := name != ""
+			if !tagged {
+				name = field.Name
+			}
+			candidates = append(candidates, candidate{
+				goField: goField{name: name, index: fieldIndex},
+				depth:   len(fieldIndex),
+				tagged:  tagged,
+			})
+		}
+	}
+	walk(t, nil, map[reflect.Type]bool{})
+
+	byName := map[string][]candidate{}
+	var names []string
+	for _, c := range candidates {
+		if byName[c.name] == nil {
+			names = append(names, c.name)
+		}
+		byName[c.name] = append(byName[c.name], c)
+	}
+	var fields goFields
+	for _, name := range names {
+		group := byName[name]
+		minDepth := group[0].depth
+		for _, c := range group {
+			minDepth = min(minDepth, c.depth)
+		}
+		var winners []candidate
+		for _, c := range group {
+			if c.depth == minDepth {
+				winners = append(winners, c)
+			}
+		}
+		if len(winners) > 1 {
+			var tagged []candidate
+			for _, c := range winners {
+				if c.tagged {
+					tagged = append(tagged, c)
+				}
+			}
+			winners = tagged
+		}
+		if len(winners) == 1 {
+			fields = append(fields, winners[0].goField)
+		}
+	}
+
+	cached, _ := structFieldCache.LoadOrStore(t, fields)
+	return cached.(goFields)
+}
@@ -0,0 +1,191 @@
+package nickel
+
+import (
+	"math/big"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type decodeBase struct {
+	ID   int    `json:"id"`
+	Kind string `json:"kind"`
+}
+
+type decodeTarget struct {
+	decodeBase
+	Name     string           `json:"name"`
+	Port     uint16           `json:"port"`
+	Ratio    float64          `json:"ratio"`
+	Enabled  *bool            `json:"enabled"`
+	Tags     []string         `json:"tags"`
+	Pair     [3]int           `json:"pair"`
+	Limits   map[string]int64 `json:"limits"`
+	Codes    map[int]string   `json:"codes"`
+	Extra    any              `json:"extra"`
+	Started  time.Time        `json:"started"`
+	Addr     netip.Addr       `json:"addr"`
+	Level    string           `json:"level"`
+	Data     []byte           `json:"data"`
+	Nested   *decodeBase      `json:"nested"`
+	Ignored  string           `json:"-"`
+	Untagged string
+	Optional map[string]string `json:"optional"`
+}
+
+const decodeSrc = `{
+	id = 7,
+	kind = "server",
+	name = "srv",
+	port = 8080,
+	ratio = 1 / 4,
+	enabled = true,
+	tags = ["a", "b"],
+	pair = [1, 2],
+	limits = { cpu = 2, memory = 4294967296 },
+	codes = { "404" = "not found" },
+	extra = { list = [1, "x", null], flag = false },
+	started = "2024-03-01T12:00:00Z",
+	addr = "10.0.0.1",
+	level = 'Debug,
+	data = "aGVsbG8=",
+	nested = { id = 1, kind = "child" },
+	Ignored = "no",
+	untagged = "case-insensitive",
+	optional = null,
+	unknown = 1,
+}`
+
+func TestExprDecode(t *testing.T) {
+	expr, err := EvalDeep(decodeSrc)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	var got decodeTarget
+	got.Optional = map[string]string{"x": "y"}
+	if err := expr.Decode(&got); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	var want decodeTarget
+	want.Optional = map[string]string{"x": "y"}
+	if err := expr.ConvertTo(&want); err != nil {
+		t.Fatalf("convert error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode and ConvertTo differ:\n%+v\n%+v", got, want)
+	}
+	if got.ID != 7 || got.Port != 8080 || got.Level != "Debug" || string(got.Data) != "hello" ||
+		got.Untagged != "case-insensitive" || got.Optional != nil || got.Codes[404] != "not found" {
+		t.Errorf("unexpected result: %+v", got)
+	}
+
+	// Numbers keep their exact value.
+	expr, err = EvalDeep(`{ third = 1 / 3, big = 123456789012345678901234567890, max = 18446744073709551615 }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	var numbers struct {
+		Third big.Rat
+		Big   *big.Int
+		Max   uint64
+	}
+	if err := expr.Decode(&numbers); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if numbers.Third.RatString() != "1/3" || numbers.Big.String() != "123456789012345678901234567890" || numbers.Max != 18446744073709551615 {
+		t.Errorf("unexpected numbers: %s, %s, %d", numbers.Third.RatString(), numbers.Big, numbers.Max)
+	}
+
+	// Parts of the value can be kept as Exprs, and lazy values are
+	// evaluated as needed.
+	expr, err = EvalShallow(`{ config = { a = 1 + 1 }, raw = { b = "x" } }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	var partial struct {
+		Config struct{ A int }
+		Raw    *Expr
+	}
+	if err := expr.Decode(&partial); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if partial.Config.A != 2 || partial.Raw == nil || !partial.Raw.IsRecord() {
+		t.Errorf("unexpected result: %+v", partial)
+	}
+}
+
+func TestExprDecodeErrors(t *testing.T) {
+	for _, c := range []struct {
+		src    string
+		target any
+		err    string
+	}{
+		{`{ port = 1.5 }`, new(struct{ Port int }), "port: can't decode 3/2 into int: it isn't an integer"},
+		{`{ port = 70000 }`, new(struct{ Port uint16 }), "port: can't decode 70000 into uint16: it's out of range"},
+		{`{ a = [1, "x"] }`, new(struct{ A []int }), "a.1: can't decode into int: expected number, got string"},
+		{`[1]`, new(map[string]int), "can't decode into map[string]int: expected record, got array"},
+		{`{ a = 'Foo 1 }`, new(any), "a: can't decode an enum variant"},
+		{`{ "x" = 1 }`, new(map[int]int), `x: can't decode key "x" into int`},
+		{`1`, struct{}{}, "isn't a non-nil pointer"},
+	} {
+		expr, err := EvalDeep(c.src)
+		if err != nil {
+			t.Fatalf("%s: eval error: %v", c.src, err)
+		}
+		err = expr.Decode(c.target)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: expected an error containing %q, got %v", c.src, c.err, err)
+		}
+	}
+}
+
+func TestExprDecodeWithOptions(t *testing.T) {
+	type Server struct {
+		Host    string        `nickel:"hostname,omitempty" json:"host"`
+		Port    int           `json:"port"`
+		Secret  string        `nickel:"-" json:"secret"`
+		Timeout time.Duration `nickel:"timeout"`
+	}
+
+	ctx := NewContext()
+	ctx.SetDecodeHooks(DurationHook)
+	expr, err := ctx.EvalDeep(`{ hostname = "example.com", Port = 80, secret = "x", timeout = "5s" }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	// nickel tags take precedence over json tags, and names only differing
+	// in case match by default.
+	var server Server
+	if err := expr.Decode(&server); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	want := Server{Host: "example.com", Port: 80, Timeout: 5 * time.Second}
+	if server != want {
+		t.Errorf("expected %+v, got %+v", want, server)
+	}
+
+	server = Server{}
+	if err := expr.DecodeWithOptions(&server, DecodeOptions{}); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	want.Port = 0
+	if server != want {
+		t.Errorf("expected %+v with exact names, got %+v", want, server)
+	}
+
+	var servers map[string]Server
+	expr, _ = ctx.EvalDeep(`{ main = { hostname = "example.com", prot = 80 } }`)
+	err = expr.DecodeWithOptions(&servers, DecodeOptions{ErrorOnUnknownFields: true})
+	if err == nil || err.Error() != "main: unknown field prot for nickel.Server" {
+		t.Errorf("expected an unknown field error, got %v", err)
+	}
+	expr, _ = ctx.EvalDeep(`{ main = { hostname = "example.com", PORT = 80 } }`)
+	err = expr.DecodeWithOptions(&servers, DecodeOptions{CaseInsensitive: true, ErrorOnUnknownFields: true})
+	if err != nil || servers["main"].Port != 80 {
+		t.Errorf("expected PORT to match port, got %+v, %v", servers, err)
+	}
+}
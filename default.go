@@ -0,0 +1,57 @@
+package nickel
+
+import "sync"
+
+// defaultContext is the Context used by the package-level evaluation
+// functions. It is created the first time one of them is called.
+var defaultContext = sync.OnceValue(NewContext)
+
+// DefaultContext returns the Context used by the package-level functions
+// EvalDeep, EvalExport, EvalShallow, and Decode.
+//
+// It is created on first use, and it can be used concurrently. Settings
+// applied to it (like SetTraceWriter) affect every user of the package-level
+// functions.
+func DefaultContext() *Context {
+	return defaultContext()
+}
+
+// EvalDeep evaluates a Nickel program deeply, using the default context.
+//
+// See Context.EvalDeep.
+func EvalDeep(src string) (*Expr, error) {
+	return DefaultContext().EvalDeep(src)
+}
+
+// EvalExport evaluates a Nickel program deeply for export, using the default
+// context.
+//
+// See Context.EvalExport.
+func EvalExport(src string) (*Expr, error) {
+	return DefaultContext().EvalExport(src)
+}
+
+// EvalShallow evaluates a Nickel program shallowly, using the default context.
+//
+// See Context.EvalShallow.
+func EvalShallow(src string) (*Expr, error) {
+	return DefaultContext().EvalShallow(src)
+}
+
+// Decode evaluates a Nickel program deeply, using the default context, and
+// converts the result to a T.
+//
+// The conversion is the same as the one done by Expr.ConvertTo.
+func Decode[T any](src string) (T, error) {
+	var ret T
+
+	// Evaluating for export leaves out the fields that the conversion
+	// would skip anyway, which lets it take shortcuts.
+	expr, err := DefaultContext().EvalExport(src)
+	if err != nil {
+		return ret, err
+	}
+
+	err = expr.ConvertTo(&ret)
+	return ret, err
+}
@@ -0,0 +1,160 @@
+package nickel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+)
+
+// NondeterminismError is returned by EvalDeterministic when evaluations of
+// the same program export different values.
+type NondeterminismError struct {
+	// Path is the path of the first value that differs, in the order of
+	// the exports: record fields in alphabetical order, and array elements
+	// by index. It's empty if the values differ at the top.
+	Path []string
+	// First and Second are the values at Path in the two exports that
+	// differ, as JSON, or "" if there's no value at Path in one of them.
+	First, Second string
+}
+
+func (e *NondeterminismError) Error() string {
+	what := "the result"
+	if len(e.Path) > 0 {
+		what = FormatPath(e.Path)
+	}
+	show := func(s string) string {
+		if s == "" {
+			return "nothing"
+		}
+		return s
+	}
+	return fmt.Sprintf("evaluation isn't deterministic: %s was %s, then %s", what, show(e.First), show(e.Second))
+}
+
+// EvalDeterministic evaluates a Nickel program for export (see EvalExport)
+// runs times, or twice if runs is less than 2, and checks that every
+// evaluation exports the same value, for configurations that must be
+// reproducible. It returns the result of the first evaluation, or a
+// *NondeterminismError for the first value that changed.
+//
+// Nickel itself is deterministic: records are always iterated in the
+// alphabetical order of their fields, by the standard library and by the
+// serializers, so there's no iteration order to vary between evaluations.
+// What can change is what a program gets from the host: the host
+// capabilities, like host.env and host.exec, imports resolved by an
+// ImportResolver, and files that change between the evaluations.
+func (ctx *Context) EvalDeterministic(src string, runs int) (*Expr, error) {
+	first, err := ctx.EvalExport(src)
+	if err != nil {
+		return nil, err
+	}
+	want, err := first.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	for range max(runs, 2) - 1 {
+		expr, err := ctx.EvalExport(src)
+		if err != nil {
+			return nil, err
+		}
+		got, err := expr.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(got, want) {
+			return nil, nondeterminismError(want, got)
+		}
+	}
+	return first, nil
+}
+
+// nondeterminismError describes the first difference between two exports.
+func nondeterminismError(first, second []byte) error {
+	a, err := decodeExported(first)
+	if err != nil {
+		return err
+	}
+	b, err := decodeExported(second)
+	if err != nil {
+		return err
+	}
+	ret := &NondeterminismError{}
+	ret.Path, a, b = firstDifference(nil, a, b)
+	ret.First, ret.Second = exportedJSON(a), exportedJSON(b)
+	return ret
+}
+
+// missing stands for a value missing from an export in firstDifference.
+type missing struct{}
+
+// firstDifference returns the path of the first value that differs
+// between the values a and b from decodeExported, and the values there,
+// which can be missing.
+func firstDifference(path []string, a, b any) ([]string, any, any) {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(a)+len(b))
+		for key := range a {
+			keys = append(keys, key)
+		}
+		for key := range b {
+			if _, ok := a[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			x, ok := a[key]
+			if !ok {
+				x = missing{}
+			}
+			y, ok := b[key]
+			if !ok {
+				y = missing{}
+			}
+			if !reflect.DeepEqual(x, y) {
+				return firstDifference(append(path, key), x, y)
+			}
+		}
+	case []any:
+		b, ok := b.([]any)
+		if !ok {
+			break
+		}
+		for i := range max(len(a), len(b)) {
+			var x, y any = missing{}, missing{}
+			if i < len(a) {
+				x = a[i]
+			}
+			if i < len(b) {
+				y = b[i]
+			}
+			if !reflect.DeepEqual(x, y) {
+				return firstDifference(append(path, strconv.Itoa(i)), x, y)
+			}
+		}
+	}
+	return path, a, b
+}
+
+// exportedJSON formats a value from decodeExported in one line.
+func exportedJSON(value any) string {
+	if value == (missing{}) {
+		return ""
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
@@ -0,0 +1,52 @@
+package nickel
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestEvalDeterministic(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalDeterministic(`{ b = std.record.fields { y = 1, x = 2 }, a | not_exported = 1 }`, 3)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got := expr.String(); got != `{ b = ["x", "y"] }` {
+		t.Errorf("unexpected result: %s", got)
+	}
+
+	// Each evaluation resolves the import again.
+	calls := 0
+	ctx.SetImportResolver(ImportResolverFunc(func(path string) ([]byte, error) {
+		calls++
+		return fmt.Appendf(nil, `{ a = 1, b = { c = %d }, list = std.array.range 0 %d }`, calls, calls), nil
+	}))
+	for _, c := range []struct {
+		src  string
+		path []string
+		msg  string
+	}{
+		{`import "gen.ncl"`, []string{"b", "c"}, "evaluation isn't deterministic: b.c was 1, then 2"},
+		{`(import "gen.ncl").list`, []string{"1"}, "evaluation isn't deterministic: 1 was nothing, then 1"},
+		{`(import "gen.ncl").a`, nil, ""},
+	} {
+		calls = 0
+		_, err := ctx.EvalDeterministic(c.src, 2)
+		if c.msg == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", c.src, err)
+			}
+			continue
+		}
+		var nondeterminism *NondeterminismError
+		if !errors.As(err, &nondeterminism) {
+			t.Errorf("%s: expected a *NondeterminismError, got %v", c.src, err)
+			continue
+		}
+		if !slices.Equal(nondeterminism.Path, c.path) || err.Error() != c.msg {
+			t.Errorf("%s: unexpected error: %v at %q", c.src, err, nondeterminism.Path)
+		}
+	}
+}
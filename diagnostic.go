@@ -0,0 +1,218 @@
+package nickel
+
+/*
+#include <nickel_lang.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Severity is how serious a Diagnostic is.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityNote
+	SeverityHelp
+	// SeverityBug is for the errors that come from a bug in Nickel itself.
+	SeverityBug
+)
+
+var severityNames = map[string]Severity{
+	"Error":   SeverityError,
+	"Warning": SeverityWarning,
+	"Note":    SeverityNote,
+	"Help":    SeverityHelp,
+	"Bug":     SeverityBug,
+}
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityNote:
+		return "note"
+	case SeverityHelp:
+		return "help"
+	case SeverityBug:
+		return "bug"
+	default:
+		return "unknown"
+	}
+}
+
+// Diagnostic is one of the messages that make up a Nickel error. The first
+// one is the error itself, and the ones that follow are notes about it, like
+// the function calls that led to it.
+type Diagnostic struct {
+	Severity Severity
+	Message  string
+	Labels   []Label
+	Notes    []string
+}
+
+// Label points at the part of a source that a Diagnostic is about.
+type Label struct {
+	// Primary is set for the labels pointing at the cause of the
+	// diagnostic, and not for the ones that give context.
+	Primary bool
+	Message string
+
+	// File is the file that the label points into, named as in error
+	// messages: the source name of the main program (see EvalFile), the
+	// absolute path of an imported file, or a name in angle brackets for
+	// sources that aren't files, like "<stdlib/std.ncl>". It is empty if
+	// the file can't be determined, or if the label points at the
+	// bindings of the globals (see SetGlobals), which aren't part of any
+	// file.
+	File       string
+	Start, End Position
+}
+
+// Position is a position in a source.
+type Position struct {
+	// Offset is the offset in bytes from the start of the file.
+	Offset int
+	// Line and Column start at 1, and columns are counted in characters.
+	// They are 0 if the source of the file isn't available, as for the
+	// standard library, or for the main program of an error that didn't
+	// come from a Context's evaluation functions.
+	Line, Column int
+}
+
+// diagnosticStart matches the first line of each diagnostic in the text
+// format, like "error: dynamic type error".
+var diagnosticStart = regexp.MustCompile(`(?m)^(?:bug|error|warning|note|help)(?:\[[^\]]*\])?:`)
+
+// Diagnostics returns the diagnostics that make up the error, as structured
+// data for building error reports or mapping errors to editor positions.
+//
+// Positions in the main program are relative to the program as it was given
+// to the evaluation function, without the bindings of the globals. When an
+// import in the main program was found through the import search paths (see
+// AddImportPath), the columns that follow it on the same line can be off.
+//
+// Diagnostics returns nil if the Nickel library fails to report them.
+func (e *Error) Diagnostics() []Diagnostic {
+	data, ok := e.format(C.NICKEL_ERROR_FORMAT_JSON)
+	if !ok {
+		return nil
+	}
+	var report struct {
+		Diagnostics []struct {
+			Severity string
+			Message  string
+			Labels   []struct {
+				Style   string
+				FileID  int `json:"file_id"`
+				Range   struct{ Start, End int }
+				Message string
+			}
+			Notes []string
+		}
+	}
+	if err := json.Unmarshal([]byte(data), &report); err != nil {
+		return nil
+	}
+
+	// The JSON format identifies files by a number, and only the text
+	// format names them: each diagnostic names the files of its labels,
+	// in order of appearance.
+	text := e.Error()
+	starts := diagnosticStart.FindAllStringIndex(text, -1)
+	fileNames := map[int]string{}
+	for i, d := range report.Diagnostics {
+		if len(starts) != len(report.Diagnostics) {
+			break
+		}
+		end := len(text)
+		if i+1 < len(starts) {
+			end = starts[i+1][0]
+		}
+		locations := errorLocation.FindAllStringSubmatch(text[starts[i][0]:end], -1)
+		seen := map[int]bool{}
+		for _, label := range d.Labels {
+			if !seen[label.FileID] && len(locations) > 0 {
+				fileNames[label.FileID] = locations[0][1]
+				locations = locations[1:]
+			}
+			seen[label.FileID] = true
+		}
+	}
+
+	mainName := e.mainName
+	if mainName == "" {
+		mainName = defaultSourceName
+	}
+	sources := map[string]string{}
+	if e.mainSrc != "" {
+		sources[mainName] = e.mainSrc[e.mainPrelude:]
+	}
+	source := func(name string) (string, bool) {
+		if src, ok := sources[name]; ok {
+			return src, true
+		}
+		if name == "" || name[0] == '<' {
+			return "", false
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return "", false
+		}
+		sources[name] = string(data)
+		return string(data), true
+	}
+
+	ret := make([]Diagnostic, 0, len(report.Diagnostics))
+	for _, d := range report.Diagnostics {
+		diag := Diagnostic{
+			Severity: severityNames[d.Severity],
+			Message:  d.Message,
+			Notes:    d.Notes,
+		}
+		for _, l := range d.Labels {
+			label := Label{
+				Primary: l.Style == "Primary",
+				Message: l.Message,
+				File:    fileNames[l.FileID],
+				Start:   Position{Offset: l.Range.Start},
+				End:     Position{Offset: l.Range.End},
+			}
+			if label.File == mainName && e.mainSrc != "" {
+				label.Start.Offset -= e.mainPrelude
+				label.End.Offset -= e.mainPrelude
+				if label.Start.Offset < 0 {
+					label = Label{Primary: label.Primary, Message: label.Message}
+				}
+			}
+			if src, ok := source(label.File); ok {
+				label.Start = sourcePosition(src, label.Start.Offset)
+				label.End = sourcePosition(src, label.End.Offset)
+			}
+			diag.Labels = append(diag.Labels, label)
+		}
+		ret = append(ret, diag)
+	}
+	return ret
+}
+
+// sourcePosition returns the position at offset in src.
+func sourcePosition(src string, offset int) Position {
+	pos := Position{Offset: offset}
+	if offset < 0 || offset > len(src) {
+		return pos
+	}
+	before := src[:offset]
+	pos.Line = strings.Count(before, "\n") + 1
+	pos.Column = utf8.RuneCountInString(before[strings.LastIndexByte(before, '\n')+1:]) + 1
+	return pos
+}
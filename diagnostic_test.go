@@ -0,0 +1,85 @@
+package nickel
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiagnostics(t *testing.T) {
+	ctx := NewContext()
+	if err := ctx.SetGlobals(map[string]any{"region": "eu"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ctx.EvalDeep("{\n  x = 1 + \"é\",\n  y = region,\n}")
+	var nickelErr *Error
+	if !errors.As(err, &nickelErr) {
+		t.Fatalf("expected a Nickel error, got %v", err)
+	}
+
+	diags := nickelErr.Diagnostics()
+	if len(diags) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %+v", diags)
+	}
+	diag := diags[0]
+	if diag.Severity != SeverityError || diag.Message != "dynamic type error" {
+		t.Errorf("unexpected diagnostic: %+v", diag)
+	}
+	if len(diag.Notes) != 1 || diag.Notes[0] != "(+) expects its 2nd argument to be a Number" {
+		t.Errorf("unexpected notes: %q", diag.Notes)
+	}
+	want := Label{
+		Primary: true,
+		Message: "this expression has type String, but Number was expected",
+		File:    "<source>",
+		Start:   Position{Offset: 12, Line: 2, Column: 11},
+		End:     Position{Offset: 16, Line: 2, Column: 14},
+	}
+	if len(diag.Labels) != 1 || diag.Labels[0] != want {
+		t.Errorf("unexpected labels: %+v", diag.Labels)
+	}
+}
+
+func TestDiagnosticsFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.ncl")
+	if err := os.WriteFile(path, []byte("{\n  port | Number = \"80\",\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewContext().EvalDeep("(import " + QuoteString(path) + ").port")
+	var nickelErr *Error
+	if !errors.As(err, &nickelErr) {
+		t.Fatalf("expected a Nickel error, got %v", err)
+	}
+	diags := nickelErr.Diagnostics()
+	if len(diags) == 0 {
+		t.Fatal("no diagnostics")
+	}
+	var primary *Label
+	for i, label := range diags[0].Labels {
+		if label.Primary {
+			primary = &diags[0].Labels[i]
+		}
+	}
+	if primary == nil {
+		t.Fatalf("no primary label in %+v", diags[0].Labels)
+	}
+	if primary.File != path || primary.Start.Line != 2 || primary.Start.Column != 19 {
+		t.Errorf("unexpected primary label: %+v", primary)
+	}
+
+	// Calls are reported as notes.
+	_, err = NewContext().EvalDeep("[1, 2] |> std.array.at 5")
+	if !errors.As(err, &nickelErr) {
+		t.Fatalf("expected a Nickel error, got %v", err)
+	}
+	diags = nickelErr.Diagnostics()
+	if len(diags) < 2 || diags[1].Severity != SeverityNote {
+		t.Fatalf("expected notes, got %+v", diags)
+	}
+	if label := diags[0].Labels[0]; label.File != "<stdlib/std.ncl>" || label.Start.Line != 0 {
+		t.Errorf("unexpected standard library label: %+v", label)
+	}
+}
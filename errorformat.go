@@ -0,0 +1,56 @@
+package nickel
+
+/*
+#include <nickel_lang.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorFormat is a format for Nickel errors, see Error.Format.
+type ErrorFormat int
+
+// The numbering of these must match nickel_error_format in nickel_lang.h.
+const (
+	// ErrorText is the plain text format used by Error.Error.
+	ErrorText ErrorFormat = iota
+	// ErrorANSI is the text format with ANSI color codes, as printed by
+	// the nickel command on a terminal.
+	ErrorANSI
+	// ErrorJSON is a JSON object holding the diagnostics of the error (see
+	// Error.Diagnostics), for machine consumption.
+	ErrorJSON
+	// ErrorYAML holds the same data as ErrorJSON, in YAML.
+	ErrorYAML
+	// ErrorTOML holds the same data as ErrorJSON, in TOML.
+	ErrorTOML
+)
+
+// FormatOptions are the options of Error.Format.
+type FormatOptions struct {
+	Format ErrorFormat
+}
+
+// errFormatting is returned by Format when the Nickel library fails to
+// format an error.
+var errFormatting = errors.New("error formatting error")
+
+// Format formats the error as configured by opts.
+//
+// The text formats have the same source snippets as the message of Error,
+// and the other formats describe the diagnostics of the error as data. Files
+// are identified by a number in the data formats: use Diagnostics to find
+// them by name.
+func (e *Error) Format(opts FormatOptions) (string, error) {
+	if opts.Format < ErrorText || opts.Format > ErrorTOML {
+		return "", fmt.Errorf("unknown error format %d", opts.Format)
+	}
+	msg, ok := e.format(C.nickel_error_format(opts.Format))
+	if !ok {
+		return "", errFormatting
+	}
+	return msg, nil
+}
@@ -0,0 +1,200 @@
+package nickel
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ErrorCategory is the kind of failure that a Nickel error reports. See
+// Error.Category.
+type ErrorCategory int
+
+const (
+	// CategoryEval is for the errors of evaluation that don't fall in
+	// another category, like a missing field or a division by zero.
+	CategoryEval ErrorCategory = iota
+	// CategoryParse is for syntax errors, in the program or in a file it
+	// imports.
+	CategoryParse
+	// CategoryTypecheck is for the errors of the static type checker.
+	CategoryTypecheck
+	// CategoryImport is for imports that can't be loaded.
+	CategoryImport
+	// CategoryContract is for contract violations.
+	CategoryContract
+)
+
+func (c ErrorCategory) String() string {
+	switch c {
+	case CategoryEval:
+		return "eval"
+	case CategoryParse:
+		return "parse"
+	case CategoryTypecheck:
+		return "typecheck"
+	case CategoryImport:
+		return "import"
+	case CategoryContract:
+		return "contract"
+	default:
+		return "unknown"
+	}
+}
+
+// The starts of the messages of each category, other than CategoryEval and
+// CategoryContract (see blamePatterns).
+var (
+	parseMessages = []string{
+		"unexpected end of file",
+		"unexpected token",
+		"invalid escape sequence",
+		"invalid ASCII escape code",
+		"invalid unicode escape code",
+		"duplicated binding",
+		"unbound type variable",
+		"parse error",
+	}
+	typecheckMessages = []string{
+		"incompatible types",
+		"incompatible rows",
+		"type error",
+		"illformed type",
+	}
+	importMessage = regexp.MustCompile(`^import of (.*) failed: `)
+)
+
+// message returns the message of the error, without the location and
+// notes that follow it.
+func (e *Error) message() string {
+	// The text format starts with "error: ", then the message.
+	return strings.TrimPrefix(e.Error(), "error: ")
+}
+
+// Category returns the category of the error.
+//
+// The Nickel library doesn't report categories, or codes for its errors,
+// so the category is inferred from the error message, and new kinds of
+// errors in later versions of Nickel may be reported as CategoryEval until
+// the bindings learn them.
+//
+// The errors.As function also finds the error of a category as one of the
+// types ParseError, TypecheckError, ImportError, ContractError and
+// EvalError, which hold the Error.
+func (e *Error) Category() ErrorCategory {
+	msg := e.message()
+	for _, prefix := range parseMessages {
+		if strings.HasPrefix(msg, prefix) {
+			return CategoryParse
+		}
+	}
+	for _, prefix := range typecheckMessages {
+		if strings.HasPrefix(msg, prefix) {
+			return CategoryTypecheck
+		}
+	}
+	if importMessage.MatchString(msg) {
+		return CategoryImport
+	}
+	for _, pattern := range blamePatterns {
+		if pattern.MatchString(msg) {
+			return CategoryContract
+		}
+	}
+	return CategoryEval
+}
+
+// As implements errors.As for the types of the categories of errors (see
+// Category), setting target to an error holding e if it's of the category
+// of the target's type.
+func (e *Error) As(target any) bool {
+	switch target := target.(type) {
+	case **ParseError:
+		if e.Category() == CategoryParse {
+			*target = &ParseError{Err: e}
+			return true
+		}
+	case **TypecheckError:
+		if e.Category() == CategoryTypecheck {
+			*target = &TypecheckError{Err: e}
+			return true
+		}
+	case **ImportError:
+		if m := importMessage.FindStringSubmatch(e.message()); m != nil {
+			path := m[1]
+			if written, ok := e.imports[path]; ok {
+				path = written
+			}
+			*target = &ImportError{Path: path, Err: e}
+			return true
+		}
+	case **ContractError:
+		if err, ok := blameError(e, nil).(*ContractError); ok {
+			*target = err
+			return true
+		}
+	case **EvalError:
+		if e.Category() == CategoryEval {
+			*target = &EvalError{Err: e}
+			return true
+		}
+	}
+	return false
+}
+
+// ParseError is a Nickel error of CategoryParse, as found by errors.As.
+type ParseError struct {
+	Err *Error
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// TypecheckError is a Nickel error of CategoryTypecheck, as found by
+// errors.As.
+type TypecheckError struct {
+	Err *Error
+}
+
+func (e *TypecheckError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TypecheckError) Unwrap() error {
+	return e.Err
+}
+
+// ImportError is a Nickel error of CategoryImport, as found by errors.As.
+type ImportError struct {
+	// Path is the path of the import that failed, as written in the
+	// program, even if it was resolved to another file (see
+	// AddImportPath).
+	Path string
+
+	Err *Error
+}
+
+func (e *ImportError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ImportError) Unwrap() error {
+	return e.Err
+}
+
+// EvalError is a Nickel error of CategoryEval, as found by errors.As.
+type EvalError struct {
+	Err *Error
+}
+
+func (e *EvalError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *EvalError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,147 @@
+package nickel
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestErrorCategory(t *testing.T) {
+	tests := []struct {
+		src  string
+		want ErrorCategory
+	}{
+		{`{ a = `, CategoryParse},
+		{`)`, CategoryParse},
+		{`"\q"`, CategoryParse},
+		{`(1 : String)`, CategoryTypecheck},
+		{`({ a = 1 } : { b : Number })`, CategoryTypecheck},
+		{`import "nonexistent.ncl"`, CategoryImport},
+		{`{ a | Number = "x" }`, CategoryContract},
+		{`{ a | Number }`, CategoryContract},
+		{`std.fail_with "boom"`, CategoryContract},
+		{`{ a = 1 }.b`, CategoryEval},
+		{`1 + "a"`, CategoryEval},
+		{`1 / 0`, CategoryEval},
+	}
+	for _, tt := range tests {
+		_, err := NewContext().EvalDeep(tt.src)
+		var nickelErr *Error
+		if !errors.As(err, &nickelErr) {
+			t.Errorf("%s: expected a Nickel error, got %v", tt.src, err)
+			continue
+		}
+		if got := nickelErr.Category(); got != tt.want {
+			t.Errorf("%s: expected category %s, got %s", tt.src, tt.want, got)
+		}
+	}
+}
+
+func TestErrorAs(t *testing.T) {
+	_, err := EvalDeep(`import "nonexistent.ncl"`)
+	var importErr *ImportError
+	if !errors.As(err, &importErr) {
+		t.Fatalf("expected an import error, got %v", err)
+	}
+	if importErr.Path != "nonexistent.ncl" {
+		t.Errorf("expected the import's path, got %q", importErr.Path)
+	}
+	var evalErr *EvalError
+	if errors.As(err, &evalErr) {
+		t.Error("expected an import error not to be an eval error")
+	}
+
+	// The path is the one written in the program, not the one it was
+	// resolved to.
+	ctx := NewContext()
+	dir := t.TempDir()
+	// A directory is found among the import paths, but can't be read.
+	if err := os.Mkdir(filepath.Join(dir, "dir.ncl"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.AddImportPath(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, eval := range []func(string) (*Expr, error){ctx.EvalDeep, ctx.EvalShallow} {
+		_, err = eval(`import "dir.ncl"`)
+		if !errors.As(err, &importErr) || importErr.Path != "dir.ncl" {
+			t.Errorf("expected an import error for dir.ncl, got %v", err)
+		}
+	}
+
+	_, err = EvalDeep(`{ port | Number = "x" }`)
+	var contractErr *ContractError
+	if !errors.As(err, &contractErr) || contractErr.Field != "port" {
+		t.Errorf("expected a contract error for port, got %v", err)
+	}
+
+	_, err = EvalDeep(`{ a = `)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Errorf("expected a parse error, got %v", err)
+	}
+	var nickelErr *Error
+	if !errors.As(parseErr, &nickelErr) || nickelErr != parseErr.Err {
+		t.Error("expected a parse error to unwrap to the Nickel error")
+	}
+	var typecheckErr *TypecheckError
+	if errors.As(err, &typecheckErr) {
+		t.Error("expected a parse error not to be a typecheck error")
+	}
+
+	_, err = EvalDeep(`(1 : String)`)
+	if !errors.As(err, &typecheckErr) {
+		t.Errorf("expected a typecheck error, got %v", err)
+	}
+
+	_, err = EvalDeep(`1 / 0`)
+	if !errors.As(err, &evalErr) {
+		t.Errorf("expected an eval error, got %v", err)
+	}
+}
+
+func TestErrorFormats(t *testing.T) {
+	_, err := EvalDeep("1 + \"a\"")
+	var nickelErr *Error
+	if !errors.As(err, &nickelErr) {
+		t.Fatalf("expected a Nickel error, got %v", err)
+	}
+
+	text, err := nickelErr.Format(FormatOptions{})
+	if err != nil || text != nickelErr.Error() {
+		t.Errorf("expected the text format to match Error, got %q, %v", text, err)
+	}
+	ansi, err := nickelErr.Format(FormatOptions{Format: ErrorANSI})
+	if err != nil || !strings.Contains(ansi, "\x1b[") {
+		t.Errorf("expected color codes, got %q, %v", ansi, err)
+	}
+
+	data, err := nickelErr.Format(FormatOptions{Format: ErrorJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report struct {
+		Diagnostics []struct {
+			Severity string
+			Message  string
+		}
+	}
+	if err := json.Unmarshal([]byte(data), &report); err != nil {
+		t.Fatalf("invalid JSON %q: %v", data, err)
+	}
+	if len(report.Diagnostics) != 1 || report.Diagnostics[0].Message != "dynamic type error" {
+		t.Errorf("unexpected diagnostics: %+v", report.Diagnostics)
+	}
+	for _, format := range []ErrorFormat{ErrorYAML, ErrorTOML} {
+		if data, err := nickelErr.Format(FormatOptions{Format: format}); err != nil || !strings.Contains(data, "dynamic type error") {
+			t.Errorf("format %d: got %q, %v", format, data, err)
+		}
+	}
+
+	if _, err := nickelErr.Format(FormatOptions{Format: ErrorFormat(42)}); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,252 @@
+package nickel
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// EvalRecorder writes a log of the evaluations of a Context, for
+// reconstructing after the fact what a program evaluated to, and in which
+// order its lazy parts were evaluated. See Context.SetEvalRecorder.
+//
+// The log is written as one JSON object per line, each an EvalEvent. Use
+// ReadEvalLog to read it back.
+type EvalRecorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	seq int
+	err error
+}
+
+// NewEvalRecorder returns a recorder that writes its log to w.
+func NewEvalRecorder(w io.Writer) *EvalRecorder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &EvalRecorder{enc: enc}
+}
+
+// Err returns the first error that writing the log failed with. Events
+// are dropped after a failure.
+func (r *EvalRecorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// EvalEvent is an entry of an evaluation log.
+type EvalEvent struct {
+	// Seq numbers the events of a log in order, from 1.
+	Seq int `json:"seq"`
+
+	// Time is when the evaluation finished.
+	Time time.Time `json:"time"`
+
+	// Op is EvalProgram for the evaluation of a program, and EvalForce for
+	// the shallow evaluation of a part of its result, by Expr.EvalShallow or
+	// Expr.PrefetchChildren.
+	Op string `json:"op"`
+
+	// Eval is the Seq of the program evaluation that the event is about.
+	Eval int `json:"eval"`
+
+	// Name is the source name of the evaluated program (see EvalFile), in
+	// EvalProgram events.
+	Name string `json:"name,omitempty"`
+
+	// Program is the source of the evaluated program, in EvalProgram events.
+	Program string `json:"program,omitempty"`
+
+	// Deep is true for deep evaluations of programs.
+	Deep bool `json:"deep,omitempty"`
+
+	// Path is the path of the forced value within the program's result, in
+	// EvalForce events.
+	Path []string `json:"path,omitempty"`
+
+	// Value is the result of the evaluation, as given by Expr.String, so
+	// large values are truncated and unevaluated parts are shown as <lazy>.
+	Value string `json:"value,omitempty"`
+
+	// Error is the error that the evaluation failed with, if it failed.
+	Error string `json:"error,omitempty"`
+}
+
+// The operations of EvalEvents.
+const (
+	EvalProgram = "program"
+	EvalForce   = "force"
+)
+
+// SetEvalRecorder starts recording the evaluations of the context to r, or
+// stops recording if r is nil.
+//
+// The evaluations of the programs given to the context's evaluation functions
+// are recorded, along with the later evaluations of the lazy parts of their
+// results through Expr.EvalShallow and Expr.PrefetchChildren, each with its
+// path within the result. The Nickel library doesn't report what it
+// evaluates along the way, so a value that's evaluated as part of the
+// evaluation of another one doesn't have an event of its own.
+//
+// Recording costs a little for every Expr taken from a recorded result, to
+// keep track of its path, and the log contains the programs' sources and
+// values, which may be sensitive.
+func (ctx *Context) SetEvalRecorder(r *EvalRecorder) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.recorder = r
+}
+
+func (ctx *Context) evalRecorder() *EvalRecorder {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.recorder
+}
+
+// exprOrigin is where an Expr from a recorded evaluation comes from: the
+// evaluation, and the path within its result.
+type exprOrigin struct {
+	eval   int
+	parent *exprOrigin
+	key    string
+	index  int
+}
+
+// field returns the origin of the field of a record coming from o, or nil
+// if o is nil.
+func (o *exprOrigin) field(key string) *exprOrigin {
+	if o == nil {
+		return nil
+	}
+	return &exprOrigin{eval: o.eval, parent: o, key: key, index: -1}
+}
+
+// elem returns the origin of the element of an array coming from o, or nil
+// if o is nil.
+func (o *exprOrigin) elem(i int) *exprOrigin {
+	if o == nil {
+		return nil
+	}
+	return &exprOrigin{eval: o.eval, parent: o, index: i}
+}
+
+func (o *exprOrigin) path() []string {
+	var path []string
+	for ; o.parent != nil; o = o.parent {
+		if o.index >= 0 {
+			path = append(path, strconv.Itoa(o.index))
+		} else {
+			path = append(path, o.key)
+		}
+	}
+	slices.Reverse(path)
+	return path
+}
+
+// record numbers an event and writes it out.
+func (r *EvalRecorder) record(ev EvalEvent) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	ev.Seq = r.seq
+	if ev.Op == EvalProgram {
+		ev.Eval = ev.Seq
+	}
+	ev.Time = time.Now()
+	if r.err == nil {
+		r.err = r.enc.Encode(ev)
+	}
+	return ev.Seq
+}
+
+// recordProgram records the evaluation of a program, and marks its result
+// as coming from it.
+func (r *EvalRecorder) recordProgram(name string, src string, deep bool, expr *Expr, err error) {
+	if name == "" {
+		name = defaultSourceName
+	}
+	ev := EvalEvent{Op: EvalProgram, Name: name, Program: src, Deep: deep}
+	if err != nil {
+		ev.Error = err.Error()
+	} else {
+		ev.Value = expr.String()
+	}
+	seq := r.record(ev)
+	if expr != nil {
+		expr.origin = &exprOrigin{eval: seq, index: -1}
+	}
+}
+
+// recordForce records the shallow evaluation of a part of a recorded
+// result.
+func (ctx *Context) recordForce(origin *exprOrigin, expr *Expr, err error) {
+	r := ctx.evalRecorder()
+	if r == nil {
+		return
+	}
+	ev := EvalEvent{Op: EvalForce, Eval: origin.eval, Path: origin.path()}
+	if err != nil {
+		ev.Error = err.Error()
+	} else {
+		ev.Value = expr.String()
+	}
+	r.record(ev)
+}
+
+// EvalLog is an evaluation log read by ReadEvalLog, in order.
+type EvalLog []EvalEvent
+
+// ReadEvalLog reads a log written by an EvalRecorder.
+func ReadEvalLog(r io.Reader) (EvalLog, error) {
+	var log EvalLog
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var ev EvalEvent
+		if err := dec.Decode(&ev); err == io.EOF {
+			return log, nil
+		} else if err != nil {
+			return log, err
+		}
+		log = append(log, ev)
+	}
+}
+
+// Forced returns the first event of the evaluation with Seq eval for the
+// value at path: the program's evaluation for an empty path, and the
+// evaluation of the value otherwise.
+func (log EvalLog) Forced(eval int, path []string) (EvalEvent, bool) {
+	for _, ev := range log {
+		if ev.Eval == eval && slices.Equal(ev.Path, path) {
+			return ev, true
+		}
+	}
+	return EvalEvent{}, false
+}
+
+// ValueAt answers what the value at path in the result of the evaluation
+// with Seq eval was known to be at the time of the event with Seq seq.
+//
+// It returns the last event up to seq for the value at path, or, if the
+// value wasn't evaluated by then, for the closest value containing it. In
+// that case, the value is part of the event's Value, or it's still <lazy>.
+// It returns false if the program wasn't evaluated by then.
+func (log EvalLog) ValueAt(eval int, path []string, seq int) (EvalEvent, bool) {
+	var best EvalEvent
+	found := false
+	for _, ev := range log {
+		if ev.Seq > seq {
+			break
+		}
+		if ev.Eval != eval || ev.Error != "" || len(ev.Path) > len(path) || !slices.Equal(ev.Path, path[:len(ev.Path)]) {
+			continue
+		}
+		if !found || len(ev.Path) >= len(best.Path) {
+			best, found = ev, true
+		}
+	}
+	return best, found
+}
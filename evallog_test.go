@@ -0,0 +1,92 @@
+package nickel
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEvalRecorder(t *testing.T) {
+	var buf bytes.Buffer
+	recorder := NewEvalRecorder(&buf)
+	ctx := NewContext()
+	ctx.SetEvalRecorder(recorder)
+
+	expr, err := ctx.EvalShallow(`{
+		region = "eu",
+		db = { host = "db." ++ region, replicas = [1 + 1, 2 + 2] },
+		bad = std.fail_with "oops",
+	}`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	record, _ := expr.ToRecord()
+	db, err := record["db"].EvalShallow()
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if err := db.PrefetchChildren(2); err != nil {
+		t.Fatalf("prefetch error: %v", err)
+	}
+	if _, err := record["bad"].EvalShallow(); err == nil {
+		t.Fatal("expected an error")
+	}
+	// Values aren't recorded as forced again.
+	if _, err := db.EvalShallow(); err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if _, err := ctx.EvalDeep(`{ a = 1 }`); err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	ctx.SetEvalRecorder(nil)
+	if _, err := ctx.EvalDeep(`2`); err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if err := recorder.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := ReadEvalLog(&buf)
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	var summary []string
+	for _, ev := range log {
+		summary = append(summary, ev.Op+" "+strings.Join(ev.Path, ".")+" "+ev.Value)
+	}
+	expected := []string{
+		"program  { bad = <lazy>, db = <lazy>, region = <lazy> }",
+		`force db { host = <lazy>, replicas = <lazy> }`,
+		`force db.host "db.eu"`,
+		`force db.replicas [<lazy>, <lazy>]`,
+		`force db.replicas.0 2`,
+		`force db.replicas.1 4`,
+		"force bad ",
+		"program  { a = 1 }",
+	}
+	if !slices.Equal(summary, expected) {
+		t.Fatalf("unexpected log:\n%s", strings.Join(summary, "\n"))
+	}
+	if !strings.Contains(log[6].Error, "oops") || log[0].Name != "<source>" || !strings.Contains(log[0].Program, "region") {
+		t.Fatalf("unexpected events: %+v, %+v", log[0], log[6])
+	}
+
+	// What was db.replicas.1 when db.replicas.0 was forced?
+	forced, ok := log.Forced(1, []string{"db", "replicas", "0"})
+	if !ok {
+		t.Fatal("expected db.replicas.0 to be forced")
+	}
+	ev, ok := log.ValueAt(1, []string{"db", "replicas", "1"}, forced.Seq)
+	if !ok || ev.Value != "[<lazy>, <lazy>]" {
+		t.Fatalf("expected the unevaluated array, got %+v", ev)
+	}
+	ev, _ = log.ValueAt(1, []string{"db", "replicas", "1"}, len(log))
+	if ev.Value != "4" {
+		t.Fatalf("expected the evaluated element, got %+v", ev)
+	}
+	if _, ok := log.ValueAt(8, nil, 7); ok {
+		t.Fatal("expected no value before the evaluation")
+	}
+}
@@ -0,0 +1,479 @@
+package nickel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+// ExportFormat is a serialization format for Expr.Export.
+type ExportFormat int
+
+const (
+	ExportJSON ExportFormat = iota
+	ExportYAML
+	ExportTOML
+)
+
+// ExportOptions customize Expr.Export.
+//
+// Include and Exclude contain path patterns: paths as accepted by ParsePath,
+// in which a field name of `*` matches any record field or array element.
+// Array elements can also be selected by their index.
+type ExportOptions struct {
+	// Format is the serialization format. The default is JSON.
+	Format ExportFormat
+
+	// Include, if non-empty, restricts the export to the values matching one
+	// of these patterns, along with the records and arrays containing them.
+	Include []string
+
+	// Exclude lists patterns for values to leave out of the export.
+	// Exclusions apply after Include.
+	Exclude []string
+
+	// Masks replace values in the export, after Include and Exclude have
+	// been applied.
+	Masks []Mask
+
+	// Canonical produces JSON in a canonical form (see CanonicalizeJSON)
+	// that doesn't depend on the formatting choices of the Nickel library,
+	// for comparing against golden files. It only applies to JSON.
+	Canonical bool
+
+	// Annotation, if not nil, adds a field describing how the export was
+	// generated to the top-level record, after the masks have been
+	// applied. The value being exported must be a record without that
+	// field.
+	Annotation *Annotation
+
+	// Documents exports a top-level array as a stream of YAML documents,
+	// one for each element, separated by "---" lines, as expected by tools
+	// like kubectl for several manifests. Other values are exported as a
+	// single document. It only applies to YAML.
+	Documents bool
+}
+
+// Annotation is the metadata added to an export by ExportOptions.Annotation,
+// to trace the artifacts built from Nickel programs back to the evaluation
+// that produced them. The metadata is a record with the fields generator,
+// time and program_hash, which are left out when empty.
+type Annotation struct {
+	// Key is the name of the field holding the metadata. The default is
+	// "_generated".
+	Key string
+
+	// Generator identifies the program that did the export, like
+	// "deployer v1.4.2".
+	Generator string
+
+	// Time is when the export was made, written in RFC 3339 format. Leave
+	// it zero for reproducible exports.
+	Time time.Time
+
+	// ProgramHash is the hash of the program that was evaluated, from
+	// Context.ProgramHash or ProgramHashFile.
+	ProgramHash string
+}
+
+// Mask replaces the values matching some path patterns with a placeholder.
+type Mask struct {
+	// Paths are the patterns of the values to replace. Patterns that don't
+	// match anything are ignored: masks don't add fields.
+	Paths []string
+
+	// Replacement is the value to put in place of the masked values. It can
+	// be anything that encoding/json can marshal.
+	Replacement any
+}
+
+// Mask adds a mask replacing the values at the given path patterns with
+// replacement.
+//
+// For example, opts.Mask([]string{"*.password"}, "REDACTED") hides every
+// password field of the records at the top level.
+func (opts *ExportOptions) Mask(paths []string, replacement any) {
+	opts.Masks = append(opts.Masks, Mask{Paths: paths, Replacement: replacement})
+}
+
+// Export serializes an Expr, like MarshalJSON, MarshalYAML or MarshalTOML,
+// with additional options.
+//
+// The expression is first serialized natively, so fields marked not_exported
+// are left out as usual, and failures are the same as for MarshalJSON. The
+// options are then applied to the serialized data.
+func (expr *Expr) Export(opts ExportOptions) ([]byte, error) {
+	if opts.Format != ExportJSON && opts.Format != ExportYAML && opts.Format != ExportTOML {
+		return nil, fmt.Errorf("unknown export format %d", opts.Format)
+	}
+	if opts.Canonical && opts.Format != ExportJSON {
+		return nil, fmt.Errorf("canonical export is only supported for JSON")
+	}
+	if opts.Documents && opts.Format != ExportYAML {
+		return nil, fmt.Errorf("multi-document export is only supported for YAML")
+	}
+	if len(opts.Include) == 0 && len(opts.Exclude) == 0 && len(opts.Masks) == 0 && !opts.Canonical && opts.Annotation == nil {
+		switch {
+		case opts.Documents && expr.kind == KindArray:
+			elems, _ := expr.ToArray()
+			docs := make([][]byte, len(elems))
+			for i, elem := range elems {
+				var err error
+				if docs[i], err = elem.MarshalYAML(); err != nil {
+					return nil, err
+				}
+			}
+			return expr.ctx.joinDocuments(docs)
+		case opts.Format == ExportYAML:
+			return expr.MarshalYAML()
+		case opts.Format == ExportTOML:
+			return expr.MarshalTOML()
+		}
+		return expr.MarshalJSON()
+	}
+
+	include, err := parsePatterns(opts.Include)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := parsePatterns(opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := expr.serialize(serializeJSON)
+	if err != nil {
+		return nil, err
+	}
+	value, err := decodeExported(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(include) > 0 {
+		value = includeMatching(value, include)
+	}
+	if len(exclude) > 0 {
+		value = excludeMatching(value, exclude)
+	}
+	for _, mask := range opts.Masks {
+		patterns, err := parsePatterns(mask.Paths)
+		if err != nil {
+			return nil, err
+		}
+		replacement, err := jsonValue(mask.Replacement)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mask replacement: %w", err)
+		}
+		value = replaceMatching(value, patterns, replacement)
+	}
+	if opts.Annotation != nil {
+		if value, err = opts.Annotation.add(value); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Canonical {
+		value = canonicalValue(value)
+	}
+	if elems, ok := value.([]any); ok && opts.Documents {
+		docs := make([][]byte, len(elems))
+		for i, elem := range elems {
+			if docs[i], err = expr.ctx.encodeExported(elem, opts.Format); err != nil {
+				return nil, err
+			}
+		}
+		return expr.ctx.joinDocuments(docs)
+	}
+	return expr.ctx.encodeExported(value, opts.Format)
+}
+
+// joinDocuments joins YAML documents into a stream, within the context's
+// MaxExportBytes limit.
+func (ctx *Context) joinDocuments(docs [][]byte) ([]byte, error) {
+	data := bytes.Join(docs, []byte("---\n"))
+	if err := ctx.checkExportSize(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// add adds the metadata to a value from decodeExported.
+func (a *Annotation) add(value any) (any, error) {
+	key := a.Key
+	if key == "" {
+		key = "_generated"
+	}
+	record, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("can't annotate an export that isn't a record")
+	}
+	if _, ok := record[key]; ok {
+		return nil, fmt.Errorf("can't annotate an export that has a field %q already", key)
+	}
+
+	metadata := map[string]any{}
+	if a.Generator != "" {
+		metadata["generator"] = a.Generator
+	}
+	if !a.Time.IsZero() {
+		metadata["time"] = a.Time.Format(time.RFC3339Nano)
+	}
+	if a.ProgramHash != "" {
+		metadata["program_hash"] = a.ProgramHash
+	}
+	record[key] = metadata
+	return record, nil
+}
+
+// CanonicalizeJSON rewrites JSON data in the canonical form used by
+// ExportOptions.Canonical: indented by two spaces, with the keys of objects
+// sorted, and with numbers written in a fixed way. Integers are written out
+// in full, and other numbers are rounded to the nearest float64 and written
+// like JavaScript would.
+//
+// The form only depends on the value, so two exports of the same value are
+// the same, whichever version of Nickel produced them.
+func CanonicalizeJSON(data []byte) ([]byte, error) {
+	value, err := decodeExported(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(canonicalValue(value)); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// canonicalValue rewrites the numbers in a value from decodeExported in
+// canonical form. Maps are sorted by encoding/json.
+func canonicalValue(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, child := range value {
+			value[key] = canonicalValue(child)
+		}
+	case []any:
+		for i, child := range value {
+			value[i] = canonicalValue(child)
+		}
+	case json.Number:
+		if r, ok := new(big.Rat).SetString(string(value)); ok && r.IsInt() {
+			return json.Number(r.Num().String())
+		}
+		if f, err := value.Float64(); err == nil {
+			// encoding/json formats floats like JavaScript.
+			data, _ := json.Marshal(f)
+			return json.Number(data)
+		}
+	}
+	return value
+}
+
+// decodeExported parses JSON produced by the native serializer, keeping the
+// exact text of numbers.
+func decodeExported(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// encodeExported serializes a value from decodeExported.
+//
+// JSON is indented like the native serializer's output. For other formats,
+// the value is turned back into a Nickel value so that the native serializer
+// can be used.
+func (ctx *Context) encodeExported(value any, format ExportFormat) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if format == ExportJSON {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(value); err != nil {
+		return nil, err
+	}
+	if format == ExportJSON {
+		data := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+		if err := ctx.checkExportSize(data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+
+	expr, err := ctx.evalDeep("std.deserialize 'Json "+QuoteString(buf.String()), evalOptions{data: true})
+	if err != nil {
+		return nil, err
+	}
+	if format == ExportTOML {
+		return expr.MarshalTOML()
+	}
+	return expr.MarshalYAML()
+}
+
+func parsePatterns(patterns []string) ([][]string, error) {
+	ret := make([][]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		fields, err := ParsePath(pattern)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, fields)
+	}
+	return ret, nil
+}
+
+// advance returns the remainders of the patterns whose first field matches
+// key. The second return value is true if one of the patterns ends at key.
+func advance(patterns [][]string, key string) ([][]string, bool) {
+	var rest [][]string
+	for _, pattern := range patterns {
+		if pattern[0] != "*" && pattern[0] != key {
+			continue
+		}
+		if len(pattern) == 1 {
+			return nil, true
+		}
+		rest = append(rest, pattern[1:])
+	}
+	return rest, false
+}
+
+// includeMatching keeps only the parts of value matched by the patterns (and
+// the containers leading to them).
+func includeMatching(value any, patterns [][]string) any {
+	ret, ok := includeChildren(value, patterns)
+	if ok {
+		return ret
+	}
+
+	// Nothing matched. Keep the top-level container, but nothing inside it.
+	switch value.(type) {
+	case map[string]any:
+		return map[string]any{}
+	case []any:
+		return []any{}
+	default:
+		return nil
+	}
+}
+
+func includeChildren(value any, patterns [][]string) (any, bool) {
+	switch value := value.(type) {
+	case map[string]any:
+		ret := map[string]any{}
+		for key, child := range value {
+			rest, matched := advance(patterns, key)
+			if matched {
+				ret[key] = child
+			} else if len(rest) > 0 {
+				if kept, ok := includeChildren(child, rest); ok {
+					ret[key] = kept
+				}
+			}
+		}
+		return ret, len(ret) > 0
+	case []any:
+		ret := []any{}
+		for i, child := range value {
+			rest, matched := advance(patterns, strconv.Itoa(i))
+			if matched {
+				ret = append(ret, child)
+			} else if len(rest) > 0 {
+				if kept, ok := includeChildren(child, rest); ok {
+					ret = append(ret, kept)
+				}
+			}
+		}
+		return ret, len(ret) > 0
+	default:
+		return nil, false
+	}
+}
+
+// replaceMatching replaces the parts of value matched by the patterns.
+func replaceMatching(value any, patterns [][]string, replacement any) any {
+	replaceChild := func(key string, child any) any {
+		rest, matched := advance(patterns, key)
+		if matched {
+			return replacement
+		}
+		if len(rest) > 0 {
+			return replaceMatching(child, rest, replacement)
+		}
+		return child
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		ret := make(map[string]any, len(value))
+		for key, child := range value {
+			ret[key] = replaceChild(key, child)
+		}
+		return ret
+	case []any:
+		ret := make([]any, len(value))
+		for i, child := range value {
+			ret[i] = replaceChild(strconv.Itoa(i), child)
+		}
+		return ret
+	default:
+		return value
+	}
+}
+
+// jsonValue converts a Go value to the representation used by
+// decodeExported.
+func jsonValue(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return decodeExported(data)
+}
+
+// excludeMatching removes the parts of value matched by the patterns.
+func excludeMatching(value any, patterns [][]string) any {
+	switch value := value.(type) {
+	case map[string]any:
+		ret := map[string]any{}
+		for key, child := range value {
+			rest, matched := advance(patterns, key)
+			if matched {
+				continue
+			}
+			if len(rest) > 0 {
+				child = excludeMatching(child, rest)
+			}
+			ret[key] = child
+		}
+		return ret
+	case []any:
+		ret := []any{}
+		for i, child := range value {
+			rest, matched := advance(patterns, strconv.Itoa(i))
+			if matched {
+				continue
+			}
+			if len(rest) > 0 {
+				child = excludeMatching(child, rest)
+			}
+			ret = append(ret, child)
+		}
+		return ret
+	default:
+		return value
+	}
+}
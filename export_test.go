@@ -0,0 +1,298 @@
+package nickel
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const exportSrc = `{
+	public = { name = "srv", port = 80, tags = ["a", "b"] },
+	internal = { token = "secret", debug = true },
+	hidden | not_exported = 1,
+	ratio = 1 / 3,
+}`
+
+func TestExport(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalDeep(exportSrc)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	plain, err := expr.Export(ExportOptions{})
+	if err != nil {
+		t.Fatalf("export error: %v", err)
+	}
+	native, _ := expr.MarshalJSON()
+	if string(plain) != string(native) {
+		t.Fatalf("expected the native export, got %s", plain)
+	}
+
+	// Round-tripping through the filters shouldn't change the formatting.
+	unfiltered, err := expr.Export(ExportOptions{Exclude: []string{"nothing"}})
+	if err != nil {
+		t.Fatalf("export error: %v", err)
+	}
+	if string(unfiltered) != string(native) {
+		t.Fatalf("expected\n%s\ngot\n%s", native, unfiltered)
+	}
+
+	cases := []struct {
+		opts     ExportOptions
+		expected string
+	}{
+		{
+			ExportOptions{Include: []string{"public"}, Exclude: []string{"public.tags.0"}},
+			"{\n  \"public\": {\n    \"name\": \"srv\",\n    \"port\": 80,\n    \"tags\": [\n      \"b\"\n    ]\n  }\n}",
+		},
+		{
+			ExportOptions{Include: []string{"*.name", "ratio"}},
+			"{\n  \"public\": {\n    \"name\": \"srv\"\n  },\n  \"ratio\": 0.3333333333333333\n}",
+		},
+		{
+			ExportOptions{Exclude: []string{"internal", "public.*"}},
+			"{\n  \"public\": {},\n  \"ratio\": 0.3333333333333333\n}",
+		},
+		{
+			ExportOptions{Include: []string{"missing"}},
+			"{}",
+		},
+		{
+			ExportOptions{Format: ExportYAML, Include: []string{"public.tags"}},
+			"public:\n  tags:\n  - a\n  - b\n",
+		},
+	}
+	for _, c := range cases {
+		out, err := expr.Export(c.opts)
+		if err != nil {
+			t.Fatalf("%+v: export error: %v", c.opts, err)
+		}
+		if string(out) != c.expected {
+			t.Errorf("%+v: expected\n%s\ngot\n%s", c.opts, c.expected, out)
+		}
+	}
+
+	if _, err := expr.Export(ExportOptions{Include: []string{"a..b"}}); err == nil {
+		t.Fatal("expected a pattern error")
+	}
+}
+
+func TestExportMask(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalDeep(exportSrc)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	var opts ExportOptions
+	opts.Mask([]string{"*.token", "missing"}, "***")
+	opts.Mask([]string{"public.tags.*"}, map[string]int{"n": 0})
+	out, err := expr.Export(opts)
+	if err != nil {
+		t.Fatalf("export error: %v", err)
+	}
+	expected := `{
+  "internal": {
+    "debug": true,
+    "token": "***"
+  },
+  "public": {
+    "name": "srv",
+    "port": 80,
+    "tags": [
+      {
+        "n": 0
+      },
+      {
+        "n": 0
+      }
+    ]
+  },
+  "ratio": 0.3333333333333333
+}`
+	if string(out) != expected {
+		t.Fatalf("unexpected export:\n%s", out)
+	}
+
+	opts.Format = ExportYAML
+	out, err = expr.Export(opts)
+	if err != nil {
+		t.Fatalf("export error: %v", err)
+	}
+	if !strings.Contains(string(out), "token: '***'") || strings.Contains(string(out), "secret") {
+		t.Fatalf("unexpected export:\n%s", out)
+	}
+
+	opts = ExportOptions{}
+	opts.Mask([]string{"internal"}, func() {})
+	if _, err := expr.Export(opts); err == nil {
+		t.Fatal("expected an error for an unencodable replacement")
+	}
+}
+
+func TestExportCanonical(t *testing.T) {
+	expr, err := EvalDeep(`{ z = 1e21, a = [1 / 4, 'Tag, "<&>"], m = { y = 1.50, x = -0 } }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	out, err := expr.Export(ExportOptions{Canonical: true})
+	if err != nil {
+		t.Fatalf("export error: %v", err)
+	}
+	expected := `{
+  "a": [
+    0.25,
+    "Tag",
+    "<&>"
+  ],
+  "m": {
+    "x": 0,
+    "y": 1.5
+  },
+  "z": 1000000000000000000000
+}`
+	if string(out) != expected {
+		t.Fatalf("unexpected export:\n%s", out)
+	}
+
+	// Formatting differences go away.
+	canonical, err := CanonicalizeJSON([]byte(`{"z":1e21,"m":{"y":1.500,"x":0.0},"a":[2.5e-1,"Tag","<&>"]}`))
+	if err != nil {
+		t.Fatalf("canonicalize error: %v", err)
+	}
+	if string(canonical) != expected {
+		t.Fatalf("unexpected canonical JSON:\n%s", canonical)
+	}
+
+	if _, err := expr.Export(ExportOptions{Canonical: true, Format: ExportYAML}); err == nil {
+		t.Fatal("expected an error for canonical YAML")
+	}
+}
+
+func TestExportAnnotation(t *testing.T) {
+	expr, err := EvalDeep(`{ name = "srv", port = 80 }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	annotation := &Annotation{
+		Generator:   "deployer v1.4.2",
+		Time:        time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		ProgramHash: "sha256:abc",
+	}
+	out, err := expr.Export(ExportOptions{Canonical: true, Annotation: annotation})
+	if err != nil {
+		t.Fatalf("export error: %v", err)
+	}
+	expected := `{
+  "_generated": {
+    "generator": "deployer v1.4.2",
+    "program_hash": "sha256:abc",
+    "time": "2024-03-01T12:00:00Z"
+  },
+  "name": "srv",
+  "port": 80
+}`
+	if string(out) != expected {
+		t.Fatalf("unexpected export:\n%s", out)
+	}
+
+	out, err = expr.Export(ExportOptions{Format: ExportYAML, Annotation: &Annotation{Key: "meta", Generator: "gen"}})
+	if err != nil {
+		t.Fatalf("export error: %v", err)
+	}
+	if !strings.Contains(string(out), "meta:\n  generator: gen\n") {
+		t.Fatalf("unexpected YAML export:\n%s", out)
+	}
+
+	if _, err := expr.Export(ExportOptions{Annotation: &Annotation{Key: "name"}}); err == nil {
+		t.Error("expected an error for an existing field")
+	}
+	array, err := EvalDeep(`[1]`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if _, err := array.Export(ExportOptions{Annotation: annotation}); err == nil {
+		t.Error("expected an error for an array")
+	}
+}
+
+func TestExportDocuments(t *testing.T) {
+	expr, err := EvalDeep(`[{ kind = "Service", name = "a" }, { kind = "Deployment", name = "b", secret = "x" }]`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	expected := "kind: Service\nname: a\n---\nkind: Deployment\nname: b\nsecret: x\n"
+	out, err := expr.Export(ExportOptions{Format: ExportYAML, Documents: true})
+	if err != nil {
+		t.Fatalf("export error: %v", err)
+	}
+	if string(out) != expected {
+		t.Fatalf("unexpected export:\n%s", out)
+	}
+
+	// Patterns apply to the whole array.
+	out, err = expr.Export(ExportOptions{Format: ExportYAML, Documents: true, Exclude: []string{"*.secret"}})
+	if err != nil {
+		t.Fatalf("export error: %v", err)
+	}
+	if string(out) != "kind: Service\nname: a\n---\nkind: Deployment\nname: b\n" {
+		t.Fatalf("unexpected export:\n%s", out)
+	}
+
+	record, err := EvalDeep(`{ name = "a" }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	out, err = record.Export(ExportOptions{Format: ExportYAML, Documents: true})
+	if err != nil {
+		t.Fatalf("export error: %v", err)
+	}
+	if string(out) != "name: a\n" {
+		t.Fatalf("unexpected export:\n%s", out)
+	}
+
+	if _, err := expr.Export(ExportOptions{Documents: true}); err == nil {
+		t.Fatal("expected an error for JSON")
+	}
+}
+
+func TestEvalExport(t *testing.T) {
+	src := `{ a | optional, b | not_exported = 1, c = 2, d = { e | not_exported = 3, f = 4 } }`
+	expr, err := EvalExport(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := expr.String(); got != `{ c = 2, d = { f = 4 } }` {
+		t.Errorf("unexpected result: %s", got)
+	}
+	record, _ := expr.ToRecord()
+	if len(record) != 2 || record["b"] != nil {
+		t.Errorf("unexpected fields: %v", record)
+	}
+	var decoded map[string]any
+	if err := expr.ConvertTo(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 2 || len(decoded["d"].(map[string]any)) != 1 {
+		t.Errorf("unexpected conversion: %v", decoded)
+	}
+
+	// Without export, the fields are there, but MarshalJSON leaves them out.
+	expr, err = EvalDeep(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record, _ := expr.ToRecord(); len(record) != 3 {
+		t.Errorf("unexpected fields: %v", record)
+	}
+	exported, err := EvalExport(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := expr.MarshalJSON()
+	b, _ := exported.MarshalJSON()
+	if string(a) != string(b) {
+		t.Errorf("expected the same JSON, got %s and %s", a, b)
+	}
+}
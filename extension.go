@@ -0,0 +1,57 @@
+package nickel
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Extension bundles related contracts and host capabilities, so that they
+// can be set up on a Context in one go. See Context.Use.
+type Extension interface {
+	// Name identifies the extension. Using two extensions with the same
+	// name on one Context is an error.
+	Name() string
+	// Register sets up the extension on ctx, typically by calling
+	// RegisterContractSource, AllowEnv, and the like.
+	Register(ctx *Context) error
+}
+
+// Use registers the given extensions on ctx, in order.
+//
+// It stops at the first extension that fails to register, leaving the
+// earlier ones in place. An extension that fails can be retried.
+func (ctx *Context) Use(exts ...Extension) error {
+	for _, ext := range exts {
+		name := ext.Name()
+
+		// The name is claimed first, so that concurrent calls can't both
+		// register the same extension.
+		ctx.mu.Lock()
+		used := slices.Contains(ctx.extensions, name)
+		if !used {
+			ctx.extensions = append(ctx.extensions, name)
+		}
+		ctx.mu.Unlock()
+		if used {
+			return fmt.Errorf("extension %q is already in use", name)
+		}
+
+		// Register typically calls back into ctx, so it can't be called
+		// with the lock held.
+		if err := ext.Register(ctx); err != nil {
+			ctx.mu.Lock()
+			ctx.extensions = slices.DeleteFunc(ctx.extensions, func(n string) bool { return n == name })
+			ctx.mu.Unlock()
+			return fmt.Errorf("registering extension %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Extensions returns the names of the extensions in use on ctx, in the
+// order that they were registered.
+func (ctx *Context) Extensions() []string {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return slices.Clone(ctx.extensions)
+}
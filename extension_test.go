@@ -0,0 +1,48 @@
+package nickel
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+type testExtension struct {
+	name string
+	err  error
+}
+
+func (ext testExtension) Name() string {
+	return ext.name
+}
+
+func (ext testExtension) Register(ctx *Context) error {
+	if ext.err != nil {
+		return ext.err
+	}
+	ctx.AllowExec(ext.name+"-version", nil, func([]string) ([]byte, error) { return []byte("1"), nil })
+	return ctx.RegisterContractSource("Port", `std.contract.from_predicate (fun p => std.is_number p && p > 0 && p < 65536)`)
+}
+
+func TestUse(t *testing.T) {
+	ctx := NewContext()
+	if err := ctx.Use(testExtension{name: "net"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.Validate(`8080`, "Port"); err != nil {
+		t.Errorf("validate error: %v", err)
+	}
+	if expr, err := ctx.EvalDeep(`host.exec "net-version" []`); err != nil || expr.String() != `"1"` {
+		t.Errorf("unexpected result: %v (%v)", expr, err)
+	}
+
+	if err := ctx.Use(testExtension{name: "net"}); err == nil {
+		t.Error("expected an error using an extension twice")
+	}
+	broken := errors.New("broken")
+	if err := ctx.Use(testExtension{name: "other", err: broken}); !errors.Is(err, broken) {
+		t.Errorf("expected the registration error, got %v", err)
+	}
+	if got := ctx.Extensions(); !slices.Equal(got, []string{"net"}) {
+		t.Errorf("unexpected extensions: %v", got)
+	}
+}
@@ -0,0 +1,104 @@
+package nickel
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// EvalFile reads the Nickel program in the file at path and evaluates it
+// deeply (see EvalDeep).
+//
+// Error messages refer to the program by its path, and relative imports in
+// it are resolved from the directory containing it.
+func (ctx *Context) EvalFile(path string) (*Expr, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.evalDeep(string(src), evalOptions{name: path})
+}
+
+// fileCache holds the results of EvalFileCached.
+type fileCache struct {
+	mu      sync.Mutex
+	entries map[string]*fileCacheEntry
+}
+
+type fileCacheEntry struct {
+	expr    *Expr
+	evalAt  time.Time
+	modTime time.Time
+	size    int64
+	hash    [sha256.Size]byte
+}
+
+// EvalFileCached is like EvalFile, but reuses the result of a previous call
+// for the same file if it was evaluated less than ttl ago and the file
+// hasn't changed since.
+//
+// A file counts as changed when its contents do: if only its modification
+// time changes, the cached result is kept. Files imported by the program
+// aren't checked, so a change to one of them is only noticed once the ttl
+// has elapsed. Errors aren't cached.
+//
+// The returned Expr is shared between callers, which is fine since Exprs
+// are never modified.
+func (ctx *Context) EvalFileCached(path string, ttl time.Duration) (*Expr, error) {
+	key, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.files.mu.Lock()
+	entry := ctx.files.entries[key]
+	ctx.files.mu.Unlock()
+
+	fresh := entry != nil && time.Since(entry.evalAt) < ttl
+	if fresh && info.ModTime().Equal(entry.modTime) && info.Size() == entry.size {
+		return entry.expr, nil
+	}
+
+	src, err := os.ReadFile(key)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(src)
+	if fresh && hash == entry.hash {
+		updated := *entry
+		updated.modTime = info.ModTime()
+		updated.size = info.Size()
+		ctx.files.store(key, &updated)
+		return entry.expr, nil
+	}
+
+	evalAt := time.Now()
+	expr, err := ctx.evalDeep(string(src), evalOptions{name: path})
+	if err != nil {
+		return nil, err
+	}
+	ctx.files.store(key, &fileCacheEntry{
+		expr:    expr,
+		evalAt:  evalAt,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		hash:    hash,
+	})
+	return expr, nil
+}
+
+func (c *fileCache) store(key string, entry *fileCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[string]*fileCacheEntry{}
+	}
+	c.entries[key] = entry
+}
@@ -0,0 +1,90 @@
+package nickel
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvalFile(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.ncl")
+	if err := os.WriteFile(filepath.Join(dir, "other.ncl"), []byte(`{ x = 1 }`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(main, []byte(`(import "other.ncl").x + 1`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := NewContext()
+	expr, err := ctx.EvalFile(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := expr.ToInt64(); n != 2 {
+		t.Errorf("expected 2, got %v", expr)
+	}
+
+	if err := os.WriteFile(main, []byte(`1 + true`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ctx.EvalFile(main)
+	if err == nil || !strings.Contains(err.Error(), main) {
+		t.Errorf("expected an error mentioning %s, got %v", main, err)
+	}
+
+	// The source name only applies to the file.
+	_, err = ctx.EvalDeep(`1 + true`)
+	if err == nil || strings.Contains(err.Error(), main) {
+		t.Errorf("expected an error not mentioning %s, got %v", main, err)
+	}
+}
+
+func TestEvalFileCached(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.ncl")
+	write := func(src string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+
+	ctx := NewContext()
+	write(`{ x = 1 }`, now)
+	first, err := ctx.EvalFileCached(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ctx.EvalFileCached(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Error("expected the cached result")
+	}
+
+	// Touching the file without changing it keeps the result.
+	write(`{ x = 1 }`, now.Add(time.Second))
+	if third, _ := ctx.EvalFileCached(path, time.Hour); third != first {
+		t.Error("expected the cached result after touching the file")
+	}
+
+	write(`{ x = 2 }`, now.Add(2*time.Second))
+	changed, err := ctx.EvalFileCached(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed == first || changed.String() != "{ x = 2 }" {
+		t.Errorf("expected a new result, got %v", changed)
+	}
+
+	if expired, _ := ctx.EvalFileCached(path, 0); expired == changed {
+		t.Error("expected a new result after the ttl")
+	}
+}
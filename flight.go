@@ -0,0 +1,76 @@
+package nickel
+
+import (
+	"errors"
+	"sync"
+)
+
+// flightGroup coalesces concurrent evaluations of the same thing, so that
+// only one of them goes through the Nickel library.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done chan struct{}
+	expr *Expr
+	err  error
+}
+
+// errEvalPanicked is what the callers waiting for an evaluation get if it
+// panics. The caller that ran it gets the panic.
+var errEvalPanicked = errors.New("nickel: coalesced evaluation panicked")
+
+// do calls eval and returns its result, unless a call with the same key is
+// already in progress, in which case it waits for that one and returns its
+// result instead.
+func (g *flightGroup) do(key string, eval func() (*Expr, error)) (*Expr, error) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.expr, call.err
+	}
+	call := &flightCall{done: make(chan struct{}), err: errEvalPanicked}
+	if g.calls == nil {
+		g.calls = map[string]*flightCall{}
+	}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.expr, call.err = eval()
+	return call.expr, call.err
+}
+
+// SetCoalescing sets whether concurrent calls evaluating the same program
+// (with EvalDeep, EvalShallow and the like) are coalesced into a single
+// evaluation. It's off by default.
+//
+// Coalesced calls all get the same Expr. Exprs can be used concurrently,
+// but callers that prefetch its parts (see Expr.PrefetchChildren) do it for
+// the others too. Evaluations aren't coalesced in explicit close mode (see
+// SetExplicitClose), where every caller needs a result of its own to close.
+func (ctx *Context) SetCoalescing(enabled bool) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.coalescing = enabled
+}
+
+// coalesce runs eval through ctx.flights, if coalescing is on.
+func (ctx *Context) coalesce(key string, eval func() (*Expr, error)) (*Expr, error) {
+	ctx.mu.Lock()
+	enabled := ctx.coalescing && !ctx.explicitClose
+	ctx.mu.Unlock()
+	if !enabled {
+		return eval()
+	}
+	return ctx.flights.do(key, eval)
+}
@@ -0,0 +1,90 @@
+package nickel
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/synctest"
+)
+
+func TestFlightGroup(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		var g flightGroup
+		var calls atomic.Int32
+		release := make(chan struct{})
+		started := make(chan struct{})
+		want := &Expr{kind: KindNull}
+
+		var wg sync.WaitGroup
+		results := make([]*Expr, 10)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[0], _ = g.do("key", func() (*Expr, error) {
+				calls.Add(1)
+				close(started)
+				<-release
+				return want, nil
+			})
+		}()
+		<-started
+		for i := 1; i < len(results); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i], _ = g.do("key", func() (*Expr, error) {
+					calls.Add(1)
+					return want, nil
+				})
+			}()
+		}
+
+		// Wait for all the calls to be waiting for the first one.
+		synctest.Wait()
+		close(release)
+		wg.Wait()
+
+		for i, r := range results {
+			if r != want {
+				t.Errorf("result %d: got %v", i, r)
+			}
+		}
+		if n := calls.Load(); n != 1 {
+			t.Errorf("unexpected number of calls: %d", n)
+		}
+
+		// Once a call is done, the next one runs again.
+		g.do("key", func() (*Expr, error) {
+			calls.Add(1)
+			return want, nil
+		})
+		if calls.Load() != 2 || len(g.calls) != 0 {
+			t.Errorf("calls left behind: %v", g.calls)
+		}
+	})
+}
+
+func TestCoalescing(t *testing.T) {
+	ctx := NewContext()
+	own := &Expr{kind: KindNull}
+	shared := &Expr{kind: KindBool}
+	call := &flightCall{done: make(chan struct{}), expr: shared}
+	ctx.flights.calls = map[string]*flightCall{"key": call}
+
+	// Coalescing is off by default, so the call in progress is ignored.
+	expr, _ := ctx.coalesce("key", func() (*Expr, error) { return own, nil })
+	if expr != own {
+		t.Errorf("expected a result of its own, got %v", expr)
+	}
+
+	ctx.SetCoalescing(true)
+	close(call.done)
+	if expr, _ := ctx.coalesce("key", func() (*Expr, error) { return own, nil }); expr != shared {
+		t.Errorf("expected the shared result, got %v", expr)
+	}
+
+	ctx.SetExplicitClose(true)
+	if expr, _ := ctx.coalesce("key", func() (*Expr, error) { return own, nil }); expr != own {
+		t.Errorf("expected a result of its own in explicit close mode, got %v", expr)
+	}
+}
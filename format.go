@@ -0,0 +1,292 @@
+package nickel
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits on how much of a value String renders. Anything beyond these is
+// elided with "...", so that logging a huge configuration stays cheap.
+const (
+	stringMaxDepth  = 4
+	stringMaxItems  = 8
+	stringMaxStrLen = 64
+)
+
+// String implements the fmt.Stringer interface for Expr.
+//
+// It renders the expression in a concise, Nickel-like syntax. Large records,
+// arrays, strings, and deeply nested values are truncated, expressions
+// that haven't been evaluated yet are shown as <lazy>, and functions as
+// <function>. The output is meant for humans (debug logs, test failures); use
+// MarshalJSON for a faithful serialization.
+func (expr *Expr) String() string {
+	var b strings.Builder
+	writeShort(&b, expr, 0)
+	return b.String()
+}
+
+// GoString implements the fmt.GoStringer interface for Expr, which is used by
+// the %#v verb.
+//
+// It shows the kind of the expression as well as the (truncated) value
+// rendered by String. For a view of nested values, see DebugDump.
+func (expr *Expr) GoString() string {
+	if expr == nil {
+		return "(*nickel.Expr)(nil)"
+	}
+	return fmt.Sprintf("&nickel.Expr{Kind: %s, Value: %s}", kindGoNames[expr.kind], expr.String())
+}
+
+var kindGoNames = [...]string{
+	KindThunk:       "nickel.KindThunk",
+	KindNull:        "nickel.KindNull",
+	KindBool:        "nickel.KindBool",
+	KindNumber:      "nickel.KindNumber",
+	KindString:      "nickel.KindString",
+	KindEnumTag:     "nickel.KindEnumTag",
+	KindEnumVariant: "nickel.KindEnumVariant",
+	KindRecord:      "nickel.KindRecord",
+	KindArray:       "nickel.KindArray",
+	KindFunction:    "nickel.KindFunction",
+}
+
+// DebugDump writes a description of the expression to w, with one line
+// per nested value showing its kind and whether it has been evaluated.
+//
+// This is meant to help figure out the shape of a partially evaluated value
+// (see EvalShallow). Nesting deeper than maxDepth is elided; a negative
+// maxDepth means no limit. Nothing is evaluated by DebugDump.
+func (expr *Expr) DebugDump(w io.Writer, maxDepth int) error {
+	d := dumper{w: w, maxDepth: maxDepth}
+	d.dump(expr, 0)
+	return d.err
+}
+
+type dumper struct {
+	w        io.Writer
+	maxDepth int
+	// The first write error. Once this is set, nothing else is written.
+	err error
+}
+
+func (d *dumper) line(depth int, format string, args ...any) {
+	if d.err != nil {
+		return
+	}
+	_, d.err = fmt.Fprintf(d.w, "%s"+format+"\n", append([]any{strings.Repeat("  ", depth)}, args...)...)
+}
+
+func (d *dumper) dump(expr *Expr, depth int) {
+	d.dumpLabeled("", expr, depth)
+}
+
+func (d *dumper) dumpLabeled(label string, expr *Expr, depth int) {
+	if expr == nil {
+		d.line(depth, "%sundefined (field without a value)", label)
+		return
+	}
+
+	switch expr.kind {
+	case KindRecord:
+		d.line(depth, "%srecord (%d fields)", label, expr.Len())
+		if d.descend(depth, expr.Len()) {
+			names, indexes := expr.sortedFields()
+			for i, name := range names {
+				d.dumpLabeled(formatIdent(name)+": ", expr.fieldAt(indexes[i]), depth+1)
+			}
+		}
+	case KindArray:
+		d.line(depth, "%sarray (%d elements)", label, expr.Len())
+		if d.descend(depth, expr.Len()) {
+			elems, _ := expr.ToArray()
+			for i, elem := range elems {
+				d.dumpLabeled(fmt.Sprintf("[%d]: ", i), elem, depth+1)
+			}
+		}
+	case KindEnumVariant:
+		tag, payload, _ := expr.ToEnumVariant()
+		d.line(depth, "%senum variant '%s", label, formatIdent(tag))
+		if d.descend(depth, 1) {
+			d.dumpLabeled("payload: ", payload, depth+1)
+		}
+	case KindThunk:
+		d.line(depth, "%sthunk (not evaluated)", label)
+	case KindFunction:
+		d.line(depth, "%sfunction", label)
+	default:
+		d.line(depth, "%s%s %s", label, expr.kind, expr.String())
+	}
+}
+
+// descend reports whether the children of a value at the given depth should
+// be written, writing an elision marker if not.
+func (d *dumper) descend(depth int, children int) bool {
+	if children == 0 {
+		return false
+	}
+	if d.maxDepth >= 0 && depth >= d.maxDepth {
+		d.line(depth+1, "...")
+		return false
+	}
+	return true
+}
+
+func writeShort(b *strings.Builder, expr *Expr, depth int) {
+	if expr == nil {
+		b.WriteString("<undefined>")
+		return
+	}
+
+	switch expr.kind {
+	case KindNull:
+		b.WriteString("null")
+	case KindBool:
+		b.WriteString(strconv.FormatBool(expr.b))
+	case KindNumber:
+		b.WriteString(formatNumber(expr))
+	case KindString:
+		s, _ := expr.ToString()
+		if len(s) > stringMaxStrLen {
+			b.WriteString(strconv.Quote(truncateUTF8(s, stringMaxStrLen)))
+			b.WriteString("...")
+		} else {
+			b.WriteString(strconv.Quote(s))
+		}
+	case KindEnumTag:
+		tag, _ := expr.ToEnumTag()
+		b.WriteString("'")
+		b.WriteString(formatIdent(tag))
+	case KindEnumVariant:
+		tag, payload, _ := expr.ToEnumVariant()
+		b.WriteString("'")
+		b.WriteString(formatIdent(tag))
+		b.WriteString(" ")
+		if depth >= stringMaxDepth {
+			b.WriteString("...")
+		} else {
+			writeShort(b, payload, depth+1)
+		}
+	case KindRecord:
+		if expr.Len() == 0 {
+			b.WriteString("{}")
+			return
+		}
+		if depth >= stringMaxDepth {
+			b.WriteString("{ ... }")
+			return
+		}
+
+		// Only the values of the fields that are written are retrieved.
+		names, indexes := expr.sortedFields()
+		b.WriteString("{ ")
+		for i, name := range names {
+			if i == stringMaxItems {
+				b.WriteString(", ...")
+				break
+			}
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(formatIdent(name))
+			b.WriteString(" = ")
+			writeShort(b, expr.fieldAt(indexes[i]), depth+1)
+		}
+		b.WriteString(" }")
+	case KindArray:
+		n := expr.Len()
+		if n == 0 {
+			b.WriteString("[]")
+			return
+		}
+		if depth >= stringMaxDepth {
+			b.WriteString("[ ... ]")
+			return
+		}
+
+		elems, _ := expr.Slice(0, min(n, stringMaxItems))
+		b.WriteString("[")
+		for i, elem := range elems {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeShort(b, elem, depth+1)
+		}
+		if n > stringMaxItems {
+			b.WriteString(", ...")
+		}
+		b.WriteString("]")
+	case KindFunction:
+		b.WriteString("<function>")
+	default:
+		b.WriteString("<lazy>")
+	}
+}
+
+// formatNumber renders a number expression, exactly if it's an int64 and
+// rounded to a float64 otherwise.
+func formatNumber(expr *Expr) string {
+	if i, ok := expr.ToInt64(); ok {
+		return strconv.FormatInt(i, 10)
+	}
+	f, _ := expr.ToFloat64()
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// formatIdent renders a record key or enum tag, quoting it if it isn't a
+// valid bare Nickel identifier.
+func formatIdent(s string) string {
+	if isIdent(s) {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+// keywords are the words that can't be used as bare record keys.
+var keywords = map[string]bool{
+	"let": true, "in": true, "rec": true, "if": true, "then": true, "else": true,
+	"fun": true, "match": true, "import": true, "null": true, "true": true,
+	"false": true, "forall": true, "Number": true, "String": true, "Bool": true,
+	"Dyn": true, "Array": true,
+}
+
+// isIdent reports whether s can be written as a Nickel identifier without
+// quotes.
+func isIdent(s string) bool {
+	if s == "" || s == "_" || keywords[s] {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z'):
+		case i > 0 && (c == '-' || c == '\'' || ('0' <= c && c <= '9')):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func sortedKeys(fields map[string]*Expr) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,50 @@
+package nickel
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// SetGlobals makes values available to every program evaluated in the
+// context, as variables: with a global named region, a program can refer to
+// `region` directly. This is a way of passing the same host-provided
+// information (a region, a cluster name, build information) to every
+// program, without writing it into their sources.
+//
+// The names must be plain Nickel identifiers, and the values can be an *Expr,
+// or anything that encoding/json can marshal. The values are converted once,
+// by SetGlobals. The globals replace the ones set before, and a nil or empty
+// map removes them.
+//
+// The globals are only bound in the programs given to the context's
+// evaluation functions, not in the files they import, and the programs'
+// own bindings take precedence. The host record (see AllowEnv) takes
+// precedence over a global named host.
+func (ctx *Context) SetGlobals(globals map[string]any) error {
+	var b strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(globals)) {
+		if !isIdent(name) {
+			return fmt.Errorf("invalid global name %q", name)
+		}
+		src, err := valueSource(globals[name])
+		if err != nil {
+			return fmt.Errorf("global %s: %w", name, err)
+		}
+		// The bindings are kept on one line, so that the line numbers in
+		// error messages are the program's.
+		b.WriteString("let " + name + " = " + src + " in ")
+	}
+
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.globals = b.String()
+	return nil
+}
+
+func (ctx *Context) globalsPrelude() string {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.globals
+}
@@ -0,0 +1,59 @@
+package nickel
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGlobals(t *testing.T) {
+	ctx := NewContext()
+	build, err := ctx.EvalDeep(`{ version = "1.2", commit = "abc" }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if err := ctx.SetGlobals(map[string]any{
+		"region":   "eu-west-1",
+		"replicas": 3,
+		"build":    build,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expr, err := ctx.EvalDeep(`{ name = "srv-" ++ region, count = replicas, version = build.version }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got := expr.String(); got != `{ count = 3, name = "srv-eu-west-1", version = "1.2" }` {
+		t.Errorf("unexpected result: %s", got)
+	}
+	expr, err = ctx.EvalShallow(`let region = "local" in region`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got := expr.String(); got != `"local"` {
+		t.Errorf("expected the program's binding to win, got %s", got)
+	}
+
+	// Line numbers in errors are the program's.
+	_, err = ctx.EvalDeep("{\n  x = region + 1,\n}")
+	if err == nil || !strings.Contains(err.Error(), "<source>:2:") {
+		t.Errorf("expected an error on line 2, got %v", err)
+	}
+
+	if err := ctx.SetGlobals(map[string]any{"not valid": 1}); err == nil {
+		t.Error("expected an error for an invalid name")
+	}
+	if err := ctx.SetGlobals(map[string]any{"f": func() {}}); err == nil {
+		t.Error("expected an error for an unencodable value")
+	}
+	if _, err := ctx.EvalDeep("region"); err != nil {
+		t.Errorf("expected failed calls to keep the globals, got %v", err)
+	}
+
+	if err := ctx.SetGlobals(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.EvalDeep("region"); err == nil {
+		t.Error("expected an error without globals")
+	}
+}
@@ -0,0 +1,63 @@
+package nickel
+
+/*
+#include <nickel_lang.h>
+*/
+import "C"
+
+import "sync/atomic"
+
+// HandleCounts are numbers of native objects of the Nickel library.
+type HandleCounts struct {
+	Contexts int64
+	Exprs    int64
+	Errors   int64
+	Strings  int64
+}
+
+// The numbers of live native objects, see LiveHandles.
+var liveContexts, liveExprs, liveErrors, liveStrings atomic.Int64
+
+// LiveHandles returns the numbers of native objects that the package has
+// allocated and not yet freed. It's safe to call at any time, and cheap
+// enough to export as a metric.
+//
+// Native memory doesn't show up in Go heap profiles, so these counts are how
+// to notice Exprs being retained for longer than intended. Contexts, Exprs
+// and Errors are freed once they're garbage collected, so the counts only go
+// down after a garbage collection, unless explicit close is on (see
+// Context.SetExplicitClose): then the Exprs of a result are freed by Close.
+// A count of Exprs that keeps growing from one garbage collection to the
+// next points to a leak, such as a cache that's never trimmed or results
+// that aren't closed. Strings only live for the duration of a call.
+func LiveHandles() HandleCounts {
+	return HandleCounts{
+		Contexts: liveContexts.Load(),
+		Exprs:    liveExprs.Load(),
+		Errors:   liveErrors.Load(),
+		Strings:  liveStrings.Load(),
+	}
+}
+
+// The allocation functions of the Nickel library, keeping count of the
+// objects.
+
+func allocExpr() *C.nickel_expr {
+	liveExprs.Add(1)
+	return C.nickel_expr_alloc()
+}
+
+func freeExpr(ptr *C.nickel_expr) {
+	C.nickel_expr_free(ptr)
+	liveExprs.Add(-1)
+}
+
+func allocString() *C.nickel_string {
+	liveStrings.Add(1)
+	return C.nickel_string_alloc()
+}
+
+func freeString(s *C.nickel_string) {
+	C.nickel_string_free(s)
+	liveStrings.Add(-1)
+}
@@ -0,0 +1,57 @@
+package nickel
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// settleHandles waits for the finalizers of the garbage left by other tests
+// to run, so that they don't change the counts during a test. Finalizing an
+// Expr can make its Context garbage in turn, so the counts need to stay the
+// same over a few collections.
+func settleHandles() {
+	stable := 0
+	for range 100 {
+		runtime.GC()
+		counts := LiveHandles()
+		time.Sleep(time.Millisecond)
+		if LiveHandles() != counts {
+			stable = 0
+		} else if stable++; stable == 3 {
+			return
+		}
+	}
+}
+
+func TestLiveHandles(t *testing.T) {
+	settleHandles()
+	before := LiveHandles()
+	ctx := NewContext()
+	if got := LiveHandles().Contexts; got != before.Contexts+1 {
+		t.Errorf("expected %d contexts, got %d", before.Contexts+1, got)
+	}
+
+	// Explicit close frees deterministically, unlike finalizers.
+	ctx.SetExplicitClose(true)
+	before = LiveHandles()
+	expr, err := ctx.EvalShallow(`{ a = [1, 2], b = 1 + 1 }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if err := expr.PrefetchChildren(2); err != nil {
+		t.Fatalf("prefetch error: %v", err)
+	}
+	record, _ := expr.ToRecord()
+	if _, err := record["b"].EvalShallow(); err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	// The result, a, its two elements, b, and b evaluated again.
+	if got := LiveHandles().Exprs - before.Exprs; got != 6 {
+		t.Errorf("expected 6 more exprs, got %d", got)
+	}
+	expr.Close()
+	if got := LiveHandles(); got.Exprs != before.Exprs || got.Strings != before.Strings {
+		t.Errorf("expected the handles to be freed, got %+v, had %+v", got, before)
+	}
+}
@@ -0,0 +1,70 @@
+package nickel
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ProgramHash returns a hash of the Nickel program src and of the files it
+// imports, directly or not, for use as a cache key, or to tell whether a
+// configuration changed, without evaluating it.
+//
+// The imports are found like for Pack, so imports of paths with
+// interpolations aren't taken into account. The hash only depends on the
+// contents of the files, their extension, and which files import which:
+// it's the same for a copy of the files in another directory, and changes
+// if any of the files do. It doesn't cover the settings of the context,
+// like globals and host capabilities, which can change the result of an
+// evaluation too.
+//
+// The hash is "sha256:" followed by a hexadecimal SHA-256, like the hashes
+// of a BundleManifest.
+func (ctx *Context) ProgramHash(src string) (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return ctx.programHash(src, filepath.Join(wd, defaultSourceName))
+}
+
+// ProgramHashFile is like ProgramHash, for the program in the file at path,
+// with its imports relative to it, as for EvalFile.
+func (ctx *Context) ProgramHashFile(path string) (string, error) {
+	main, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	src, err := os.ReadFile(main)
+	if err != nil {
+		return "", err
+	}
+	return ctx.programHash(string(src), main)
+}
+
+func (ctx *Context) programHash(src string, main string) (string, error) {
+	files, err := ctx.importClosure(src, main)
+	if err != nil {
+		return "", err
+	}
+
+	// Files are numbered in the order they're found, which only depends on
+	// their contents.
+	h := sha256.New()
+	for i, file := range files {
+		data, ext := file.src, filepath.Ext(file.path)
+		if i == 0 {
+			// The resolved imports of the program are absolute paths, and
+			// it's Nickel whatever its name.
+			data, ext = []byte(src), ""
+		}
+		fmt.Fprintf(h, "%s %q", fileHash(data), ext)
+		for _, target := range file.targets {
+			fmt.Fprintf(h, " %d", target)
+		}
+		fmt.Fprintln(h)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,66 @@
+package nickel
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProgramHash(t *testing.T) {
+	write := func(dir string, files map[string]string) {
+		for name, src := range files {
+			path := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	files := map[string]string{
+		"main.ncl":      `{ a = import "lib/a.ncl", b = import "b.json" } # import "ignored.ncl"`,
+		"lib/a.ncl":     `import "../b.json"`,
+		"b.json":        `{"x": 1}`,
+		"unrelated.ncl": `1`,
+	}
+	first, second := t.TempDir(), t.TempDir()
+	write(first, files)
+	write(second, files)
+
+	ctx := NewContext()
+	hash := func(path string) string {
+		t.Helper()
+		h, err := ctx.ProgramHashFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	h := hash(filepath.Join(first, "main.ncl"))
+	if h != hash(filepath.Join(second, "main.ncl")) {
+		t.Error("expected the same hash for copies of the files")
+	}
+
+	write(second, map[string]string{"unrelated.ncl": `2`})
+	if got := hash(filepath.Join(second, "main.ncl")); got != h {
+		t.Error("expected files that aren't imported not to change the hash")
+	}
+	write(second, map[string]string{"b.json": `{"x": 2}`})
+	if got := hash(filepath.Join(second, "main.ncl")); got == h {
+		t.Error("expected a change to an imported file to change the hash")
+	}
+
+	// The hash of a program given as source doesn't depend on its name.
+	src, err := ctx.ProgramHash(files["unrelated.ncl"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src != hash(filepath.Join(first, "unrelated.ncl")) {
+		t.Error("expected the same hash for the source of a file")
+	}
+
+	if _, err := ctx.ProgramHash(`import "missing.ncl"`); err == nil {
+		t.Error("expected an error for a missing import")
+	}
+}
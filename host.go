@@ -0,0 +1,676 @@
+package nickel
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// Nickel programs can't call back into Go while they are being evaluated,
+// so the host capabilities are provided as a `host` record that is bound
+// before every evaluation, and filled in from the Go side at that point.
+// The record only has the values that the evaluation asked for so far, so
+// that the values it doesn't use aren't read, and don't show up in error
+// messages. Asking for another one fails with a request (see hostRequest),
+// which evalWithHost serves, and reports to the audit function, before
+// evaluating the program again.
+//
+// host.trace_value reports its values through std.trace, with messages
+// marked so that they can be told apart from the program's own traces.
+
+// The prefix of the trace messages of host.trace_value. Nickel strings can
+// contain control characters as-is, and programs are unlikely to trace this
+// one.
+const hostTraceMarker = "\x1e"
+
+// HostAccess describes an attempt by a Nickel program to use a host
+// capability. See Context.SetHostAudit.
+type HostAccess struct {
+	// Capability is the name of the capability in the `host` record, such
+	// as "env".
+	Capability string
+	// Name is what was asked for, such as the name of an environment
+	// variable.
+	Name string
+	// Args are the arguments passed to host.exec.
+	Args []string
+	// Allowed is false if the access was denied.
+	Allowed bool
+}
+
+// hostSettings are the host capabilities enabled on a Context.
+type hostSettings struct {
+	env      []string
+	files    []string
+	fetches  []hostFetch
+	commands []hostCommand
+	audit    func(HostAccess)
+	// See SetTraceValueHandler.
+	traceValue func(label string, value *Expr)
+}
+
+type hostCommand struct {
+	name     string
+	validate func(args []string) error
+	run      ExecFunc
+}
+
+type hostFetch struct {
+	url   string
+	fetch FetchFunc
+}
+
+// AllowEnv lets the Nickel programs evaluated by ctx read the given
+// environment variables, by calling `host.env "NAME"`. The result is the
+// variable's value, or null if it isn't set; asking for a variable that
+// wasn't allowed is an error.
+//
+// A variable is read when a program first uses it, at most once per
+// evaluation, and the evaluation starts over as described in AllowFetch.
+// Without any host capabilities enabled (the default), `host` is an
+// ordinary unbound variable.
+//
+// While host capabilities are enabled, the `host` binding is put in front
+// of the program, on its first line. Error messages pointing at the first
+// line show it, with the values that the evaluation used, and their column
+// numbers for that line are shifted.
+func (ctx *Context) AllowEnv(names ...string) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	for _, name := range names {
+		if !slices.Contains(ctx.host.env, name) {
+			ctx.host.env = append(ctx.host.env, name)
+		}
+	}
+}
+
+// AllowFiles lets the Nickel programs evaluated by ctx read the files
+// matching the given patterns (in the syntax of filepath.Match), by calling
+// `host.read_file "path"`. The result is the contents of the file as a
+// string; asking for a file that wasn't allowed is an error.
+//
+// The path has to be given exactly as the pattern matches it: with the
+// pattern "certs/*.pem", a program can read "certs/ca.pem" but not
+// "./certs/ca.pem". A file is read when a program first uses it, at most
+// once per evaluation, and the evaluation starts over as described in
+// AllowFetch. Files that can't be read, or that aren't valid UTF-8 text,
+// are only an error for the programs that read them.
+//
+// See AllowEnv for how enabling host capabilities affects error messages.
+func (ctx *Context) AllowFiles(patterns ...string) error {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid file pattern %q: %w", pattern, err)
+		}
+	}
+
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	for _, pattern := range patterns {
+		if !slices.Contains(ctx.host.files, pattern) {
+			ctx.host.files = append(ctx.host.files, pattern)
+		}
+	}
+	return nil
+}
+
+// fileAllowed reports whether path matches one of the patterns given to
+// AllowFiles, and isn't something other than a regular file.
+func fileAllowed(patterns []string, path string) bool {
+	matches := slices.ContainsFunc(patterns, func(pattern string) bool {
+		// The patterns were checked by AllowFiles.
+		ok, _ := filepath.Match(pattern, path)
+		return ok
+	})
+	if !matches {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err != nil || info.Mode().IsRegular()
+}
+
+// FetchFunc retrieves the contents of a URL for `host.fetch`. See
+// Context.AllowFetch.
+type FetchFunc func(url string) ([]byte, error)
+
+// AllowFetch lets the Nickel programs evaluated by ctx get the contents of
+// the given URLs, by calling `host.fetch "url"`. The result is the contents
+// as a string; asking for a URL that wasn't allowed is an error.
+//
+// The contents are retrieved by calling fetch, which is where TLS, proxies,
+// retries, and caching are up to the caller. A URL is fetched when a
+// program first uses it, at most once per evaluation. Since programs can't
+// call back into Go during evaluation, the evaluation stops there and
+// starts over once the contents are in. The trace output that it repeats
+// isn't written again. A failed fetch is only an error for the
+// programs that use the URL.
+//
+// An evaluation can get at most 100 values from the host this way, for
+// all the capabilities together, and the evaluation timeout (see
+// SetEvalTimeout) covers all its rounds, and the fetches.
+//
+// The results of EvalShallow can only use the URLs that their evaluation
+// used: fetching another one when forcing them further is an error.
+//
+// See AllowEnv for how enabling host capabilities affects error messages.
+func (ctx *Context) AllowFetch(fetch FetchFunc, urls ...string) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	for _, url := range urls {
+		i := slices.IndexFunc(ctx.host.fetches, func(f hostFetch) bool { return f.url == url })
+		if i >= 0 {
+			ctx.host.fetches[i].fetch = fetch
+		} else {
+			ctx.host.fetches = append(ctx.host.fetches, hostFetch{url: url, fetch: fetch})
+		}
+	}
+}
+
+// ExecFunc runs a command for `host.exec` with the arguments that the
+// program passed, and returns its output. See Context.AllowExec.
+type ExecFunc func(args []string) ([]byte, error)
+
+// ErrOutputTooLarge is returned (wrapped) by the ExecFuncs made by Command
+// when a command writes more output than allowed.
+var ErrOutputTooLarge = errors.New("command output too large")
+
+// Command returns an ExecFunc that runs the program at path with the given
+// arguments, followed by the ones from the Nickel program, and returns what
+// it writes to its standard output. It's an error for the program to fail,
+// or to write more than maxOutput bytes.
+func Command(maxOutput int, path string, args ...string) ExecFunc {
+	args = slices.Clip(args)
+	return func(extra []string) ([]byte, error) {
+		cmd := exec.Command(path, append(args, extra...)...)
+		stdout := &limitedBuffer{max: maxOutput}
+		stderr := &limitedBuffer{max: 1024, truncate: true}
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		if err := cmd.Run(); err != nil {
+			if stdout.exceeded {
+				return nil, fmt.Errorf("%s: %w (more than %d bytes)", path, ErrOutputTooLarge, maxOutput)
+			}
+			if msg := strings.TrimSpace(stderr.buf.String()); msg != "" {
+				return nil, fmt.Errorf("%s: %w: %s", path, err, msg)
+			}
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return stdout.buf.Bytes(), nil
+	}
+}
+
+// limitedBuffer is a buffer with a maximum size. Writes past it fail, or
+// are dropped if truncate is set.
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	max      int
+	truncate bool
+	exceeded bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.buf.Len()+len(p) > b.max {
+		b.exceeded = true
+		if b.truncate {
+			b.buf.Write(p[:b.max-b.buf.Len()])
+			return len(p), nil
+		}
+		return 0, ErrOutputTooLarge
+	}
+	return b.buf.Write(p)
+}
+
+// AllowExec lets the Nickel programs evaluated by ctx use the output of a
+// command, by calling `host.exec "name" ["arg", ..]`. The result is the
+// output as a string; asking for a command that wasn't allowed, or passing
+// arguments that validate rejects, is an error. With a nil validate, the
+// command takes no arguments.
+//
+// The arguments are checked by validate, then passed to run, typically made
+// with Command. A command is run when a program first uses it with the
+// given arguments, at most once per evaluation, and never for programs that
+// don't use it. As with AllowFetch, the evaluation starts over once the
+// output is in, and the results of EvalShallow can only use the commands
+// that their evaluation ran. A failed command is only an error for the
+// programs that use it.
+//
+// See AllowEnv for how enabling host capabilities affects error messages.
+func (ctx *Context) AllowExec(name string, validate func(args []string) error, run ExecFunc) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	command := hostCommand{name: name, validate: validate, run: run}
+	i := slices.IndexFunc(ctx.host.commands, func(c hostCommand) bool { return c.name == name })
+	if i >= 0 {
+		ctx.host.commands[i] = command
+	} else {
+		ctx.host.commands = append(ctx.host.commands, command)
+	}
+}
+
+// The start of the messages of the failures that ask for a host access.
+const hostRequestMarker = "go-nickel host request "
+
+// hostRequest is an access to a host capability that wasn't served yet. The
+// program fails with hostRequestMarker followed by the request as JSON.
+type hostRequest struct {
+	Capability string   `json:"capability"`
+	Name       string   `json:"name"`
+	Args       []string `json:"args"`
+	// Key is what the host function looks the result up by, the name and
+	// the arguments serialized by Nickel.
+	Key string `json:"key"`
+}
+
+// hostServed holds the results of the host requests served during an
+// evaluation, by capability.
+type hostServed map[string][]hostValue
+
+type hostValue struct {
+	key string
+	// The source of the result.
+	src string
+}
+
+// len returns the number of requests served.
+func (served hostServed) len() int {
+	n := 0
+	for _, values := range served {
+		n += len(values)
+	}
+	return n
+}
+
+func (served hostServed) has(req hostRequest) bool {
+	return slices.ContainsFunc(served[req.Capability], func(v hostValue) bool {
+		return v.key == req.Key
+	})
+}
+
+// The most host requests that one evaluation can make. Each one costs an
+// evaluation of the program.
+const maxHostRequests = 100
+
+// hostRound is one of the evaluations of a program by evalWithHost.
+type hostRound struct {
+	// The bindings to put in front of the program.
+	prelude string
+	// The deadline of the whole evaluation, the zero time if none, and
+	// the timeout it comes from.
+	deadline time.Time
+	timeout  time.Duration
+	// The length of the trace output of the previous round, which this
+	// one writes again, and the length of what it wrote so far.
+	skipTrace, traced int
+}
+
+// evalWithHost evaluates a program with eval, starting with the bindings in
+// prelude. Each time the program asks for a host access that wasn't
+// served, evalWithHost serves it and evaluates the program again, up to
+// maxHostRequests times, within the context's evaluation timeout overall.
+// scope is as in withPrelude.
+func (ctx *Context) evalWithHost(prelude string, scope string, eval func(round *hostRound) (*Expr, error)) (*Expr, error) {
+	served := hostServed{}
+	round := &hostRound{prelude: prelude}
+	round.deadline, round.timeout = ctx.deadline()
+	for {
+		expr, err := eval(round)
+		if err == nil {
+			return expr, nil
+		}
+		if ok, serveErr := ctx.serveHost(served, err); serveErr != nil {
+			return nil, serveErr
+		} else if !ok {
+			return nil, err
+		}
+		round = &hostRound{
+			prelude:   ctx.withPrelude(scope, served),
+			deadline:  round.deadline,
+			timeout:   round.timeout,
+			skipTrace: max(round.traced, round.skipTrace),
+		}
+	}
+}
+
+// serveHost adds the result of the host request that err makes, if any, to
+// served, and reports whether it did. Failures that look like requests for
+// capabilities that aren't enabled are left alone. It's an error to make
+// more than maxHostRequests.
+func (ctx *Context) serveHost(served hostServed, err error) (bool, error) {
+	var nickelErr *Error
+	if !errors.As(err, &nickelErr) {
+		return false, nil
+	}
+	diagnostics := nickelErr.Diagnostics()
+	if len(diagnostics) == 0 {
+		return false, nil
+	}
+	_, reqJSON, ok := strings.Cut(diagnostics[0].Message, hostRequestMarker)
+	if !ok {
+		return false, nil
+	}
+	var req hostRequest
+	if json.NewDecoder(strings.NewReader(reqJSON)).Decode(&req) != nil {
+		return false, nil
+	}
+	if len(req.Args) == 0 {
+		req.Args = nil
+	}
+	// A request for something that was served didn't come from host, and
+	// serving it again wouldn't change anything.
+	if served.has(req) || checkSource(QuoteString(req.Key)) != nil {
+		return false, nil
+	}
+
+	ctx.mu.Lock()
+	env := slices.Clone(ctx.host.env)
+	var files []string
+	if !ctx.sandbox {
+		files = slices.Clone(ctx.host.files)
+	}
+	fetches := slices.Clone(ctx.host.fetches)
+	commands := slices.Clone(ctx.host.commands)
+	audit := ctx.host.audit
+	ctx.mu.Unlock()
+	enabled := map[string]bool{
+		"env":       len(env) > 0,
+		"read_file": len(files) > 0,
+		"fetch":     len(fetches) > 0,
+		"exec":      len(commands) > 0,
+	}
+	if !enabled[req.Capability] {
+		return false, nil
+	}
+	if served.len() == maxHostRequests {
+		return false, fmt.Errorf("host: the program made more than %d host requests", maxHostRequests)
+	}
+
+	var src string
+	allowed := false
+	switch req.Capability {
+	case "env":
+		if slices.Contains(env, req.Name) {
+			allowed = true
+			if value, ok := os.LookupEnv(req.Name); ok {
+				src = hostText("env", req.Name, value)
+			} else {
+				src = "null"
+			}
+		} else {
+			src = hostFailure("env", "access to environment variable "+req.Name+" is not allowed")
+		}
+	case "read_file":
+		if fileAllowed(files, req.Name) {
+			allowed = true
+			src = hostResult("read_file", req.Name, func() ([]byte, error) { return os.ReadFile(req.Name) })
+		} else {
+			src = hostFailure("read_file", "access to file "+req.Name+" is not allowed")
+		}
+	case "fetch":
+		if i := slices.IndexFunc(fetches, func(f hostFetch) bool { return f.url == req.Name }); i >= 0 {
+			allowed = true
+			src = hostResult("fetch", req.Name, func() ([]byte, error) { return fetches[i].fetch(req.Name) })
+		} else {
+			src = hostFailure("fetch", "access to URL "+req.Name+" is not allowed")
+		}
+	case "exec":
+		i := slices.IndexFunc(commands, func(c hostCommand) bool { return c.name == req.Name })
+		switch {
+		case i < 0:
+			src = hostFailure("exec", "access to command "+req.Name+" is not allowed")
+		case commands[i].validate == nil && len(req.Args) > 0:
+			src = hostFailure("exec", req.Name+" takes no arguments")
+		case commands[i].validate != nil:
+			if err := commands[i].validate(req.Args); err != nil {
+				src = hostFailure("exec", "invalid arguments for "+req.Name+": "+err.Error())
+				break
+			}
+			fallthrough
+		default:
+			allowed = true
+			src = hostResult("exec", req.Name, func() ([]byte, error) { return commands[i].run(req.Args) })
+		}
+	}
+
+	served[req.Capability] = append(served[req.Capability], hostValue{key: req.Key, src: src})
+	if audit != nil {
+		audit(HostAccess{Capability: req.Capability, Name: req.Name, Args: req.Args, Allowed: allowed})
+	}
+	return true, nil
+}
+
+// hostResult calls fn, and returns the source of its result for a host
+// lookup.
+func hostResult(capability string, name string, fn func() ([]byte, error)) string {
+	data, err := fn()
+	if err != nil {
+		return hostFailure(capability, err.Error())
+	}
+	return hostText(capability, name, string(data))
+}
+
+// SetHostAudit registers a function that is called every time a Nickel
+// program evaluated by ctx uses a host capability (see AllowEnv,
+// AllowFiles, AllowFetch, and AllowExec), whether or not the access is
+// allowed. Passing nil removes it.
+//
+// The function is called once per evaluation for each access, when the
+// access is first made, by the goroutine evaluating the program.
+func (ctx *Context) SetHostAudit(audit func(HostAccess)) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.host.audit = audit
+}
+
+// SetTraceValueHandler registers a function that receives the values that
+// Nickel programs evaluated by ctx trace with `host.trace_value "label"
+// value`. Like std.trace, host.trace_value returns the value, so it can be
+// put around any expression. Unlike std.trace, it accepts any value that can
+// be serialized to JSON, which it evaluates deeply, and hands it to the
+// function as an Expr rather than as text. Passing nil removes the function,
+// after which host.trace_value is no longer bound.
+//
+// The values are decoded from JSON, so numbers are rounded to floats as in
+// MarshalJSON, and they belong to a context of their own. The function is
+// called during evaluation, so it must not use ctx.
+func (ctx *Context) SetTraceValueHandler(handler func(label string, value *Expr)) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.host.traceValue = handler
+	ctx.installTracer()
+}
+
+// traceValueContext is the context that traced values are decoded in. It
+// can't be the context being traced, which is busy evaluating.
+var traceValueContext = sync.OnceValue(NewContext)
+
+// tracedValue decodes the label and value of a host.trace_value message.
+func tracedValue(msg string) (string, *Expr, bool) {
+	i := strings.LastIndexByte(msg, '\x1f')
+	if i < 0 {
+		return "", nil, false
+	}
+	value, err := traceValueContext().evalDeep("std.deserialize 'Json "+QuoteString(msg[i+1:]), evalOptions{data: true})
+	if err != nil {
+		return "", nil, false
+	}
+	return msg[:i], value, true
+}
+
+// hostPrelude returns the source that binds the `host` record, to put in
+// front of a program, with the results of the host requests served so far.
+// It is empty if no host capabilities are enabled.
+func (ctx *Context) hostPrelude(served hostServed) string {
+	ctx.mu.Lock()
+	env := len(ctx.host.env)
+	files := len(ctx.host.files)
+	if ctx.sandbox {
+		files = 0
+	}
+	fetches := len(ctx.host.fetches)
+	commands := len(ctx.host.commands)
+	traceValue := ctx.host.traceValue != nil
+	ctx.mu.Unlock()
+
+	if env == 0 && files == 0 && fetches == 0 && commands == 0 && !traceValue {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("let host = { ")
+	if traceValue {
+		// The value is traced as JSON on one line, after the label. JSON
+		// has no raw control characters, so the last separator is the
+		// one before it.
+		b.WriteString("trace_value = fun label value => std.trace (" + QuoteString(hostTraceMarker+"trace_value\x1f") +
+			" ++ std.string.replace \"\\n\" \" \" label ++ " + QuoteString("\x1f") +
+			" ++ std.string.replace \"\\n\" \" \" (std.serialize 'Json value)) value, ")
+	}
+	if env > 0 {
+		b.WriteString("env = ")
+		hostServedLookup(&b, "env", false, served["env"])
+		b.WriteString(", ")
+	}
+	if files > 0 {
+		b.WriteString("read_file = ")
+		hostServedLookup(&b, "read_file", false, served["read_file"])
+		b.WriteString(", ")
+	}
+	if fetches > 0 {
+		b.WriteString("fetch = ")
+		hostServedLookup(&b, "fetch", false, served["fetch"])
+		b.WriteString(", ")
+	}
+	if commands > 0 {
+		b.WriteString("exec = ")
+		hostServedLookup(&b, "exec", true, served["exec"])
+		b.WriteString(", ")
+	}
+	b.WriteString("} in ")
+	return b.String()
+}
+
+// hostText returns the source of a string from the host, or of an error if
+// it can't be passed to Nickel. Failing lazily keeps a bad value from
+// breaking the programs that don't use it.
+func hostText(capability string, name string, value string) string {
+	if !utf8.ValidString(value) {
+		return hostFailure(capability, name+" is not valid UTF-8")
+	}
+	if strings.IndexByte(value, 0) >= 0 {
+		return hostFailure(capability, name+" contains a NUL byte")
+	}
+	return QuoteString(value)
+}
+
+func hostFailure(capability string, msg string) string {
+	return "std.fail_with " + QuoteString("host."+capability+": "+strings.ToValidUTF8(msg, "\uFFFD"))
+}
+
+// hostServedLookup writes the source of a host function that looks up the
+// results served so far, and makes a request for the others. With args, the
+// function takes a list of arguments after the name. The source stays on
+// one line.
+func hostServedLookup(b *strings.Builder, capability string, args bool, values []hostValue) {
+	b.WriteString("let values = { ")
+	for _, v := range values {
+		b.WriteString(QuoteString(v.key) + " = " + v.src + ", ")
+	}
+	// Nickel's let and records are recursive, so the arguments are renamed
+	// to check their types.
+	argsSrc := "[]"
+	if args {
+		argsSrc = "a"
+		b.WriteString("} in fun n a => let args | Array String = a in ")
+	} else {
+		b.WriteString("} in fun n => let args = [] in ")
+	}
+	b.WriteString("let name | String = n in " +
+		"let k = std.serialize 'Json ([name] @ args) in " +
+		"if std.record.has_field k values then values.\"%{k}\" else " +
+		"std.fail_with (" + QuoteString("host."+capability+": ") + " ++ name ++ " +
+		QuoteString(" was first used after its evaluation returned ("+hostRequestMarker) +
+		" ++ std.serialize 'Json { capability = " + QuoteString(capability) + ", name = n, args = " + argsSrc + ", key = k } ++ \")\")")
+}
+
+// installTracer points the trace callback for ctx at the right writer,
+// given the trace writer, its framing and the trace_value handler. ctx.mu
+// must be held.
+func (ctx *Context) installTracer() {
+	var w io.Writer = ctx.traceWriter
+	ctx.tracer = nil
+	if w != nil && ctx.traceFraming != TraceChunks {
+		ctx.tracer = &traceFramer{framing: ctx.traceFraming, next: w}
+		w = ctx.tracer
+	}
+	if ctx.host.traceValue != nil {
+		w = &hostTracer{traceValue: ctx.host.traceValue, next: w}
+	}
+
+	if w != nil {
+		w = &roundTracer{rounds: ctx.traceRounds, next: w}
+	}
+
+	contextTracerMutex.Lock()
+	if w == nil {
+		delete(contextTracer, unsafe.Pointer(ctx.ptr))
+	} else {
+		contextTracer[unsafe.Pointer(ctx.ptr)] = w
+	}
+	contextTracerMutex.Unlock()
+}
+
+// hostTracer picks the values traced by host.trace_value out of the trace
+// output, and passes the rest on.
+type hostTracer struct {
+	traceValue func(label string, value *Expr)
+	next       io.Writer
+	line       []byte
+}
+
+func (t *hostTracer) Write(p []byte) (int, error) {
+	n := len(p)
+	// Every trace message ends with a newline, but it comes in several
+	// writes.
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			t.line = append(t.line, p...)
+			break
+		}
+		t.line = append(t.line, p[:i+1]...)
+		p = p[i+1:]
+		t.handleLine(t.line)
+		t.line = t.line[:0]
+	}
+	return n, nil
+}
+
+func (t *hostTracer) handleLine(line []byte) {
+	_, msg, _ := bytes.Cut(line, []byte(": "))
+	if report, ok := bytes.CutPrefix(msg, []byte(hostTraceMarker+"trace_value\x1f")); ok {
+		if label, value, ok := tracedValue(strings.TrimSuffix(string(report), "\n")); ok {
+			t.traceValue(label, value)
+			return
+		}
+	}
+	if t.next != nil {
+		t.next.Write(line)
+	}
+}
+
+// withPrelude puts the bindings of the globals (see SetGlobals) and of the
+// host capabilities, with the results in served, in front of src.
+func (ctx *Context) withPrelude(src string, served hostServed) string {
+	return ctx.globalsPrelude() + ctx.hostPrelude(served) + src
+}
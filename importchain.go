@@ -0,0 +1,116 @@
+package nickel
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var (
+	// The locations in formatted error messages, like "┌─ main.ncl:1:11".
+	errorLocation = regexp.MustCompile(`(?m)^\s*┌─ (.*):\d+:\d+$`)
+	// Imports of files given by a plain string literal.
+	importExpr = regexp.MustCompile(`\bimport\s+"((?:[^"\\%]|\\.|%[^{])*)"`)
+)
+
+// ImportChain returns the chain of imports that led to the file in which
+// the error was found: the main program comes first, followed by the file
+// it imported, and so on up to the failing file.
+//
+// ImportChain returns nil if the error was found in the main program itself
+// or in the standard library, or if the chain can't be determined. That's
+// the case for errors from evaluating an Expr further (rather than from a
+// Context's evaluation functions), and for files that are only reached
+// through imports whose path isn't a plain string literal.
+//
+// The main program is given by its source name (see EvalFile), and the
+// imported files by their absolute path, as in error messages.
+//
+// Nickel doesn't report how files were loaded, so the chain is found by
+// reading the imported files again, and looking for the shortest chain of
+// imports. If the files changed since the evaluation, the chain can be
+// wrong.
+func (e *Error) ImportChain() []string {
+	if e.mainSrc == "" {
+		return nil
+	}
+	mainName := e.mainName
+	if mainName == "" {
+		mainName = defaultSourceName
+	}
+
+	var failing string
+	for _, m := range errorLocation.FindAllStringSubmatch(e.Error(), -1) {
+		if name := m[1]; name[0] != '<' {
+			failing = name
+			break
+		}
+	}
+	if failing == "" || failing == mainName {
+		return nil
+	}
+
+	mainDir, err := filepath.Abs(filepath.Dir(e.mainName))
+	if err != nil {
+		return nil
+	}
+
+	// A breadth-first search through the imports, from the main program.
+	parents := map[string]string{mainName: ""}
+	queue := []string{mainName}
+	for len(queue) > 0 {
+		file := queue[0]
+		queue = queue[1:]
+		if file == failing {
+			var chain []string
+			for ; file != ""; file = parents[file] {
+				chain = append([]string{file}, chain...)
+			}
+			return chain
+		}
+
+		src, dir := e.mainSrc, mainDir
+		if file != mainName {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			src = string(data)
+			dir = filepath.Dir(file)
+		}
+		for m := range findImportLiterals(src) {
+			imported := unquoteImport(src[m[2]:m[3]])
+			if !filepath.IsAbs(imported) {
+				imported = filepath.Join(dir, imported)
+			}
+			if _, seen := parents[imported]; !seen {
+				parents[imported] = file
+				queue = append(queue, imported)
+			}
+		}
+	}
+	return nil
+}
+
+// unquoteImport returns the path in the contents of a string literal.
+func unquoteImport(s string) string {
+	var path []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n':
+				path = append(path, '\n')
+			case 'r':
+				path = append(path, '\r')
+			case 't':
+				path = append(path, '\t')
+			default:
+				path = append(path, s[i])
+			}
+			continue
+		}
+		path = append(path, s[i])
+	}
+	return string(path)
+}
@@ -0,0 +1,88 @@
+package nickel
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestImportChain(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"main.ncl":       `{ ok = import "ok.ncl", sub = import "sub/a.ncl" }`,
+		"ok.ncl":         `1`,
+		"sub/a.ncl":      `{ b = import "../b.ncl" }`,
+		"b.ncl":          `{ y = 1 + "s" }`,
+		"broken.ncl":     `{ z = import "sub/a.ncl" }.z.b.y`,
+		"sub/bad.ncl":    `1 + "s"`,
+		"sub/direct.ncl": `(import "bad.ncl") + 1`,
+		"missing.ncl":    `import "sub/nothing.ncl"`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	abs := func(name string) string { return filepath.Join(dir, name) }
+
+	cases := []struct {
+		eval     func(ctx *Context) (*Expr, error)
+		expected []string
+	}{
+		{
+			func(ctx *Context) (*Expr, error) { return ctx.EvalFile(abs("main.ncl")) },
+			[]string{abs("main.ncl"), abs("sub/a.ncl"), abs("b.ncl")},
+		},
+		{
+			func(ctx *Context) (*Expr, error) { return ctx.EvalDeep(`import ` + QuoteString(abs("broken.ncl"))) },
+			[]string{"<source>", abs("broken.ncl"), abs("sub/a.ncl"), abs("b.ncl")},
+		},
+		{
+			func(ctx *Context) (*Expr, error) { return ctx.EvalFile(abs("sub/direct.ncl")) },
+			[]string{abs("sub/direct.ncl"), abs("sub/bad.ncl")},
+		},
+		{
+			func(ctx *Context) (*Expr, error) { return ctx.EvalShallow(`import ` + QuoteString(abs("missing.ncl"))) },
+			[]string{"<source>", abs("missing.ncl")},
+		},
+		{
+			func(ctx *Context) (*Expr, error) { return ctx.EvalFile(abs("sub/bad.ncl")) },
+			nil,
+		},
+		{
+			func(ctx *Context) (*Expr, error) { return ctx.EvalDeep(`1 + "s"`) },
+			nil,
+		},
+	}
+	for i, c := range cases {
+		_, err := c.eval(NewContext())
+		var nickelErr *Error
+		if !errors.As(err, &nickelErr) {
+			t.Fatalf("case %d: expected a Nickel error, got %v", i, err)
+		}
+		if chain := nickelErr.ImportChain(); !slices.Equal(chain, c.expected) {
+			t.Errorf("case %d: expected %q, got %q", i, c.expected, chain)
+		}
+	}
+
+	// Errors found while evaluating an Expr further don't know where the
+	// expression came from.
+	expr, err := NewContext().EvalShallow(`import ` + QuoteString(abs("main.ncl")))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	record, _ := expr.ToRecord()
+	_, err = record["sub"].EvalDeep()
+	var nickelErr *Error
+	if !errors.As(err, &nickelErr) {
+		t.Fatalf("expected a Nickel error, got %v", err)
+	}
+	if chain := nickelErr.ImportChain(); chain != nil {
+		t.Errorf("expected no chain, got %q", chain)
+	}
+}
@@ -0,0 +1,215 @@
+package nickel
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// AddImportPath adds a directory to search for imported files, after the
+// ones added before.
+//
+// An import of a relative path is resolved against the directory of the
+// program that contains it first (the current directory for programs that
+// don't come from a file), and then against the import paths, in order.
+//
+// The Nickel library can't be given import paths, so they're applied by
+// rewriting the imports in the programs given to the context's evaluation
+// functions before evaluating them. That covers the imports in those
+// programs, but not the ones in the files they import: imports in files are
+// only resolved relative to the file.
+func (ctx *Context) AddImportPath(dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("import path %s is not a directory", dir)
+	}
+
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.importPaths = append(ctx.importPaths, abs)
+	return nil
+}
+
+// ImportPaths returns the import paths added by AddImportPath, as absolute
+// paths.
+func (ctx *Context) ImportPaths() []string {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return slices.Clone(ctx.importPaths)
+}
+
+// ClearImportPaths removes all the import paths.
+func (ctx *Context) ClearImportPaths() {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.importPaths = nil
+}
+
+// SetImportFS sets a file system to search for imported files, after the
+// import paths (see AddImportPath), or removes it if fsys is nil. This allows
+// importing files from archives, in-memory test fixtures, or an embed.FS, to
+// ship a library of Nickel files inside a program. Use fs.Sub to import the
+// files of a subdirectory without its name, as embedded files keep theirs.
+//
+// Files in fsys can import each other with relative paths, and the programs
+// given to the context's evaluation functions can import them as if fsys was
+// an import path. The Nickel library only reads files from the operating
+// system, so the files of fsys are copied to a temporary directory, which
+// appears in error messages. The copy is made by SetImportFS: later changes to
+// fsys aren't seen. The directory is removed when fsys is replaced, or when
+// the Context is garbage collected.
+func (ctx *Context) SetImportFS(fsys fs.FS) error {
+	var dir string
+	if fsys != nil {
+		var err error
+		dir, err = copyFS(fsys, "nickel-import-fs-")
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx.mu.Lock()
+	old := ctx.importFSDir
+	ctx.importFSDir = dir
+	ctx.mu.Unlock()
+	if old != "" {
+		os.RemoveAll(old)
+	}
+	return nil
+}
+
+// copyFS copies the files of fsys to a new temporary directory, named after
+// pattern as in os.MkdirTemp.
+func copyFS(fsys fs.FS, pattern string) (string, error) {
+	dir, err := os.MkdirTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	if err := os.CopyFS(dir, fsys); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("copying import file system: %w", err)
+	}
+	return dir, nil
+}
+
+// RegisterSource makes src available to import under name, so that
+// programs can import generated Nickel (or JSON, YAML, ...) without a file
+// for it. The format of the source is determined by the extension of the
+// name, like for files, and names without an extension are Nickel.
+//
+// The name must be a relative, slash-separated path without "." or ".."
+// elements, as accepted by fs.ValidPath. Registered sources are found
+// before the import paths (see AddImportPath), and can import each other
+// with paths relative to their names. Registering a name again replaces its
+// source.
+//
+// As with import paths, registered sources can be imported by the programs
+// given to the context's evaluation functions, but not by the files they
+// import. The sources are written to a temporary directory, which appears in
+// error messages, and which is removed when the Context is garbage
+// collected.
+func (ctx *Context) RegisterSource(name string, src string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return fmt.Errorf("invalid source name %q", name)
+	}
+
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if ctx.sourcesDir == "" {
+		dir, err := os.MkdirTemp("", "nickel-sources-")
+		if err != nil {
+			return err
+		}
+		ctx.sourcesDir = dir
+	}
+	path := filepath.Join(ctx.sourcesDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(src), 0o644)
+}
+
+// searchPaths returns the directories to look for imports in, in order.
+func (ctx *Context) searchPaths() []string {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	var paths []string
+	if ctx.sourcesDir != "" {
+		paths = append(paths, ctx.sourcesDir)
+	}
+	paths = append(paths, ctx.importPaths...)
+	if ctx.importFSDir != "" {
+		paths = append(paths, ctx.importFSDir)
+	}
+	return paths
+}
+
+// resolveImports rewrites the imports in the program src (with source name
+// name) that are found among the registered sources, the import paths or the
+// import file system, in the bundled library, or by the import resolver, to
+// use absolute paths. It also returns the paths as written in src, by the
+// paths they were rewritten to.
+func (ctx *Context) resolveImports(src string, name string) (string, map[string]string, error) {
+	paths := ctx.searchPaths()
+	resolver := ctx.importResolver()
+	if len(paths) == 0 && resolver == nil && !strings.Contains(src, libraryPrefix) {
+		return src, nil, nil
+	}
+	dir := filepath.Dir(name)
+	fetched := map[string]bool{}
+	written := map[string]string{}
+
+	var b strings.Builder
+	last := 0
+	for m := range findImportLiterals(src) {
+		path := unquoteImport(src[m[2]:m[3]])
+		if filepath.IsAbs(path) || exists(filepath.Join(dir, path)) {
+			continue
+		}
+		found := ""
+		for _, importPath := range paths {
+			if p := filepath.Join(importPath, path); exists(p) {
+				found = p
+				break
+			}
+		}
+		if found == "" && strings.HasPrefix(filepath.ToSlash(path), libraryPrefix) {
+			var err error
+			if found, err = ctx.libraryFile(filepath.ToSlash(path)); err != nil {
+				return "", nil, err
+			}
+		}
+		if found == "" && resolver != nil {
+			var err error
+			if found, err = ctx.fetchImport(resolver, filepath.ToSlash(path), fetched); err != nil {
+				return "", nil, err
+			}
+		}
+		if found != "" {
+			b.WriteString(src[last : m[2]-1])
+			b.WriteString(QuoteString(found))
+			last = m[3] + 1
+			written[found] = path
+		}
+	}
+	if last == 0 {
+		return src, nil, nil
+	}
+	b.WriteString(src[last:])
+	return b.String(), written, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,197 @@
+package nickel
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestImportPaths(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"first/lib.ncl":     `{ from = "first", helper = import "helper.ncl" }`,
+		"first/helper.ncl":  `"first helper"`,
+		"second/lib.ncl":    `{ from = "second" }`,
+		"second/only.ncl":   `"only in second"`,
+		"project/main.ncl":  `{ lib = import "lib.ncl", only = import "only.ncl", local = import "local.ncl" }`,
+		"project/local.ncl": `"local"`,
+		"project/lib.ncl":   `{ from = "project" }`,
+		"second/nested.ncl": `import "helper.ncl"`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := NewContext()
+	if err := ctx.AddImportPath(filepath.Join(dir, "first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.AddImportPath(filepath.Join(dir, "second")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.AddImportPath(filepath.Join(dir, "first/lib.ncl")); err == nil {
+		t.Fatal("expected an error for a file")
+	}
+	if paths := ctx.ImportPaths(); !slices.Equal(paths, []string{filepath.Join(dir, "first"), filepath.Join(dir, "second")}) {
+		t.Fatalf("unexpected import paths: %q", paths)
+	}
+
+	// The first import path wins, and files import relative to themselves.
+	expr, err := ctx.EvalDeep(`{ lib = import "lib.ncl", only = import "only.ncl" }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got := expr.String(); got != `{ lib = { from = "first", helper = "first helper" }, only = "only in second" }` {
+		t.Errorf("unexpected result: %s", got)
+	}
+
+	// Import text in strings and comments is left alone.
+	expr, err = ctx.EvalDeep(`{
+		# import "only.ncl"
+		text = m%"import "only.ncl""%,
+	}`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got := expr.String(); got != `{ text = "import \"only.ncl\"" }` {
+		t.Errorf("unexpected result: %s", got)
+	}
+
+	// Files next to the program come first.
+	expr, err = ctx.EvalFile(filepath.Join(dir, "project/main.ncl"))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got := expr.String(); got != `{ lib = { from = "project" }, local = "local", only = "only in second" }` {
+		t.Errorf("unexpected result: %s", got)
+	}
+
+	// The import paths don't apply within imported files.
+	if _, err := ctx.EvalShallow(`import "nested.ncl"`); err == nil {
+		t.Error("expected an error for an import in an imported file")
+	}
+
+	ctx.ClearImportPaths()
+	if _, err := ctx.EvalDeep(`import "only.ncl"`); err == nil {
+		t.Error("expected an error without import paths")
+	}
+}
+
+func TestImportFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"lib.ncl":           {Data: []byte(`{ name = "lib", util = import "util/strings.ncl" }`)},
+		"util/strings.ncl":  {Data: []byte(`{ greeting = import "greeting.txt" }`)},
+		"util/greeting.txt": {Data: []byte("hello")},
+	}
+
+	ctx := NewContext()
+	if err := ctx.SetImportFS(fsys); err != nil {
+		t.Fatal(err)
+	}
+	// Later changes aren't seen.
+	delete(fsys, "lib.ncl")
+
+	expr, err := ctx.EvalDeep(`import "lib.ncl"`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got := expr.String(); got != `{ name = "lib", util = { greeting = "hello" } }` {
+		t.Errorf("unexpected result: %s", got)
+	}
+
+	// Import paths come first.
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "lib.ncl"), []byte(`"from the import path"`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.AddImportPath(dir); err != nil {
+		t.Fatal(err)
+	}
+	expr, err = ctx.EvalDeep(`import "lib.ncl"`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got := expr.String(); got != `"from the import path"` {
+		t.Errorf("unexpected result: %s", got)
+	}
+
+	ctx.ClearImportPaths()
+	copied := ctx.importFSDir
+	if err := ctx.SetImportFS(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(copied); !os.IsNotExist(err) {
+		t.Errorf("expected the copy to be removed, got %v", err)
+	}
+	if _, err := ctx.EvalDeep(`import "lib.ncl"`); err == nil {
+		t.Error("expected an error without the import file system")
+	}
+}
+
+//go:embed testdata/importfs
+var embeddedImports embed.FS
+
+func TestImportEmbedFS(t *testing.T) {
+	lib, err := fs.Sub(embeddedImports, "testdata/importfs/lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := NewContext()
+	if err := ctx.SetImportFS(lib); err != nil {
+		t.Fatal(err)
+	}
+
+	expr, err := ctx.EvalDeep(`{ port = 80 } | (import "schema.ncl").Server`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got := expr.String(); got != `{ name = "embedded", port = 80 }` {
+		t.Errorf("unexpected result: %s", got)
+	}
+}
+
+func TestRegisterSource(t *testing.T) {
+	ctx := NewContext()
+	for name, src := range map[string]string{
+		"machine":           `{ cores = 8, arch = (import "gen/arch.json").name }`,
+		"gen/arch.json":     `{ "name": "amd64" }`,
+		"gen/constants.ncl": `{ limit = 1 + 1 }`,
+	} {
+		if err := ctx.RegisterSource(name, src); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"", ".", "../up.ncl", "/abs.ncl", "a/./b.ncl"} {
+		if err := ctx.RegisterSource(name, "1"); err == nil {
+			t.Errorf("expected an error for name %q", name)
+		}
+	}
+
+	expr, err := ctx.EvalDeep(`{ m = import "machine", c = import "gen/constants.ncl" }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got := expr.String(); got != `{ c = { limit = 2 }, m = { arch = "amd64", cores = 8 } }` {
+		t.Errorf("unexpected result: %s", got)
+	}
+
+	if err := ctx.RegisterSource("machine", `{ cores = 16 }`); err != nil {
+		t.Fatal(err)
+	}
+	expr, err = ctx.EvalDeep(`(import "machine").cores`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got := expr.String(); got != "16" {
+		t.Errorf("expected the new source, got %s", got)
+	}
+}
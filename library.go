@@ -0,0 +1,57 @@
+package nickel
+
+import (
+	"embed"
+	"io/fs"
+	"path"
+	"path/filepath"
+)
+
+// The Nickel library shipped with the bindings, importable from every
+// Context under the "go-nickel/" prefix.
+//
+//go:embed ncl
+var bundledFiles embed.FS
+
+// LibraryFS returns the Nickel library shipped with the bindings, for
+// reading its sources or copying it to an import path of the Nickel CLI.
+//
+// Every Context can import the files of the library, like
+// import "go-nickel/contracts.ncl", without setting anything up. The files
+// in the import paths, the import file system and the registered sources
+// take precedence, so a program can ship its own version of the library.
+//
+// The library has these files:
+//
+//   - go-nickel/contracts.ncl: contracts for values that programs commonly
+//     read from configurations: Port, Duration (in the format of
+//     time.ParseDuration), IPAddr, IPv4Addr, IPv6Addr, URL (absolute, with
+//     a host) and Percentage (a number from 0 to 100).
+func LibraryFS() fs.FS {
+	fsys, _ := fs.Sub(bundledFiles, "ncl")
+	return fsys
+}
+
+// libraryPrefix starts the paths of the files of the bundled library.
+const libraryPrefix = "go-nickel/"
+
+// libraryFile returns the path to the copy of the bundled library's file
+// name, or "" if the library doesn't have it. The library is copied to a
+// temporary directory on first use, which is removed when the Context is
+// garbage collected.
+func (ctx *Context) libraryFile(name string) (string, error) {
+	if _, err := fs.Stat(LibraryFS(), path.Clean(name)); err != nil {
+		return "", nil
+	}
+
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if ctx.libraryDir == "" {
+		dir, err := copyFS(LibraryFS(), "nickel-library-")
+		if err != nil {
+			return "", err
+		}
+		ctx.libraryDir = dir
+	}
+	return filepath.Join(ctx.libraryDir, filepath.FromSlash(path.Clean(name))), nil
+}
@@ -0,0 +1,65 @@
+package nickel
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+func TestLibraryContracts(t *testing.T) {
+	ctx := NewContext()
+	tests := []struct {
+		contract string
+		valid    []string
+		invalid  []string
+	}{
+		{"Port", []string{`1`, `8080`, `65535`}, []string{`0`, `65536`, `80.5`, `"80"`}},
+		{"Duration", []string{`"10s"`, `"1h30m"`, `"1.5ms"`, `"250µs"`, `".5s"`, `"-1m"`, `"0"`}, []string{`"10"`, `"5 m"`, `"1d"`, `10`}},
+		{"IPAddr", []string{`"192.0.2.1"`, `"2001:db8::1"`, `"::"`, `"::ffff:192.0.2.1"`}, []string{`"256.0.0.1"`, `"1.2.3"`, `"2001:db8:::1"`, `"localhost"`}},
+		{"IPv4Addr", []string{`"0.0.0.0"`, `"255.255.255.255"`}, []string{`"2001:db8::1"`, `"01.2.3.4.5"`}},
+		{"IPv6Addr", []string{`"fe80::1:2:3:4"`, `"1:2:3:4:5:6:7:8"`}, []string{`"192.0.2.1"`, `"1:2:3:4:5:6:7:8:9"`}},
+		{"URL", []string{`"https://example.com"`, `"postgres://user@db:5432/app?sslmode=disable"`}, []string{`"example.com"`, `"https://"`, `"/path"`}},
+		{"Percentage", []string{`0`, `12.5`, `100`}, []string{`-1`, `100.1`, `"50%"`}},
+	}
+	for _, tt := range tests {
+		contract := `(import "go-nickel/contracts.ncl").` + tt.contract
+		for _, src := range tt.valid {
+			if err := ctx.Validate(src, contract); err != nil {
+				t.Errorf("%s: expected %s to be valid, got %v", tt.contract, src, err)
+			}
+		}
+		for _, src := range tt.invalid {
+			var contractErr *ContractError
+			if err := ctx.Validate(src, contract); !errors.As(err, &contractErr) {
+				t.Errorf("%s: expected %s to break the contract, got %v", tt.contract, src, err)
+			}
+		}
+	}
+}
+
+func TestLibraryPrecedence(t *testing.T) {
+	ctx := NewContext()
+	if err := ctx.RegisterSource("go-nickel/contracts.ncl", `{ Port = Number }`); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.Validate(`0`, `(import "go-nickel/contracts.ncl").Port`); err != nil {
+		t.Errorf("expected the registered source to replace the library, got %v", err)
+	}
+
+	// Missing files of the library aren't found.
+	resolved := false
+	ctx = NewContext()
+	ctx.SetImportResolver(ImportResolverFunc(func(path string) ([]byte, error) {
+		resolved = true
+		return nil, fs.ErrNotExist
+	}))
+	if _, err := ctx.EvalDeep(`import "go-nickel/contracts.ncl"`); err != nil {
+		t.Errorf("eval error: %v", err)
+	}
+	if resolved {
+		t.Error("expected the library to come before the resolver")
+	}
+	if _, err := ctx.EvalDeep(`import "go-nickel/missing.ncl"`); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the resolver's error, got %v", err)
+	}
+}
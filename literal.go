@@ -0,0 +1,95 @@
+package nickel
+
+/*
+#include <nickel_lang.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"unsafe"
+)
+
+// Null returns a null expression, belonging to ctx.
+//
+// Null and the other constructors (True, False, Number, Int and String)
+// build small values directly, for the functions that take an *Expr, like
+// Expr.SetPath, Expr.Append and Context.SetGlobals. The values are
+// evaluated deeply, without going through the context's globals, sandbox,
+// timeout or evaluation recorder.
+func Null(ctx *Context) *Expr {
+	return mustLiteral(ctx, "null")
+}
+
+// True returns the boolean true, belonging to ctx. See Null.
+func True(ctx *Context) *Expr {
+	return mustLiteral(ctx, "true")
+}
+
+// False returns the boolean false, belonging to ctx. See Null.
+func False(ctx *Context) *Expr {
+	return mustLiteral(ctx, "false")
+}
+
+// Number returns the number x, belonging to ctx. See Null.
+//
+// The number is the shortest decimal that identifies x, so Number(ctx, 0.1)
+// is exactly 0.1, which converts back to x (see Expr.ToFloat64Exact). Nickel
+// has no infinities or NaN, so Number panics if x is one of them.
+func Number(ctx *Context, x float64) *Expr {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		panic(fmt.Sprintf("nickel: %v is not a Nickel number", x))
+	}
+	return mustLiteral(ctx, strconv.FormatFloat(x, 'g', -1, 64))
+}
+
+// Int returns the integer n, belonging to ctx. See Null.
+func Int(ctx *Context, n int64) *Expr {
+	return mustLiteral(ctx, strconv.FormatInt(n, 10))
+}
+
+// String returns the string s, belonging to ctx. See Null.
+//
+// Nickel strings are UTF-8 without NUL characters, so String fails with
+// ErrInvalidSource if s isn't valid UTF-8 or contains a NUL byte.
+func String(ctx *Context, s string) (*Expr, error) {
+	if err := checkSource(s); err != nil {
+		return nil, err
+	}
+	return literal(ctx, QuoteString(s))
+}
+
+// mustLiteral is like literal, for the literals that can't fail to
+// evaluate.
+func mustLiteral(ctx *Context, src string) *Expr {
+	expr, err := literal(ctx, src)
+	if err != nil {
+		panic(fmt.Sprintf("nickel: evaluating %s: %v", src, err))
+	}
+	return expr
+}
+
+// literal evaluates the source of a literal deeply.
+func literal(ctx *Context, src string) (*Expr, error) {
+	out_expr := new_expr(ctx)
+	out_err := new_err()
+	csrc := C.CString(src)
+	defer C.free(unsafe.Pointer(csrc))
+
+	if err := ctx.lockNative(); err != nil {
+		return nil, err
+	}
+	result := C.nickel_context_eval_deep(ctx.ptr, csrc, out_expr.ptr, out_err.ptr)
+	ctx.unlockNative()
+	if result != C.NICKEL_RESULT_OK {
+		return nil, out_err
+	}
+	out_expr.load()
+	// Literals have no fields that an export would leave out.
+	out_expr.deep = true
+	out_expr.exported = true
+	return out_expr, nil
+}
@@ -0,0 +1,65 @@
+package nickel
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestLiterals(t *testing.T) {
+	ctx := NewContext()
+	// The constructors don't go through the sandbox.
+	ctx.SetSandbox(true)
+
+	s, err := String(ctx, "a \"quoted\" %{string}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		expr *Expr
+		kind Kind
+		want string
+	}{
+		{Null(ctx), KindNull, "null"},
+		{True(ctx), KindBool, "true"},
+		{False(ctx), KindBool, "false"},
+		{Number(ctx, 0.1), KindNumber, "0.1"},
+		{Number(ctx, -1e30), KindNumber, "-1e+30"},
+		{Int(ctx, math.MaxInt64), KindNumber, "9223372036854775807"},
+		{s, KindString, `"a \"quoted\" %{string}"`},
+	}
+	for _, test := range tests {
+		if test.expr.Kind() != test.kind || test.expr.String() != test.want {
+			t.Errorf("got %s %s, want %s %s", test.expr.Kind(), test.expr, test.kind, test.want)
+		}
+	}
+
+	if f, err := Number(ctx, 0.1).ToFloat64Exact(); err != nil || f != 0.1 {
+		t.Errorf("expected 0.1 to convert back exactly, got %v, %v", f, err)
+	}
+	if n, ok := Int(ctx, math.MinInt64).ToInt64(); !ok || n != math.MinInt64 {
+		t.Errorf("unexpected integer %d", n)
+	}
+	if _, err := String(ctx, "nul\x00"); !errors.Is(err, ErrInvalidSource) {
+		t.Errorf("expected ErrInvalidSource, got %v", err)
+	}
+
+	record, err := NewContext().EvalDeep("{ a = 1 }")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	record, err = record.SetPath("b", Null(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := record.String(); got != "{ a = 1, b = null }" {
+		t.Errorf("unexpected record: %s", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for NaN")
+		}
+	}()
+	Number(ctx, math.NaN())
+}
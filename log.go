@@ -0,0 +1,93 @@
+package nickel
+
+import (
+	"log/slog"
+	"strconv"
+)
+
+// LogRedacted is the value logged in place of redacted fields (see
+// Context.SetLogRedactor).
+const LogRedacted = "REDACTED"
+
+// SetLogRedactor provides a hook for hiding sensitive values when logging
+// Exprs created by this context.
+//
+// When an Expr is logged through log/slog (see Expr.LogValue), redact is
+// called with the path of each nested record field or array element (array
+// indices are given in decimal). If it returns true, the value is logged as
+// LogRedacted instead. Passing nil removes the hook.
+func (ctx *Context) SetLogRedactor(redact func(path []string) bool) {
+	ctx.mu.Lock()
+	ctx.logRedactor = redact
+	ctx.mu.Unlock()
+}
+
+// LogValue implements the slog.LogValuer interface for Expr.
+//
+// Records and arrays are logged as groups (with array elements keyed by
+// their index), and enum variants as a group with a single attribute keyed
+// by the tag. Nothing is evaluated: expressions that haven't been evaluated
+// yet are logged as "<lazy>".
+func (expr *Expr) LogValue() slog.Value {
+	if expr == nil {
+		return slog.StringValue("<undefined>")
+	}
+
+	expr.ctx.mu.Lock()
+	redact := expr.ctx.logRedactor
+	expr.ctx.mu.Unlock()
+
+	return logValue(expr, nil, redact)
+}
+
+func logValue(expr *Expr, path []string, redact func([]string) bool) slog.Value {
+	if expr == nil {
+		return slog.StringValue("<undefined>")
+	}
+
+	switch expr.kind {
+	case KindNull:
+		return slog.AnyValue(nil)
+	case KindBool:
+		return slog.BoolValue(expr.b)
+	case KindNumber:
+		if i, ok := expr.ToInt64(); ok {
+			return slog.Int64Value(i)
+		}
+		f, _ := expr.ToFloat64()
+		return slog.Float64Value(f)
+	case KindString:
+		s, _ := expr.ToString()
+		return slog.StringValue(s)
+	case KindEnumTag:
+		tag, _ := expr.ToEnumTag()
+		return slog.StringValue(tag)
+	case KindEnumVariant:
+		tag, payload, _ := expr.ToEnumVariant()
+		return slog.GroupValue(slog.Attr{Key: tag, Value: logValue(payload, path, redact)})
+	case KindRecord:
+		fields, _ := expr.ToRecord()
+		attrs := make([]slog.Attr, 0, len(fields))
+		for _, key := range sortedKeys(fields) {
+			attrs = append(attrs, logAttr(key, fields[key], path, redact))
+		}
+		return slog.GroupValue(attrs...)
+	case KindArray:
+		elems, _ := expr.ToArray()
+		attrs := make([]slog.Attr, 0, len(elems))
+		for i, elem := range elems {
+			attrs = append(attrs, logAttr(strconv.Itoa(i), elem, path, redact))
+		}
+		return slog.GroupValue(attrs...)
+	default:
+		return slog.StringValue("<lazy>")
+	}
+}
+
+func logAttr(key string, expr *Expr, path []string, redact func([]string) bool) slog.Attr {
+	path = append(path[:len(path):len(path)], key)
+	if redact != nil && redact(path) {
+		return slog.String(key, LogRedacted)
+	}
+	return slog.Attr{Key: key, Value: logValue(expr, path, redact)}
+}
@@ -0,0 +1,150 @@
+package nickel
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Match is a value found by Expr.Match, with its path.
+type Match struct {
+	// Path is the sequence of record field names and array indices (in
+	// decimal) leading to the value, as accepted by FormatPath.
+	Path  []string
+	Value *Expr
+}
+
+// Match returns the values at the paths matching pattern, like
+// `services.*.port`, in the order of a Walk.
+//
+// A pattern is a path (see ParsePath) whose fields can contain wildcards,
+// matching both record field names and array indices. A `*` in a field
+// matches any sequence of characters, so that `*` alone matches any single
+// field, and `web-*` the fields starting with "web-". A field that is just
+// `**` matches any number of fields, including none: `services.**.port`
+// matches the port fields anywhere under services. Quoted fields, like
+// `"*"`, are matched literally.
+//
+// Values are evaluated shallowly as needed to look into them, so the
+// matches may not have been evaluated yet. With `**`, that means evaluating
+// everything below the point where it appears. Fields without a value and
+// the payloads of enum variants aren't matched.
+func (expr *Expr) Match(pattern string) ([]Match, error) {
+	fields, quoted, err := parsePath(pattern)
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]patternSegment, len(fields))
+	for i, field := range fields {
+		segments[i] = patternSegment{field: field, glob: !quoted[i] && strings.Contains(field, "*")}
+	}
+
+	m := matcher{seen: map[string]bool{}}
+	if err := m.match(nil, expr, segments); err != nil {
+		return nil, err
+	}
+	return m.matches, nil
+}
+
+// patternSegment is one of the fields of a pattern given to Match.
+type patternSegment struct {
+	field string
+	// Whether field has wildcards.
+	glob bool
+}
+
+// matches reports whether the segment matches the field name.
+func (s patternSegment) matches(name string) bool {
+	if !s.glob {
+		return name == s.field
+	}
+	parts := strings.Split(s.field, "*")
+	rest, ok := strings.CutPrefix(name, parts[0])
+	if !ok {
+		return false
+	}
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	return strings.HasSuffix(rest, parts[len(parts)-1])
+}
+
+type matcher struct {
+	matches []Match
+	// The paths matched already, since a pattern with several `**` can
+	// match the same path in several ways.
+	seen map[string]bool
+}
+
+func (m *matcher) match(path []string, expr *Expr, pattern []patternSegment) error {
+	if len(pattern) == 0 {
+		if key := FormatPath(path); !m.seen[key] {
+			m.seen[key] = true
+			m.matches = append(m.matches, Match{Path: slices.Clone(path), Value: expr})
+		}
+		return nil
+	}
+
+	segment := pattern[0]
+	if segment.glob && segment.field == "**" {
+		if err := m.match(path, expr, pattern[1:]); err != nil {
+			return err
+		}
+		return m.children(path, expr, func(name string, child *Expr) error {
+			return m.match(append(path, name), child, pattern)
+		})
+	}
+
+	forced, err := expr.force()
+	if err != nil {
+		return fmt.Errorf("%s: %w", FormatPath(path), err)
+	}
+	if !segment.glob && forced.kind == KindRecord {
+		// There's no need to look at the other fields.
+		if child := forced.field(segment.field); child != nil {
+			return m.match(append(path, segment.field), child, pattern[1:])
+		}
+		return nil
+	}
+	return m.children(path, forced, func(name string, child *Expr) error {
+		if !segment.matches(name) {
+			return nil
+		}
+		return m.match(append(path, name), child, pattern[1:])
+	})
+}
+
+// children calls fn on the fields of expr if it's a record, or on its
+// elements if it's an array, evaluating it shallowly first if needed.
+func (m *matcher) children(path []string, expr *Expr, fn func(name string, child *Expr) error) error {
+	expr, err := expr.force()
+	if err != nil {
+		return fmt.Errorf("%s: %w", FormatPath(path), err)
+	}
+
+	switch expr.kind {
+	case KindRecord:
+		fields, _ := expr.ToRecord()
+		for _, name := range sortedKeys(fields) {
+			if fields[name] == nil {
+				continue
+			}
+			if err := fn(name, fields[name]); err != nil {
+				return err
+			}
+		}
+	case KindArray:
+		elems, _ := expr.ToArray()
+		for i, elem := range elems {
+			if err := fn(strconv.Itoa(i), elem); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package nickel
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalShallow(`{
+		services = {
+			web = { port = 80, sidecars = [{ port = 9000 }, { name = "log" }] },
+			web-admin = { port = 8080 },
+			db = { port = 5432, "*" = 1 },
+		},
+		port = 1,
+		broken = 1 + "a",
+	}`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"services.*.port", []string{"services.db.port=5432", "services.web.port=80", "services.web-admin.port=8080"}},
+		{"services.web-*.port", []string{"services.web-admin.port=8080"}},
+		{"services.*b*.port", []string{"services.db.port=5432", "services.web.port=80", "services.web-admin.port=8080"}},
+		{`services.db."*"`, []string{`services.db."*"=1`}},
+		{"services.web.sidecars.*.port", []string{"services.web.sidecars.0.port=9000"}},
+		{"services.**.port", []string{"services.db.port=5432", "services.web.port=80", "services.web.sidecars.0.port=9000", "services.web-admin.port=8080"}},
+		{"services.**.**.port", []string{"services.db.port=5432", "services.web.port=80", "services.web.sidecars.0.port=9000", "services.web-admin.port=8080"}},
+		{"services.nope.*", nil},
+		{"port.*", nil},
+	}
+	for _, test := range tests {
+		matches, err := expr.Match(test.pattern)
+		if err != nil {
+			t.Errorf("%s: %v", test.pattern, err)
+			continue
+		}
+		var got []string
+		for _, m := range matches {
+			got = append(got, FormatPath(m.Path)+"="+m.Value.String())
+		}
+		if strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("%s: got %q, want %q", test.pattern, got, test.want)
+		}
+	}
+
+	if _, err := expr.Match("**.port"); err == nil || !strings.HasPrefix(err.Error(), "broken: ") {
+		t.Errorf("expected an error in broken, got %v", err)
+	}
+	if _, err := expr.Match("services..port"); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
@@ -1,4 +1,7 @@
 #include <stdint.h>
+#include <stdlib.h>
+#include <string.h>
+#include <nickel_lang.h>
 
 extern uintptr_t traceCallback(void*, uint8_t*, uintptr_t);
 
@@ -7,3 +10,219 @@ uintptr_t traceCallbackTrampoline(void* context, const uint8_t* buf, uintptr_t l
 	// so traceCallback can't accept one. But we promise not to write to it.
 	return traceCallback(context, (uint8_t*)buf, len);
 }
+
+// Classify an expression in a single call, so that the Go side doesn't need
+// to cross into C once per `nickel_expr_is_*` check.
+//
+// The return values must match the Kind constants in nickel.go. For bools
+// and numbers that fit in an int64, the value is also written out.
+int exprInfo(const nickel_expr* expr, int* out_bool, int* out_is_i64, int64_t* out_i64) {
+	if (!nickel_expr_is_value(expr)) {
+		return 0;
+	} else if (nickel_expr_is_null(expr)) {
+		return 1;
+	} else if (nickel_expr_is_bool(expr)) {
+		*out_bool = nickel_expr_as_bool(expr);
+		return 2;
+	} else if (nickel_expr_is_number(expr)) {
+		const nickel_number* num = nickel_expr_as_number(expr);
+		*out_is_i64 = nickel_number_is_i64(num);
+		if (*out_is_i64) {
+			*out_i64 = nickel_number_as_i64(num);
+		}
+		return 3;
+	} else if (nickel_expr_is_str(expr)) {
+		return 4;
+	} else if (nickel_expr_is_enum_tag(expr)) {
+		return 5;
+	} else if (nickel_expr_is_enum_variant(expr)) {
+		return 6;
+	} else if (nickel_expr_is_record(expr)) {
+		return 7;
+	} else if (nickel_expr_is_array(expr)) {
+		return 8;
+	} else {
+		// Some special values (like contract labels) are none of the above,
+		// and can't be inspected any further. Treat them like functions.
+		return 9;
+	}
+}
+
+// Everything that the plain value conversion in decode.go needs to know
+// about an expression, looked up in a single call.
+typedef struct {
+	int kind;
+	int b;
+	int is_i64;
+	int64_t i64;
+	double f64;
+	// The contents of strings and enum tags, or the number of elements
+	// of records and arrays.
+	const char* str;
+	uintptr_t len;
+} plainInfo;
+
+void plainInfoOf(const nickel_expr* expr, plainInfo* info) {
+	info->kind = exprInfo(expr, &info->b, &info->is_i64, &info->i64);
+	switch (info->kind) {
+	case 3:
+		if (!info->is_i64) {
+			info->f64 = nickel_number_as_f64(nickel_expr_as_number(expr));
+		}
+		break;
+	case 4:
+		info->len = nickel_expr_as_str(expr, &info->str);
+		break;
+	case 5:
+		info->len = nickel_expr_as_enum_tag(expr, &info->str);
+		break;
+	case 7:
+		info->len = nickel_record_len(nickel_expr_as_record(expr));
+		break;
+	case 8:
+		info->len = nickel_array_len(nickel_expr_as_array(expr));
+		break;
+	}
+}
+
+// Fetch the element at index i of a record or array, and look it up. For
+// records, the key is also written out, and 0 is returned if the field has
+// no value.
+int plainChild(const nickel_expr* expr, int kind, uintptr_t i, nickel_expr* out_expr,
+		const char** out_key, uintptr_t* out_key_len, plainInfo* info) {
+	if (kind == 7) {
+		if (!nickel_record_key_value_by_index(nickel_expr_as_record(expr), i, out_key, out_key_len, out_expr)) {
+			return 0;
+		}
+	} else {
+		nickel_array_get(nickel_expr_as_array(expr), i, out_expr);
+	}
+	plainInfoOf(out_expr, info);
+	return 1;
+}
+
+// Write out the keys of a record that start with prefix, and return how many
+// there are. The output arrays must have room for every key of the record.
+uintptr_t keysWithPrefix(const nickel_expr* expr, const char* prefix, uintptr_t prefix_len,
+		const char** out_keys, uintptr_t* out_lens) {
+	const nickel_record* rec = nickel_expr_as_record(expr);
+	uintptr_t len = nickel_record_len(rec);
+	uintptr_t n = 0;
+	for (uintptr_t i = 0; i < len; i++) {
+		const char* key;
+		uintptr_t key_len;
+		nickel_record_key_value_by_index(rec, i, &key, &key_len, NULL);
+		if (key_len >= prefix_len && memcmp(key, prefix, prefix_len) == 0) {
+			out_keys[n] = key;
+			out_lens[n] = key_len;
+			n++;
+		}
+	}
+	return n;
+}
+
+// A node of the tree evaluated by prefetch. The parent is the index of the
+// parent node in the output, plus one, or 0 for the root.
+typedef struct {
+	nickel_expr* expr;
+	int kind;
+	int b;
+	int is_i64;
+	int64_t i64;
+	uintptr_t parent;
+	uintptr_t index;
+	int level;
+	// The number of children, if they were evaluated too.
+	uintptr_t len;
+	// Whether the node wasn't a value before.
+	int forced;
+} prefetchNode;
+
+// The number of children of an expression: record fields, array elements, or
+// the payload of an enum variant.
+static uintptr_t childCount(const nickel_expr* expr, int kind) {
+	switch (kind) {
+	case 6:
+		return 1;
+	case 7:
+		return nickel_record_len(nickel_expr_as_record(expr));
+	case 8:
+		return nickel_array_len(nickel_expr_as_array(expr));
+	default:
+		return 0;
+	}
+}
+
+// Evaluate the children of expr shallowly, and append them to the nodes.
+// Returns 0 if an evaluation fails.
+static int prefetchLevel(nickel_context* ctx, const nickel_expr* expr, int kind, uintptr_t parent, int level,
+		nickel_expr* scratch, prefetchNode** nodes, uintptr_t* len, uintptr_t* cap, nickel_error* out_err) {
+	uintptr_t n = childCount(expr, kind);
+	for (uintptr_t i = 0; i < n; i++) {
+		if (kind == 6) {
+			const char* tag;
+			nickel_expr_as_enum_variant(expr, &tag, scratch);
+		} else if (kind == 7) {
+			const char* key;
+			uintptr_t key_len;
+			if (!nickel_record_key_value_by_index(nickel_expr_as_record(expr), i, &key, &key_len, scratch)) {
+				continue;
+			}
+		} else {
+			nickel_array_get(nickel_expr_as_array(expr), i, scratch);
+		}
+
+		int forced = !nickel_expr_is_value(scratch);
+		nickel_expr* out = nickel_expr_alloc();
+		if (nickel_context_eval_expr_shallow(ctx, scratch, out, out_err) != NICKEL_RESULT_OK) {
+			nickel_expr_free(out);
+			return 0;
+		}
+		if (*len == *cap) {
+			*cap = *cap ? 2 * *cap : 64;
+			*nodes = realloc(*nodes, *cap * sizeof(prefetchNode));
+		}
+		prefetchNode* node = &(*nodes)[(*len)++];
+		memset(node, 0, sizeof(prefetchNode));
+		node->expr = out;
+		node->kind = exprInfo(out, &node->b, &node->is_i64, &node->i64);
+		node->parent = parent;
+		node->index = i;
+		node->level = level;
+		node->forced = forced;
+	}
+	return 1;
+}
+
+// Evaluate the children of root shallowly, down to the given depth, in
+// breadth-first order. On success, the nodes are written out, along with the
+// number of children of the root, and the caller takes ownership of them. On
+// failure, 0 is returned and nothing is written out.
+int prefetch(nickel_context* ctx, const nickel_expr* root, int root_kind, int depth,
+		prefetchNode** out_nodes, uintptr_t* out_len, uintptr_t* out_root_len, nickel_error* out_err) {
+	prefetchNode* nodes = NULL;
+	uintptr_t len = 0, cap = 0;
+	nickel_expr* scratch = nickel_expr_alloc();
+
+	int ok = prefetchLevel(ctx, root, root_kind, 0, 1, scratch, &nodes, &len, &cap, out_err);
+	for (uintptr_t i = 0; ok && i < len; i++) {
+		if (nodes[i].level < depth) {
+			nodes[i].len = childCount(nodes[i].expr, nodes[i].kind);
+			ok = prefetchLevel(ctx, nodes[i].expr, nodes[i].kind, i + 1, nodes[i].level + 1,
+				scratch, &nodes, &len, &cap, out_err);
+		}
+	}
+	nickel_expr_free(scratch);
+
+	if (!ok) {
+		for (uintptr_t i = 0; i < len; i++) {
+			nickel_expr_free(nodes[i].expr);
+		}
+		free(nodes);
+		return 0;
+	}
+	*out_nodes = nodes;
+	*out_len = len;
+	*out_root_len = childCount(root, root_kind);
+	return 1;
+}
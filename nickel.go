@@ -9,12 +9,18 @@ package nickel
 #cgo CFLAGS: -I${SRCDIR}/include
 
 #include <nickel_lang.h>
+
+int exprInfo(const nickel_expr* expr, int* out_bool, int* out_is_i64, int64_t* out_i64);
 */
 import "C"
 
 import (
 	"encoding/json"
+	"fmt"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -32,39 +38,203 @@ type Expr struct {
 	// its own.) The cost of this is that the context will stay alive longer than
 	// strictly needed. But it isn't too big.
 	ctx *Context
+
+	// The kind of value, and the value itself for bools and small integers.
+	// These are looked up once, when the Expr is filled in (see load), so
+	// that inspecting it doesn't need a cgo call per check.
+	kind  Kind
+	b     bool
+	isI64 bool
+	i64   int64
+
+	// Whether this expression is known to be fully evaluated, because it
+	// came from a deep evaluation.
+	deep bool
+	// Whether this expression came from an evaluation for export, which
+	// removes the fields that wouldn't be exported. Exported expressions
+	// are also deep.
+	exported bool
+
+	// The children of the expression, evaluated shallowly by
+	// PrefetchChildren, indexed like the fields of records and the elements
+	// of arrays. Enum variants have their payload as the only child. Record
+	// fields without a value have a nil child. It's set atomically, since
+	// PrefetchChildren can be called while the expression is in use.
+	prefetched atomic.Pointer[[]*Expr]
+
+	// Where the expression comes from, if the evaluation that produced it
+	// was recorded (see Context.SetEvalRecorder).
+	origin *exprOrigin
+
+	// The evaluation result that owns the native expression, in explicit
+	// close mode (see Context.SetExplicitClose).
+	arena *exprArena
+}
+
+// Kind is the kind of value that an Expr holds.
+type Kind int
+
+// The numbering of these must match the return values of exprInfo in nickel.c.
+const (
+	// KindThunk is an expression that has not been evaluated yet (see
+	// EvalShallow).
+	KindThunk Kind = iota
+	KindNull
+	KindBool
+	KindNumber
+	KindString
+	KindEnumTag
+	KindEnumVariant
+	KindRecord
+	KindArray
+	// KindFunction is a function, or another special value that can't be
+	// inspected, like a contract label. The C API only tells functions
+	// apart from unevaluated expressions once they have been evaluated, so
+	// a function that hasn't been evaluated yet has KindThunk.
+	KindFunction
+)
+
+var kindNames = [...]string{
+	KindThunk:       "thunk",
+	KindNull:        "null",
+	KindBool:        "bool",
+	KindNumber:      "number",
+	KindString:      "string",
+	KindEnumTag:     "enum tag",
+	KindEnumVariant: "enum variant",
+	KindRecord:      "record",
+	KindArray:       "array",
+	KindFunction:    "function",
+}
+
+// String implements the fmt.Stringer interface for Kind.
+func (k Kind) String() string {
+	if k >= 0 && int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "Kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// KindError is returned by operations that need an expression of a
+// particular kind, when given an expression of another kind.
+type KindError struct {
+	Want Kind
+	Got  Kind
+}
+
+func (e *KindError) Error() string {
+	return fmt.Sprintf("expected %s, got %s", e.Want, e.Got)
 }
 
 // Error is a Nickel error message.
 type Error struct {
 	ptr *C.nickel_error
+
+	// The program whose evaluation failed, if known, for ImportChain and
+	// Diagnostics: its source name ("" for the default one), its source,
+	// and the length of the bindings in front of it in the source (see
+	// withPrelude).
+	mainName    string
+	mainSrc     string
+	mainPrelude int
+	// The imports of the program that were rewritten (see resolveImports),
+	// as written in the program, by the paths they were rewritten to.
+	imports map[string]string
 }
 
 // Implement the Error interface for our Error type.
 func (e *Error) Error() string {
-	s := C.nickel_string_alloc()
-	defer C.nickel_string_free(s)
+	msg, ok := e.format(C.NICKEL_ERROR_FORMAT_TEXT)
+	if !ok {
+		return "error formatting error"
+	}
+	return msg
+}
+
+// format formats the error with the Nickel library.
+func (e *Error) format(format C.nickel_error_format) (string, bool) {
+	s := allocString()
+	defer freeString(s)
 
-	result := C.nickel_error_format_as_string(e.ptr, s, C.NICKEL_ERROR_FORMAT_TEXT)
+	result := C.nickel_error_format_as_string(e.ptr, s, format)
 	if result == C.NICKEL_RESULT_ERR {
-		return "error formatting error"
-	} else {
-		var len C.uintptr_t
-		var bytes *C.char
-		C.nickel_string_data(s, &bytes, &len)
-		return C.GoStringN(bytes, C.int(len))
+		return "", false
 	}
+	return goString(s), true
 }
 
+// new_expr allocates the Expr for a new evaluation result.
 func new_expr(ctx *Context) *Expr {
+	if ctx.explicitCloseEnabled() {
+		arena := &exprArena{}
+		return &Expr{ptr: arena.alloc(), ctx: ctx, arena: arena}
+	}
+
 	expr := &Expr{
-		ptr: C.nickel_expr_alloc(),
+		ptr: allocExpr(),
 		ctx: ctx,
 	}
 
 	runtime.SetFinalizer(expr, func(expr *Expr) {
-		C.nickel_expr_free(expr.ptr)
+		freeExpr(expr.ptr)
+	})
+
+	return expr
+}
+
+// new_child allocates an Expr for a part of parent, or for the result of
+// evaluating it further, which belongs to the same result as parent.
+func new_child(parent *Expr) *Expr {
+	if parent.arena == nil {
+		return adopt_child(parent, C.nickel_expr_alloc())
+	}
+	return &Expr{ptr: parent.arena.alloc(), ctx: parent.ctx, arena: parent.arena}
+}
+
+// adopt_child wraps a native expression allocated elsewhere, which belongs
+// to the same result as parent.
+func adopt_child(parent *Expr, ptr *C.nickel_expr) *Expr {
+	if parent.arena != nil {
+		parent.arena.adopt(ptr)
+		return &Expr{ptr: ptr, ctx: parent.ctx, arena: parent.arena}
+	}
+	liveExprs.Add(1)
+	expr := &Expr{ptr: ptr, ctx: parent.ctx}
+	runtime.SetFinalizer(expr, func(expr *Expr) {
+		freeExpr(expr.ptr)
 	})
+	return expr
+}
+
+// inherit marks expr, which is part of parent, as evaluated in the same way.
+func (expr *Expr) inherit(parent *Expr) {
+	expr.deep = parent.deep
+	expr.exported = parent.exported
+	if expr.deep {
+		expr.evaluated()
+	}
+}
+
+// evaluated marks expr, which has been evaluated, as a function if it isn't
+// a value, since the C API doesn't tell them apart from unevaluated
+// expressions.
+func (expr *Expr) evaluated() *Expr {
+	if expr.kind == KindThunk {
+		expr.kind = KindFunction
+	}
+	return expr
+}
+
+// load caches the kind of the expression. It must be called once the native
+// expression has been written, before the Expr is handed out.
+func (expr *Expr) load() *Expr {
+	var b, isI64 C.int
+	var i64 C.int64_t
 
+	expr.kind = Kind(C.exprInfo(expr.ptr, &b, &isI64, &i64))
+	expr.b = b != 0
+	expr.isI64 = isI64 != 0
+	expr.i64 = int64(i64)
 	return expr
 }
 
@@ -72,9 +242,11 @@ func new_err() *Error {
 	err := &Error{
 		ptr: C.nickel_error_alloc(),
 	}
+	liveErrors.Add(1)
 
 	runtime.SetFinalizer(err, func(err *Error) {
 		C.nickel_error_free(err.ptr)
+		liveErrors.Add(-1)
 	})
 
 	return err
@@ -88,46 +260,120 @@ func new_err() *Error {
 // enum, record, or array. In case it's a record, array, or enum
 // variant, the payload (record values, array elements, or enum
 // payloads) will be left unevaluated.
+//
+// It is safe to call EvalShallow on several expressions from the same
+// evaluation concurrently, for example on different fields of a record.
+// The calls are serialized by the Context, so this doesn't evaluate them in
+// parallel.
 func (expr *Expr) EvalShallow() (*Expr, error) {
-	out_expr := new_expr(expr.ctx)
+	out_expr := new_child(expr)
 	out_err := new_err()
 
-	result := C.nickel_context_eval_expr_shallow(expr.ctx.ptr, expr.ptr, out_expr.ptr, out_err.ptr)
-	if result == C.NICKEL_RESULT_OK {
-		return out_expr, nil
+	result, err := runNative(expr.ctx, func() C.nickel_result {
+		defer expr.ctx.flushTrace()
+		return C.nickel_context_eval_expr_shallow(expr.ctx.ptr, expr.ptr, out_expr.ptr, out_err.ptr)
+	}, func(C.nickel_result) {})
+	if err != nil {
+		// The evaluation timed out.
+	} else if result == C.NICKEL_RESULT_OK {
+		out_expr.load().evaluated()
+		out_expr.origin = expr.origin
+		err = expr.ctx.checkSize(out_expr)
 	} else {
-		return nil, out_err
+		err = out_err
+	}
+	if expr.origin != nil && expr.kind == KindThunk {
+		expr.ctx.recordForce(expr.origin, out_expr, err)
+	}
+	if err != nil {
+		return nil, err
 	}
+	return out_expr, nil
+}
+
+// force evaluates the expression shallowly if it isn't a value yet.
+func (expr *Expr) force() (*Expr, error) {
+	if expr.kind != KindThunk {
+		return expr, nil
+	}
+	return expr.EvalShallow()
+}
+
+// EvalDeep evaluates an expression deeply.
+//
+// This is the counterpart of Context.EvalDeep for expressions that came from
+// a shallow evaluation. It has no effect if the expression came from a deep
+// evaluation already.
+//
+// Since the C API can only evaluate deeply from source, this evaluates
+// the unevaluated parts of the expression shallowly, one at a time, and then
+// evaluates the result again from an exact source representation. This loses
+// record field metadata: for example, fields marked not_exported become
+// ordinary fields. Expressions containing functions can't be evaluated deeply.
+func (expr *Expr) EvalDeep() (*Expr, error) {
+	if expr.deep {
+		return expr, nil
+	}
+
+	var b strings.Builder
+	if err := writeSource(&b, expr); err != nil {
+		return nil, err
+	}
+	return expr.ctx.evalDeep(b.String(), evalOptions{data: true})
 }
 
 // ToRecord converts an Expr to a native Go map, if the expression represented a Nickel record.
 //
 // If the record was the result of lazy evaluation, it may have undefined
 // fields. In that case, the returned map will have keys whose values are nil.
+//
+// The map has the fields marked not_exported, unless the record was
+// evaluated for export (see Context.EvalExport). MarshalJSON leaves them
+// out either way.
 func (expr *Expr) ToRecord() (map[string]*Expr, bool) {
-	if C.nickel_expr_is_record(expr.ptr) != 0 {
-		ptr := C.nickel_expr_as_record(expr.ptr)
-		len := C.nickel_record_len(ptr)
-		ret := make(map[string]*Expr)
-
-		for i := range len {
-			var key *C.char
-			var key_len C.uintptr_t
-			value := new_expr(expr.ctx)
-
-			has_value := C.nickel_record_key_value_by_index(ptr, C.uintptr_t(i), &key, &key_len, value.ptr)
-			if has_value == 0 {
-				value = nil
-			}
+	if expr.kind != KindRecord {
+		return nil, false
+	}
+	ret := make(map[string]*Expr, expr.Len())
+	expr.ToRecordInto(ret)
+	return ret, true
+}
 
-			key_string := C.GoStringN(key, C.int(key_len))
-			ret[key_string] = value
+// ToRecordInto is like ToRecord, but fills m instead of allocating a new
+// map. Whatever m contains is removed first, and its storage is reused, so
+// this saves allocations when converting many records in a loop. If the
+// expression isn't a record, m is left alone and ToRecordInto returns false.
+func (expr *Expr) ToRecordInto(m map[string]*Expr) bool {
+	if expr.kind != KindRecord {
+		return false
+	}
+	clear(m)
+
+	ptr := C.nickel_expr_as_record(expr.ptr)
+	len := C.nickel_record_len(ptr)
+	prefetched := expr.prefetchedChildren()
+	for i := range len {
+		var key *C.char
+		var key_len C.uintptr_t
+		if prefetched != nil {
+			C.nickel_record_key_value_by_index(ptr, C.uintptr_t(i), &key, &key_len, nil)
+			m[C.GoStringN(key, C.int(key_len))] = prefetched[i]
+			continue
 		}
-
-		return ret, true
-	} else {
-		return nil, false
+		value := new_child(expr)
+
+		has_value := C.nickel_record_key_value_by_index(ptr, C.uintptr_t(i), &key, &key_len, value.ptr)
+		key_string := C.GoStringN(key, C.int(key_len))
+		if has_value == 0 {
+			value = nil
+		} else {
+			value.load()
+			value.inherit(expr)
+			value.origin = expr.origin.field(key_string)
+		}
+		m[key_string] = value
 	}
+	return true
 }
 
 // ToArray converts an Expr to a native Go array, if the expression represented a Nickel array.
@@ -135,27 +381,46 @@ func (expr *Expr) ToRecord() (map[string]*Expr, bool) {
 // If the expression was shallowly evaluated, some of the elements of the returned array may
 // not have been evaluated yet.
 func (expr *Expr) ToArray() ([]*Expr, bool) {
-	if C.nickel_expr_is_array(expr.ptr) != 0 {
-		ptr := C.nickel_expr_as_array(expr.ptr)
-		len := C.nickel_array_len(ptr)
-		ret := make([]*Expr, len)
+	if expr.kind != KindArray {
+		return nil, false
+	}
+	return expr.ToArrayInto(make([]*Expr, 0, expr.Len()))
+}
+
+// ToArrayInto is like ToArray, but appends the elements to dst[:0] instead
+// of allocating a new slice, and returns the result. Passing the slice from
+// the previous call reuses its storage, which saves allocations when
+// converting many arrays in a loop. If the expression isn't an array,
+// ToArrayInto returns dst unchanged, and false.
+func (expr *Expr) ToArrayInto(dst []*Expr) ([]*Expr, bool) {
+	if expr.kind != KindArray {
+		return dst, false
+	}
 
+	ptr := C.nickel_expr_as_array(expr.ptr)
+	len := C.nickel_array_len(ptr)
+	ret := dst[:0]
+	if prefetched := expr.prefetchedChildren(); prefetched != nil {
+		ret = append(ret, prefetched...)
+	} else {
 		for i := range len {
-			value := new_expr(expr.ctx)
+			value := new_child(expr)
 			C.nickel_array_get(ptr, i, value.ptr)
-			ret[i] = value
+			value.load()
+			value.inherit(expr)
+			value.origin = expr.origin.elem(int(i))
+			ret = append(ret, value)
 		}
-		return ret, true
-	} else {
-		return nil, false
 	}
+	// Don't keep the elements from a previous call alive.
+	clear(ret[int(len):cap(ret)])
+	return ret, true
 }
 
 // ToBool converts an Expr into a bool, if the expression represented a Nickel bool.
 func (expr *Expr) ToBool() (bool, bool) {
-	if C.nickel_expr_is_bool(expr.ptr) != 0 {
-		b := C.nickel_expr_as_bool(expr.ptr) != 0
-		return b, true
+	if expr.kind == KindBool {
+		return expr.b, true
 	} else {
 		return false, false
 	}
@@ -163,9 +428,10 @@ func (expr *Expr) ToBool() (bool, bool) {
 
 // ToFloat64 converts an Expr into a float64, if the expression represented a Nickel number.
 //
-// The conversion from Nickel number to a float64 may involve rounding.
+// The conversion from Nickel number to a float64 may involve rounding. To
+// detect it, see ToFloat64Exact.
 func (expr *Expr) ToFloat64() (float64, bool) {
-	if C.nickel_expr_is_number(expr.ptr) != 0 {
+	if expr.kind == KindNumber {
 		num := C.nickel_expr_as_number(expr.ptr)
 		x := C.nickel_number_as_f64(num)
 		return float64(x), true
@@ -180,19 +446,15 @@ func (expr *Expr) ToFloat64() (float64, bool) {
 // This conversion will fail if the expression is a Nickel number that doesn't fit in
 // an int64, either because it is too large or not an integer.
 func (expr *Expr) ToInt64() (int64, bool) {
-	if C.nickel_expr_is_number(expr.ptr) != 0 {
-		num := C.nickel_expr_as_number(expr.ptr)
-		if C.nickel_number_is_i64(num) != 0 {
-			x := C.nickel_number_as_i64(num)
-			return int64(x), true
-		}
+	if expr.kind == KindNumber && expr.isI64 {
+		return expr.i64, true
 	}
 	return 0, false
 }
 
 // ToString converts an Expr into a string, if the expression represented a Nickel string.
 func (expr *Expr) ToString() (string, bool) {
-	if C.nickel_expr_is_str(expr.ptr) != 0 {
+	if expr.kind == KindString {
 		var ptr *C.char
 		len := C.nickel_expr_as_str(expr.ptr, &ptr)
 		return C.GoStringN(ptr, (C.int)(len)), true
@@ -203,7 +465,7 @@ func (expr *Expr) ToString() (string, bool) {
 
 // ToEnumTag converts an Expr into a string, if the expression represented a Nickel enum tag.
 func (expr *Expr) ToEnumTag() (string, bool) {
-	if C.nickel_expr_is_enum_tag(expr.ptr) != 0 {
+	if expr.kind == KindEnumTag {
 		var ptr *C.char
 		len := C.nickel_expr_as_enum_tag(expr.ptr, &ptr)
 		return C.GoStringN(ptr, (C.int)(len)), true
@@ -218,60 +480,136 @@ func (expr *Expr) ToEnumTag() (string, bool) {
 // If the expression was shallowly evaluated, the payload may
 // not have been evaluated yet.
 func (expr *Expr) ToEnumVariant() (string, *Expr, bool) {
-	if C.nickel_expr_is_enum_variant(expr.ptr) != 0 {
+	if expr.kind == KindEnumVariant {
 		var ptr *C.char
-		out_expr := new_expr(expr.ctx)
+		if prefetched := expr.prefetchedChildren(); prefetched != nil {
+			payload := allocExpr()
+			defer freeExpr(payload)
+			len := C.nickel_expr_as_enum_variant(expr.ptr, &ptr, payload)
+			return C.GoStringN(ptr, (C.int)(len)), prefetched[0], true
+		}
+		out_expr := new_child(expr)
 		len := C.nickel_expr_as_enum_variant(expr.ptr, &ptr, out_expr.ptr)
 		tag := C.GoStringN(ptr, (C.int)(len))
+		out_expr.load()
+		out_expr.inherit(expr)
+		// The payload has the same path as the variant, as in Walk.
+		out_expr.origin = expr.origin
 		return tag, out_expr, true
 	} else {
 		return "", nil, false
 	}
 }
 
+// Kind returns the kind of value that this expression holds.
+//
+// This is cheap: it doesn't need to call into the Nickel library, so it is
+// the preferred way to dispatch on the type of an Expr.
+func (expr *Expr) Kind() Kind {
+	return expr.kind
+}
+
 func (expr *Expr) IsRecord() bool {
-	return C.nickel_expr_is_record(expr.ptr) != 0
+	return expr.kind == KindRecord
 }
 
 func (expr *Expr) IsArray() bool {
-	return C.nickel_expr_is_array(expr.ptr) != 0
+	return expr.kind == KindArray
 }
 
 func (expr *Expr) IsBool() bool {
-	return C.nickel_expr_is_bool(expr.ptr) != 0
+	return expr.kind == KindBool
 }
 
 func (expr *Expr) IsNumber() bool {
-	return C.nickel_expr_is_number(expr.ptr) != 0
+	return expr.kind == KindNumber
 }
 
 func (expr *Expr) IsString() bool {
-	return C.nickel_expr_is_str(expr.ptr) != 0
+	return expr.kind == KindString
 }
 
 func (expr *Expr) IsEnumTag() bool {
-	return C.nickel_expr_is_enum_tag(expr.ptr) != 0
+	return expr.kind == KindEnumTag
 }
 
 func (expr *Expr) IsEnumVariant() bool {
-	return C.nickel_expr_is_enum_variant(expr.ptr) != 0
+	return expr.kind == KindEnumVariant
 }
 
 func (expr *Expr) IsValue() bool {
-	return C.nickel_expr_is_value(expr.ptr) != 0
+	return expr.kind != KindThunk
 }
 
 func (expr *Expr) IsNull() bool {
-	return C.nickel_expr_is_null(expr.ptr) != 0
+	return expr.kind == KindNull
 }
 
 // MarshalJSON implements the json.Marshaler interface for Expr.
 func (expr *Expr) MarshalJSON() ([]byte, error) {
+	return expr.export(serializeJSON)
+}
+
+// MarshalYAML serializes an Expr to YAML.
+//
+// This uses the same serializer as `nickel export --format yaml`. Like
+// MarshalJSON, it fails if the expression contains enum variants or
+// unevaluated sub-expressions.
+func (expr *Expr) MarshalYAML() ([]byte, error) {
+	return expr.export(serializeYAML)
+}
+
+// MarshalTOML serializes an Expr to TOML.
+//
+// This uses the same serializer as `nickel export --format toml`, so
+// integers and floats stay distinct. Like MarshalJSON, it fails if the
+// expression contains enum variants or unevaluated sub-expressions, and
+// TOML can only represent records at the top level.
+func (expr *Expr) MarshalTOML() ([]byte, error) {
+	return expr.export(serializeTOML)
+}
+
+type serializeFormat int
+
+const (
+	serializeJSON serializeFormat = iota
+	serializeYAML
+	serializeTOML
+)
+
+// export serializes expr, within the context's MaxExportBytes limit.
+func (expr *Expr) export(format serializeFormat) ([]byte, error) {
+	if err := expr.checkNumbers(); err != nil {
+		return nil, err
+	}
+	data, err := expr.serialize(format)
+	if err != nil {
+		return nil, err
+	}
+	if err := expr.ctx.checkExportSize(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (expr *Expr) serialize(format serializeFormat) ([]byte, error) {
 	out_err := new_err()
-	out_string := C.nickel_string_alloc()
-	defer C.nickel_string_free(out_string)
+	out_string := allocString()
+	defer freeString(out_string)
 
-	result := C.nickel_context_expr_to_json(expr.ctx.ptr, expr.ptr, out_string, out_err.ptr)
+	var result C.nickel_result
+	if err := expr.ctx.lockNative(); err != nil {
+		return nil, err
+	}
+	switch format {
+	case serializeJSON:
+		result = C.nickel_context_expr_to_json(expr.ctx.ptr, expr.ptr, out_string, out_err.ptr)
+	case serializeYAML:
+		result = C.nickel_context_expr_to_yaml(expr.ctx.ptr, expr.ptr, out_string, out_err.ptr)
+	case serializeTOML:
+		result = C.nickel_context_expr_to_toml(expr.ctx.ptr, expr.ptr, out_string, out_err.ptr)
+	}
+	expr.ctx.unlockNative()
 	if result == C.NICKEL_RESULT_ERR {
 		return nil, out_err
 	} else {
@@ -290,11 +628,64 @@ func (expr *Expr) MarshalJSON() ([]byte, error) {
 }
 
 // ConvertTo converts an Expr to anything that can be unmarshaled from JSON.
+//
+// Parts of the value can be left for later by giving the corresponding
+// fields of the target the type json.RawMessage, which captures them as
+// JSON, or *Expr (see Expr.UnmarshalJSON).
+//
+// Converting the result of Decode to an any, a map[string]any or an []any
+// doesn't need to go through JSON, and is much faster. Expr.Decode converts
+// to other types without JSON as well.
+//
+// The context's decode hooks (see Context.SetDecodeHooks) are applied to the
+// value before it's decoded. Numbers are rounded to the nearest float64 if
+// needed, unless the context is in strict numbers mode (see
+// Context.SetStrictNumbers).
 func (expr *Expr) ConvertTo(target any) error {
-	data, err := expr.MarshalJSON()
+	if err := expr.checkNumbers(); err != nil {
+		return err
+	}
+	if convertPlain(expr, target) {
+		return nil
+	}
+	if hooks := expr.ctx.decodeHookList(); len(hooks) > 0 {
+		return expr.convertWithHooks(target, hooks)
+	}
+
+	data, err := expr.serialize(serializeJSON)
 	if err != nil {
 		return err
 	}
 
 	return json.Unmarshal(data, target)
 }
+
+// UnmarshalJSON implements the json.Unmarshaler interface for Expr, so that
+// an *Expr can capture part of the value being decoded by ConvertTo (or by
+// json.Unmarshal).
+//
+// The resulting expression is evaluated deeply, and belongs to the default
+// context. It can only be unmarshaled into a new Expr, as in a nil *Expr
+// field: an Expr that already holds a value can't be changed.
+func (expr *Expr) UnmarshalJSON(data []byte) error {
+	if expr.ptr != nil {
+		return fmt.Errorf("can't unmarshal into an Expr that already holds a value")
+	}
+
+	value, err := DefaultContext().evalDeep("std.deserialize 'Json "+QuoteString(string(data)), evalOptions{data: true})
+	if err != nil {
+		return err
+	}
+
+	// There's no way to copy a native expression, so take ownership of
+	// the new one. expr may not be the start of an allocation, so this
+	// needs a cleanup rather than a finalizer.
+	runtime.SetFinalizer(value, nil)
+	expr.ptr, expr.ctx, expr.arena = value.ptr, value.ctx, value.arena
+	expr.kind, expr.b, expr.isI64, expr.i64 = value.kind, value.b, value.isI64, value.i64
+	expr.deep, expr.exported = value.deep, value.exported
+	if expr.arena == nil {
+		runtime.AddCleanup(expr, freeExpr, expr.ptr)
+	}
+	return nil
+}
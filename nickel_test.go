@@ -2,7 +2,18 @@ package nickel
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -220,3 +231,675 @@ func TestErrorFormat(t *testing.T) {
 
 	}
 }
+
+func TestDefaultContext(t *testing.T) {
+	if DefaultContext() != DefaultContext() {
+		t.Fatal("expected the default context to be reused")
+	}
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			target, err := Decode[FooBar](fmt.Sprintf("{ foo = %d, bar = 2 }", i))
+			if err != nil {
+				t.Errorf("decode error: %v", err)
+				return
+			}
+			if target.Foo != i || target.Bar != 2 {
+				t.Errorf("unexpected result: %v", target)
+			}
+		}()
+	}
+	wg.Wait()
+
+	_, err := Decode[FooBar]("{ foo = \"1\", bar = 2 }")
+	if err == nil {
+		t.Fatal("expected a conversion error")
+	}
+}
+
+func TestScalars(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalDeep("[true, null, 9999999999999999999999, 'Foo, 1.5]")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	arr, _ := expr.ToArray()
+	b, ok := arr[0].ToBool()
+	if !ok || !b {
+		t.Fatal("expected true")
+	}
+	if !arr[1].IsNull() || arr[1].IsBool() {
+		t.Fatal("expected null")
+	}
+	if _, ok := arr[2].ToInt64(); ok {
+		t.Fatal("expected a number that doesn't fit in an int64")
+	}
+	if x, ok := arr[2].ToFloat64(); !ok || x != 1e22 {
+		t.Fatalf("expected 1e22, got %v", x)
+	}
+	if tag, ok := arr[3].ToEnumTag(); !ok || tag != "Foo" {
+		t.Fatal("expected 'Foo")
+	}
+	if _, ok := arr[4].ToInt64(); ok {
+		t.Fatal("expected a non-integer")
+	}
+	if x, ok := arr[4].ToFloat64(); !ok || x != 1.5 {
+		t.Fatal("expected 1.5")
+	}
+}
+
+func TestKind(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalDeep("{ a = null, b = true, c = 1, d = \"s\", e = 'Tag, f = 'Tag 1, g = {}, h = [] }")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if expr.Kind() != KindRecord {
+		t.Fatalf("expected a record, got %v", expr.Kind())
+	}
+
+	record, _ := expr.ToRecord()
+	expected := map[string]Kind{
+		"a": KindNull,
+		"b": KindBool,
+		"c": KindNumber,
+		"d": KindString,
+		"e": KindEnumTag,
+		"f": KindEnumVariant,
+		"g": KindRecord,
+		"h": KindArray,
+	}
+	for key, kind := range expected {
+		if record[key].Kind() != kind {
+			t.Errorf("%s: expected %v, got %v", key, kind, record[key].Kind())
+		}
+	}
+
+	expr, err = ctx.EvalShallow("{ a = 1 + 1 }")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	record, _ = expr.ToRecord()
+	if record["a"].Kind() != KindThunk {
+		t.Fatalf("expected a thunk, got %v", record["a"].Kind())
+	}
+
+	// Functions are only told apart from thunks once they're evaluated.
+	expr, err = ctx.EvalShallow("{ f = fun x => x }")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	record, _ = expr.ToRecord()
+	if record["f"].Kind() != KindThunk {
+		t.Fatalf("expected a thunk, got %v", record["f"].Kind())
+	}
+	f, err := record["f"].EvalShallow()
+	if err != nil || f.Kind() != KindFunction {
+		t.Fatalf("expected a function, got %v, %v", f.Kind(), err)
+	}
+	expr, err = ctx.EvalDeep("{ f = fun x => x, g = std.array.map }")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	record, _ = expr.ToRecord()
+	for _, key := range []string{"f", "g"} {
+		if record[key].Kind() != KindFunction {
+			t.Errorf("%s: expected a function, got %v", key, record[key].Kind())
+		}
+	}
+
+	if KindEnumVariant.String() != "enum variant" {
+		t.Fatalf("unexpected kind name %q", KindEnumVariant.String())
+	}
+}
+
+// countingVisitor counts the leaves of a deeply evaluated expression.
+type countingVisitor struct {
+	leaves int
+}
+
+func (v *countingVisitor) VisitNull() error          { v.leaves++; return nil }
+func (v *countingVisitor) VisitBool(bool) error      { v.leaves++; return nil }
+func (v *countingVisitor) VisitNumber(*Expr) error   { v.leaves++; return nil }
+func (v *countingVisitor) VisitString(string) error  { v.leaves++; return nil }
+func (v *countingVisitor) VisitEnumTag(string) error { v.leaves++; return nil }
+func (v *countingVisitor) VisitThunk(*Expr) error    { return errors.New("unexpected thunk") }
+func (v *countingVisitor) VisitEnumVariant(_ string, payload *Expr) error {
+	return payload.Visit(v)
+}
+
+func (v *countingVisitor) VisitRecord(fields map[string]*Expr) error {
+	for _, field := range fields {
+		if err := field.Visit(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *countingVisitor) VisitArray(elems []*Expr) error {
+	for _, elem := range elems {
+		if err := elem.Visit(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestVisit(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalDeep("{ a = [null, true, 1], b = { c = \"s\", d = 'Tag }, e = 'Var [1, 2] }")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	var v countingVisitor
+	if err := expr.Visit(&v); err != nil {
+		t.Fatalf("visit error: %v", err)
+	}
+	if v.leaves != 7 {
+		t.Fatalf("expected 7 leaves, got %d", v.leaves)
+	}
+
+	expr, err = ctx.EvalShallow("{ a = 1 + 1 }")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if err := expr.Visit(&countingVisitor{}); err == nil {
+		t.Fatal("expected the thunk to be visited")
+	}
+}
+
+func TestString(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalDeep("{ b = [1, 2.5, null], a = { \"x y\" = 'Tag, z = 'Var true }, c = \"hi\" }")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	expected := `{ a = { "x y" = 'Tag, z = 'Var true }, b = [1, 2.5, null], c = "hi" }`
+	if expr.String() != expected {
+		t.Fatalf("unexpected string: %s", expr)
+	}
+
+	expr, err = ctx.EvalDeep("std.array.range 0 100")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if expr.String() != "[0, 1, 2, 3, 4, 5, 6, 7, ...]" {
+		t.Fatalf("unexpected string: %s", expr)
+	}
+
+	expr, err = ctx.EvalShallow("{ j = 10, i = 9, h = 8, g = 7, f = 6, e = 5, d = 4, c = 3, b = 2, a = 1 }")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if expr.String() != "{ a = 1, b = 2, c = 3, d = 4, e = 5, f = 6, g = 7, h = 8, ... }" {
+		t.Fatalf("unexpected string: %s", expr)
+	}
+
+	expr, err = ctx.EvalShallow("{ a = 1 + 1, b = 1 }")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if fmt.Sprint(expr) != "{ a = <lazy>, b = 1 }" {
+		t.Fatalf("unexpected string: %s", expr)
+	}
+}
+
+func TestDebugDump(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalShallow("{ a = [1, 1 + 1], b = 'Var { c = 1 }, d | optional }")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	record, _ := expr.ToRecord()
+	a, err := record["a"].EvalShallow()
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := a.DebugDump(&buf, -1); err != nil {
+		t.Fatalf("dump error: %v", err)
+	}
+	expected := "array (2 elements)\n  [0]: number 1\n  [1]: thunk (not evaluated)\n"
+	if buf.String() != expected {
+		t.Fatalf("unexpected dump:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := expr.DebugDump(&buf, 0); err != nil {
+		t.Fatalf("dump error: %v", err)
+	}
+	if buf.String() != "record (3 fields)\n  ...\n" {
+		t.Fatalf("unexpected dump:\n%s", buf.String())
+	}
+
+	gostring := fmt.Sprintf("%#v", a)
+	if gostring != "&nickel.Expr{Kind: nickel.KindArray, Value: [1, <lazy>]}" {
+		t.Fatalf("unexpected GoString: %s", gostring)
+	}
+}
+
+func TestLogValue(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetLogRedactor(func(path []string) bool {
+		return path[len(path)-1] == "password"
+	})
+	expr, err := ctx.EvalDeep("{ db = { user = \"me\", password = \"hunter2\" }, ports = [80, 443], mode = 'Fast }")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	logger.Info("loaded", "config", expr)
+
+	expected := "level=INFO msg=loaded config.db.password=REDACTED config.db.user=me config.mode=Fast config.ports.0=80 config.ports.1=443\n"
+	if buf.String() != expected {
+		t.Fatalf("unexpected log output: %s", buf.String())
+	}
+}
+
+func TestInvalidSource(t *testing.T) {
+	ctx := NewContext()
+	for _, src := range []string{"\"\xff\"", "{ a = 1 }\x00{ b = 2 }"} {
+		_, err := ctx.EvalDeep(src)
+		if !errors.Is(err, ErrInvalidSource) {
+			t.Fatalf("%q: expected an invalid source error, got %v", src, err)
+		}
+		_, err = ctx.EvalShallow(src)
+		if !errors.Is(err, ErrInvalidSource) {
+			t.Fatalf("%q: expected an invalid source error, got %v", src, err)
+		}
+	}
+}
+
+func FuzzEvalDeep(f *testing.F) {
+	for _, src := range []string{
+		"{ foo = 1, bar = [1, 2, \"x\"] }",
+		"'Tag (1 + 1)",
+		"{ foo | String = 1 }",
+		"let rec r = { a = r.b, b = 1 } in r",
+		"m%\"multi%{\"line\"}\"%",
+		"\"\xff\"",
+		"1\x00",
+	} {
+		f.Add(src)
+	}
+
+	ctx := NewContext()
+	f.Fuzz(func(t *testing.T, src string) {
+		// Deeply nested syntax can overflow the native parser's stack (see
+		// EvalDeep), so keep the inputs too small for that.
+		if len(src) > 512 {
+			t.Skip()
+		}
+
+		expr, err := ctx.EvalDeep(src)
+		if err != nil {
+			_ = err.Error()
+			return
+		}
+		_ = expr.String()
+		_, _ = expr.MarshalJSON()
+	})
+}
+
+func TestQuoteString(t *testing.T) {
+	ctx := NewContext()
+	for _, s := range []string{"", "plain", "a \"quoted\" \\ string", "%{interpolation}", "%%{", "100%", "multi\nline\r\n\ttabbed", "é😀\x07"} {
+		expr, err := ctx.EvalDeep(QuoteString(s))
+		if err != nil {
+			t.Fatalf("%q: eval error: %v", s, err)
+		}
+		got, ok := expr.ToString()
+		if !ok || got != s {
+			t.Fatalf("%q: round-tripped to %q", s, got)
+		}
+	}
+}
+
+func TestQuoteIdent(t *testing.T) {
+	for s, expected := range map[string]string{
+		"plain":      "plain",
+		"kebab-case": "kebab-case",
+		"if":         `"if"`,
+		"a.b":        `"a.b"`,
+		"%{x}":       `"%{"%"}{x}"`,
+		"":           `""`,
+	} {
+		if got := QuoteIdent(s); got != expected {
+			t.Errorf("%q: expected %s, got %s", s, expected, got)
+		}
+	}
+}
+
+func TestSourceBuilder(t *testing.T) {
+	var b SourceBuilder
+	b.Raw("{ ").Path("outer", "in", "x.y").Raw(" = ").Quote("%{oops}\n\"").Raw(", ")
+	b.Ident("list").Raw(" = ").Value([]any{1, "two", nil}).Raw(", ")
+	b.Raw("tag = '").Ident("Not an ident").Raw(" }")
+	src, err := b.Source()
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+	expr, err := EvalDeep(src)
+	if err != nil {
+		t.Fatalf("eval error: %v\n%s", err, src)
+	}
+	expected := `{ list = [1, "two", null], outer = { "in" = { "x.y" = "%{oops}\n\"" } }, tag = '"Not an ident" }`
+	if got := expr.String(); got != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, got)
+	}
+
+	b = SourceBuilder{}
+	if _, err := b.Value(func() {}).Raw("1").Source(); err == nil {
+		t.Error("expected an error for an unmarshalable value")
+	}
+}
+
+func TestMarshalYAML(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalDeep("{ foo = 1, bar = [\"a\"] }")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	yaml, err := expr.MarshalYAML()
+	if err != nil {
+		t.Fatalf("marshal error: %v", err)
+	}
+	if string(yaml) != "bar:\n- a\nfoo: 1\n" {
+		t.Fatalf("unexpected YAML: %q", yaml)
+	}
+}
+
+func TestMarshalTOML(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalDeep("{ port = 80, ratio = 0.5, server = { name = \"srv\" } }")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	toml, err := expr.MarshalTOML()
+	if err != nil {
+		t.Fatalf("marshal error: %v", err)
+	}
+	if string(toml) != "port = 80\nratio = 0.5\n\n[server]\nname = \"srv\"\n" {
+		t.Fatalf("unexpected TOML: %q", toml)
+	}
+
+	out, err := expr.Export(ExportOptions{Format: ExportTOML, Exclude: []string{"ratio"}})
+	if err != nil {
+		t.Fatalf("export error: %v", err)
+	}
+	if string(out) != "port = 80\n\n[server]\nname = \"srv\"\n" {
+		t.Fatalf("unexpected TOML export: %q", out)
+	}
+
+	array, err := ctx.EvalDeep("[1]")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if _, err := array.MarshalTOML(); err == nil {
+		t.Fatal("expected an error for an array")
+	}
+}
+
+func TestConcurrentShallowEval(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalShallow("let shared = std.array.range 0 100 in { a = std.array.length shared, b = std.array.at 5 shared, c = shared }")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	record, _ := expr.ToRecord()
+
+	var wg sync.WaitGroup
+	for range 10 {
+		for _, field := range record {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := field.EvalShallow(); err != nil {
+					t.Errorf("eval error: %v", err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+func TestSlice(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalDeep("std.array.range 0 1000")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if expr.Len() != 1000 {
+		t.Fatalf("expected 1000 elements, got %d", expr.Len())
+	}
+
+	page, err := expr.Slice(10, 13)
+	if err != nil {
+		t.Fatalf("slice error: %v", err)
+	}
+	if len(page) != 3 {
+		t.Fatalf("expected 3 elements, got %d", len(page))
+	}
+	for i, elt := range page {
+		if x, ok := elt.ToInt64(); !ok || x != int64(10+i) {
+			t.Fatalf("unexpected element %v", elt)
+		}
+	}
+
+	if page, err := expr.Slice(1000, 1000); err != nil || len(page) != 0 {
+		t.Fatalf("expected an empty slice, got %v, %v", page, err)
+	}
+	if _, err := expr.Slice(999, 1001); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("expected an out of range error, got %v", err)
+	}
+
+	var kindErr *KindError
+	if _, err := page[0].Slice(0, 0); !errors.As(err, &kindErr) || kindErr.Got != KindNumber {
+		t.Fatalf("expected a kind error, got %v", err)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalDeep("std.array.map (fun x => 3 * x) (std.array.range 0 1000)")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	// Binary search for 1500, which is at index 500.
+	target := 1500
+	i, found := sort.Find(expr.Len(), func(i int) int {
+		elt, err := expr.Index(i)
+		if err != nil {
+			t.Fatalf("index error: %v", err)
+		}
+		x, _ := elt.ToInt64()
+		return target - int(x)
+	})
+	if !found || i != 500 {
+		t.Fatalf("expected to find 1500 at index 500, got %d, %v", i, found)
+	}
+
+	for _, i := range []int{-1, 1000} {
+		if _, err := expr.Index(i); !errors.Is(err, ErrOutOfRange) {
+			t.Fatalf("%d: expected an out of range error, got %v", i, err)
+		}
+	}
+
+	lazy, err := ctx.EvalShallow(`[1, std.fail_with "lazy"]`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	elt, err := lazy.Index(1)
+	if err != nil || elt.Kind() != KindThunk {
+		t.Fatalf("expected an unevaluated element, got %#v, %v", elt, err)
+	}
+
+	var kindErr *KindError
+	if _, err := elt.Index(0); !errors.As(err, &kindErr) || kindErr.Want != KindArray {
+		t.Fatalf("expected a kind error, got %v", err)
+	}
+}
+
+func TestConvertInto(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalShallow(`[{ a = 1, b = 2 }, { c = 3 }, [1, 2, 3], [4]]`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	elems, _ := expr.ToArray()
+
+	m := map[string]*Expr{}
+	for i, expected := range [][]string{{"a", "b"}, {"c"}} {
+		value, err := elems[i].EvalShallow()
+		if err != nil {
+			t.Fatalf("eval error: %v", err)
+		}
+		if !value.ToRecordInto(m) {
+			t.Fatalf("%v: expected a record", value)
+		}
+		if keys := slices.Sorted(maps.Keys(m)); !slices.Equal(keys, expected) {
+			t.Fatalf("expected keys %q, got %q", expected, keys)
+		}
+	}
+
+	var buf []*Expr
+	for i, expected := range []int{3, 1} {
+		value, err := elems[2+i].EvalShallow()
+		if err != nil {
+			t.Fatalf("eval error: %v", err)
+		}
+		var ok bool
+		buf, ok = value.ToArrayInto(buf)
+		if !ok || len(buf) != expected {
+			t.Fatalf("expected %d elements, got %v, %v", expected, buf, ok)
+		}
+	}
+	if first, _ := buf[0].EvalShallow(); first.String() != "4" {
+		t.Fatalf("unexpected element %v", first)
+	}
+	if buf[:3][1] != nil {
+		t.Fatal("expected the elements of the previous array to be cleared")
+	}
+
+	if expr.ToRecordInto(m) || len(m) != 1 {
+		t.Fatal("expected an array not to convert to a record")
+	}
+	if got, ok := elems[0].ToArrayInto(buf); ok || len(got) != 1 {
+		t.Fatal("expected a thunk not to convert to an array")
+	}
+}
+
+func TestConvertToDeferred(t *testing.T) {
+	type config struct {
+		Name    string          `json:"name"`
+		Raw     json.RawMessage `json:"raw"`
+		Plugin  *Expr           `json:"plugin"`
+		Plugins []*Expr         `json:"plugins"`
+	}
+
+	expr, err := EvalDeep(`{
+		name = "x",
+		raw = { b = [1, 2] },
+		plugin = { kind = "cache", size = 1 / 4 },
+		plugins = ["a", { b = null }],
+	}`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	var c config
+	if err := expr.ConvertTo(&c); err != nil {
+		t.Fatalf("convert error: %v", err)
+	}
+	var raw bytes.Buffer
+	if err := json.Compact(&raw, c.Raw); err != nil || raw.String() != `{"b":[1,2]}` {
+		t.Errorf("unexpected raw message: %s", c.Raw)
+	}
+	if c.Plugin.String() != `{ kind = "cache", size = 0.25 }` {
+		t.Errorf("unexpected plugin: %v", c.Plugin)
+	}
+	if len(c.Plugins) != 2 || c.Plugins[0].String() != `"a"` || c.Plugins[1].String() != "{ b = null }" {
+		t.Errorf("unexpected plugins: %v", c.Plugins)
+	}
+	var plugin struct {
+		Kind string  `json:"kind"`
+		Size float64 `json:"size"`
+	}
+	if err := c.Plugin.ConvertTo(&plugin); err != nil || plugin.Kind != "cache" || plugin.Size != 0.25 {
+		t.Errorf("unexpected plugin conversion: %+v (%v)", plugin, err)
+	}
+
+	// Exprs are immutable.
+	if err := json.Unmarshal([]byte("1"), c.Plugin); err == nil {
+		t.Error("expected an error unmarshaling into an existing Expr")
+	}
+	runtime.GC()
+}
+
+// TestStableOutputHelper prints the outputs that TestStableOutput compares
+// across locales.
+func TestStableOutputHelper(t *testing.T) {
+	if os.Getenv("NICKEL_TEST_STABLE_HELPER") == "" {
+		t.Skip("only run by TestStableOutput")
+	}
+	ctx := NewContext()
+	expr, err := ctx.EvalDeep(`{
+		ratio = 1 / 3, big = 1e30, small = 0.000001, negative = -1234567.5,
+		int = 1234567890123, name = "İstanbul straße", list = [0.1, 2.5e-7],
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json, err := expr.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	yaml, err := expr.MarshalYAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, evalErr := ctx.EvalDeep("{ x | Number = 1.5 ++ \"a\" }")
+	os.Stdout.Write(json)
+	os.Stdout.Write(yaml)
+	os.Stdout.WriteString(expr.String() + "\n" + evalErr.Error())
+}
+
+func TestStableOutput(t *testing.T) {
+	var outputs []string
+	for _, locale := range []string{"C", "de_DE.UTF-8", "tr_TR.UTF-8", "fr_FR.ISO-8859-1"} {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestStableOutputHelper$")
+		cmd.Env = append(os.Environ(), "NICKEL_TEST_STABLE_HELPER=1", "LC_ALL="+locale, "LANG="+locale)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%s: %v", locale, err)
+		}
+		outputs = append(outputs, string(out))
+	}
+	for i, out := range outputs[1:] {
+		if out != outputs[0] {
+			t.Errorf("output %d differs from the C locale's:\n%s\n%s", i+1, outputs[0], out)
+		}
+	}
+	if !strings.Contains(outputs[0], `"negative": -1234567.5`) {
+		t.Errorf("unexpected output:\n%s", outputs[0])
+	}
+}
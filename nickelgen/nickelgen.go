@@ -0,0 +1,434 @@
+// Package nickelgen generates formatted Nickel source from Go.
+//
+// Programs are built out of Nodes, such as records with annotated fields,
+// let bindings and imports, and turned into source with Format:
+//
+//	src, err := nickelgen.Format(nickelgen.Let("lib", nickelgen.Import("lib.ncl"),
+//		nickelgen.Record(
+//			nickelgen.Field{
+//				Name:      "port",
+//				Doc:       "The port to listen on.",
+//				Contracts: []nickelgen.Node{nickelgen.Var("lib", "Port")},
+//				Value:     nickelgen.Value(8080),
+//			},
+//		),
+//	))
+//
+// Every string coming from Go is quoted, so the generated source means what
+// the Nodes say, whatever the strings contain.
+package nickelgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nickel-lang/go-nickel"
+)
+
+// Lines longer than this are broken up, when possible.
+const maxLineLength = 80
+
+// Node is a piece of Nickel syntax.
+type Node interface {
+	// format returns the source of the node. Lines after the first are
+	// prefixed with indent.
+	format(indent string) (string, error)
+}
+
+// Format returns the source of a Nickel program, followed by a newline.
+func Format(node Node) (string, error) {
+	src, err := node.format("")
+	if err != nil {
+		return "", err
+	}
+	return src + "\n", nil
+}
+
+const indentStep = "  "
+
+type rawNode string
+
+// Raw is source written as is, such as a built-in contract like `String`.
+// It should be source written by the programmer, never input.
+func Raw(src string) Node {
+	return rawNode(src)
+}
+
+func (n rawNode) format(string) (string, error) {
+	return string(n), nil
+}
+
+type stringNode string
+
+// String is a string literal. Strings that span several lines are written
+// as multiline strings when their content allows it.
+func String(s string) Node {
+	return stringNode(s)
+}
+
+func (n stringNode) format(indent string) (string, error) {
+	return quote(string(n), indent), nil
+}
+
+// quote returns a literal for s, as a multiline string if s spans several
+// lines and isn't changed by the indentation that Nickel strips from them.
+func quote(s string, indent string) string {
+	lines := strings.Split(s, "\n")
+	if len(lines) == 1 || !multiline(lines) {
+		return nickel.QuoteString(s)
+	}
+
+	// The delimiters need more percent signs than the content ever has in a
+	// row, so that the content can't end the string or interpolate.
+	longest, run := 0, 0
+	for _, c := range []byte(s) {
+		if c == '%' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	percents := strings.Repeat("%", longest+1)
+
+	var b strings.Builder
+	b.WriteString("m" + percents + "\"\n")
+	for _, line := range lines {
+		if line != "" {
+			b.WriteString(indent + indentStep + line)
+		}
+		b.WriteByte('\n')
+	}
+	b.WriteString(indent + "\"" + percents)
+	return b.String()
+}
+
+// multiline reports whether the lines can be written in a multiline string.
+// Nickel removes the first and last lines of multiline strings if they're
+// blank, the indentation common to all the lines, and trailing spaces.
+func multiline(lines []string) bool {
+	if strings.TrimSpace(lines[0]) == "" || strings.TrimSpace(lines[len(lines)-1]) == "" {
+		return false
+	}
+	unindented := false
+	for _, line := range lines {
+		if strings.ContainsAny(line, "\r\t") || strings.TrimRight(line, " ") != line {
+			return false
+		}
+		if line != "" && line[0] != ' ' {
+			unindented = true
+		}
+	}
+	return unindented
+}
+
+type valueNode struct {
+	v any
+}
+
+// Value is an expression evaluating to v, which is a Node, or anything that
+// encoding/json can marshal. Records are written with their fields sorted.
+// Values that can't be marshaled cause an error in Format.
+func Value(v any) Node {
+	if node, ok := v.(Node); ok {
+		return node
+	}
+	return valueNode{v}
+}
+
+func (n valueNode) format(indent string) (string, error) {
+	data, err := json.Marshal(n.v)
+	if err != nil {
+		return "", err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return "", err
+	}
+	return jsonNode(v).format(indent)
+}
+
+// jsonNode converts a value decoded by encoding/json into nodes.
+func jsonNode(v any) Node {
+	switch v := v.(type) {
+	case nil:
+		return Raw("null")
+	case bool:
+		return Raw(strconv.FormatBool(v))
+	case json.Number:
+		return Raw(v.String())
+	case string:
+		return String(v)
+	case []any:
+		elems := make([]Node, len(v))
+		for i, elem := range v {
+			elems[i] = jsonNode(elem)
+		}
+		return Array(elems...)
+	case map[string]any:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		fields := make([]Field, len(names))
+		for i, name := range names {
+			fields[i] = Field{Name: name, Value: jsonNode(v[name])}
+		}
+		return Record(fields...)
+	default:
+		panic(fmt.Sprintf("unexpected JSON value %T", v))
+	}
+}
+
+type varNode struct {
+	name   string
+	fields []string
+}
+
+// Var is a reference to a variable, followed by field accesses: Var("std",
+// "string", "NonEmpty") is `std.string.NonEmpty`.
+func Var(name string, fields ...string) Node {
+	return varNode{name, fields}
+}
+
+func (n varNode) format(string) (string, error) {
+	if nickel.QuoteIdent(n.name) != n.name {
+		return "", fmt.Errorf("invalid variable name %q", n.name)
+	}
+	var b strings.Builder
+	b.WriteString(n.name)
+	for _, field := range n.fields {
+		b.WriteString("." + nickel.QuoteIdent(field))
+	}
+	return b.String(), nil
+}
+
+type importNode string
+
+// Import imports a file. Relative paths are resolved against the directory of
+// the file containing the import.
+func Import(path string) Node {
+	return importNode(path)
+}
+
+func (n importNode) format(string) (string, error) {
+	return "import " + nickel.QuoteString(string(n)), nil
+}
+
+type applyNode struct {
+	fn   Node
+	args []Node
+}
+
+// Apply is the application of a function to arguments, like
+// `std.string.join ", " parts`.
+func Apply(fn Node, args ...Node) Node {
+	return applyNode{fn, args}
+}
+
+func (n applyNode) format(indent string) (string, error) {
+	parts := make([]string, 0, len(n.args)+1)
+	for _, node := range append([]Node{n.fn}, n.args...) {
+		src, err := node.format(indent)
+		if err != nil {
+			return "", err
+		}
+		switch node.(type) {
+		case applyNode, letNode, importNode:
+			src = "(" + src + ")"
+		case rawNode, valueNode:
+			// Negative numbers would be parsed as subtractions.
+			if strings.HasPrefix(src, "-") || strings.ContainsAny(src, " \t\n") && !strings.ContainsRune(`{["`, rune(src[0])) {
+				src = "(" + src + ")"
+			}
+		}
+		parts = append(parts, src)
+	}
+	return strings.Join(parts, " "), nil
+}
+
+type letNode struct {
+	name  string
+	value Node
+	body  Node
+}
+
+// Let binds name to value in body: `let name = value in body`.
+func Let(name string, value Node, body Node) Node {
+	return letNode{name, value, body}
+}
+
+func (n letNode) format(indent string) (string, error) {
+	if nickel.QuoteIdent(n.name) != n.name {
+		return "", fmt.Errorf("invalid variable name %q", n.name)
+	}
+	value, err := n.value.format(indent)
+	if err != nil {
+		return "", err
+	}
+	body, err := n.body.format(indent)
+	if err != nil {
+		return "", err
+	}
+	return "let " + n.name + " = " + value + " in\n" + indent + body, nil
+}
+
+type arrayNode []Node
+
+// Array is an array literal. Arrays are written on one line if they fit,
+// and with one element per line otherwise.
+func Array(elems ...Node) Node {
+	return arrayNode(elems)
+}
+
+func (n arrayNode) format(indent string) (string, error) {
+	if len(n) == 0 {
+		return "[]", nil
+	}
+
+	inner := indent + indentStep
+	elems := make([]string, len(n))
+	length := len(indent)
+	oneLine := true
+	for i, elem := range n {
+		src, err := elem.format(inner)
+		if err != nil {
+			return "", err
+		}
+		elems[i] = src
+		length += len(src) + 2
+		oneLine = oneLine && !strings.Contains(src, "\n")
+	}
+	if oneLine && length <= maxLineLength {
+		return "[" + strings.Join(elems, ", ") + "]", nil
+	}
+
+	var b strings.Builder
+	b.WriteString("[\n")
+	for _, elem := range elems {
+		b.WriteString(inner + elem + ",\n")
+	}
+	b.WriteString(indent + "]")
+	return b.String(), nil
+}
+
+// Field is a field definition in a Record.
+type Field struct {
+	// Name is the name of the field. It's quoted if it isn't a valid
+	// identifier.
+	Name string
+
+	// Doc is the documentation of the field, written as a doc annotation.
+	Doc string
+
+	// Contracts are the contracts attached to the field, like Raw("String").
+	Contracts []Node
+
+	// Optional marks the field as optional.
+	Optional bool
+
+	// Priority is the merge priority of the field.
+	Priority nickel.Priority
+
+	// NotExported marks the field as not exported.
+	NotExported bool
+
+	// Value is the value of the field. If nil, the field is only declared,
+	// with its annotations.
+	Value Node
+}
+
+type recordNode []Field
+
+// Record is a record literal, with the fields in the order given. Records
+// are written with one field per line, and fields with several annotations
+// with one annotation per line.
+func Record(fields ...Field) Node {
+	return recordNode(fields)
+}
+
+func (n recordNode) format(indent string) (string, error) {
+	if len(n) == 0 {
+		return "{}", nil
+	}
+
+	inner := indent + indentStep
+	var b strings.Builder
+	b.WriteString("{\n")
+	for _, field := range n {
+		src, err := field.format(inner)
+		if err != nil {
+			return "", fmt.Errorf("field %s: %w", nickel.QuoteIdent(field.Name), err)
+		}
+		b.WriteString(inner + src + ",\n")
+	}
+	b.WriteString(indent + "}")
+	return b.String(), nil
+}
+
+func (f Field) format(indent string) (string, error) {
+	annotationIndent := indent + indentStep
+	var annotations []string
+	for _, contract := range f.Contracts {
+		src, err := contract.format(annotationIndent)
+		if err != nil {
+			return "", err
+		}
+		annotations = append(annotations, src)
+	}
+	if f.Doc != "" {
+		annotations = append(annotations, "doc "+quote(f.Doc, annotationIndent))
+	}
+	if f.Optional {
+		annotations = append(annotations, "optional")
+	}
+	switch f.Priority {
+	case nickel.PriorityNormal:
+	case nickel.PriorityDefault:
+		annotations = append(annotations, "default")
+	case nickel.PriorityForce:
+		annotations = append(annotations, "force")
+	default:
+		annotations = append(annotations, "priority "+strconv.Itoa(int(f.Priority)))
+	}
+	if f.NotExported {
+		annotations = append(annotations, "not_exported")
+	}
+
+	// A single short annotation stays on the line of the field name.
+	oneLine := len(annotations) <= 1 && f.Doc == ""
+
+	var b strings.Builder
+	b.WriteString(nickel.QuoteIdent(f.Name))
+	if oneLine {
+		for _, annotation := range annotations {
+			b.WriteString(" | " + annotation)
+		}
+	} else {
+		for _, annotation := range annotations {
+			b.WriteString("\n" + annotationIndent + "| " + annotation)
+		}
+	}
+	if f.Value != nil {
+		valueIndent := indent
+		if oneLine {
+			b.WriteString(" = ")
+		} else {
+			valueIndent = annotationIndent
+			b.WriteString("\n" + annotationIndent + "= ")
+		}
+		value, err := f.Value.format(valueIndent)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(value)
+	}
+	return b.String(), nil
+}
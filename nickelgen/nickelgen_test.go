@@ -0,0 +1,151 @@
+package nickelgen
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nickel-lang/go-nickel"
+)
+
+func TestFormat(t *testing.T) {
+	program := Let("lib", Import("lib.ncl"),
+		Let("defaults", Record(Field{Name: "replicas", Value: Value(1)}),
+			Apply(Raw("std.record.update"), String("replicas"), Var("defaults", "replicas"), Record(
+				Field{
+					Name:      "port",
+					Doc:       "The port to listen on.",
+					Contracts: []Node{Var("lib", "Port")},
+					Priority:  nickel.PriorityDefault,
+					Value:     Value(8080),
+				},
+				Field{
+					Name:      "name",
+					Contracts: []Node{Raw("String")},
+					Value:     String("web"),
+				},
+				Field{
+					Name:  "description",
+					Doc:   "What the service does.\n\nUse %{...} and \"quotes\" freely.",
+					Value: String("Serves\n  the %{site}\nfrontend"),
+				},
+				Field{Name: "labels", Value: Value(map[string]any{"app.kubernetes.io/name": "web", "tier": nil})},
+				Field{Name: "ports", Value: Value([]int{80, -443})},
+				Field{Name: "offset", Value: Apply(Raw("std.number.abs"), Value(-1))},
+				Field{Name: "secret", NotExported: true, Value: String("hunter2")},
+				Field{Name: "if", Optional: true, Contracts: []Node{Raw("Number")}},
+				Field{Name: "weight", Priority: 5, Value: Value(0.5)},
+			)),
+		),
+	)
+
+	src, err := Format(program)
+	if err != nil {
+		t.Fatalf("format error: %v", err)
+	}
+	expected := `let lib = import "lib.ncl" in
+let defaults = {
+  replicas = 1,
+} in
+std.record.update "replicas" defaults.replicas {
+  port
+    | lib.Port
+    | doc "The port to listen on."
+    | default
+    = 8080,
+  name | String = "web",
+  description
+    | doc m%%"
+      What the service does.
+
+      Use %{...} and "quotes" freely.
+    "%%
+    = m%%"
+      Serves
+        the %{site}
+      frontend
+    "%%,
+  labels = {
+    "app.kubernetes.io/name" = "web",
+    tier = null,
+  },
+  ports = [80, -443],
+  offset = std.number.abs (-1),
+  secret | not_exported = "hunter2",
+  "if"
+    | Number
+    | optional,
+  weight | priority 5 = 0.5,
+}
+`
+	if src != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, src)
+	}
+
+	dir := t.TempDir()
+	lib := "{ Port = std.contract.from_predicate (fun p => std.is_number p && p > 0 && p < 65536) }"
+	if err := os.WriteFile(filepath.Join(dir, "lib.ncl"), []byte(lib), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	main := filepath.Join(dir, "main.ncl")
+	if err := os.WriteFile(main, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expr, err := nickel.NewContext().EvalFile(main)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	var got struct {
+		Port        int
+		Name        string
+		Description string
+		Labels      map[string]any
+		Ports       []int
+		Offset      int
+		Secret      string
+		Replicas    int
+	}
+	if err := expr.ConvertTo(&got); err != nil {
+		t.Fatalf("convert error: %v", err)
+	}
+	if got.Port != 8080 || got.Replicas != 1 || got.Offset != 1 || got.Description != "Serves\n  the %{site}\nfrontend" || got.Labels["app.kubernetes.io/name"] != "web" {
+		t.Fatalf("unexpected value: %+v", got)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	ctx := nickel.NewContext()
+	for _, s := range []string{
+		"one line",
+		"two\nlines",
+		"  indented\n  lines",
+		"trailing \nspace",
+		"\nleading newline",
+		"trailing newline\n",
+		"%%{\n\"%%%",
+		"tab\n\tbed",
+		"windows\r\nline",
+	} {
+		src := quote(s, "    ")
+		expr, err := ctx.EvalDeep(src)
+		if err != nil {
+			t.Fatalf("%q: eval error: %v\n%s", s, err, src)
+		}
+		if got, _ := expr.ToString(); got != s {
+			t.Errorf("%q: round-tripped to %q through\n%s", s, got, src)
+		}
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	for _, node := range []Node{
+		Var("not a name"),
+		Let("in", Value(1), Var("in")),
+		Record(Field{Name: "f", Value: Value(func() {})}),
+		Array(Value(make(chan int))),
+	} {
+		if _, err := Format(node); err == nil {
+			t.Errorf("%#v: expected an error", node)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// Package nickelhttp serves evaluated Nickel configurations over HTTP.
+package nickelhttp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nickel-lang/go-nickel"
+)
+
+// Handler is an http.Handler that evaluates a Nickel program on every request
+// and responds with the result, serialized as JSON or YAML.
+//
+// The serialization format is chosen from the request's Accept header, with
+// JSON as the default. Responses carry an ETag derived from the response
+// body, and conditional requests with a matching If-None-Match header get
+// a 304 Not Modified response.
+//
+// If AllowOverride is set, query parameters override fields of the evaluated
+// configuration: a request for `?server.port=8080` merges the program with
+// `{ server.port | force = 8080 }`. Override values that parse as JSON
+// scalars (numbers, booleans, null, or quoted strings) are used as such, and
+// other values are used as strings.
+type Handler struct {
+	// Program returns the Nickel source to evaluate for a request.
+	Program func(r *http.Request) (string, error)
+
+	// Context is the context used for evaluation. If nil, the package-level
+	// default context is used (see nickel.DefaultContext).
+	Context *nickel.Context
+
+	// AllowOverride reports whether a query parameter may override the field
+	// at the given dotted path. If nil, query parameters are ignored.
+	AllowOverride func(path string) bool
+
+	// Export holds options applied to every response, such as masks for
+	// secrets. Its Format is ignored in favor of content negotiation.
+	Export nickel.ExportOptions
+}
+
+// NewHandler returns a Handler that always evaluates src, with no overrides.
+func NewHandler(src string) *Handler {
+	return &Handler{
+		Program: func(*http.Request) (string, error) { return src, nil },
+	}
+}
+
+// The media types that we know how to produce, in order of preference.
+var mediaTypes = []string{"application/json", "application/yaml"}
+
+// yamlAliases are the other names that clients use for YAML.
+var yamlAliases = []string{"application/x-yaml", "text/yaml", "text/x-yaml"}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+
+	mediaType := negotiate(r.Header.Get("Accept"))
+	if mediaType == "" {
+		http.Error(w, "supported media types: "+strings.Join(mediaTypes, ", "), http.StatusNotAcceptable)
+		return
+	}
+
+	src, err := h.Program(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	src, err = h.applyOverrides(src, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := h.Context
+	if ctx == nil {
+		ctx = nickel.DefaultContext()
+	}
+	expr, err := ctx.EvalDeep(src)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	opts := h.Export
+	opts.Format = nickel.ExportJSON
+	if mediaType == "application/yaml" {
+		opts.Format = nickel.ExportYAML
+	}
+	body, err := expr.Export(opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	if matchesETag(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
+
+// applyOverrides merges the allowed query parameters into the program.
+func (h *Handler) applyOverrides(src string, r *http.Request) (string, error) {
+	if h.AllowOverride == nil {
+		return src, nil
+	}
+
+	query := r.URL.Query()
+	paths := make([]string, 0, len(query))
+	for path := range query {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	var overrides []string
+	for _, path := range paths {
+		if !h.AllowOverride(path) {
+			continue
+		}
+		values := query[path]
+		if len(values) != 1 {
+			return "", fmt.Errorf("override %q given %d times", path, len(values))
+		}
+
+		var fields []string
+		for _, field := range strings.Split(path, ".") {
+			if field == "" {
+				return "", fmt.Errorf("invalid override path %q", path)
+			}
+			fields = append(fields, nickel.QuoteString(field))
+		}
+		overrides = append(overrides, strings.Join(fields, ".")+" | force = "+overrideValue(values[0]))
+	}
+
+	if len(overrides) == 0 {
+		return src, nil
+	}
+	// The newline protects against the program ending with a comment.
+	return "(" + src + "\n) & { " + strings.Join(overrides, ", ") + " }", nil
+}
+
+// overrideValue converts a query parameter value into Nickel source.
+func overrideValue(value string) string {
+	var scalar any
+	if err := json.Unmarshal([]byte(value), &scalar); err == nil {
+		switch scalar := scalar.(type) {
+		case nil:
+			return "null"
+		case bool:
+			return strconv.FormatBool(scalar)
+		case float64:
+			// Use the original text, which may be more precise than a float64.
+			return "(" + strings.TrimSpace(value) + ")"
+		case string:
+			return nickel.QuoteString(scalar)
+		}
+	}
+	return nickel.QuoteString(value)
+}
+
+// negotiate picks the media type to respond with, or returns "" if the
+// client doesn't accept any that we support.
+func negotiate(accept string) string {
+	if strings.TrimSpace(accept) == "" {
+		return mediaTypes[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qs, 64); err != nil {
+				continue
+			}
+		}
+		if slices.Contains(yamlAliases, mediaType) {
+			mediaType = "application/yaml"
+		}
+
+		for _, supported := range mediaTypes {
+			if matchesMediaType(mediaType, supported) && q > bestQ {
+				best, bestQ = supported, q
+			}
+		}
+	}
+	return best
+}
+
+func matchesMediaType(pattern string, mediaType string) bool {
+	if pattern == "*/*" || pattern == mediaType {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(pattern, "/*")
+	return ok && strings.HasPrefix(mediaType, prefix+"/")
+}
+
+func matchesETag(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package nickelhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const program = `{ server = { port | default = 80, name = "srv" } }`
+
+func get(t *testing.T, h http.Handler, url string, header map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestContentNegotiation(t *testing.T) {
+	h := NewHandler(program)
+
+	rec := get(t, h, "/", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response: %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), `"port": 80`) {
+		t.Fatalf("unexpected body: %s", rec.Body)
+	}
+
+	rec = get(t, h, "/", map[string]string{"Accept": "application/json;q=0.5, text/yaml"})
+	if rec.Header().Get("Content-Type") != "application/yaml" {
+		t.Fatalf("expected YAML, got %s", rec.Header().Get("Content-Type"))
+	}
+	if rec.Body.String() != "server:\n  name: srv\n  port: 80\n" {
+		t.Fatalf("unexpected body: %q", rec.Body)
+	}
+
+	rec = get(t, h, "/", map[string]string{"Accept": "text/html"})
+	if rec.Code != http.StatusNotAcceptable {
+		t.Fatalf("expected 406, got %d", rec.Code)
+	}
+}
+
+func TestETag(t *testing.T) {
+	h := NewHandler(program)
+
+	rec := get(t, h, "/", nil)
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected an ETag")
+	}
+
+	rec = get(t, h, "/", map[string]string{"If-None-Match": etag})
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatalf("expected 304, got %d", rec.Code)
+	}
+
+	rec = get(t, h, "/", map[string]string{"Accept": "application/yaml", "If-None-Match": etag})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected a different ETag for YAML, got %d", rec.Code)
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	h := NewHandler(program)
+
+	rec := get(t, h, "/?server.port=8080", nil)
+	if !strings.Contains(rec.Body.String(), `"port": 80`) {
+		t.Fatalf("overrides should be ignored by default: %s", rec.Body)
+	}
+
+	h.AllowOverride = func(path string) bool { return strings.HasPrefix(path, "server.") }
+	rec = get(t, h, "/?server.port=8080&server.name=%22%25%7Bx%7D%22&other=1", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `"port": 8080`) || !strings.Contains(body, `"name": "%{x}"`) || strings.Contains(body, "other") {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	rec = get(t, h, "/?server..port=1", nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+}
+
+func TestEvalError(t *testing.T) {
+	rec := get(t, NewHandler("{ port | Number = \"80\" }"), "/", nil)
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "contract broken") {
+		t.Fatalf("unexpected response: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestExportMask(t *testing.T) {
+	h := NewHandler(`{ db = { user = "me", password = "hunter2" } }`)
+	h.Export.Mask([]string{"db.password"}, "REDACTED")
+
+	for _, accept := range []string{"application/json", "application/yaml"} {
+		rec := get(t, h, "/", map[string]string{"Accept": accept})
+		if strings.Contains(rec.Body.String(), "hunter2") || !strings.Contains(rec.Body.String(), "REDACTED") {
+			t.Fatalf("%s: password not masked: %s", accept, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Package nickelmigrate upgrades configurations written for older versions
+// of a schema to the current one, so that programs can keep decoding the
+// configurations of every schema generation they support into one Go type.
+//
+// A configuration holds the version of its schema in a field, and a Migrator
+// has a Migration from each version to the next:
+//
+//	m := nickelmigrate.NewMigrator("version")
+//	m.Register(1, func(expr *nickel.Expr) (*nickel.Expr, error) {
+//		// Version 2 moved the port into a server record.
+//		port, err := expr.EvalFieldDeep("port")
+//		if err != nil {
+//			return nil, err
+//		}
+//		expr, err = expr.Without("port")
+//		if err != nil {
+//			return nil, err
+//		}
+//		return expr.SetPath("server.port", port)
+//	})
+//
+//	expr, err := m.Migrate(config)
+//	...
+//	err = expr.ConvertTo(&cfg)
+package nickelmigrate
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/nickel-lang/go-nickel"
+)
+
+// Migration upgrades a configuration from a version of its schema to the
+// next one. It doesn't need to update the version field, which Migrate
+// does.
+type Migration func(expr *nickel.Expr) (*nickel.Expr, error)
+
+// Migrator upgrades configurations through a sequence of migrations.
+//
+// Its migrations need to be registered before it's used: Migrate can be
+// called concurrently, but not at the same time as Register.
+type Migrator struct {
+	versionField string
+	migrations   map[int]Migration
+}
+
+// NewMigrator returns a Migrator for configurations that hold the version
+// of their schema, an integer, in the field at versionField (a path as
+// accepted by nickel.ParsePath).
+func NewMigrator(versionField string) *Migrator {
+	return &Migrator{versionField: versionField, migrations: map[int]Migration{}}
+}
+
+// Register adds the migration from version from of the schema to version
+// from+1, replacing the one registered before for from, if any.
+func (m *Migrator) Register(from int, fn Migration) {
+	m.migrations[from] = fn
+}
+
+// Current returns the current version of the schema: the one after the
+// last migration. It's zero if no migrations are registered.
+func (m *Migrator) Current() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return slices.Max(slices.Collect(maps.Keys(m.migrations))) + 1
+}
+
+// First returns the oldest version that can be migrated. It's zero if no
+// migrations are registered.
+func (m *Migrator) First() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return slices.Min(slices.Collect(maps.Keys(m.migrations)))
+}
+
+// MigrationError is returned by Migrate when a configuration can't be
+// upgraded.
+type MigrationError struct {
+	// From and To are the versions of the failed migration. To is the
+	// current version if there's no migration from From.
+	From, To int
+
+	// Err is the error from the migration, or an error describing why there
+	// isn't one.
+	Err error
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("migrating configuration from version %d to %d: %v", e.From, e.To, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// Version returns the version of the schema of expr. Configurations
+// without a version field are of the First version, as schemas often only
+// gain a version field in their second generation.
+func (m *Migrator) Version(expr *nickel.Expr) (int, error) {
+	field, err := expr.EvalFieldDeep(m.versionField)
+	if errors.Is(err, nickel.ErrFieldNotFound) {
+		return m.First(), nil
+	} else if err != nil {
+		return 0, err
+	}
+	version, ok := field.ToInt64()
+	if !ok {
+		return 0, fmt.Errorf("version field %s is %s, not an integer", m.versionField, field)
+	}
+	return int(version), nil
+}
+
+// Migrate upgrades expr to the Current version of the schema by applying
+// the migrations from its version on, in order, and setting its version
+// field after each one. It returns expr itself if it's current already.
+//
+// Migrating a configuration that is newer than the Current version, or
+// whose version has no migration, fails with a *MigrationError.
+func (m *Migrator) Migrate(expr *nickel.Expr) (*nickel.Expr, error) {
+	version, err := m.Version(expr)
+	if err != nil {
+		return nil, err
+	}
+	current := m.Current()
+	if version > current {
+		return nil, &MigrationError{From: version, To: current, Err: errors.New("the version is newer than the current one")}
+	}
+
+	for ; version < current; version++ {
+		fn, ok := m.migrations[version]
+		if !ok {
+			return nil, &MigrationError{From: version, To: current, Err: errors.New("no migration is registered for the version")}
+		}
+		migrated, err := fn(expr)
+		if err == nil {
+			migrated, err = migrated.SetPath(m.versionField, version+1)
+		}
+		if err != nil {
+			return nil, &MigrationError{From: version, To: version + 1, Err: err}
+		}
+		expr = migrated
+	}
+	return expr, nil
+}
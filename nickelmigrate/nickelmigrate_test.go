@@ -0,0 +1,109 @@
+package nickelmigrate
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nickel-lang/go-nickel"
+)
+
+// newMigrator returns a migrator for a schema with three generations: the
+// first has no version field and a top-level port, the second moves the
+// port into a server record, and the third renames server to listen.
+func newMigrator() *Migrator {
+	m := NewMigrator("version")
+	m.Register(1, func(expr *nickel.Expr) (*nickel.Expr, error) {
+		port, err := expr.EvalFieldDeep("port")
+		if err != nil {
+			return nil, err
+		}
+		expr, err = expr.Without("port")
+		if err != nil {
+			return nil, err
+		}
+		return expr.SetPath("server.port", port)
+	})
+	m.Register(2, func(expr *nickel.Expr) (*nickel.Expr, error) {
+		server, err := expr.EvalFieldDeep("server")
+		if err != nil {
+			return nil, err
+		}
+		expr, err = expr.Without("server")
+		if err != nil {
+			return nil, err
+		}
+		return expr.SetPath("listen", server)
+	})
+	return m
+}
+
+type config struct {
+	Version int
+	Name    string
+	Listen  struct {
+		Port int
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	m := newMigrator()
+	if m.First() != 1 || m.Current() != 3 {
+		t.Errorf("expected versions 1 to 3, got %d to %d", m.First(), m.Current())
+	}
+
+	want := config{Version: 3, Name: "srv"}
+	want.Listen.Port = 80
+	for _, src := range []string{
+		`{ name = "srv", port = 80 }`,
+		`{ version = 2, name = "srv", server.port = 80 }`,
+		`{ version = 3, name = "srv", listen.port = 80 }`,
+	} {
+		expr, err := nickel.EvalDeep(src)
+		if err != nil {
+			t.Fatalf("eval error: %v", err)
+		}
+		migrated, err := m.Migrate(expr)
+		if err != nil {
+			t.Errorf("%s: migrate error: %v", src, err)
+			continue
+		}
+		var got config
+		if err := migrated.ConvertTo(&got); err != nil {
+			t.Errorf("%s: convert error: %v", src, err)
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %+v, got %+v", src, want, got)
+		}
+	}
+}
+
+func TestMigrateErrors(t *testing.T) {
+	m := newMigrator()
+
+	var migrationErr *MigrationError
+	expr, _ := nickel.EvalDeep(`{ version = 4 }`)
+	if _, err := m.Migrate(expr); !errors.As(err, &migrationErr) || migrationErr.From != 4 {
+		t.Errorf("expected a migration error from version 4, got %v", err)
+	}
+
+	// This version 2 configuration has no server record to move.
+	expr, _ = nickel.EvalDeep(`{ version = 2, port = 80 }`)
+	_, err := m.Migrate(expr)
+	if !errors.As(err, &migrationErr) || migrationErr.From != 2 || migrationErr.To != 3 {
+		t.Errorf("expected a migration error from version 2 to 3, got %v", err)
+	}
+	if !errors.Is(err, nickel.ErrFieldNotFound) {
+		t.Errorf("expected the migration's error to be wrapped, got %v", err)
+	}
+
+	expr, _ = nickel.EvalDeep(`{ version = 0 }`)
+	if _, err := m.Migrate(expr); !errors.As(err, &migrationErr) || migrationErr.From != 0 {
+		t.Errorf("expected a migration error from version 0, got %v", err)
+	}
+
+	expr, _ = nickel.EvalDeep(`{ version = "2" }`)
+	if _, err := m.Migrate(expr); err == nil || !strings.Contains(err.Error(), "not an integer") {
+		t.Errorf("expected an error for a string version, got %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+// Package nickelrender formats evaluated Nickel values for terminals, as
+// trees or as tables, for commands that show configurations to people.
+//
+// Rendering doesn't evaluate anything: the unevaluated parts of shallowly
+// evaluated values are shown as <lazy>. Scalars are shown like Expr.String
+// shows them, so long strings are truncated.
+package nickelrender
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nickel-lang/go-nickel"
+)
+
+// Options customize rendering.
+type Options struct {
+	// MaxDepth bounds the nesting of the values shown, with deeper values
+	// elided. Zero means no limit.
+	MaxDepth int
+
+	// Types adds the kind of every value, like "number" or "record".
+	Types bool
+}
+
+// Tree writes expr to w as a tree, with one line per value:
+//
+//	├── name: "srv"
+//	└── db
+//	    ├── host: <lazy>
+//	    └── replicas
+//	        ├── [0]: 1
+//	        └── [1]: 2
+//
+// Record fields are sorted by name.
+func Tree(w io.Writer, expr *nickel.Expr, opts Options) error {
+	r := renderer{opts: opts}
+	r.tree(expr, "", 0)
+	_, err := io.WriteString(w, r.b.String())
+	return err
+}
+
+// Table writes expr to w as a table with a row per value that has no parts
+// shown (like numbers, strings, enums, and unevaluated values), with the path
+// of the value and the value in aligned columns:
+//
+//	PATH             VALUE
+//	db.host          <lazy>
+//	db.replicas.0    1
+//	name             "srv"
+//
+// With Options.Types, a column with the kind of the values comes between the
+// two.
+func Table(w io.Writer, expr *nickel.Expr, opts Options) error {
+	r := renderer{opts: opts}
+	r.rows(expr, nil)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 4, ' ', 0)
+	if opts.Types {
+		fmt.Fprintln(tw, "PATH\tTYPE\tVALUE")
+	} else {
+		fmt.Fprintln(tw, "PATH\tVALUE")
+	}
+	for _, row := range r.table {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+type renderer struct {
+	opts  Options
+	b     strings.Builder
+	table [][]string
+}
+
+// child is a part of a value, with its label.
+type child struct {
+	label string
+	// The name of the field, or the index of the element, in paths.
+	key  string
+	expr *nickel.Expr
+}
+
+// children returns the parts of a record, array or enum variant.
+func children(expr *nickel.Expr) []child {
+	switch expr.Kind() {
+	case nickel.KindRecord:
+		fields, _ := expr.ToRecord()
+		ret := make([]child, 0, len(fields))
+		for _, name := range slices.Sorted(maps.Keys(fields)) {
+			ret = append(ret, child{label: nickel.QuoteIdent(name), key: name, expr: fields[name]})
+		}
+		return ret
+	case nickel.KindArray:
+		elems, _ := expr.ToArray()
+		ret := make([]child, len(elems))
+		for i, elem := range elems {
+			ret[i] = child{label: "[" + strconv.Itoa(i) + "]", key: strconv.Itoa(i), expr: elem}
+		}
+		return ret
+	case nickel.KindEnumVariant:
+		_, payload, _ := expr.ToEnumVariant()
+		return []child{{label: "payload", expr: payload}}
+	default:
+		return nil
+	}
+}
+
+// summary describes a value on its line. It's empty for records and arrays
+// with parts, which have lines of their own, and only has the tag of enum
+// variants.
+func summary(expr *nickel.Expr) string {
+	switch {
+	case expr == nil:
+		return "<undefined>"
+	case expr.Kind() == nickel.KindThunk:
+		return "<lazy>"
+	case expr.Kind() == nickel.KindRecord && expr.Len() == 0:
+		return "{}"
+	case expr.Kind() == nickel.KindArray && expr.Len() == 0:
+		return "[]"
+	case expr.Kind() == nickel.KindEnumVariant:
+		tag, _, _ := expr.ToEnumVariant()
+		return "'" + nickel.QuoteIdent(tag)
+	case expr.Kind() == nickel.KindRecord || expr.Kind() == nickel.KindArray:
+		return ""
+	default:
+		return expr.String()
+	}
+}
+
+func kind(expr *nickel.Expr) string {
+	switch {
+	case expr == nil:
+		return "undefined"
+	case expr.Kind() == nickel.KindThunk:
+		return "lazy"
+	default:
+		return expr.Kind().String()
+	}
+}
+
+func (r *renderer) elided(depth int) bool {
+	return r.opts.MaxDepth > 0 && depth >= r.opts.MaxDepth
+}
+
+func (r *renderer) tree(expr *nickel.Expr, prefix string, depth int) {
+	var parts []child
+	if expr != nil {
+		parts = children(expr)
+	}
+	if len(parts) > 0 && r.elided(depth) {
+		r.b.WriteString(prefix + "└── ...\n")
+		return
+	}
+	for i, part := range parts {
+		branch, indent := "├── ", "│   "
+		if i == len(parts)-1 {
+			branch, indent = "└── ", "    "
+		}
+		line := part.label
+		if s := summary(part.expr); s != "" {
+			line += ": " + s
+		}
+		if r.opts.Types {
+			line += " (" + kind(part.expr) + ")"
+		}
+		r.b.WriteString(prefix + branch + line + "\n")
+		r.tree(part.expr, prefix+indent, depth+1)
+	}
+}
+
+func (r *renderer) rows(expr *nickel.Expr, path []string) {
+	// Enum variants get a row of their own, to show their tag.
+	var parts []child
+	if expr != nil && expr.Kind() != nickel.KindEnumVariant {
+		parts = children(expr)
+	}
+	if len(parts) == 0 || r.elided(len(path)) {
+		value := summary(expr)
+		if len(parts) > 0 {
+			value = "..."
+		} else if expr != nil && expr.Kind() == nickel.KindEnumVariant {
+			value = expr.String()
+		}
+		row := []string{nickel.FormatPath(path)}
+		if len(path) == 0 {
+			row[0] = "."
+		}
+		if r.opts.Types {
+			row = append(row, kind(expr))
+		}
+		r.table = append(r.table, append(row, value))
+		return
+	}
+	for _, part := range parts {
+		r.rows(part.expr, append(path[:len(path):len(path)], part.key))
+	}
+}
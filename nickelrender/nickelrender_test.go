@@ -0,0 +1,96 @@
+package nickelrender
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nickel-lang/go-nickel"
+)
+
+const src = `{
+	name = "srv",
+	db = { host = "db." ++ "local", replicas = [1, 2], opts = {} },
+	mode = 'Fast,
+	level = 'Some 3,
+}`
+
+func TestTree(t *testing.T) {
+	expr, err := nickel.EvalDeep(src)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	var b strings.Builder
+	if err := Tree(&b, expr, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	expected := `├── db
+│   ├── host: "db.local"
+│   ├── opts: {}
+│   └── replicas
+│       ├── [0]: 1
+│       └── [1]: 2
+├── level: 'Some
+│   └── payload: 3
+├── mode: 'Fast
+└── name: "srv"
+`
+	if b.String() != expected {
+		t.Errorf("unexpected tree:\n%s", b.String())
+	}
+
+	// Shallow values show their laziness.
+	ctx := nickel.NewContext()
+	expr, err = ctx.EvalShallow(src)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	b.Reset()
+	if err := Tree(&b, expr, Options{Types: true}); err != nil {
+		t.Fatal(err)
+	}
+	expected = `├── db: <lazy> (lazy)
+├── level: <lazy> (lazy)
+├── mode: 'Fast (enum tag)
+└── name: <lazy> (lazy)
+`
+	if b.String() != expected {
+		t.Errorf("unexpected tree:\n%s", b.String())
+	}
+}
+
+func TestTable(t *testing.T) {
+	expr, err := nickel.EvalDeep(src)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	var b strings.Builder
+	if err := Table(&b, expr, Options{Types: true}); err != nil {
+		t.Fatal(err)
+	}
+	expected := `PATH             TYPE            VALUE
+db.host          string          "db.local"
+db.opts          record          {}
+db.replicas.0    number          1
+db.replicas.1    number          2
+level            enum variant    'Some 3
+mode             enum tag        'Fast
+name             string          "srv"
+`
+	if b.String() != expected {
+		t.Errorf("unexpected table:\n%s", b.String())
+	}
+
+	b.Reset()
+	if err := Table(&b, expr, Options{MaxDepth: 1}); err != nil {
+		t.Fatal(err)
+	}
+	expected = `PATH     VALUE
+db       ...
+level    'Some 3
+mode     'Fast
+name     "srv"
+`
+	if b.String() != expected {
+		t.Errorf("unexpected table:\n%s", b.String())
+	}
+}
@@ -0,0 +1,193 @@
+// Package nickelrpc exposes Nickel evaluation as a JSON-RPC service.
+//
+// The service is built on net/rpc and net/rpc/jsonrpc, so any JSON-RPC 1.0
+// client can use it. Methods are registered under the name "Nickel":
+//
+//	Nickel.Evaluate  {"Source": "...", "Format": "json"}
+//	Nickel.Query     {"Source": "...", "Path": "server.port", "Format": "yaml"}
+//
+// Both reply with {"Output": "..."}, the serialized result.
+//
+// By default, the service is meant for untrusted callers: each request is
+// evaluated in a sandboxed context of its own, with a timeout and limits on
+// the size of the result, and errors are reported to the caller without the
+// source snippets and notes of Nickel's error messages. Trusted setups can
+// opt out by setting Service.Context and Service.DetailedErrors.
+package nickelrpc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"strings"
+	"time"
+
+	"github.com/nickel-lang/go-nickel"
+)
+
+// ErrSourceTooLarge is returned when a request's source exceeds the
+// service's MaxSourceBytes.
+var ErrSourceTooLarge = errors.New("nickelrpc: source too large")
+
+// Service implements the RPC methods.
+type Service struct {
+	// Context is the context used for evaluation. If nil, each request is
+	// evaluated in a new context, in sandbox mode, with an evaluation
+	// timeout of DefaultTimeout and the size limits in DefaultSizeLimits.
+	//
+	// A Context that is set is used as is, so it's up to its creator to
+	// configure it for the programs it evaluates (see
+	// nickel.Context.SetSandbox). Setting it to nickel.DefaultContext()
+	// evaluates requests without any of these protections.
+	Context *nickel.Context
+
+	// MaxSourceBytes limits the size of the source in a request. Zero means
+	// no limit. Setting a limit is recommended for services that accept
+	// untrusted programs (see nickel.Context.EvalDeep).
+	MaxSourceBytes int
+
+	// Export holds options applied to every reply, such as masks for
+	// secrets. Its Format is ignored in favor of the one in the request.
+	// Paths are relative to the returned value, which for Query is the
+	// value at the queried path.
+	Export nickel.ExportOptions
+
+	// DetailedErrors makes the service reply with Nickel's full error
+	// messages, which quote the program and may name files of the server.
+	// By default, only the first line of a Nickel error is returned.
+	DetailedErrors bool
+}
+
+// DefaultTimeout is the evaluation timeout of the contexts created for
+// requests when Service.Context is nil.
+var DefaultTimeout = 10 * time.Second
+
+// DefaultSizeLimits are the size limits of the contexts created for
+// requests when Service.Context is nil.
+var DefaultSizeLimits = nickel.SizeLimits{
+	MaxArrayLen:    100_000,
+	MaxStringBytes: 1 << 20,
+	MaxExportBytes: 8 << 20,
+}
+
+// EvalArgs are the arguments to Nickel.Evaluate.
+type EvalArgs struct {
+	// Source is the Nickel program to evaluate.
+	Source string
+	// Format is the output format: "json" (the default) or "yaml".
+	Format string
+}
+
+// QueryArgs are the arguments to Nickel.Query.
+type QueryArgs struct {
+	// Source is the Nickel program to evaluate.
+	Source string
+	// Path is a dotted path of record fields, like "server.port". Only the
+	// value at that path is evaluated and returned.
+	Path string
+	// Format is the output format: "json" (the default) or "yaml".
+	Format string
+}
+
+// Reply is the result of a successful call.
+type Reply struct {
+	Output string
+}
+
+// Evaluate evaluates a program deeply and serializes the result.
+func (s *Service) Evaluate(args EvalArgs, reply *Reply) error {
+	return s.eval(args.Source, "", args.Format, reply)
+}
+
+// Query evaluates the value at a path in a program and serializes it.
+func (s *Service) Query(args QueryArgs, reply *Reply) error {
+	if args.Path == "" {
+		return errors.New("nickelrpc: empty query path")
+	}
+	return s.eval(args.Source, args.Path, args.Format, reply)
+}
+
+func (s *Service) eval(src string, path string, format string, reply *Reply) error {
+	if s.MaxSourceBytes > 0 && len(src) > s.MaxSourceBytes {
+		return fmt.Errorf("%w: %d bytes (limit %d)", ErrSourceTooLarge, len(src), s.MaxSourceBytes)
+	}
+	if format != "" && format != "json" && format != "yaml" {
+		return fmt.Errorf("nickelrpc: unsupported format %q", format)
+	}
+
+	if path != "" {
+		var b strings.Builder
+		// The newline protects against the program ending with a comment.
+		b.WriteString("(" + src + "\n)")
+		for _, field := range strings.Split(path, ".") {
+			if field == "" {
+				return fmt.Errorf("nickelrpc: invalid query path %q", path)
+			}
+			b.WriteString("." + nickel.QuoteString(field))
+		}
+		src = b.String()
+	}
+
+	ctx := s.Context
+	if ctx == nil {
+		// A context per request, so that a request that times out only
+		// keeps its own context busy.
+		ctx = nickel.NewContext()
+		ctx.SetSandbox(true)
+		ctx.SetEvalTimeout(DefaultTimeout)
+		ctx.SetSizeLimits(DefaultSizeLimits)
+	}
+	expr, err := ctx.EvalDeep(src)
+	if err != nil {
+		return s.replyError(err)
+	}
+
+	opts := s.Export
+	opts.Format = nickel.ExportJSON
+	if format == "yaml" {
+		opts.Format = nickel.ExportYAML
+	}
+	out, err := expr.Export(opts)
+	if err != nil {
+		return s.replyError(err)
+	}
+	reply.Output = string(out)
+	return nil
+}
+
+// replyError returns the error to send to the caller for err.
+func (s *Service) replyError(err error) error {
+	var nickelErr *nickel.Error
+	if s.DetailedErrors || !errors.As(err, &nickelErr) {
+		return err
+	}
+	diags := nickelErr.Diagnostics()
+	if len(diags) == 0 {
+		return errors.New("nickelrpc: evaluation failed")
+	}
+	return fmt.Errorf("nickelrpc: %s", diags[0].Message)
+}
+
+// Register registers the service's methods on server under the name "Nickel".
+func Register(server *rpc.Server, s *Service) error {
+	return server.RegisterName("Nickel", s)
+}
+
+// Serve accepts connections on l and serves JSON-RPC requests on each of
+// them, until l is closed.
+func Serve(l net.Listener, s *Service) error {
+	server := rpc.NewServer()
+	if err := Register(server, s); err != nil {
+		return err
+	}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
@@ -0,0 +1,90 @@
+package nickelrpc
+
+import (
+	"errors"
+	"net"
+	"net/rpc/jsonrpc"
+	"strings"
+	"testing"
+
+	"github.com/nickel-lang/go-nickel"
+)
+
+func TestService(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	defer l.Close()
+	go Serve(l, &Service{MaxSourceBytes: 1000})
+
+	client, err := jsonrpc.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	defer client.Close()
+
+	var reply Reply
+	err = client.Call("Nickel.Evaluate", EvalArgs{Source: "{ a = 1 + 1 }", Format: "yaml"}, &reply)
+	if err != nil {
+		t.Fatalf("call error: %v", err)
+	}
+	if reply.Output != "a: 2\n" {
+		t.Fatalf("unexpected output: %q", reply.Output)
+	}
+
+	// The other field is never evaluated, so its error doesn't matter.
+	err = client.Call("Nickel.Query", QueryArgs{Source: "{ server.port = 80, other = std.fail_with \"no\" }", Path: "server.port"}, &reply)
+	if err != nil {
+		t.Fatalf("call error: %v", err)
+	}
+	if reply.Output != "80" {
+		t.Fatalf("unexpected output: %q", reply.Output)
+	}
+
+	err = client.Call("Nickel.Evaluate", EvalArgs{Source: "{ a | String = 1 }"}, &reply)
+	if err == nil || !strings.Contains(err.Error(), "contract broken") {
+		t.Fatalf("expected a contract error, got %v", err)
+	}
+
+	err = client.Call("Nickel.Evaluate", EvalArgs{Source: strings.Repeat(" ", 1001)}, &reply)
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("expected a size error, got %v", err)
+	}
+}
+
+func TestSourceTooLarge(t *testing.T) {
+	s := &Service{MaxSourceBytes: 3}
+	err := s.Evaluate(EvalArgs{Source: "1234"}, &Reply{})
+	if !errors.Is(err, ErrSourceTooLarge) {
+		t.Fatalf("expected ErrSourceTooLarge, got %v", err)
+	}
+}
+
+func TestDefaultContext(t *testing.T) {
+	s := &Service{}
+	err := s.Evaluate(EvalArgs{Source: `import "/etc/passwd"`}, &Reply{})
+	if !errors.Is(err, nickel.ErrSandboxed) {
+		t.Errorf("expected a sandbox error, got %v", err)
+	}
+
+	err = s.Evaluate(EvalArgs{Source: "std.array.replicate 200000 0"}, &Reply{})
+	if !errors.Is(err, nickel.ErrResultTooLarge) {
+		t.Errorf("expected a size limit error, got %v", err)
+	}
+}
+
+func TestReplyErrors(t *testing.T) {
+	src := "let secret = \"hunter2\" in { a | String = std.string.length secret }"
+	s := &Service{}
+	err := s.Evaluate(EvalArgs{Source: src}, &Reply{})
+	if err == nil || !strings.Contains(err.Error(), "contract broken") || strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("expected a contract error without the source, got %v", err)
+	}
+
+	s.DetailedErrors = true
+	err = s.Evaluate(EvalArgs{Source: src}, &Reply{})
+	if err == nil || !strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("expected a detailed error, got %v", err)
+	}
+}
@@ -0,0 +1,263 @@
+package nickeltest
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nickel-lang/go-nickel"
+)
+
+// MaxDepth bounds the nesting of records and arrays in generated Values.
+const MaxDepth = 4
+
+// Value is a randomly generated Nickel value, for property-based tests.
+//
+// V holds the value in the same form that encoding/json uses when decoding
+// into an `any`: nil, bool, float64, string, []any, or map[string]any. This
+// makes it easy to check round-trip properties, for example that evaluating
+// Source and converting the result with Expr.ConvertTo gives back V.
+//
+// Value implements quick.Generator, so it can be used as an argument of
+// functions passed to testing/quick.Check. Use Minimize to find a small
+// counterexample once a property fails.
+type Value struct {
+	V any
+}
+
+// Generate implements the quick.Generator interface.
+//
+// The size hint bounds the length of generated strings, arrays, and records.
+func (Value) Generate(r *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(Generate(r, size))
+}
+
+// Generate returns a random Value. Top-level containers have at most size
+// elements, nested ones get a smaller budget, and nesting is at most MaxDepth
+// deep.
+func Generate(r *rand.Rand, size int) Value {
+	return Value{V: generate(r, max(size, 1), MaxDepth)}
+}
+
+func generate(r *rand.Rand, size int, depth int) any {
+	kinds := 4
+	if depth > 0 {
+		kinds = 6
+	}
+
+	switch r.Intn(kinds) {
+	case 0:
+		return nil
+	case 1:
+		return r.Intn(2) == 0
+	case 2:
+		return generateNumber(r)
+	case 3:
+		return generateString(r, size)
+	case 4:
+		arr := make([]any, r.Intn(size+1))
+		for i := range arr {
+			arr[i] = generate(r, size/2, depth-1)
+		}
+		return arr
+	default:
+		rec := make(map[string]any)
+		for range r.Intn(size + 1) {
+			rec[generateString(r, size)] = generate(r, size/2, depth-1)
+		}
+		return rec
+	}
+}
+
+func generateNumber(r *rand.Rand) float64 {
+	switch r.Intn(3) {
+	case 0:
+		return float64(r.Intn(2001) - 1000)
+	case 1:
+		return r.NormFloat64() * 1e6
+	default:
+		// Any finite float64, including huge and tiny ones.
+		for {
+			f := math.Float64frombits(r.Uint64())
+			if !math.IsNaN(f) && !math.IsInf(f, 0) {
+				return f
+			}
+		}
+	}
+}
+
+// stringAlphabet contains the characters that are interesting to Nickel's
+// string syntax, plus some multi-byte ones.
+var stringAlphabet = []rune("abcXYZ019 _-'\"\\%{}\n\t\r\x07éλ😀")
+
+func generateString(r *rand.Rand, size int) string {
+	var b strings.Builder
+	for range r.Intn(size + 1) {
+		b.WriteRune(stringAlphabet[r.Intn(len(stringAlphabet))])
+	}
+	return b.String()
+}
+
+// Source renders the value as Nickel source code.
+func (v Value) Source() string {
+	var b strings.Builder
+	writeSource(&b, v.V)
+	return b.String()
+}
+
+func writeSource(b *strings.Builder, v any) {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case float64:
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if v < 0 {
+			s = "(" + s + ")"
+		}
+		b.WriteString(s)
+	case string:
+		b.WriteString(nickel.QuoteString(v))
+	case []any:
+		b.WriteString("[")
+		for i, elt := range v {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeSource(b, elt)
+		}
+		b.WriteString("]")
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		b.WriteString("{")
+		for i, key := range keys {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString(" ")
+			b.WriteString(nickel.QuoteString(key))
+			b.WriteString(" = ")
+			writeSource(b, v[key])
+		}
+		b.WriteString(" }")
+	default:
+		panic("nickeltest: unsupported value type " + reflect.TypeOf(v).String())
+	}
+}
+
+// Shrink returns values that are simpler than v, simplest first.
+func (v Value) Shrink() []Value {
+	var ret []Value
+	for _, s := range shrink(v.V) {
+		ret = append(ret, Value{V: s})
+	}
+	return ret
+}
+
+func shrink(v any) []any {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case bool:
+		if v {
+			return []any{nil, false}
+		}
+		return []any{nil}
+	case float64:
+		ret := []any{nil}
+		if v != 0 {
+			ret = append(ret, 0.0)
+		}
+		if t := math.Trunc(v); t != v && !math.IsInf(t, 0) {
+			ret = append(ret, t)
+		}
+		if h := math.Trunc(v / 2); h != v && h != 0 {
+			ret = append(ret, h)
+		}
+		return ret
+	case string:
+		ret := []any{nil}
+		if v != "" {
+			runes := []rune(v)
+			ret = append(ret, "", string(runes[:len(runes)/2]), string(runes[1:]))
+		}
+		return ret
+	case []any:
+		ret := []any{nil}
+		ret = append(ret, v...)
+		for i := range v {
+			ret = append(ret, slices.Delete(slices.Clone(v), i, i+1))
+		}
+		for i, elt := range v {
+			for _, s := range shrink(elt) {
+				arr := slices.Clone(v)
+				arr[i] = s
+				ret = append(ret, arr)
+			}
+		}
+		return ret
+	case map[string]any:
+		ret := []any{nil}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		for _, key := range keys {
+			ret = append(ret, v[key])
+		}
+		for _, key := range keys {
+			rec := cloneRecord(v)
+			delete(rec, key)
+			ret = append(ret, rec)
+		}
+		for _, key := range keys {
+			for _, s := range shrink(v[key]) {
+				rec := cloneRecord(v)
+				rec[key] = s
+				ret = append(ret, rec)
+			}
+		}
+		return ret
+	default:
+		return nil
+	}
+}
+
+func cloneRecord(rec map[string]any) map[string]any {
+	ret := make(map[string]any, len(rec))
+	for key, value := range rec {
+		ret[key] = value
+	}
+	return ret
+}
+
+// Minimize shrinks a failing value to a locally minimal one.
+//
+// It repeatedly replaces v by the first of its shrinks (see Value.Shrink)
+// for which fails returns true, until no shrink fails.
+func Minimize(v Value, fails func(Value) bool) Value {
+	for {
+		progress := false
+		for _, s := range v.Shrink() {
+			if fails(s) {
+				v = s
+				progress = true
+				break
+			}
+		}
+		if !progress {
+			return v
+		}
+	}
+}
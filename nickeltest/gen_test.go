@@ -0,0 +1,64 @@
+package nickeltest
+
+import (
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/nickel-lang/go-nickel"
+)
+
+func TestRoundTrip(t *testing.T) {
+	ctx := nickel.NewContext()
+	roundTrips := func(v Value) bool {
+		expr, err := ctx.EvalDeep(v.Source())
+		if err != nil {
+			t.Logf("eval error for %s: %v", v.Source(), err)
+			return false
+		}
+		var got any
+		if err := expr.ConvertTo(&got); err != nil {
+			t.Logf("convert error for %s: %v", v.Source(), err)
+			return false
+		}
+		return reflect.DeepEqual(got, v.V)
+	}
+
+	err := quick.Check(roundTrips, &quick.Config{MaxCount: 200})
+	if err != nil {
+		failing := err.(*quick.CheckError).In[0].(Value)
+		t.Fatalf("round trip failed; minimal input: %s", Minimize(failing, func(v Value) bool { return !roundTrips(v) }).Source())
+	}
+}
+
+func TestMinimize(t *testing.T) {
+	v := Value{V: map[string]any{"a": []any{1.0, "bad", true}, "b": 2.5}}
+	containsBad := func(v Value) bool {
+		return findString(v.V, "bad")
+	}
+
+	minimal := Minimize(v, containsBad)
+	if minimal.Source() != `"bad"` {
+		t.Fatalf("unexpected minimal value %s", minimal.Source())
+	}
+}
+
+func findString(v any, s string) bool {
+	switch v := v.(type) {
+	case string:
+		return v == s
+	case []any:
+		for _, elt := range v {
+			if findString(elt, s) {
+				return true
+			}
+		}
+	case map[string]any:
+		for _, elt := range v {
+			if findString(elt, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
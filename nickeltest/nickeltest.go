@@ -0,0 +1,119 @@
+// Package nickeltest provides helpers for testing code that evaluates Nickel
+// configurations.
+package nickeltest
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nickel-lang/go-nickel"
+)
+
+var update = flag.Bool("nickeltest.update", false, "rewrite golden files instead of comparing against them")
+
+// MustEval evaluates src deeply in a new context, failing the test if
+// evaluation fails.
+func MustEval(t testing.TB, src string) *nickel.Expr {
+	t.Helper()
+	return MustEvalIn(t, nickel.NewContext(), src)
+}
+
+// MustEvalIn is like MustEval, but evaluates src in ctx, for programs that
+// need its globals, registered sources, or other settings.
+func MustEvalIn(t testing.TB, ctx *nickel.Context, src string) *nickel.Expr {
+	t.Helper()
+
+	expr, err := ctx.EvalDeep(src)
+	if err != nil {
+		t.Fatalf("failed to evaluate Nickel source: %v", err)
+	}
+	return expr
+}
+
+// AssertEval evaluates src deeply and checks that the result is equal to want.
+//
+// The comparison is done on the JSON forms of the evaluated expression and
+// of want, so want can be anything that encoding/json can marshal: a struct
+// with json tags, a map[string]any, a number, and so on.
+func AssertEval(t testing.TB, src string, want any) {
+	t.Helper()
+	AssertEvalIn(t, nickel.NewContext(), src, want)
+}
+
+// AssertEvalIn is like AssertEval, but evaluates src in ctx (see MustEvalIn).
+func AssertEvalIn(t testing.TB, ctx *nickel.Context, src string, want any) {
+	t.Helper()
+
+	expr := MustEvalIn(t, ctx, src)
+	got, err := expr.MarshalJSON()
+	if err != nil {
+		t.Fatalf("failed to convert result to JSON: %v", err)
+	}
+	expected, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("failed to convert expected value to JSON: %v", err)
+	}
+
+	var gotValue, expectedValue any
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatalf("failed to parse result JSON: %v", err)
+	}
+	if err := json.Unmarshal(expected, &expectedValue); err != nil {
+		t.Fatalf("failed to parse expected JSON: %v", err)
+	}
+	if !reflect.DeepEqual(gotValue, expectedValue) {
+		t.Errorf("unexpected evaluation result\n got: %s\nwant: %s", normalize(got), normalize(expected))
+	}
+}
+
+// AssertGolden checks that the JSON export of expr matches the contents of the
+// file at path.
+//
+// Both sides are normalized to canonical JSON (see nickel.CanonicalizeJSON)
+// before comparing, so the golden file can be formatted however you like,
+// and changes in how Nickel formats its output don't break the comparison.
+// Running the tests with
+// the -nickeltest.update flag writes the export to the golden file instead.
+func AssertGolden(t testing.TB, expr *nickel.Expr, path string) {
+	t.Helper()
+
+	got, err := expr.Export(nickel.ExportOptions{Canonical: true})
+	if err != nil {
+		t.Fatalf("failed to convert result to JSON: %v", err)
+	}
+	got = normalize(got)
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create golden directory: %v", err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("failed to write golden file: %v", err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file (run with -nickeltest.update to create it): %v", err)
+	}
+	expected = normalize(expected)
+	if !bytes.Equal(got, expected) {
+		t.Errorf("export doesn't match golden file %s\n got: %s\nwant: %s", path, got, expected)
+	}
+}
+
+// normalize canonicalizes JSON data, leaving it unchanged if it isn't valid
+// JSON.
+func normalize(data []byte) []byte {
+	canonical, err := nickel.CanonicalizeJSON(data)
+	if err != nil {
+		return data
+	}
+	return append(canonical, '\n')
+}
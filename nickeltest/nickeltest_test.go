@@ -0,0 +1,66 @@
+package nickeltest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nickel-lang/go-nickel"
+)
+
+// recordingTB captures failures instead of failing the test.
+type recordingTB struct {
+	testing.TB
+	failed bool
+	msg    string
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Errorf(format string, args ...any) {
+	r.failed = true
+	r.msg = fmt.Sprintf(format, args...)
+}
+
+func (r *recordingTB) Fatalf(format string, args ...any) {
+	r.Errorf(format, args...)
+}
+
+func TestAssertEval(t *testing.T) {
+	AssertEval(t, "{ port = 80, name = \"srv\" }", map[string]any{"port": 80, "name": "srv"})
+
+	type config struct {
+		Port int `json:"port"`
+	}
+	AssertEval(t, "{ port = 79 + 1 }", config{Port: 80})
+
+	rec := &recordingTB{TB: t}
+	AssertEval(rec, "{ port = 81 }", config{Port: 80})
+	if !rec.failed {
+		t.Fatal("expected a mismatch")
+	}
+}
+
+func TestAssertEvalIn(t *testing.T) {
+	ctx := nickel.NewContext()
+	if err := ctx.SetGlobals(map[string]any{"port": 80}); err != nil {
+		t.Fatalf("globals error: %v", err)
+	}
+	AssertEvalIn(t, ctx, "{ next = port + 1 }", map[string]any{"next": 81})
+
+	rec := &recordingTB{TB: t}
+	AssertEvalIn(rec, ctx, "{ next = port }", map[string]any{"next": 81})
+	if !rec.failed {
+		t.Fatal("expected a mismatch")
+	}
+}
+
+func TestAssertGolden(t *testing.T) {
+	expr := MustEval(t, "{ b = [1, 2], a = \"x\" }")
+	AssertGolden(t, expr, "testdata/golden.json")
+
+	rec := &recordingTB{TB: t}
+	AssertGolden(rec, MustEval(t, "{ a = \"y\" }"), "testdata/golden.json")
+	if !rec.failed {
+		t.Fatal("expected a mismatch")
+	}
+}
@@ -0,0 +1,116 @@
+package nickel
+
+/*
+#include <nickel_lang.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// ErrInexactNumber is returned (wrapped) when a Nickel number can't be
+// converted to a float64 without rounding, by ToFloat64Exact, or by the
+// conversions of a context in strict numbers mode (see SetStrictNumbers).
+var ErrInexactNumber = errors.New("number can't be represented exactly as a float64")
+
+// ToFloat64Exact is like ToFloat64, but fails with ErrInexactNumber if the
+// conversion rounds the number.
+//
+// Nickel numbers are arbitrary precision fractions, and floats can't
+// represent most decimal fractions exactly. So a conversion counts as exact
+// when the float64 is the number written in the shortest way that
+// identifies it, as by strconv.FormatFloat with precision -1: 0.1 and 1e30
+// convert exactly, but 1/3 and 2^60 + 1 don't. If the expression isn't a
+// number, ToFloat64Exact returns a *KindError.
+func (expr *Expr) ToFloat64Exact() (float64, error) {
+	f, ok := expr.ToFloat64()
+	if !ok {
+		return 0, &KindError{Want: KindNumber, Got: expr.kind}
+	}
+	if !expr.exactFloat(f) {
+		return 0, fmt.Errorf("%w: %s", ErrInexactNumber, expr.rational())
+	}
+	return f, nil
+}
+
+// rational returns the exact value of a number, as an integer or a
+// fraction.
+func (expr *Expr) rational() string {
+	if expr.isI64 {
+		return strconv.FormatInt(expr.i64, 10)
+	}
+	num := allocString()
+	defer freeString(num)
+	den := allocString()
+	defer freeString(den)
+
+	number := C.nickel_expr_as_number(expr.ptr)
+	C.nickel_number_as_rational(number, num, den)
+	n := goString(num)
+	// The Nickel library leaves out the sign of the numerator.
+	if !strings.HasPrefix(n, "-") && math.Signbit(float64(C.nickel_number_as_f64(number))) {
+		n = "-" + n
+	}
+	if d := goString(den); d != "1" {
+		return n + "/" + d
+	}
+	return n
+}
+
+// exactFloat reports whether f, the conversion of the number expr, is exact
+// in the sense of ToFloat64Exact.
+func (expr *Expr) exactFloat(f float64) bool {
+	want, ok := new(big.Rat).SetString(expr.rational())
+	if !ok {
+		return false
+	}
+	// Infinities fail to parse.
+	got, ok := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	return ok && got.Cmp(want) == 0
+}
+
+// SetStrictNumbers turns strict numbers mode on or off.
+//
+// In strict numbers mode, converting an expression with ConvertTo (and so
+// Decode), or serializing it with MarshalJSON or MarshalYAML, fails with
+// ErrInexactNumber if it contains a number that would be rounded, in the
+// sense of ToFloat64Exact. This includes integers beyond 2^53, which
+// encoding/json rounds when decoding into a float64 or an any. Without it,
+// numbers are silently rounded to the nearest float64.
+func (ctx *Context) SetStrictNumbers(enabled bool) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.strictNumbers = enabled
+}
+
+func (ctx *Context) strictNumbersEnabled() bool {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.strictNumbers
+}
+
+// checkNumbers returns an error for the first number in expr that can't be
+// converted exactly, if the context is in strict numbers mode.
+func (expr *Expr) checkNumbers() error {
+	if !expr.ctx.strictNumbersEnabled() {
+		return nil
+	}
+	return Walk(expr, func(path []string, value *Expr) (WalkAction, error) {
+		if value.kind != KindNumber {
+			return WalkContinue, nil
+		}
+		if _, err := value.ToFloat64Exact(); err != nil {
+			if len(path) == 0 {
+				return WalkStop, err
+			}
+			return WalkStop, fmt.Errorf("%s: %w", FormatPath(path), err)
+		}
+		return WalkContinue, nil
+	})
+}
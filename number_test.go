@@ -0,0 +1,87 @@
+package nickel
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestToFloat64Exact(t *testing.T) {
+	tests := []struct {
+		src   string
+		want  float64
+		exact bool
+	}{
+		{"1", 1, true},
+		{"0.1", 0.1, true},
+		{"-2.5", -2.5, true},
+		{"1e30", 1e30, true},
+		{"9007199254740993", 0, false},
+		{"1 / 3", 0, false},
+		{"1e400", 0, false},
+	}
+	for _, test := range tests {
+		expr, err := EvalDeep(test.src)
+		if err != nil {
+			t.Fatalf("%s: eval error: %v", test.src, err)
+		}
+		got, err := expr.ToFloat64Exact()
+		if test.exact && (err != nil || got != test.want) {
+			t.Errorf("%s: got %v, %v, want %v", test.src, got, err, test.want)
+		}
+		if !test.exact && !errors.Is(err, ErrInexactNumber) {
+			t.Errorf("%s: expected ErrInexactNumber, got %v, %v", test.src, got, err)
+		}
+	}
+
+	expr, err := EvalDeep(`"1"`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	var kindErr *KindError
+	if _, err := expr.ToFloat64Exact(); !errors.As(err, &kindErr) {
+		t.Errorf("expected a KindError, got %v", err)
+	}
+}
+
+func TestStrictNumbers(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalDeep("{ cpu = 0.1, memory = { quota = 2 / 3 } }")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	var target map[string]any
+	if err := expr.ConvertTo(&target); err != nil {
+		t.Fatalf("expected rounding outside of strict mode, got %v", err)
+	}
+
+	ctx.SetStrictNumbers(true)
+	err = expr.ConvertTo(&target)
+	if !errors.Is(err, ErrInexactNumber) || err.Error() != "memory.quota: number can't be represented exactly as a float64: 2/3" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := expr.MarshalJSON(); !errors.Is(err, ErrInexactNumber) {
+		t.Errorf("expected MarshalJSON to fail, got %v", err)
+	}
+
+	expr, err = ctx.EvalDeep("{ cpu = 0.1, memory = 1024 }")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if err := expr.ConvertTo(&target); err != nil {
+		t.Errorf("expected exact numbers to convert, got %v", err)
+	}
+}
+
+func TestNegativeFractions(t *testing.T) {
+	expr, err := EvalShallow("{ a = -2.5, b = -1 / 3 }")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	deep, err := expr.EvalDeep()
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got := deep.String(); got != "{ a = -2.5, b = -0.3333333333333333 }" {
+		t.Errorf("unexpected result: %s", got)
+	}
+}
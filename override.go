@@ -0,0 +1,96 @@
+package nickel
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Priority is a Nickel merge priority.
+//
+// Nickel's numeric priorities are Priority values, with PriorityNormal being
+// priority 0. PriorityDefault and PriorityForce are the extremes, below and
+// above all the numeric priorities.
+type Priority int
+
+const (
+	PriorityDefault Priority = math.MinInt
+	PriorityNormal  Priority = 0
+	PriorityForce   Priority = math.MaxInt
+)
+
+// annotation returns the priority annotation for a field, like " | force".
+func (p Priority) annotation() string {
+	switch p {
+	case PriorityDefault:
+		return " | default"
+	case PriorityNormal:
+		return ""
+	case PriorityForce:
+		return " | force"
+	default:
+		return " | priority " + strconv.Itoa(int(p))
+	}
+}
+
+// Override is a value to merge into a Nickel configuration, at a path and
+// with a merge priority. See Context.EvalDeepWithOverrides.
+type Override struct {
+	// Path is the path of the field to set, as accepted by ParsePath.
+	Path string
+	// Value is an *Expr, or anything that encoding/json can marshal.
+	Value any
+	// Priority is the merge priority of the field. The zero value is
+	// PriorityNormal, which makes it an error to override a field that
+	// the configuration already defines with normal priority; pick
+	// PriorityForce to replace it, or PriorityDefault to only provide a
+	// fallback.
+	Priority Priority
+}
+
+// OverrideSource returns the source of a Nickel record containing the
+// overrides, with their priorities, for merging into a program. Overrides of
+// records replace them: the values aren't merged recursively.
+func OverrideSource(overrides ...Override) (string, error) {
+	var b strings.Builder
+	b.WriteString("{")
+	for _, override := range overrides {
+		fields, err := ParsePath(override.Path)
+		if err != nil {
+			return "", err
+		}
+		if len(fields) == 0 {
+			return "", fmt.Errorf("invalid override path %q", override.Path)
+		}
+		valueSrc, err := valueSource(override.Value)
+		if err != nil {
+			return "", fmt.Errorf("override %s: %w", override.Path, err)
+		}
+
+		b.WriteString(" ")
+		for i, field := range fields {
+			if i > 0 {
+				b.WriteString(".")
+			}
+			b.WriteString(QuoteString(field))
+		}
+		b.WriteString(override.Priority.annotation())
+		b.WriteString(" = ")
+		b.WriteString(valueSrc)
+		b.WriteString(",")
+	}
+	b.WriteString(" }")
+	return b.String(), nil
+}
+
+// EvalDeepWithOverrides evaluates a Nickel program deeply, like EvalDeep,
+// after merging the overrides into it (see OverrideSource).
+func (ctx *Context) EvalDeepWithOverrides(src string, overrides ...Override) (*Expr, error) {
+	overrideSrc, err := OverrideSource(overrides...)
+	if err != nil {
+		return nil, err
+	}
+	// The newline protects against the program ending with a comment.
+	return ctx.EvalDeep("(" + src + "\n) & " + overrideSrc)
+}
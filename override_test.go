@@ -0,0 +1,38 @@
+package nickel
+
+import "testing"
+
+func TestEvalDeepWithOverrides(t *testing.T) {
+	ctx := NewContext()
+	src := `{
+		server = { port | default = 80, host = "localhost", tls = { enabled = true, cert = "a.pem" } },
+		replicas | priority 5 = 2,
+		name = "app",
+	}`
+
+	expr, err := ctx.EvalDeepWithOverrides(src,
+		Override{Path: "server.port", Value: 8080},
+		Override{Path: "server.host", Value: "example.com", Priority: PriorityForce},
+		Override{Path: "server.tls", Value: map[string]any{"enabled": false}, Priority: PriorityForce},
+		Override{Path: "replicas", Value: 3, Priority: 10},
+		Override{Path: "name", Value: "ignored", Priority: PriorityDefault},
+		Override{Path: "labels.\"app.kubernetes.io/name\"", Value: "app"},
+	)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	want := `{ labels = { "app.kubernetes.io/name" = "app" }, name = "app", replicas = 3, server = { host = "example.com", port = 8080, tls = { enabled = false } } }`
+	if got := expr.String(); got != want {
+		t.Errorf("unexpected result:\n got %s\nwant %s", got, want)
+	}
+
+	if _, err := ctx.EvalDeepWithOverrides(src, Override{Path: "replicas", Value: 3, Priority: -1}); err != nil {
+		t.Errorf("eval error: %v", err)
+	}
+	if _, err := ctx.EvalDeepWithOverrides(src, Override{Path: "name", Value: "x"}); err == nil {
+		t.Error("expected a merge conflict with normal priority")
+	}
+	if _, err := ctx.EvalDeepWithOverrides(src, Override{Path: "", Value: 1}); err == nil {
+		t.Error("expected an error for an empty path")
+	}
+}
@@ -0,0 +1,247 @@
+package nickel
+
+/*
+#include <nickel_lang.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// ErrFieldNotFound is returned (wrapped) when a path refers to a record field
+// that doesn't exist or has no value.
+var ErrFieldNotFound = errors.New("field not found")
+
+// ParsePath splits a path into field names.
+//
+// A path is a sequence of field names separated by dots, like
+// `outputs.manifest`. Field names that contain dots, quotes, or spaces can be
+// written as quoted strings, as in `labels."app.kubernetes.io/name"`. Inside
+// quotes, `\"`, `\\`, `\n`, `\r`, and `\t` are the supported escapes.
+func ParsePath(path string) ([]string, error) {
+	fields, _, err := parsePath(path)
+	return fields, err
+}
+
+// parsePath implements ParsePath, also reporting which field names were
+// quoted.
+func parsePath(path string) ([]string, []bool, error) {
+	var fields []string
+	var quoted []bool
+	rest := path
+	for {
+		var field string
+		isQuoted := strings.HasPrefix(rest, `"`)
+		if isQuoted {
+			var b strings.Builder
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] != '\\' {
+					b.WriteByte(rest[i])
+					continue
+				}
+				i++
+				if i == len(rest) {
+					break
+				}
+				switch rest[i] {
+				case '"', '\\':
+					b.WriteByte(rest[i])
+				case 'n':
+					b.WriteByte('\n')
+				case 'r':
+					b.WriteByte('\r')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					return nil, nil, fmt.Errorf("invalid path %q: unknown escape sequence \\%c", path, rest[i])
+				}
+			}
+			if i >= len(rest) {
+				return nil, nil, fmt.Errorf("invalid path %q: unterminated quote", path)
+			}
+			field = b.String()
+			rest = rest[i+1:]
+		} else {
+			end := strings.IndexAny(rest, `."`)
+			if end < 0 {
+				end = len(rest)
+			}
+			field = rest[:end]
+			rest = rest[end:]
+			if field == "" {
+				return nil, nil, fmt.Errorf("invalid path %q: empty field name", path)
+			}
+		}
+		fields = append(fields, field)
+		quoted = append(quoted, isQuoted)
+
+		if rest == "" {
+			return fields, quoted, nil
+		}
+		if rest[0] != '.' {
+			return nil, nil, fmt.Errorf("invalid path %q: expected a dot after field %q", path, field)
+		}
+		rest = rest[1:]
+	}
+}
+
+// FormatPath joins field names into a path that ParsePath accepts, quoting
+// them when necessary. Field names containing `*` are quoted too, so that
+// the path can be given to Expr.Match.
+func FormatPath(fields []string) string {
+	var b strings.Builder
+	for i, field := range fields {
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		if field != "" && !strings.ContainsAny(field, ".\"\\ \n\r\t*") {
+			b.WriteString(field)
+			continue
+		}
+
+		b.WriteByte('"')
+		for _, c := range []byte(field) {
+			switch c {
+			case '"', '\\':
+				b.WriteByte('\\')
+				b.WriteByte(c)
+			case '\n':
+				b.WriteString(`\n`)
+			case '\r':
+				b.WriteString(`\r`)
+			case '\t':
+				b.WriteString(`\t`)
+			default:
+				b.WriteByte(c)
+			}
+		}
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+// field looks up a field of a record by name, returning nil if the record
+// has no such field or if the field has no value.
+func (expr *Expr) field(name string) *Expr {
+	if expr.kind != KindRecord || strings.IndexByte(name, 0) >= 0 {
+		return nil
+	}
+
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	value := new_child(expr)
+	if C.nickel_record_value_by_name(C.nickel_expr_as_record(expr.ptr), cname, value.ptr) == 0 {
+		return nil
+	}
+	value.load()
+	value.inherit(expr)
+	value.origin = expr.origin.field(name)
+	return value
+}
+
+// lookup follows a path of fields from expr, evaluating shallowly along the
+// way. The returned expression is the field at the end of the path, which
+// may not have been evaluated yet.
+func (expr *Expr) lookup(fields []string) (*Expr, error) {
+	cur := expr
+	for i, name := range fields {
+		var err error
+		if cur, err = cur.force(); err != nil {
+			return nil, err
+		}
+		if cur.kind != KindRecord {
+			return nil, fmt.Errorf("%s: %w", FormatPath(fields[:i]), &KindError{Want: KindRecord, Got: cur.kind})
+		}
+		next := cur.field(name)
+		if next == nil {
+			return nil, fmt.Errorf("%w: %s", ErrFieldNotFound, FormatPath(fields[:i+1]))
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+// EvalFieldDeep evaluates the field at path (see ParsePath) deeply.
+//
+// Only what is needed to reach the field is evaluated along the way, so this
+// works on the result of a shallow evaluation whose other fields would fail
+// to evaluate. See Expr.EvalDeep for how the field is evaluated.
+func (expr *Expr) EvalFieldDeep(path string) (*Expr, error) {
+	fields, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	field, err := expr.lookup(fields)
+	if err != nil {
+		return nil, err
+	}
+	return field.EvalDeep()
+}
+
+// MarshalJSONPath serializes the field at path (see ParsePath) to JSON.
+//
+// Like EvalFieldDeep, it only evaluates what is needed to reach the field,
+// and then the field itself, so fragments of a large configuration can be
+// served without evaluating and serializing all of it.
+func (expr *Expr) MarshalJSONPath(path string) ([]byte, error) {
+	field, err := expr.EvalFieldDeep(path)
+	if err != nil {
+		return nil, err
+	}
+	return field.MarshalJSON()
+}
+
+// EvalPaths evaluates a Nickel program lazily, and evaluates deeply only the
+// values at the given paths (see ParsePath). The results are keyed by path.
+//
+// Other parts of the program are evaluated only as far as needed to reach
+// the requested paths, so this can be much faster than EvalDeep on large
+// programs, and it succeeds even if unrelated fields would fail to evaluate.
+func (ctx *Context) EvalPaths(src string, paths []string) (map[string]*Expr, error) {
+	ret := make(map[string]*Expr, len(paths))
+	if len(paths) == 0 {
+		return ret, nil
+	}
+
+	// We select all the paths in one program, so that the main program is
+	// only parsed and evaluated once:
+	//
+	//   let r = (<src>) in { "0" = r."a"."b", "1" = ... }
+	var b strings.Builder
+	b.WriteString("let r = (")
+	b.WriteString(src)
+	// The newline protects against the program ending with a comment.
+	b.WriteString("\n) in {")
+	for i, path := range paths {
+		fields, err := ParsePath(path)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, ` "%d" = r`, i)
+		for _, field := range fields {
+			b.WriteString(".")
+			b.WriteString(QuoteString(field))
+		}
+	}
+	b.WriteString(" }")
+
+	selected, err := ctx.EvalDeep(b.String())
+	if err != nil {
+		return nil, err
+	}
+	for i, path := range paths {
+		ret[path] = selected.field(strconv.Itoa(i))
+	}
+	return ret, nil
+}
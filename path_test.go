@@ -0,0 +1,166 @@
+package nickel
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	cases := map[string][]string{
+		"a":                   {"a"},
+		"outputs.manifest":    {"outputs", "manifest"},
+		`labels."app.io/x".y`: {"labels", "app.io/x", "y"},
+		`"q\"uote\\d\n"`:      {"q\"uote\\d\n"},
+		`""`:                  {""},
+	}
+	for path, expected := range cases {
+		fields, err := ParsePath(path)
+		if err != nil {
+			t.Fatalf("%s: parse error: %v", path, err)
+		}
+		if !slices.Equal(fields, expected) {
+			t.Fatalf("%s: expected %q, got %q", path, expected, fields)
+		}
+		if reparsed, _ := ParsePath(FormatPath(fields)); !slices.Equal(reparsed, fields) {
+			t.Fatalf("%s: FormatPath gave %s", path, FormatPath(fields))
+		}
+	}
+
+	for _, path := range []string{"", "a.", ".a", "a..b", `"a`, `a"b"`, `"\x"`} {
+		if _, err := ParsePath(path); err == nil {
+			t.Fatalf("%s: expected a parse error", path)
+		}
+	}
+}
+
+func TestEvalFieldDeep(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalShallow(`{
+		outputs = { manifest = { name = "x", ports = [70 + 10, 1 / 3] }, other = std.fail_with "other" },
+		broken = std.fail_with "broken",
+	}`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	manifest, err := expr.EvalFieldDeep("outputs.manifest")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	json, err := manifest.MarshalJSON()
+	if err != nil {
+		t.Fatalf("marshal error: %v", err)
+	}
+	if string(json) != "{\n  \"name\": \"x\",\n  \"ports\": [\n    80,\n    0.3333333333333333\n  ]\n}" {
+		t.Fatalf("unexpected JSON: %s", json)
+	}
+
+	// The fraction should have been preserved exactly.
+	ports, _ := manifest.field("ports").ToArray()
+	if source := ports[1].String(); source != "0.3333333333333333" {
+		t.Fatalf("unexpected number %s", source)
+	}
+	if numberSource(ports[1]) != "(1 / 3)" {
+		t.Fatalf("expected an exact fraction, got %s", numberSource(ports[1]))
+	}
+
+	if _, err := expr.EvalFieldDeep("outputs.missing"); !errors.Is(err, ErrFieldNotFound) {
+		t.Fatalf("expected a missing field error, got %v", err)
+	}
+	var kindErr *KindError
+	if _, err := expr.EvalFieldDeep("outputs.manifest.name.x"); !errors.As(err, &kindErr) {
+		t.Fatalf("expected a kind error, got %v", err)
+	}
+	if _, err := expr.EvalFieldDeep("broken"); err == nil {
+		t.Fatal("expected an evaluation error")
+	}
+}
+
+func TestExprEvalDeep(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalShallow("{ a = 'Tag (1 + 1), b = [\"%\" ++ \"{x\", -5, 99999999999999999999999], c = null }")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	deep, err := expr.EvalDeep()
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if deep.String() != `{ a = 'Tag 2, b = ["%{x", -5, 1e+23], c = null }` {
+		t.Fatalf("unexpected result: %s", deep)
+	}
+	if again, _ := deep.EvalDeep(); again != deep {
+		t.Fatal("expected a deep expression to be returned as is")
+	}
+
+	fun, err := ctx.EvalShallow("{ f = fun x => x }")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if _, err := fun.EvalDeep(); err == nil {
+		t.Fatal("expected an error for functions")
+	}
+}
+
+func TestMarshalJSONPath(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalShallow(`{
+		services = { web = { ports = [80, 443] }, broken = std.fail_with "broken" },
+		"app.name" = "x",
+	}`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	json, err := expr.MarshalJSONPath("services.web.ports")
+	if err != nil {
+		t.Fatalf("marshal error: %v", err)
+	}
+	if string(json) != "[\n  80,\n  443\n]" {
+		t.Fatalf("unexpected JSON: %s", json)
+	}
+	if json, err := expr.MarshalJSONPath(`"app.name"`); err != nil || string(json) != `"x"` {
+		t.Fatalf("unexpected JSON: %s (%v)", json, err)
+	}
+	if _, err := expr.MarshalJSONPath("services.missing"); !errors.Is(err, ErrFieldNotFound) {
+		t.Fatalf("expected a missing field error, got %v", err)
+	}
+}
+
+func TestEvalPaths(t *testing.T) {
+	ctx := NewContext()
+	src := `{
+		services = { web = { replicas = 1 + 2, secret | not_exported = "x" }, db.port = 5432 },
+		broken = std.fail_with "broken",
+	} # trailing comment`
+
+	values, err := ctx.EvalPaths(src, []string{"services.web", "services.db.port", `"services"."db"`})
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if len(values) != 3 {
+		t.Fatalf("expected 3 values, got %d", len(values))
+	}
+	if port, ok := values["services.db.port"].ToInt64(); !ok || port != 5432 {
+		t.Fatalf("unexpected port %v", values["services.db.port"])
+	}
+	json, err := values["services.web"].MarshalJSON()
+	if err != nil {
+		t.Fatalf("marshal error: %v", err)
+	}
+	if string(json) != "{\n  \"replicas\": 3\n}" {
+		t.Fatalf("unexpected JSON: %s", json)
+	}
+	if !values[`"services"."db"`].IsRecord() {
+		t.Fatal("expected a record")
+	}
+
+	if _, err := ctx.EvalPaths(src, []string{"broken"}); err == nil {
+		t.Fatal("expected an evaluation error")
+	}
+	if _, err := ctx.EvalPaths(src, []string{"services..web"}); err == nil {
+		t.Fatal("expected a path error")
+	}
+}
@@ -0,0 +1,153 @@
+package nickel
+
+/*
+#include <stdlib.h>
+#include <nickel_lang.h>
+
+typedef struct {
+	nickel_expr* expr;
+	int kind;
+	int b;
+	int is_i64;
+	int64_t i64;
+	uintptr_t parent;
+	uintptr_t index;
+	int level;
+	uintptr_t len;
+	int forced;
+} prefetchNode;
+
+int prefetch(nickel_context* ctx, const nickel_expr* root, int root_kind, int depth,
+	prefetchNode** out_nodes, uintptr_t* out_len, uintptr_t* out_root_len, nickel_error* out_err);
+*/
+import "C"
+
+import (
+	"unsafe"
+)
+
+// PrefetchChildren evaluates the parts of a shallowly evaluated record,
+// array or enum variant shallowly, down to depth levels below it, in a single
+// call to the Nickel library. With a depth of 1, the fields of a record are
+// evaluated, with a depth of 2 the fields of those fields too, and so on.
+//
+// This is meant for programs that explore lazy values a level at a time,
+// like tree views of configurations: expanding a node costs a native call
+// per child otherwise. After PrefetchChildren, ToRecord, ToArray, Index,
+// Slice and ToEnumVariant return the evaluated children, which have their
+// own children prefetched down to the requested depth.
+//
+// If an evaluation fails, PrefetchChildren returns its error, and nothing is
+// prefetched. It has no effect on deeply evaluated expressions, whose parts
+// are already evaluated, nor on expressions without parts. It can be called
+// while the Expr is in use elsewhere: the children taken from it before
+// PrefetchChildren returns just aren't prefetched.
+func (expr *Expr) PrefetchChildren(depth int) error {
+	if depth <= 0 || expr.deep {
+		return nil
+	}
+	switch expr.kind {
+	case KindRecord, KindArray, KindEnumVariant:
+	default:
+		return nil
+	}
+
+	var nodes *C.prefetchNode
+	var n, rootLen C.uintptr_t
+	out_err := new_err()
+
+	ok, err := runNative(expr.ctx, func() C.int {
+		defer expr.ctx.flushTrace()
+		return C.prefetch(expr.ctx.ptr, expr.ptr, C.int(expr.kind), C.int(depth), &nodes, &n, &rootLen, out_err.ptr)
+	}, func(ok C.int) {
+		if ok != 0 {
+			for _, node := range unsafe.Slice(nodes, n) {
+				C.nickel_expr_free(node.expr)
+			}
+			C.free(unsafe.Pointer(nodes))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return out_err
+	}
+	defer C.free(unsafe.Pointer(nodes))
+
+	// The nodes come in breadth-first order, so the parents of a node are
+	// always wrapped before it.
+	exprs := make([]*Expr, n)
+	for i, node := range unsafe.Slice(nodes, n) {
+		child := adopt_child(expr, node.expr)
+		child.kind = Kind(node.kind)
+		child.b = node.b != 0
+		child.isI64 = node.is_i64 != 0
+		child.i64 = int64(node.i64)
+		child.evaluated()
+		exprs[i] = child
+		if err == nil {
+			err = expr.ctx.checkSize(child)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// The children are attached after recording their parent, whose value
+	// is recorded as it was forced.
+	children := make([]*Expr, rootLen)
+	nodeSlice := unsafe.Slice(nodes, n)
+	for i, node := range nodeSlice {
+		parent := expr
+		if node.parent != 0 {
+			parent = exprs[node.parent-1]
+		}
+		switch {
+		case parent == expr:
+			children[node.index] = exprs[i]
+		default:
+			// The other parents are new, and can be set up before they're
+			// handed out.
+			prefetched := parent.prefetchedChildren()
+			if prefetched == nil {
+				prefetched = make([]*Expr, nodeSlice[node.parent-1].len)
+				parent.prefetched.Store(&prefetched)
+			}
+			prefetched[node.index] = exprs[i]
+		}
+		if parent.origin != nil {
+			exprs[i].origin = parent.childOrigin(int(node.index))
+			if node.forced != 0 {
+				expr.ctx.recordForce(exprs[i].origin, exprs[i], nil)
+			}
+		}
+	}
+	expr.prefetched.Store(&children)
+	return nil
+}
+
+// prefetchedChildren returns the children set by PrefetchChildren, if any.
+func (expr *Expr) prefetchedChildren() []*Expr {
+	if children := expr.prefetched.Load(); children != nil {
+		return *children
+	}
+	return nil
+}
+
+// childOrigin returns the origin of the child of expr at index i, as
+// indexed in Expr.prefetched.
+func (expr *Expr) childOrigin(i int) *exprOrigin {
+	switch expr.kind {
+	case KindRecord:
+		var key *C.char
+		var keyLen C.uintptr_t
+		C.nickel_record_key_value_by_index(C.nickel_expr_as_record(expr.ptr), C.uintptr_t(i), &key, &keyLen, nil)
+		return expr.origin.field(C.GoStringN(key, C.int(keyLen)))
+	case KindArray:
+		return expr.origin.elem(i)
+	default:
+		// The payload has the same path as the variant, as in Walk.
+		return expr.origin
+	}
+}
@@ -0,0 +1,173 @@
+package nickel
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPrefetchChildren(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalShallow(`{
+		a = 1 + 1,
+		b = { c = "x" ++ "y", d = { e = 3 + 3 } },
+		list = std.array.map (fun x => x * 2) [1, 2],
+		tagged = 'Some (4 + 4),
+		decl | Number,
+	}`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if err := expr.PrefetchChildren(2); err != nil {
+		t.Fatalf("prefetch error: %v", err)
+	}
+
+	record, _ := expr.ToRecord()
+	if record["decl"] != nil {
+		t.Errorf("expected no value for decl, got %s", record["decl"])
+	}
+	if got := record["a"]; got.Kind() != KindNumber || got.String() != "2" {
+		t.Errorf("expected a prefetched number, got %s %s", got.Kind(), got)
+	}
+	b, _ := record["b"].ToRecord()
+	if got := b["c"]; got.Kind() != KindString {
+		t.Errorf("expected a prefetched string, got %s", got.Kind())
+	}
+	// Three levels down isn't prefetched.
+	d, _ := b["d"].ToRecord()
+	if got := d["e"].Kind(); got != KindThunk {
+		t.Errorf("expected an unevaluated field, got %s", got)
+	}
+
+	list, _ := record["list"].ToArray()
+	if len(list) != 2 || list[1].Kind() != KindNumber {
+		t.Errorf("expected prefetched elements, got %v", list)
+	}
+	elem, err := record["list"].Index(0)
+	if err != nil || elem != list[0] {
+		t.Errorf("expected the prefetched element, got %v, %v", elem, err)
+	}
+	tag, payload, _ := record["tagged"].ToEnumVariant()
+	if tag != "Some" || payload.Kind() != KindNumber {
+		t.Errorf("unexpected variant %s %s", tag, payload.Kind())
+	}
+
+	// A prefetched result can be prefetched further.
+	if err := b["d"].PrefetchChildren(1); err != nil {
+		t.Fatalf("prefetch error: %v", err)
+	}
+	d, _ = b["d"].ToRecord()
+	if got := d["e"].Kind(); got != KindNumber {
+		t.Errorf("expected a prefetched field, got %s", got)
+	}
+
+	expr, err = ctx.EvalShallow(`{ ok = 1, bad = { x = std.fail_with "oops" } }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if err := expr.PrefetchChildren(2); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Fatalf("expected an evaluation error, got %v", err)
+	}
+	if expr.prefetchedChildren() != nil {
+		t.Fatal("expected nothing to be prefetched")
+	}
+}
+
+func TestPrefetchChildrenConcurrent(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalShallow(`{ a = 1 + 1, b = [2 + 2] }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	// Prefetching while other goroutines read the same Expr is safe.
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := expr.PrefetchChildren(2); err != nil {
+				t.Errorf("prefetch error: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			record, _ := expr.ToRecord()
+			b, err := record["b"].EvalShallow()
+			if err != nil {
+				t.Errorf("eval error: %v", err)
+				return
+			}
+			if _, err := b.Index(0); err != nil {
+				t.Errorf("index error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	record, _ := expr.ToRecord()
+	if got := record["a"].Kind(); got != KindNumber {
+		t.Errorf("expected a prefetched field, got %s", got)
+	}
+}
+
+func TestPrefetchChildrenExplicitClose(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetExplicitClose(true)
+	expr, err := ctx.EvalShallow(`{ a = [1 + 1] }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if err := expr.PrefetchChildren(2); err != nil {
+		t.Fatalf("prefetch error: %v", err)
+	}
+	record, _ := expr.ToRecord()
+	elem, _ := record["a"].Index(0)
+	if record["a"].arena != expr.arena || elem.arena != expr.arena {
+		t.Fatal("expected the prefetched children to belong to the result")
+	}
+	expr.Close()
+	if len(expr.arena.ptrs) != 0 {
+		t.Fatal("expected the prefetched children to be freed")
+	}
+}
+
+func BenchmarkPrefetchChildren(b *testing.B) {
+	var src strings.Builder
+	src.WriteString("{\n")
+	for i := range 100 {
+		fmt.Fprintf(&src, "f%d = { a = %d + 1, b = [%d, %d] },\n", i, i, i, i)
+	}
+	src.WriteString("}")
+
+	ctx := NewContext()
+	expand := func(b *testing.B, prefetch bool) {
+		for b.Loop() {
+			expr, err := ctx.EvalShallow(src.String())
+			if err != nil {
+				b.Fatal(err)
+			}
+			if prefetch {
+				if err := expr.PrefetchChildren(2); err != nil {
+					b.Fatal(err)
+				}
+			}
+			record, _ := expr.ToRecord()
+			for _, field := range record {
+				field, err := field.force()
+				if err != nil {
+					b.Fatal(err)
+				}
+				children, _ := field.ToRecord()
+				for _, child := range children {
+					if _, err := child.force(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		}
+	}
+	b.Run("OneAtATime", func(b *testing.B) { expand(b, false) })
+	b.Run("Prefetch", func(b *testing.B) { expand(b, true) })
+}
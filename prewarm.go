@@ -0,0 +1,40 @@
+package nickel
+
+import "path/filepath"
+
+// Prewarm prepares ctx for evaluation in the background, so that the first
+// evaluation that someone is waiting for doesn't pay for it.
+//
+// The Nickel library loads its standard library the first time a context is
+// used, which takes a few milliseconds; Prewarm does that. It also reads and
+// parses the given files and everything they import, but the library doesn't
+// keep them between evaluations, so for those this mostly serves to warm the
+// operating system's file cache and to check early that they load.
+//
+// The returned channel receives the first error encountered (or nil) once
+// warming is done, and is then closed. Evaluations started in the meantime
+// wait for warming to finish.
+func (ctx *Context) Prewarm(entrypoints ...string) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		defer close(done)
+		done <- ctx.prewarm(entrypoints)
+	}()
+	return done
+}
+
+func (ctx *Context) prewarm(entrypoints []string) error {
+	if _, err := ctx.EvalShallow("std.typeof null"); err != nil {
+		return err
+	}
+	for _, path := range entrypoints {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		if _, err := ctx.EvalShallow("import " + QuoteString(path)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package nickel
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrewarm(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.ncl")
+	bad := filepath.Join(dir, "bad.ncl")
+	if err := os.WriteFile(good, []byte(`{ x = 1 }`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bad, []byte(`{ x = import "missing.ncl" }`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := NewContext()
+	if err := <-ctx.Prewarm(good); err != nil {
+		t.Errorf("prewarm error: %v", err)
+	}
+	if err := <-ctx.Prewarm(good, bad); err == nil {
+		t.Error("expected an error for a missing import")
+	}
+
+	// Evaluation doesn't need to wait for the result.
+	ctx.Prewarm()
+	if _, err := ctx.EvalDeep("std.array.length [1]"); err != nil {
+		t.Errorf("eval error: %v", err)
+	}
+}
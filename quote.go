@@ -0,0 +1,120 @@
+package nickel
+
+import "strings"
+
+// QuoteString returns a Nickel string literal whose value is s.
+//
+// The result can be pasted into Nickel source: quotes, backslashes, and
+// interpolation sequences ("%{") in s are escaped, so the literal evaluates
+// to exactly s. Nickel has no way to write a NUL character, so if s contains
+// one, the literal will contain it too and evaluating it fails with
+// ErrInvalidSource.
+func QuoteString(s string) string {
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '%':
+			// There's no escape sequence for "%{", so we interpolate the
+			// "%" instead.
+			if i+1 < len(s) && s[i+1] == '{' {
+				b.WriteString(`%{"%"}`)
+			} else {
+				b.WriteByte(c)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// QuoteIdent returns s as a Nickel record field name or enum tag: s itself
+// if it's a valid identifier, and a string literal (see QuoteString)
+// otherwise.
+func QuoteIdent(s string) string {
+	if isIdent(s) {
+		return s
+	}
+	return QuoteString(s)
+}
+
+// SourceBuilder builds Nickel source out of fixed source text and values
+// that come from Go, quoting the values so that they can't change the
+// meaning of the source around them.
+//
+// The methods return the builder, so calls can be chained. The first error
+// (for a value that can't be written as Nickel) is reported by Source.
+//
+//	var b nickel.SourceBuilder
+//	b.Raw("{ ").Ident(key).Raw(" = ").Quote(value).Raw(" }")
+//	src, err := b.Source()
+type SourceBuilder struct {
+	b   strings.Builder
+	err error
+}
+
+// Raw appends src as is. It should be source written by the programmer,
+// never input.
+func (b *SourceBuilder) Raw(src string) *SourceBuilder {
+	b.b.WriteString(src)
+	return b
+}
+
+// Quote appends a string literal whose value is s.
+func (b *SourceBuilder) Quote(s string) *SourceBuilder {
+	b.b.WriteString(QuoteString(s))
+	return b
+}
+
+// Ident appends s as a field name or enum tag name, quoting it if needed.
+func (b *SourceBuilder) Ident(s string) *SourceBuilder {
+	b.b.WriteString(QuoteIdent(s))
+	return b
+}
+
+// Path appends a field path made of the given field names, like `a."b.c"`.
+func (b *SourceBuilder) Path(fields ...string) *SourceBuilder {
+	for i, field := range fields {
+		if i > 0 {
+			b.b.WriteByte('.')
+		}
+		b.Ident(field)
+	}
+	return b
+}
+
+// Value appends an expression evaluating to v. The value can be an *Expr,
+// or anything that encoding/json can marshal.
+func (b *SourceBuilder) Value(v any) *SourceBuilder {
+	if b.err != nil {
+		return b
+	}
+	src, err := valueSource(v)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	b.b.WriteString(src)
+	return b
+}
+
+// Source returns the source built so far, or the first error encountered
+// while building it.
+func (b *SourceBuilder) Source() (string, error) {
+	if b.err != nil {
+		return "", b.err
+	}
+	return b.b.String(), nil
+}
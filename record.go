@@ -0,0 +1,106 @@
+package nickel
+
+/*
+#include <nickel_lang.h>
+
+uintptr_t keysWithPrefix(const nickel_expr* expr, const char* prefix, uintptr_t prefix_len,
+	const char** out_keys, uintptr_t* out_lens);
+*/
+import "C"
+
+import (
+	"slices"
+	"strings"
+	"unsafe"
+)
+
+// FieldsWithPrefix returns the sorted names of the fields of a record that
+// start with prefix, for records used as maps with structured keys (like
+// "zone/us-east-1a").
+//
+// The names are looked up in a single pass over the record, without
+// retrieving the values, so this is much cheaper than ToRecord for large
+// records. Fields without a value (in shallowly evaluated records) are
+// included. For the number of fields, see Len.
+func (expr *Expr) FieldsWithPrefix(prefix string) ([]string, error) {
+	if expr.kind != KindRecord {
+		return nil, &KindError{Want: KindRecord, Got: expr.kind}
+	}
+	n := expr.Len()
+	if n == 0 {
+		return []string{}, nil
+	}
+
+	keys := make([]*C.char, n)
+	lens := make([]C.uintptr_t, n)
+	matches := int(C.keysWithPrefix(expr.ptr, (*C.char)(unsafe.Pointer(unsafe.StringData(prefix))), C.uintptr_t(len(prefix)), &keys[0], &lens[0]))
+
+	ret := make([]string, matches)
+	for i := range ret {
+		ret[i] = C.GoStringN(keys[i], C.int(lens[i]))
+	}
+	slices.Sort(ret)
+	return ret, nil
+}
+
+// FieldDefined reports whether a record has a value for the field name,
+// without evaluating the value. A field whose value fails to evaluate is
+// defined, while fields that are only declared, like optional fields
+// without a value in a shallowly evaluated record, aren't.
+//
+// If expr hasn't been evaluated yet, it is evaluated shallowly first, which
+// only evaluates the record itself, not its fields.
+func (expr *Expr) FieldDefined(name string) (bool, error) {
+	record, err := expr.force()
+	if err != nil {
+		return false, err
+	}
+	if record.kind != KindRecord {
+		return false, &KindError{Want: KindRecord, Got: record.kind}
+	}
+	return record.field(name) != nil, nil
+}
+
+// sortedFields returns the names of the fields of a record, sorted, along
+// with their indexes in the record (see fieldAt), without retrieving the
+// values.
+func (expr *Expr) sortedFields() ([]string, []int) {
+	ptr := C.nickel_expr_as_record(expr.ptr)
+	n := int(C.nickel_record_len(ptr))
+	names := make([]string, n)
+	indexes := make([]int, n)
+	for i := range n {
+		var key *C.char
+		var keyLen C.uintptr_t
+		C.nickel_record_key_value_by_index(ptr, C.uintptr_t(i), &key, &keyLen, nil)
+		names[i] = C.GoStringN(key, C.int(keyLen))
+		indexes[i] = i
+	}
+	slices.SortFunc(indexes, func(a, b int) int {
+		return strings.Compare(names[a], names[b])
+	})
+	sorted := make([]string, n)
+	for i, index := range indexes {
+		sorted[i] = names[index]
+	}
+	return sorted, indexes
+}
+
+// fieldAt returns the value of the field at index i of a record, like
+// ToRecord would, or nil if the field has no value.
+func (expr *Expr) fieldAt(i int) *Expr {
+	if prefetched := expr.prefetchedChildren(); prefetched != nil {
+		return prefetched[i]
+	}
+
+	var key *C.char
+	var keyLen C.uintptr_t
+	value := new_child(expr)
+	if C.nickel_record_key_value_by_index(C.nickel_expr_as_record(expr.ptr), C.uintptr_t(i), &key, &keyLen, value.ptr) == 0 {
+		return nil
+	}
+	value.load()
+	value.inherit(expr)
+	value.origin = expr.origin.field(C.GoStringN(key, C.int(keyLen)))
+	return value
+}
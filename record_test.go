@@ -0,0 +1,83 @@
+package nickel
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFieldsWithPrefix(t *testing.T) {
+	expr, err := EvalShallow(`{ "zone/us-east-1b" = 1, "zone/us-east-1a" = std.fail_with "lazy", "zone/eu-west-1a" = 3, zone = 4, "é/x" = 5 }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	cases := map[string][]string{
+		"zone/us-":             {"zone/us-east-1a", "zone/us-east-1b"},
+		"zone":                 {"zone", "zone/eu-west-1a", "zone/us-east-1a", "zone/us-east-1b"},
+		"é":                    {"é/x"},
+		"":                     {"zone", "zone/eu-west-1a", "zone/us-east-1a", "zone/us-east-1b", "é/x"},
+		"zone/us-east-1a/more": {},
+		"\xc3":                 {"é/x"},
+	}
+	for prefix, expected := range cases {
+		keys, err := expr.FieldsWithPrefix(prefix)
+		if err != nil {
+			t.Fatalf("%q: error: %v", prefix, err)
+		}
+		if !slices.Equal(keys, expected) {
+			t.Errorf("%q: expected %q, got %q", prefix, expected, keys)
+		}
+	}
+
+	empty, _ := EvalDeep("{}")
+	if keys, err := empty.FieldsWithPrefix("a"); err != nil || len(keys) != 0 {
+		t.Errorf("unexpected result for an empty record: %q, %v", keys, err)
+	}
+	array, _ := EvalDeep("[]")
+	if _, err := array.FieldsWithPrefix("a"); !errors.As(err, new(*KindError)) {
+		t.Errorf("expected a kind error, got %v", err)
+	}
+}
+
+func BenchmarkFieldsWithPrefix(b *testing.B) {
+	var src strings.Builder
+	src.WriteString("{")
+	for i := range 20000 {
+		fmt.Fprintf(&src, ` "zone/%d/%d" = %d,`, i%100, i, i)
+	}
+	src.WriteString(" }")
+	expr, err := EvalDeep(src.String())
+	if err != nil {
+		b.Fatalf("eval error: %v", err)
+	}
+	for i := 0; b.Loop(); i++ {
+		if _, err := expr.FieldsWithPrefix(fmt.Sprintf("zone/%d/", i%100)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestFieldDefined(t *testing.T) {
+	expr, err := EvalShallow(`{ ok = 1, broken = 1 + "a", maybe | optional, required | Number }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	for name, want := range map[string]bool{"ok": true, "broken": true, "maybe": false, "required": false, "absent": false} {
+		got, err := expr.FieldDefined(name)
+		if err != nil || got != want {
+			t.Errorf("%s: got %v, %v, want %v", name, got, err, want)
+		}
+	}
+
+	record, _ := expr.ToRecord()
+	var kindErr *KindError
+	if _, err := record["ok"].FieldDefined("x"); !errors.As(err, &kindErr) {
+		t.Errorf("expected a KindError, got %v", err)
+	}
+	if _, err := record["broken"].FieldDefined("x"); err == nil {
+		t.Error("expected an evaluation error")
+	}
+}
@@ -0,0 +1,109 @@
+package nickel
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// ImportResolver provides the contents of imported files that aren't found
+// otherwise, for example from an HTTP server, an object store, or a
+// database. See Context.SetImportResolver.
+type ImportResolver interface {
+	// Resolve returns the contents of the file at path, a slash-separated
+	// path as accepted by fs.ValidPath.
+	Resolve(path string) ([]byte, error)
+}
+
+// ImportResolverFunc adapts a function to the ImportResolver interface.
+type ImportResolverFunc func(path string) ([]byte, error)
+
+func (f ImportResolverFunc) Resolve(path string) ([]byte, error) {
+	return f(path)
+}
+
+// SetImportResolver sets a resolver for the imports that aren't found next to
+// the program, among the registered sources, through the import paths, in
+// the import file system, or in the bundled library (see LibraryFS), or
+// removes it if r is nil.
+//
+// The Nickel library can only import files, and doesn't let the bindings
+// take part in resolving imports. The resolver is applied like import paths
+// (see AddImportPath): the imports of the programs given to the context's
+// evaluation functions are resolved before each evaluation, and the contents
+// are written to a temporary directory, which appears in error messages. The
+// imports in the resolved files are resolved in turn, relative to the path
+// of the file, so resolved files can import each other. Resolving fails for
+// paths that would leave the resolver's root, like "../x.ncl" in a program.
+//
+// Files are resolved again for every evaluation. An evaluation fails with
+// the resolver's error if it can't resolve an import.
+func (ctx *Context) SetImportResolver(r ImportResolver) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.resolver = r
+}
+
+func (ctx *Context) importResolver() ImportResolver {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.resolver
+}
+
+// fetchImport resolves the file at name, and the files it imports, and
+// returns the path it was written to. Files in fetched were written already.
+func (ctx *Context) fetchImport(r ImportResolver, name string, fetched map[string]bool) (string, error) {
+	name = path.Clean(name)
+	if !fs.ValidPath(name) || name == "." {
+		return "", fmt.Errorf("can't resolve import %q", name)
+	}
+	dir, err := ctx.resolvedFilesDir()
+	if err != nil {
+		return "", err
+	}
+	dest := filepath.Join(dir, filepath.FromSlash(name))
+	if fetched[name] {
+		return dest, nil
+	}
+	fetched[name] = true
+
+	data, err := r.Resolve(name)
+	if err != nil {
+		return "", fmt.Errorf("resolving import %q: %w", name, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(dest, data, 0o644); err != nil {
+		return "", err
+	}
+
+	src := string(data)
+	for m := range findImportLiterals(src) {
+		imported := unquoteImport(src[m[2]:m[3]])
+		if path.IsAbs(imported) || filepath.IsAbs(imported) {
+			continue
+		}
+		if _, err := ctx.fetchImport(r, path.Join(path.Dir(name), imported), fetched); err != nil {
+			return "", err
+		}
+	}
+	return dest, nil
+}
+
+// resolvedFilesDir returns the directory for the files of the import
+// resolver, creating it if needed.
+func (ctx *Context) resolvedFilesDir() (string, error) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if ctx.resolvedDir == "" {
+		dir, err := os.MkdirTemp("", "nickel-resolved-")
+		if err != nil {
+			return "", err
+		}
+		ctx.resolvedDir = dir
+	}
+	return ctx.resolvedDir, nil
+}
@@ -0,0 +1,76 @@
+package nickel
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestImportResolver(t *testing.T) {
+	files := map[string]string{
+		"lib/main.ncl": `{ name = "lib", util = import "util.ncl", data = import "../data.json" }`,
+		"lib/util.ncl": `{ double = fun x => 2 * x }`,
+		"data.json":    `{ "size": 3 }`,
+		"escape.ncl":   `import "../outside.ncl"`,
+		"broken/a.ncl": `import "missing.ncl"`,
+		"quiet.ncl": `# import "missing.ncl"
+			m%"import "missing.ncl""%`,
+	}
+	var resolved []string
+	ctx := NewContext()
+	ctx.SetImportResolver(ImportResolverFunc(func(path string) ([]byte, error) {
+		resolved = append(resolved, path)
+		src, ok := files[path]
+		if !ok {
+			return nil, fs.ErrNotExist
+		}
+		return []byte(src), nil
+	}))
+
+	expr, err := ctx.EvalDeep(`let lib = import "lib/main.ncl" in { name = lib.name, size = lib.util.double lib.data.size }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got := expr.String(); got != `{ name = "lib", size = 6 }` {
+		t.Errorf("unexpected result: %s", got)
+	}
+	if !slices.Equal(resolved, []string{"lib/main.ncl", "lib/util.ncl", "data.json"}) {
+		t.Errorf("unexpected resolutions: %q", resolved)
+	}
+
+	// Files are resolved again for every evaluation.
+	files["lib/util.ncl"] = `{ double = fun x => x + x + 1 }`
+	expr, err = ctx.EvalDeep(`(import "lib/util.ncl").double 1`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got := expr.String(); got != "3" {
+		t.Errorf("expected the new file, got %s", got)
+	}
+
+	// Import text in strings and comments isn't resolved, in the program
+	// or in the files it imports.
+	resolved = nil
+	expr, err = ctx.EvalDeep(`[import "quiet.ncl", m%"import "absent.ncl""%] # import "absent.ncl"`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if !slices.Equal(resolved, []string{"quiet.ncl"}) {
+		t.Errorf("unexpected resolutions: %q", resolved)
+	}
+
+	_, err = ctx.EvalShallow(`import "broken/a.ncl"`)
+	if !errors.Is(err, fs.ErrNotExist) || !strings.Contains(err.Error(), "broken/missing.ncl") {
+		t.Errorf("expected the resolver's error, got %v", err)
+	}
+	if _, err := ctx.EvalShallow(`import "escape.ncl"`); err == nil || !strings.Contains(err.Error(), "can't resolve") {
+		t.Errorf("expected an error for a path outside the root, got %v", err)
+	}
+
+	ctx.SetImportResolver(nil)
+	if _, err := ctx.EvalDeep(`import "data.json"`); err == nil {
+		t.Error("expected an error without a resolver")
+	}
+}
@@ -0,0 +1,186 @@
+package nickel
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"strings"
+)
+
+// ErrSandboxed is returned (wrapped in a *SandboxError) when a program
+// evaluated in sandbox mode tries to import a file.
+var ErrSandboxed = errors.New("imports are disabled in sandbox mode")
+
+// SandboxError describes an import refused by sandbox mode.
+type SandboxError struct {
+	// Path is the imported path, or "" if it isn't a plain string literal.
+	Path string
+	// Line and Column locate the import in the program, from 1. Columns
+	// count bytes.
+	Line, Column int
+}
+
+func (e *SandboxError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%v: import at line %d, column %d", ErrSandboxed, e.Line, e.Column)
+	}
+	return fmt.Sprintf("%v: import of %q at line %d, column %d", ErrSandboxed, e.Path, e.Line, e.Column)
+}
+
+func (e *SandboxError) Unwrap() error {
+	return ErrSandboxed
+}
+
+// SetSandbox turns sandbox mode on or off, for evaluating untrusted
+// programs.
+//
+// In sandbox mode, the programs given to the context's evaluation functions
+// can't import anything, so they can't read files: evaluating a program
+// containing an import fails with a *SandboxError, before evaluation starts,
+// whether or not the import would be evaluated. The host capability to
+// read files (see AllowFiles) is withdrawn too. The other host capabilities
+// stay as they were allowed, as do the contracts registered with
+// RegisterContractSource and the globals, which come from the host.
+func (ctx *Context) SetSandbox(enabled bool) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.sandbox = enabled
+}
+
+func (ctx *Context) sandboxed() bool {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.sandbox
+}
+
+// checkSandbox returns a *SandboxError if the context is in sandbox mode and
+// src contains an import.
+func (ctx *Context) checkSandbox(src string) error {
+	if !ctx.sandboxed() {
+		return nil
+	}
+	i := findImport(src)
+	if i < 0 {
+		return nil
+	}
+	err := &SandboxError{
+		Line:   strings.Count(src[:i], "\n") + 1,
+		Column: i - strings.LastIndexByte(src[:i], '\n'),
+	}
+	if m := importExpr.FindStringSubmatchIndex(src[i:]); m != nil && m[0] == 0 {
+		err.Path = unquoteImport(src[i+m[2] : i+m[3]])
+	}
+	return err
+}
+
+// findImport returns the offset of the first import keyword in src, or -1.
+func findImport(src string) int {
+	for i := range findImports(src) {
+		return i
+	}
+	return -1
+}
+
+// findImports yields the offsets of the import keywords in src. Comments and
+// the text of strings are skipped, but not the expressions interpolated in
+// strings.
+func findImports(src string) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		scanImports(src, yield)
+	}
+}
+
+// findImportLiterals yields the submatch indices of importExpr for the
+// imports in src that are found by findImports and import a plain string
+// literal.
+func findImportLiterals(src string) iter.Seq[[]int] {
+	return func(yield func([]int) bool) {
+		for i := range findImports(src) {
+			m := importExpr.FindStringSubmatchIndex(src[i:])
+			if m == nil || m[0] != 0 {
+				continue
+			}
+			for j := range m {
+				m[j] += i
+			}
+			if !yield(m) {
+				return
+			}
+		}
+	}
+}
+
+func scanImports(src string, yield func(int) bool) {
+	// The delimiters of the strings that the scanner is in, innermost last:
+	// the number of percent signs that close each one, 0 for a plain string.
+	// A negative number marks an interpolation, which ends at its closing
+	// brace, with the number of open braces in it.
+	var stack []int
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		inString := len(stack) > 0 && stack[len(stack)-1] >= 0
+		if inString {
+			percents := stack[len(stack)-1]
+			switch {
+			case percents == 0 && c == '\\':
+				i++
+			case percents == 0 && c == '"':
+				stack = stack[:len(stack)-1]
+			case percents > 0 && c == '"' && strings.HasPrefix(src[i+1:], strings.Repeat("%", percents)):
+				stack = stack[:len(stack)-1]
+				i += percents
+			case c == '%':
+				// A longer run of percent signs also interpolates, after
+				// the extra ones.
+				n := max(percents, 1)
+				if strings.HasPrefix(src[i:], strings.Repeat("%", n)+"{") {
+					stack = append(stack, -1)
+					i += n
+				}
+			}
+			continue
+		}
+
+		switch {
+		case c == '#':
+			if end := strings.IndexByte(src[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				return
+			}
+		case c == '"':
+			stack = append(stack, 0)
+		case c == '{' && len(stack) > 0:
+			stack[len(stack)-1]--
+		case c == '}' && len(stack) > 0:
+			if stack[len(stack)-1]++; stack[len(stack)-1] == 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case isIdentByte(c):
+			j := i
+			for j < len(src) && (isIdentByte(src[j]) || src[j] == '-' || src[j] == '\'') {
+				j++
+			}
+			word := src[i:j]
+			if word == "import" && !yield(i) {
+				return
+			}
+			i = j - 1
+
+			// Multiline strings start with m%", and symbolic strings with
+			// a name ending in -s, like tag-s%". Any number of percent
+			// signs can be used.
+			if word == "m" || strings.HasSuffix(word, "-s") {
+				n := len(src[j:]) - len(strings.TrimLeft(src[j:], "%"))
+				if n > 0 && strings.HasPrefix(src[j+n:], `"`) {
+					stack = append(stack, n)
+					i = j + n
+				}
+			}
+		}
+	}
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
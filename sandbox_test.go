@@ -0,0 +1,84 @@
+package nickel
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindImport(t *testing.T) {
+	cases := []struct {
+		src   string
+		found bool
+	}{
+		{`import "a.ncl"`, true},
+		{`{ x = 1 } & (import  "a.ncl")`, true},
+		{"import # comment\n \"a.ncl\"", true},
+		{`"%{import "a.ncl"}"`, true},
+		{`"%%{import "a.ncl"}"`, true},
+		{`m%"text %{ { a = import "a.ncl" }.a } more"%`, true},
+		{`m%%"%{ "text" } %%{ import "a.ncl" }"%%`, true},
+		{`let x = 5 in x%"a" + import "a.ncl" # "%`, true},
+		{`tag-s%%"x"%% ++ import "a.ncl"`, true},
+		{`"\"" ++ import "a.ncl"`, true},
+		{`"import \"a.ncl\""`, false},
+		{`"\%{import "a.ncl"}"`, false},
+		{`m%"import "a.ncl" %{ "x" }"% # import "b.ncl"`, false},
+		{`m%%"%{ import "a.ncl" }"%%`, false},
+		{`{ "import" = 1, imports = 2, important = 3 }`, false},
+		{`# import "a.ncl"`, false},
+	}
+	for _, c := range cases {
+		if got := findImport(c.src) >= 0; got != c.found {
+			t.Errorf("%s: expected %t, got %t", c.src, c.found, got)
+		}
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secret.txt")
+	if err := os.WriteFile(path, []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := NewContext()
+	if err := ctx.AllowFiles(path); err != nil {
+		t.Fatal(err)
+	}
+	ctx.SetSandbox(true)
+
+	src := "{\n  x = 1,\n  y = import " + QuoteString(path) + ",\n}"
+	_, err := ctx.EvalShallow(src)
+	var sandboxErr *SandboxError
+	if !errors.As(err, &sandboxErr) || !errors.Is(err, ErrSandboxed) {
+		t.Fatalf("expected a sandbox error, got %v", err)
+	}
+	if sandboxErr.Path != path || sandboxErr.Line != 3 || sandboxErr.Column != 7 {
+		t.Errorf("unexpected error %+v", sandboxErr)
+	}
+	if _, err := ctx.EvalDeep(src); !errors.Is(err, ErrSandboxed) {
+		t.Errorf("expected a sandbox error, got %v", err)
+	}
+	if _, err := ctx.EvalDeep(`host.read_file ` + QuoteString(path)); err == nil {
+		t.Error("expected files to be unreadable")
+	}
+
+	expr, err := ctx.EvalDeep(`{ text = "import \"x\"" }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got := expr.String(); got != `{ text = "import \"x\"" }` {
+		t.Errorf("unexpected result %s", got)
+	}
+
+	ctx.SetSandbox(false)
+	expr, err = ctx.EvalDeep(`host.read_file ` + QuoteString(path))
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got := expr.String(); got != `"secret"` {
+		t.Errorf("unexpected result %s", got)
+	}
+}
@@ -0,0 +1,41 @@
+package nickel
+
+import "strings"
+
+// EvalInScope evaluates a Nickel program deeply, with the fields of the
+// record scope in scope as variables. With a scope holding a services
+// field, the program can be `services.web.replicas * 2`. This is a way of
+// asking ad-hoc questions about a configuration that has already been
+// evaluated, without evaluating it again.
+//
+// Only the fields whose names are plain Nickel identifiers are bound; the
+// others can't be referred to by name. The parts of scope that haven't been
+// evaluated yet are evaluated first, so scope can't contain functions. The
+// program's own bindings take precedence over the fields of scope, which
+// take precedence over the globals (see SetGlobals).
+func (ctx *Context) EvalInScope(scope *Expr, src string) (*Expr, error) {
+	record, err := scope.force()
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := record.ToRecord()
+	if !ok {
+		return nil, &KindError{Want: KindRecord, Got: record.kind}
+	}
+
+	var b strings.Builder
+	for _, name := range sortedKeys(fields) {
+		value := fields[name]
+		if value == nil || !isIdent(name) {
+			continue
+		}
+		// The bindings are kept on one line, so that the line numbers in
+		// error messages are the program's.
+		b.WriteString("let " + name + " = ")
+		if err := writeSource(&b, value); err != nil {
+			return nil, err
+		}
+		b.WriteString(" in ")
+	}
+	return ctx.evalDeep(src, evalOptions{scope: b.String()})
+}
@@ -0,0 +1,49 @@
+package nickel
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEvalInScope(t *testing.T) {
+	ctx := NewContext()
+	config, err := ctx.EvalShallow(`{ services = { web = { replicas = 3 } }, region = "eu", "not an ident" = 1 }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	expr, err := ctx.EvalInScope(config, "services.web.replicas * 2")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got := expr.String(); got != "6" {
+		t.Errorf("unexpected result: %s", got)
+	}
+
+	if err := ctx.SetGlobals(map[string]any{"region": "us", "zone": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	expr, err = ctx.EvalInScope(config, `region ++ "-" ++ zone`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got := expr.String(); got != `"eu-a"` {
+		t.Errorf("expected the scope to take precedence over the globals, got %s", got)
+	}
+
+	// Line numbers in errors are the program's.
+	_, err = ctx.EvalInScope(config, "{\n  x = region + 1,\n}")
+	if err == nil || !strings.Contains(err.Error(), "<source>:2:") {
+		t.Errorf("expected an error on line 2, got %v", err)
+	}
+
+	number, err := ctx.EvalDeep("1")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	var kindErr *KindError
+	if _, err := ctx.EvalInScope(number, "1"); !errors.As(err, &kindErr) {
+		t.Errorf("expected a KindError, got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package nickel
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// EscapeCheck is the outcome of one of the attempts made by SelfTest to get
+// around the restrictions of a Context.
+type EscapeCheck struct {
+	// Name identifies the attempt, like "import-absolute".
+	Name string
+	// Program is the Nickel program that made the attempt.
+	Program string
+	// Blocked is true if the program failed to evaluate.
+	Blocked bool
+	// Err is the error that the program failed with, if it was blocked.
+	Err error
+}
+
+// SelfTest evaluates programs that try to escape the restrictions of the
+// context, and reports which of them were blocked, so that programs that
+// evaluate untrusted Nickel can check their configuration at startup. The
+// programs try to:
+//
+//   - import a file by its absolute path ("import-absolute"), and through a
+//     relative path going up from the working directory
+//     ("import-traversal"),
+//   - read environment variables, files, and URLs, and run commands, with
+//     the host capabilities ("host-env", "host-read-file", "host-fetch" and
+//     "host-exec"),
+//   - produce a 16 MiB string ("giant-string") and an array of a million
+//     elements ("giant-array").
+//
+// The programs are evaluated like any other, with the context's settings:
+// for example, the imports are refused in sandbox mode, the host
+// capabilities only work if they were allowed, and the giant values are
+// stopped by size limits (see SetSizeLimits) or by a timeout. The files to
+// import and read are created by SelfTest in a temporary directory.
+func (ctx *Context) SelfTest() ([]EscapeCheck, error) {
+	dir, err := os.MkdirTemp("", "nickel-self-test")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	secret := filepath.Join(dir, "secret.ncl")
+	if err := os.WriteFile(secret, []byte(`"secret"`), 0o600); err != nil {
+		return nil, err
+	}
+	traversal := secret
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, secret); err == nil {
+			traversal = filepath.ToSlash(rel)
+		}
+	}
+
+	checks := []EscapeCheck{
+		{Name: "import-absolute", Program: "import " + QuoteString(secret)},
+		{Name: "import-traversal", Program: "import " + QuoteString(traversal)},
+		{Name: "host-env", Program: `host.env "PATH"`},
+		{Name: "host-read-file", Program: "host.read_file " + QuoteString(secret)},
+		{Name: "host-fetch", Program: `host.fetch "http://169.254.169.254/latest/meta-data/"`},
+		{Name: "host-exec", Program: `host.exec "sh" ["-c", "id"]`},
+		{Name: "giant-string", Program: `let rec double = fun n s => if n == 0 then s else double (n - 1) (s ++ s) in double 24 "x"`},
+		{Name: "giant-array", Program: `let rec double = fun n a => if n == 0 then a else double (n - 1) (a @ a) in double 20 [0]`},
+	}
+	for i := range checks {
+		_, err := ctx.EvalDeep(checks[i].Program)
+		checks[i].Blocked = err != nil
+		checks[i].Err = err
+	}
+	return checks, nil
+}
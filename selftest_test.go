@@ -0,0 +1,53 @@
+package nickel
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSelfTest(t *testing.T) {
+	blocked := func(ctx *Context) map[string]bool {
+		checks, err := ctx.SelfTest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret := map[string]bool{}
+		for _, check := range checks {
+			ret[check.Name] = check.Blocked
+			if check.Blocked != (check.Err != nil) {
+				t.Errorf("%s: blocked is %v with error %v", check.Name, check.Blocked, check.Err)
+			}
+		}
+		return ret
+	}
+
+	open := blocked(NewContext())
+	for _, name := range []string{"import-absolute", "import-traversal", "giant-string", "giant-array"} {
+		if open[name] {
+			t.Errorf("%s: expected an unrestricted context not to block it", name)
+		}
+	}
+	for _, name := range []string{"host-env", "host-read-file", "host-fetch", "host-exec"} {
+		if !open[name] {
+			t.Errorf("%s: expected host capabilities to be off by default", name)
+		}
+	}
+
+	ctx := NewContext()
+	ctx.SetSandbox(true)
+	ctx.SetSizeLimits(SizeLimits{MaxArrayLen: 1000, MaxStringBytes: 1 << 20})
+	ctx.AllowEnv("PATH")
+	for name, blocked := range blocked(ctx) {
+		if blocked == (name == "host-env") {
+			t.Errorf("%s: unexpected blocked = %v", name, blocked)
+		}
+	}
+
+	checks, err := ctx.SelfTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(checks[0].Err, ErrSandboxed) {
+		t.Errorf("expected the import to be refused by the sandbox, got %v", checks[0].Err)
+	}
+}
@@ -0,0 +1,204 @@
+package nickel
+
+/*
+#include <nickel_lang.h>
+
+typedef struct {
+	int kind;
+	int b;
+	int is_i64;
+	int64_t i64;
+	double f64;
+	const char* str;
+	uintptr_t len;
+} plainInfo;
+
+void plainInfoOf(const nickel_expr* expr, plainInfo* info);
+int plainChild(const nickel_expr* expr, int kind, uintptr_t i, nickel_expr* out_expr,
+	const char** out_key, uintptr_t* out_key_len, plainInfo* info);
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// SizeLimits bound the size of the results of evaluations, to protect
+// programs that pass on results (for example to clients of a service) from
+// small Nickel programs that produce huge values. Zero means no limit.
+type SizeLimits struct {
+	// MaxArrayLen is the maximum number of elements of an array.
+	MaxArrayLen int
+
+	// MaxStringBytes is the maximum length of a string value, in bytes.
+	MaxStringBytes int
+
+	// MaxExportBytes is the maximum size of a serialized value, as returned
+	// by MarshalJSON, MarshalYAML, MarshalTOML, and Export.
+	MaxExportBytes int
+}
+
+// ErrResultTooLarge is returned (wrapped in a *SizeLimitError) when a result
+// exceeds the Context's SizeLimits.
+var ErrResultTooLarge = errors.New("result too large")
+
+// SizeLimitError describes a value that exceeds one of the SizeLimits.
+type SizeLimitError struct {
+	// Limit is the name of the exceeded limit, like "MaxArrayLen".
+	Limit string
+	// Path is the path to the value that exceeds the limit, within the
+	// result. It's nil for MaxExportBytes.
+	Path []string
+	// Size is the size of the value: a number of elements or bytes.
+	Size int
+	// Max is the limit.
+	Max int
+}
+
+func (e *SizeLimitError) Error() string {
+	switch e.Limit {
+	case "MaxArrayLen":
+		return fmt.Sprintf("%v: array at %s has %d elements (%s is %d)", ErrResultTooLarge, e.where(), e.Size, e.Limit, e.Max)
+	case "MaxStringBytes":
+		return fmt.Sprintf("%v: string at %s has %d bytes (%s is %d)", ErrResultTooLarge, e.where(), e.Size, e.Limit, e.Max)
+	default:
+		return fmt.Sprintf("%v: serialized value has %d bytes (%s is %d)", ErrResultTooLarge, e.Size, e.Limit, e.Max)
+	}
+}
+
+func (e *SizeLimitError) where() string {
+	if len(e.Path) == 0 {
+		return "the top level"
+	}
+	return FormatPath(e.Path)
+}
+
+func (e *SizeLimitError) Unwrap() error {
+	return ErrResultTooLarge
+}
+
+// SetSizeLimits sets limits on the size of results.
+//
+// The limits on arrays and strings apply to every Expr that the context
+// evaluates: the results of its evaluation functions (including Decode,
+// EvalFile and the like), of Expr.EvalShallow and Expr.EvalDeep, and of the
+// transformations like Expr.SetPath. For shallow evaluations, only the
+// evaluated value itself is checked, not its unevaluated parts. The limit on
+// serialized values applies to MarshalJSON, MarshalYAML, MarshalTOML and
+// Export, but not to the conversions of ConvertTo.
+//
+// Values are checked after they have been evaluated, so the limits don't
+// bound the memory or time that an evaluation takes. The Nickel library
+// allocates outside of the Go heap and can't be made to stop an evaluation
+// early, so memory can only be bounded from outside the process: to evaluate
+// programs that might exhaust memory, run the evaluation in a separate
+// process with operating system limits (like RLIMIT_AS or cgroups).
+func (ctx *Context) SetSizeLimits(limits SizeLimits) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.limits = limits
+}
+
+func (ctx *Context) sizeLimits() SizeLimits {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.limits
+}
+
+// checkSize checks an evaluation result against the context's limits. For
+// deeply evaluated expressions, it checks every value in them.
+func (ctx *Context) checkSize(expr *Expr) error {
+	limits := ctx.sizeLimits()
+	if limits.MaxArrayLen <= 0 && limits.MaxStringBytes <= 0 {
+		return nil
+	}
+
+	var w sizeWalker
+	w.limits = limits
+	w.deep = expr.deep
+	defer w.free()
+
+	var info C.plainInfo
+	C.plainInfoOf(expr.ptr, &info)
+	if err := w.check(expr.ptr, &info, 0); err != nil {
+		slices.Reverse(err.Path)
+		return err
+	}
+	return nil
+}
+
+// checkExportSize checks the size of serialized data against the context's
+// limits.
+func (ctx *Context) checkExportSize(data []byte) error {
+	if max := ctx.sizeLimits().MaxExportBytes; max > 0 && len(data) > max {
+		return &SizeLimitError{Limit: "MaxExportBytes", Size: len(data), Max: max}
+	}
+	return nil
+}
+
+// sizeWalker walks a value like plainWalker, with one scratch native
+// expression per level of nesting.
+type sizeWalker struct {
+	plainWalker
+	limits SizeLimits
+	deep   bool
+}
+
+// check returns an error for the first value that exceeds the limits, with
+// its path reversed.
+func (w *sizeWalker) check(ptr *C.nickel_expr, info *C.plainInfo, depth int) *SizeLimitError {
+	switch Kind(info.kind) {
+	case KindString:
+		if max := w.limits.MaxStringBytes; max > 0 && int(info.len) > max {
+			return &SizeLimitError{Limit: "MaxStringBytes", Size: int(info.len), Max: max}
+		}
+	case KindArray:
+		if max := w.limits.MaxArrayLen; max > 0 && int(info.len) > max {
+			return &SizeLimitError{Limit: "MaxArrayLen", Size: int(info.len), Max: max}
+		}
+		if !w.deep {
+			return nil
+		}
+		child := w.at(depth)
+		var childInfo C.plainInfo
+		for i := range info.len {
+			C.plainChild(ptr, info.kind, i, child, nil, nil, &childInfo)
+			if err := w.check(child, &childInfo, depth+1); err != nil {
+				err.Path = append(err.Path, strconv.Itoa(int(i)))
+				return err
+			}
+		}
+	case KindRecord:
+		if !w.deep {
+			return nil
+		}
+		child := w.at(depth)
+		var childInfo C.plainInfo
+		for i := range info.len {
+			var key *C.char
+			var keyLen C.uintptr_t
+			if C.plainChild(ptr, info.kind, i, child, &key, &keyLen, &childInfo) == 0 {
+				continue
+			}
+			if err := w.check(child, &childInfo, depth+1); err != nil {
+				err.Path = append(err.Path, C.GoStringN(key, C.int(keyLen)))
+				return err
+			}
+		}
+	case KindEnumVariant:
+		if !w.deep {
+			return nil
+		}
+		// The payload has the same path as the variant, as in Walk.
+		var tag *C.char
+		child := w.at(depth)
+		C.nickel_expr_as_enum_variant(ptr, &tag, child)
+		var childInfo C.plainInfo
+		C.plainInfoOf(child, &childInfo)
+		return w.check(child, &childInfo, depth+1)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package nickel
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestSizeLimits(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetSizeLimits(SizeLimits{MaxArrayLen: 3, MaxStringBytes: 5, MaxExportBytes: 40})
+
+	cases := []struct {
+		src   string
+		limit string
+		path  []string
+		size  int
+	}{
+		{`std.array.range 0 4`, "MaxArrayLen", nil, 4},
+		{`{ a = [1, { b = std.array.replicate 10 null }] }`, "MaxArrayLen", []string{"a", "1", "b"}, 10},
+		{`{ "x.y" = "ab" ++ "ab" ++ "ab" }`, "MaxStringBytes", []string{"x.y"}, 6},
+		{`{ v = 'Some ["toolong"] }`, "MaxStringBytes", []string{"v", "0"}, 7},
+	}
+	for _, c := range cases {
+		_, err := ctx.EvalDeep(c.src)
+		var limitErr *SizeLimitError
+		if !errors.As(err, &limitErr) || !errors.Is(err, ErrResultTooLarge) {
+			t.Fatalf("%s: expected a size limit error, got %v", c.src, err)
+		}
+		if limitErr.Limit != c.limit || !slices.Equal(limitErr.Path, c.path) || limitErr.Size != c.size {
+			t.Errorf("%s: unexpected error %+v: %v", c.src, limitErr, err)
+		}
+	}
+
+	// Shallow evaluations only check what they evaluate.
+	expr, err := ctx.EvalShallow(`{ ok = [1, 2], long = std.array.range 0 10 }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	record, _ := expr.ToRecord()
+	if _, err := record["ok"].EvalShallow(); err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if _, err := record["long"].EvalShallow(); !errors.Is(err, ErrResultTooLarge) {
+		t.Fatalf("expected a size limit error, got %v", err)
+	}
+	if _, err := expr.EvalDeep(); !errors.Is(err, ErrResultTooLarge) {
+		t.Fatalf("expected a size limit error, got %v", err)
+	}
+
+	// The limits on exports apply to the serialized data.
+	expr, err = ctx.EvalDeep(`{ a = "12345", b = "12345", c = "12345" }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if _, err := expr.MarshalJSON(); !errors.Is(err, ErrResultTooLarge) {
+		t.Fatalf("expected a size limit error, got %v", err)
+	}
+	if _, err := expr.Export(ExportOptions{Format: ExportYAML}); err != nil {
+		t.Fatalf("expected the YAML to fit, got %v", err)
+	}
+	if _, err := expr.Export(ExportOptions{Exclude: []string{"c"}}); err != nil {
+		t.Fatalf("expected the filtered JSON to fit, got %v", err)
+	}
+	if _, err := expr.Export(ExportOptions{Canonical: true}); !errors.Is(err, ErrResultTooLarge) {
+		t.Fatalf("expected a size limit error, got %v", err)
+	}
+	var m map[string]string
+	if err := expr.ConvertTo(&m); err != nil || len(m) != 3 {
+		t.Fatalf("expected conversions to be unlimited, got %v, %v", m, err)
+	}
+
+	ctx.SetSizeLimits(SizeLimits{})
+	if _, err := ctx.EvalDeep(`std.array.range 0 4`); err != nil {
+		t.Fatalf("expected no limits, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package nickel
+
+/*
+#include <nickel_lang.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"strings"
+)
+
+// writeSource writes Nickel source for expr to b, evaluating any parts of it
+// that haven't been evaluated yet.
+//
+// Evaluating the resulting source gives back the same value: numbers are
+// written exactly, as fractions if necessary. Record field metadata (like
+// contracts or not_exported) isn't visible through the C API, so it isn't
+// written, and neither are fields without a value. Functions can't be
+// written at all, and cause an error.
+func writeSource(b *strings.Builder, expr *Expr) error {
+	if expr.kind == KindThunk {
+		forced, err := expr.EvalShallow()
+		if err != nil {
+			return err
+		}
+		expr = forced
+	}
+	if expr.kind == KindFunction {
+		return fmt.Errorf("functions can't be evaluated deeply")
+	}
+
+	switch expr.kind {
+	case KindNull:
+		b.WriteString("null")
+	case KindBool:
+		if expr.b {
+			b.WriteString("true")
+		} else {
+			b.WriteString("false")
+		}
+	case KindNumber:
+		b.WriteString(numberSource(expr))
+	case KindString:
+		s, _ := expr.ToString()
+		b.WriteString(QuoteString(s))
+	case KindEnumTag:
+		tag, _ := expr.ToEnumTag()
+		b.WriteString("'")
+		b.WriteString(QuoteString(tag))
+	case KindEnumVariant:
+		tag, payload, _ := expr.ToEnumVariant()
+		b.WriteString("('")
+		b.WriteString(QuoteString(tag))
+		b.WriteString(" (")
+		if err := writeSource(b, payload); err != nil {
+			return err
+		}
+		b.WriteString("))")
+	case KindRecord:
+		fields, _ := expr.ToRecord()
+		b.WriteString("{")
+		first := true
+		for _, key := range sortedKeys(fields) {
+			if fields[key] == nil {
+				continue
+			}
+			if !first {
+				b.WriteString(",")
+			}
+			first = false
+			b.WriteString(" ")
+			b.WriteString(QuoteString(key))
+			b.WriteString(" = ")
+			if err := writeSource(b, fields[key]); err != nil {
+				return err
+			}
+		}
+		b.WriteString(" }")
+	case KindArray:
+		elems, _ := expr.ToArray()
+		b.WriteString("[")
+		for i, elem := range elems {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if err := writeSource(b, elem); err != nil {
+				return err
+			}
+		}
+		b.WriteString("]")
+	}
+	return nil
+}
+
+// numberSource returns Nickel source for the exact value of a number.
+func numberSource(expr *Expr) string {
+	s := strings.Replace(expr.rational(), "/", " / ", 1)
+	if strings.ContainsAny(s, "-/") {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+// goString copies the contents of a nickel_string into a Go string.
+func goString(s *C.nickel_string) string {
+	var len C.uintptr_t
+	var bytes *C.char
+	C.nickel_string_data(s, &bytes, &len)
+	return C.GoStringN(bytes, C.int(len))
+}
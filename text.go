@@ -0,0 +1,88 @@
+package nickel
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// MarshalText implements encoding.TextMarshaler for scalar expressions:
+// strings are written as their contents, enum tags as their names, and
+// null, booleans and numbers like in Nickel source. Numbers that aren't
+// integers fitting in an int64 are rounded to a float64, as in String.
+//
+// Records, arrays, enum variants and functions can't be written as text.
+// An unevaluated expression is evaluated shallowly first.
+func (expr *Expr) MarshalText() ([]byte, error) {
+	value, err := expr.force()
+	if err != nil {
+		return nil, err
+	}
+
+	switch value.kind {
+	case KindNull:
+		return []byte("null"), nil
+	case KindBool:
+		if value.b {
+			return []byte("true"), nil
+		}
+		return []byte("false"), nil
+	case KindNumber:
+		return []byte(formatNumber(value)), nil
+	case KindString:
+		s, _ := value.ToString()
+		return []byte(s), nil
+	case KindEnumTag:
+		tag, _ := value.ToEnumTag()
+		return []byte(tag), nil
+	default:
+		return nil, fmt.Errorf("can't marshal %s as text", value.kind)
+	}
+}
+
+// TextExpr is a scalar Expr read from text, for use with libraries that
+// work with encoding.TextUnmarshaler, like flag.TextVar.
+//
+// Since an Expr belongs to a Context, a TextExpr holds the Context to create
+// it in. The text "null", "true" and "false", and numbers written like in
+// Nickel source (such as "-12" or "1.5e3"), are read as those values, and any
+// other text as a string. Writing a TextExpr out as text uses
+// Expr.MarshalText.
+type TextExpr struct {
+	// Context is the context that UnmarshalText evaluates in. If nil, the
+	// package-level default context is used (see DefaultContext).
+	Context *Context
+
+	// Expr is the expression read from text. It's nil until UnmarshalText
+	// succeeds.
+	Expr *Expr
+}
+
+var numberLiteral = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (t *TextExpr) UnmarshalText(text []byte) error {
+	ctx := t.Context
+	if ctx == nil {
+		ctx = DefaultContext()
+	}
+
+	src := string(text)
+	if src != "null" && src != "true" && src != "false" && !numberLiteral.MatchString(src) {
+		src = QuoteString(src)
+	}
+	expr, err := ctx.evalDeep(src, evalOptions{data: true})
+	if err != nil {
+		return err
+	}
+	t.Expr = expr
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler. A TextExpr without an Expr
+// is written as empty text.
+func (t TextExpr) MarshalText() ([]byte, error) {
+	if t.Expr == nil {
+		return []byte{}, nil
+	}
+	return t.Expr.MarshalText()
+}
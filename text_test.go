@@ -0,0 +1,86 @@
+package nickel
+
+import (
+	"encoding/csv"
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestMarshalText(t *testing.T) {
+	expr, err := EvalShallow(`[null, true, -3, 1 / 4, "a %{"b"}", 'Tag, 1 + 1, { a = 1 }, 'Some 1]`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	elems, _ := expr.ToArray()
+
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	var record []string
+	for _, elem := range elems[:7] {
+		text, err := elem.MarshalText()
+		if err != nil {
+			t.Fatalf("%v: marshal error: %v", elem, err)
+		}
+		record = append(record, string(text))
+	}
+	w.Write(record)
+	w.Flush()
+	if got := b.String(); got != "null,true,-3,0.25,a b,Tag,2\n" {
+		t.Errorf("unexpected CSV: %q", got)
+	}
+
+	for _, elem := range elems[7:] {
+		if _, err := elem.MarshalText(); err == nil {
+			t.Errorf("%v: expected an error", elem)
+		}
+	}
+}
+
+func TestTextExpr(t *testing.T) {
+	ctx := NewContext()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	port := &TextExpr{Context: ctx}
+	name := &TextExpr{Context: ctx}
+	fs.TextVar(port, "port", TextExpr{}, "")
+	fs.TextVar(name, "name", TextExpr{}, "")
+	if err := fs.Parse([]string{"-port", "8080", "-name", "web %{x}"}); err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if n, ok := port.Expr.ToInt64(); !ok || n != 8080 {
+		t.Errorf("unexpected port: %v", port.Expr)
+	}
+	if s, ok := name.Expr.ToString(); !ok || s != "web %{x}" {
+		t.Errorf("unexpected name: %v", name.Expr)
+	}
+
+	for text, expected := range map[string]string{
+		"null":     "null",
+		"false":    "false",
+		"1.5e3":    "1500",
+		"-0.125":   "-0.125",
+		"1.":       `"1."`,
+		"0x10":     `"0x10"`,
+		"":         `""`,
+		"'Tag":     `"'Tag"`,
+		"{ a = 1}": `"{ a = 1}"`,
+	} {
+		var value TextExpr
+		if err := value.UnmarshalText([]byte(text)); err != nil {
+			t.Fatalf("%q: unmarshal error: %v", text, err)
+		}
+		if got := value.Expr.String(); got != expected {
+			t.Errorf("%q: expected %s, got %s", text, expected, got)
+		}
+		if value.Expr.Kind() == KindString {
+			if out, _ := value.MarshalText(); string(out) != text {
+				t.Errorf("%q: marshaled back to %q", text, out)
+			}
+		}
+	}
+
+	var value TextExpr
+	if err := value.UnmarshalText([]byte("\xff")); err == nil {
+		t.Error("expected an error for invalid UTF-8")
+	}
+}
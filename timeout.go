@@ -0,0 +1,126 @@
+package nickel
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TimeoutError is returned by evaluations that take longer than the
+// Context's evaluation timeout (see SetEvalTimeout). It wraps
+// context.DeadlineExceeded.
+type TimeoutError struct {
+	// After is the timeout that expired.
+	After time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("evaluation timed out after %v", e.After)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// SetEvalTimeout bounds the time that evaluations take, or removes the bound
+// if d is zero or negative. An evaluation that takes longer than d returns a
+// *TimeoutError.
+//
+// The bound applies to the context's evaluation functions, and to
+// evaluating the Exprs they return further, with Expr.EvalShallow and the
+// like. It covers all the rounds of an evaluation that serves host requests
+// (see AllowFetch), and the time spent serving them. The time spent waiting
+// for other evaluations of the context to finish counts, and so does waiting
+// to serialize an Expr.
+//
+// The Nickel library can't interrupt an evaluation, so an evaluation that
+// timed out keeps running in the background, and keeps the context busy:
+// until it's done, the context's other evaluations and serializations wait,
+// and time out themselves. Settings can still be changed meanwhile. For
+// untrusted programs that may never finish, evaluate each one in a Context
+// of its own, and drop the Context after a timeout.
+func (ctx *Context) SetEvalTimeout(d time.Duration) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.evalTimeout = d
+}
+
+func (ctx *Context) timeout() time.Duration {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.evalTimeout
+}
+
+// lockNative takes the lock for calling into the Nickel library with ctx,
+// waiting at most for the context's evaluation timeout.
+func (ctx *Context) lockNative() error {
+	d := ctx.timeout()
+	if d <= 0 {
+		ctx.native <- struct{}{}
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case ctx.native <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return &TimeoutError{After: d}
+	}
+}
+
+func (ctx *Context) unlockNative() {
+	<-ctx.native
+}
+
+// runNative runs call, which calls into the Nickel library, with the native
+// lock held and within the context's evaluation timeout. If the timeout
+// expires first, runNative returns a *TimeoutError, and if call had started,
+// abandon is called with its result once it's done, to free it.
+func runNative[T any](ctx *Context, call func() T, abandon func(T)) (T, error) {
+	deadline, d := ctx.deadline()
+	return runNativeUntil(ctx, deadline, d, call, abandon)
+}
+
+// deadline returns the deadline of an evaluation starting now, or the zero
+// time if there's no timeout, and the timeout.
+func (ctx *Context) deadline() (time.Time, time.Duration) {
+	d := ctx.timeout()
+	if d <= 0 {
+		return time.Time{}, 0
+	}
+	return time.Now().Add(d), d
+}
+
+// runNativeUntil is runNative for a part of an evaluation that has to be
+// done by deadline (none if it's zero), which comes from the timeout d.
+func runNativeUntil[T any](ctx *Context, deadline time.Time, d time.Duration, call func() T, abandon func(T)) (T, error) {
+	var zero T
+	if deadline.IsZero() {
+		ctx.native <- struct{}{}
+		defer ctx.unlockNative()
+		return call(), nil
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case ctx.native <- struct{}{}:
+	case <-timer.C:
+		return zero, &TimeoutError{After: d}
+	}
+	done := make(chan T, 1)
+	go func() {
+		defer ctx.unlockNative()
+		done <- call()
+	}()
+	select {
+	case result := <-done:
+		return result, nil
+	case <-timer.C:
+		go func() {
+			abandon(<-done)
+		}()
+		return zero, &TimeoutError{After: d}
+	}
+}
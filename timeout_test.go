@@ -0,0 +1,65 @@
+package nickel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// slowProgram takes a few seconds to evaluate.
+const slowProgram = `std.array.fold_left (fun acc x => acc + x) 0 (std.array.generate (fun x => x) 3000000)`
+
+func TestEvalTimeout(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetEvalTimeout(50 * time.Millisecond)
+	start := time.Now()
+	_, err := ctx.EvalDeep(slowProgram)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the error to wrap context.DeadlineExceeded")
+	}
+	if timeoutErr.After != 50*time.Millisecond {
+		t.Errorf("expected the timeout in the error, got %v", timeoutErr.After)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("evaluation returned after %v", elapsed)
+	}
+
+	// The next evaluation waits for the abandoned one within its own
+	// timeout, and the settings can still be changed meanwhile.
+	ctx = NewContext()
+	ctx.SetEvalTimeout(50 * time.Millisecond)
+	if _, err := ctx.EvalDeep(`std.array.fold_left (fun acc x => acc + x) 0 (std.array.generate (fun x => x) 200000)`); !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+	start = time.Now()
+	if _, err := ctx.EvalDeep("1 + 1"); !errors.As(err, &timeoutErr) {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("evaluation returned after %v", elapsed)
+	}
+	ctx.SetEvalTimeout(time.Minute)
+	if expr, err := ctx.EvalDeep("1 + 1"); err != nil || expr.String() != "2" {
+		t.Errorf("expected 2 once the abandoned evaluation is done, got %v, %v", expr, err)
+	}
+
+	ctx = NewContext()
+	ctx.SetEvalTimeout(time.Minute)
+	expr, err := ctx.EvalShallow(`{ a = 1 + 1 }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	record, _ := expr.ToRecord()
+	a, err := record["a"].EvalShallow()
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got := a.String(); got != "2" {
+		t.Errorf("expected 2, got %s", got)
+	}
+}
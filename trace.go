@@ -0,0 +1,126 @@
+package nickel
+
+import (
+	"bytes"
+	"io"
+)
+
+// TraceFraming says how trace output is divided into writes to the writer
+// given to Context.SetTraceWriter.
+type TraceFraming int
+
+const (
+	// TraceChunks passes the output on as the Nickel library produces it,
+	// which is in several writes per call to std.trace.
+	TraceChunks TraceFraming = iota
+	// TraceLines buffers the output, and writes it one complete line at a
+	// time.
+	TraceLines
+	// TraceCalls buffers the output, and writes everything that one call
+	// to std.trace produces at once, even if the message spans several
+	// lines.
+	TraceCalls
+)
+
+// The start of the output of every call to std.trace, in a write of its own.
+const tracePrefix = "std.trace: "
+
+// SetTraceFraming sets how trace output is divided into writes. The default
+// is TraceChunks.
+//
+// With TraceLines or TraceCalls, each write holds a whole line or trace
+// message, so traces from evaluations in different Contexts that share a
+// writer (such as os.Stderr) don't interleave within a line. Buffered output
+// is written out at the end of every evaluation at the latest.
+func (ctx *Context) SetTraceFraming(framing TraceFraming) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.traceFraming = framing
+	ctx.installTracer()
+}
+
+// traceFramer buffers trace output for TraceLines and TraceCalls.
+type traceFramer struct {
+	framing TraceFraming
+	next    io.Writer
+	buf     []byte
+}
+
+func (f *traceFramer) Write(p []byte) (int, error) {
+	n := len(p)
+	if f.framing == TraceCalls {
+		// The previous call is over when the next one starts.
+		if bytes.HasPrefix(p, []byte(tracePrefix)) {
+			f.flush()
+		}
+		f.buf = append(f.buf, p...)
+		return n, nil
+	}
+
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			f.buf = append(f.buf, p...)
+			break
+		}
+		f.buf = append(f.buf, p[:i+1]...)
+		p = p[i+1:]
+		f.flush()
+	}
+	return n, nil
+}
+
+// flush writes out the buffered output.
+func (f *traceFramer) flush() {
+	if len(f.buf) > 0 {
+		f.next.Write(f.buf)
+		f.buf = f.buf[:0]
+	}
+}
+
+// traceRounds holds the round of evalWithHost that is running natively, so
+// that the trace output that it repeats from the previous rounds isn't
+// written again. It's separate from the Context, so that the tracer doesn't
+// keep the Context alive.
+type traceRounds struct {
+	round *hostRound
+}
+
+// start sets the round that is running, nil if none. The native lock must
+// be held.
+func (r *traceRounds) start(round *hostRound) {
+	r.round = round
+}
+
+// roundTracer drops the trace output that a round of evalWithHost repeats.
+// The rounds evaluate the same program, with more host values served, so
+// each one starts by writing what the previous one wrote.
+type roundTracer struct {
+	rounds *traceRounds
+	next   io.Writer
+}
+
+func (t *roundTracer) Write(p []byte) (int, error) {
+	n := len(p)
+	if round := t.rounds.round; round != nil {
+		skip := min(max(round.skipTrace-round.traced, 0), len(p))
+		round.traced += len(p)
+		p = p[skip:]
+	}
+	if len(p) > 0 {
+		t.next.Write(p)
+	}
+	return n, nil
+}
+
+// flushTrace writes out the trace output buffered by the context's
+// traceFramer, if it has one. The native lock must be held, so that no
+// evaluation is writing to it.
+func (ctx *Context) flushTrace() {
+	ctx.mu.Lock()
+	tracer := ctx.tracer
+	ctx.mu.Unlock()
+	if tracer != nil {
+		tracer.flush()
+	}
+}
@@ -0,0 +1,69 @@
+package nickel
+
+import (
+	"slices"
+	"testing"
+)
+
+// writeRecorder records the individual writes made to it.
+type writeRecorder struct {
+	writes []string
+}
+
+func (w *writeRecorder) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func TestTraceFraming(t *testing.T) {
+	const src = `std.trace "two\nlines" (std.trace "" (std.trace "plain" 1))`
+	cases := map[TraceFraming][]string{
+		TraceChunks: {"std.trace: ", "two\nlines", "\n", "std.trace: ", "\n", "std.trace: ", "plain", "\n"},
+		TraceLines:  {"std.trace: two\n", "lines\n", "std.trace: \n", "std.trace: plain\n"},
+		TraceCalls:  {"std.trace: two\nlines\n", "std.trace: \n", "std.trace: plain\n"},
+	}
+	for framing, expected := range cases {
+		var w writeRecorder
+		ctx := NewContext()
+		ctx.SetTraceWriter(&w)
+		ctx.SetTraceFraming(framing)
+		if _, err := ctx.EvalDeep(src); err != nil {
+			t.Fatalf("eval error: %v", err)
+		}
+		if !slices.Equal(w.writes, expected) {
+			t.Errorf("framing %d: expected %q, got %q", framing, expected, w.writes)
+		}
+	}
+}
+
+func TestTraceFramingLazy(t *testing.T) {
+	var w writeRecorder
+	ctx := NewContext()
+	ctx.SetTraceFraming(TraceCalls)
+	ctx.SetTraceWriter(&w)
+	ctx.SetHostAudit(func(HostAccess) {})
+	ctx.SetTraceValueHandler(func(string, *Expr) {})
+	ctx.AllowEnv("HOME")
+
+	// The results of a shallow evaluation can only use the host values that
+	// the evaluation used.
+	expr, err := ctx.EvalShallow(`let home = host.env "HOME" in std.seq home { a = std.trace "first\nmessage" home, b = std.trace "second" 2 }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	record, _ := expr.ToRecord()
+	if _, err := record["a"].EvalShallow(); err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	// The message is written by the end of the evaluation that traced it,
+	// without waiting for the next one.
+	if expected := []string{"std.trace: first\nmessage\n"}; !slices.Equal(w.writes, expected) {
+		t.Fatalf("expected %q, got %q", expected, w.writes)
+	}
+	if _, err := record["b"].EvalShallow(); err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if expected := []string{"std.trace: first\nmessage\n", "std.trace: second\n"}; !slices.Equal(w.writes, expected) {
+		t.Fatalf("expected %q, got %q", expected, w.writes)
+	}
+}
@@ -0,0 +1,417 @@
+package nickel
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The C API can't build values, so the transformations below write Nickel
+// source for their result (see writeSource) and evaluate it. The result is
+// always evaluated deeply, and has the limitations described on
+// Expr.EvalDeep: record field metadata is lost, and functions can't be
+// part of it.
+
+// MapFields returns a record with the same fields as expr, whose values are
+// given by fn. Returning a nil *Expr from fn leaves the field out. Fields
+// without a value are left out without calling fn.
+//
+// If expr hasn't been evaluated yet, it is evaluated shallowly, and the
+// values passed to fn may not have been evaluated yet either.
+func (expr *Expr) MapFields(fn func(name string, value *Expr) (*Expr, error)) (*Expr, error) {
+	record, err := expr.force()
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := record.ToRecord()
+	if !ok {
+		return nil, &KindError{Want: KindRecord, Got: record.kind}
+	}
+
+	var b strings.Builder
+	b.WriteString("{")
+	for _, name := range sortedKeys(fields) {
+		if fields[name] == nil {
+			continue
+		}
+		value, err := fn(name, fields[name])
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			continue
+		}
+		b.WriteString(" " + QuoteString(name) + " = ")
+		if err := writeSource(&b, value); err != nil {
+			return nil, fmt.Errorf("field %s: %w", FormatPath([]string{name}), err)
+		}
+		b.WriteString(",")
+	}
+	b.WriteString(" }")
+	return expr.ctx.evalDeep(b.String(), evalOptions{data: true})
+}
+
+// MapElements returns an array whose elements are given by calling fn on
+// the elements of expr, along with their index. Returning a nil *Expr from
+// fn leaves the element out.
+//
+// If expr hasn't been evaluated yet, it is evaluated shallowly, and the
+// values passed to fn may not have been evaluated yet either.
+func (expr *Expr) MapElements(fn func(index int, value *Expr) (*Expr, error)) (*Expr, error) {
+	array, err := expr.force()
+	if err != nil {
+		return nil, err
+	}
+	elems, ok := array.ToArray()
+	if !ok {
+		return nil, &KindError{Want: KindArray, Got: array.kind}
+	}
+
+	var b strings.Builder
+	b.WriteString("[")
+	for i, elem := range elems {
+		value, err := fn(i, elem)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			continue
+		}
+		if err := writeSource(&b, value); err != nil {
+			return nil, fmt.Errorf("element %d: %w", i, err)
+		}
+		b.WriteString(", ")
+	}
+	b.WriteString("]")
+	return expr.ctx.evalDeep(b.String(), evalOptions{data: true})
+}
+
+// SetPath returns a copy of expr in which value is merged into the value at
+// path (see ParsePath). The path can go through record fields, which are
+// created if they don't exist, and array elements, given by their index.
+//
+// A record value is merged field by field into a record at path, recursively,
+// and the fields it sets replace the existing ones, as with a merge at force
+// priority. Any other value replaces the one at path.
+//
+// The value can be an *Expr, or anything that encoding/json can marshal.
+//
+// Like the other transformations, this isn't done natively: expr is written
+// back as source, so all of it is evaluated, and it can't contain functions.
+func (expr *Expr) SetPath(path string, value any) (*Expr, error) {
+	fields, quoted, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	valueSrc, err := valueSource(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	b.WriteString(mergeSource)
+	if err := writeSourceWith(&b, expr, nil, fields, quoted, valueSrc); err != nil {
+		return nil, err
+	}
+	return expr.ctx.evalDeep(b.String(), evalOptions{data: true})
+}
+
+// mergeSource defines the merge used by SetPath. Nickel's own merge can't
+// be used: it fails on fields that both records define with the same
+// priority, and a field annotated with force replaces a whole record.
+const mergeSource = "let rec merge = fun old new => " +
+	"if std.is_record old && std.is_record new && !(std.record.is_empty new) then " +
+	"std.array.fold_left (fun acc name => std.record.update name " +
+	"(if std.record.has_field name acc then merge acc.\"%{name}\" new.\"%{name}\" else new.\"%{name}\") acc) " +
+	"old (std.record.fields new) " +
+	"else new in "
+
+// valueSource returns Nickel source for a value given to SetPath.
+func valueSource(value any) (string, error) {
+	if expr, ok := value.(*Expr); ok {
+		var b strings.Builder
+		if err := writeSource(&b, expr); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return "(std.deserialize 'Json " + QuoteString(string(data)) + ")", nil
+}
+
+// writeSourceWith is like writeSource, but merges the source in valueSrc
+// into the value at the path rest (relative to expr, which is at the path
+// done). quoted tells which names of rest were quoted.
+func writeSourceWith(b *strings.Builder, expr *Expr, done []string, rest []string, quoted []bool, valueSrc string) error {
+	// A missing field gets a new record, but a missing array can't be
+	// created.
+	if expr == nil {
+		for i, name := range rest {
+			if _, err := strconv.Atoi(name); err == nil && !quoted[i] {
+				return fmt.Errorf("%w: %s is not an array", ErrFieldNotFound, FormatPath(append(done, rest[:i]...)))
+			}
+		}
+		for _, name := range rest {
+			b.WriteString("{ " + QuoteString(name) + " = ")
+		}
+		b.WriteString(valueSrc)
+		b.WriteString(strings.Repeat(" }", len(rest)))
+		return nil
+	}
+
+	if len(rest) == 0 {
+		b.WriteString("(merge ")
+		if err := writeSource(b, expr); err != nil {
+			return fmt.Errorf("%s: %w", FormatPath(done), err)
+		}
+		b.WriteString(" " + valueSrc + ")")
+		return nil
+	}
+
+	expr, err := expr.force()
+	if err != nil {
+		return fmt.Errorf("%s: %w", FormatPath(done), err)
+	}
+	path := append(done, rest[0])
+
+	switch expr.kind {
+	case KindRecord:
+		fields, _ := expr.ToRecord()
+		if fields[rest[0]] == nil {
+			// This also replaces a field without a value.
+			fields[rest[0]] = nil
+		}
+		b.WriteString("{")
+		for _, name := range sortedKeys(fields) {
+			value := fields[name]
+			if value == nil && name != rest[0] {
+				continue
+			}
+			b.WriteString(" " + QuoteString(name) + " = ")
+			if name == rest[0] {
+				err = writeSourceWith(b, value, path, rest[1:], quoted[1:], valueSrc)
+			} else {
+				err = writeSource(b, value)
+			}
+			if err != nil {
+				return err
+			}
+			b.WriteString(",")
+		}
+		b.WriteString(" }")
+		return nil
+	case KindArray:
+		elems, _ := expr.ToArray()
+		index, err := strconv.Atoi(rest[0])
+		if err != nil || index < 0 || index >= len(elems) {
+			return fmt.Errorf("%w: %s is not an index of an array of length %d", ErrOutOfRange, FormatPath(path), len(elems))
+		}
+		b.WriteString("[")
+		for i, elem := range elems {
+			if i == index {
+				err = writeSourceWith(b, elem, path, rest[1:], quoted[1:], valueSrc)
+			} else {
+				err = writeSource(b, elem)
+			}
+			if err != nil {
+				return err
+			}
+			b.WriteString(", ")
+		}
+		b.WriteString("]")
+		return nil
+	default:
+		return fmt.Errorf("%s: %w", FormatPath(done), &KindError{Want: KindRecord, Got: expr.kind})
+	}
+}
+
+// Without returns a copy of expr without the values at the given paths.
+// Paths are patterns like those of ExportOptions.Exclude: they can select
+// array elements by their index, and `*` matches any field or element.
+// Paths that don't match anything are ignored.
+//
+// The removed values aren't evaluated, so this can also remove fields that
+// would fail to evaluate from the result of a shallow evaluation. This isn't
+// done natively, though: the values that are kept are written back as
+// source, so they are all evaluated, and they can't contain functions.
+func (expr *Expr) Without(paths ...string) (*Expr, error) {
+	patterns, err := parsePatterns(paths)
+	if err != nil {
+		return nil, err
+	}
+	for i, pattern := range patterns {
+		if len(pattern) == 0 {
+			return nil, fmt.Errorf("invalid path %q: can't remove the whole value", paths[i])
+		}
+	}
+
+	var b strings.Builder
+	if err := writeSourceWithout(&b, expr, nil, patterns); err != nil {
+		return nil, err
+	}
+	return expr.ctx.evalDeep(b.String(), evalOptions{data: true})
+}
+
+// writeSourceWithout is like writeSource, but leaves out the values matching
+// the patterns (relative to expr, which is at the path done).
+func writeSourceWithout(b *strings.Builder, expr *Expr, done []string, patterns [][]string) error {
+	if len(patterns) == 0 {
+		return writeSource(b, expr)
+	}
+
+	expr, err := expr.force()
+	if err != nil {
+		return fmt.Errorf("%s: %w", FormatPath(done), err)
+	}
+
+	switch expr.kind {
+	case KindRecord:
+		fields, _ := expr.ToRecord()
+		b.WriteString("{")
+		for _, name := range sortedKeys(fields) {
+			rest, removed := advance(patterns, name)
+			if removed || fields[name] == nil {
+				continue
+			}
+			b.WriteString(" " + QuoteString(name) + " = ")
+			if err := writeSourceWithout(b, fields[name], append(done, name), rest); err != nil {
+				return err
+			}
+			b.WriteString(",")
+		}
+		b.WriteString(" }")
+		return nil
+	case KindArray:
+		elems, _ := expr.ToArray()
+		b.WriteString("[")
+		for i, elem := range elems {
+			index := strconv.Itoa(i)
+			rest, removed := advance(patterns, index)
+			if removed {
+				continue
+			}
+			if err := writeSourceWithout(b, elem, append(done, index), rest); err != nil {
+				return err
+			}
+			b.WriteString(", ")
+		}
+		b.WriteString("]")
+		return nil
+	default:
+		return writeSource(b, expr)
+	}
+}
+
+// Pick returns a record with only the given fields of expr. It's an error
+// for one of them to be missing (see ErrFieldNotFound).
+//
+// Only the picked fields are evaluated, but all of them are: like Without,
+// this isn't done natively, and the picked fields are written back as source,
+// so they can't contain functions either.
+func (expr *Expr) Pick(fields ...string) (*Expr, error) {
+	return expr.pick(fields, false)
+}
+
+// PickExisting is like Pick, but leaves out the fields that expr doesn't
+// have instead of failing.
+func (expr *Expr) PickExisting(fields ...string) (*Expr, error) {
+	return expr.pick(fields, true)
+}
+
+func (expr *Expr) pick(names []string, skipMissing bool) (*Expr, error) {
+	record, err := expr.force()
+	if err != nil {
+		return nil, err
+	}
+	if record.kind != KindRecord {
+		return nil, &KindError{Want: KindRecord, Got: record.kind}
+	}
+
+	var b strings.Builder
+	b.WriteString("{")
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		value := record.field(name)
+		if value == nil {
+			if skipMissing {
+				continue
+			}
+			return nil, fmt.Errorf("%w: %s", ErrFieldNotFound, FormatPath([]string{name}))
+		}
+		b.WriteString(" " + QuoteString(name) + " = ")
+		if err := writeSource(&b, value); err != nil {
+			return nil, fmt.Errorf("%s: %w", FormatPath([]string{name}), err)
+		}
+		b.WriteString(",")
+	}
+	b.WriteString(" }")
+	return expr.ctx.evalDeep(b.String(), evalOptions{data: true})
+}
+
+// Append returns an array with the elements of expr followed by items.
+//
+// This isn't done natively: the elements are written back as source and
+// evaluated again, so all of them are evaluated, including the lazy ones,
+// and they can't contain functions. The same goes for Concat and Filter.
+func (expr *Expr) Append(items ...*Expr) (*Expr, error) {
+	return expr.concat(items)
+}
+
+// Concat returns an array with the elements of expr followed by those of
+// other, which must also be an array.
+func (expr *Expr) Concat(other *Expr) (*Expr, error) {
+	array, err := other.force()
+	if err != nil {
+		return nil, err
+	}
+	elems, ok := array.ToArray()
+	if !ok {
+		return nil, &KindError{Want: KindArray, Got: array.kind}
+	}
+	return expr.concat(elems)
+}
+
+func (expr *Expr) concat(items []*Expr) (*Expr, error) {
+	array, err := expr.force()
+	if err != nil {
+		return nil, err
+	}
+	elems, ok := array.ToArray()
+	if !ok {
+		return nil, &KindError{Want: KindArray, Got: array.kind}
+	}
+
+	var b strings.Builder
+	b.WriteString("[")
+	for i, elem := range append(elems, items...) {
+		if err := writeSource(&b, elem); err != nil {
+			return nil, fmt.Errorf("element %d: %w", i, err)
+		}
+		b.WriteString(", ")
+	}
+	b.WriteString("]")
+	return expr.ctx.evalDeep(b.String(), evalOptions{data: true})
+}
+
+// Filter returns an array with the elements of expr for which pred returns
+// true. The elements that are kept are evaluated completely (see Append);
+// the others are only evaluated if pred evaluates them.
+func (expr *Expr) Filter(pred func(value *Expr) (bool, error)) (*Expr, error) {
+	return expr.MapElements(func(_ int, value *Expr) (*Expr, error) {
+		keep, err := pred(value)
+		if err != nil || !keep {
+			return nil, err
+		}
+		return value, nil
+	})
+}
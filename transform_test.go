@@ -0,0 +1,227 @@
+package nickel
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMapFields(t *testing.T) {
+	ctx := NewContext()
+	expr, err := ctx.EvalShallow(`{ host = "${HOST}", port = 80, debug = true, nested = { x = "${HOST}" } }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	resolved, err := expr.MapFields(func(name string, value *Expr) (*Expr, error) {
+		if name == "debug" {
+			return nil, nil
+		}
+		value, err := value.EvalDeep()
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := value.ToString(); ok {
+			return ctx.EvalDeep(QuoteString(strings.ReplaceAll(s, "${HOST}", "example.com")))
+		}
+		return value, nil
+	})
+	if err != nil {
+		t.Fatalf("map error: %v", err)
+	}
+	if got := resolved.String(); got != `{ host = "example.com", nested = { x = "${HOST}" }, port = 80 }` {
+		t.Errorf("unexpected result: %s", got)
+	}
+
+	if _, err := resolved.MapElements(nil); !errors.As(err, new(*KindError)) {
+		t.Errorf("expected a kind error, got %v", err)
+	}
+	failure := errors.New("failure")
+	if _, err := expr.MapFields(func(string, *Expr) (*Expr, error) { return nil, failure }); !errors.Is(err, failure) {
+		t.Errorf("expected the callback error, got %v", err)
+	}
+}
+
+func TestMapElements(t *testing.T) {
+	expr, err := EvalDeep(`[1, 2, 3, 4]`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	doubled, err := expr.MapElements(func(i int, value *Expr) (*Expr, error) {
+		if i == 0 {
+			return nil, nil
+		}
+		n, _ := value.ToInt64()
+		return EvalDeep(fmt.Sprint(n * 2))
+	})
+	if err != nil {
+		t.Fatalf("map error: %v", err)
+	}
+	if got := doubled.String(); got != "[4, 6, 8]" {
+		t.Errorf("unexpected result: %s", got)
+	}
+
+	fn, err := EvalShallow(`[fun x => x]`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if _, err := fn.MapElements(func(i int, value *Expr) (*Expr, error) { return value, nil }); err == nil {
+		t.Error("expected an error for a function")
+	}
+}
+
+func TestSetPath(t *testing.T) {
+	expr, err := EvalShallow(`{ server = { port = 80, tls = { enabled = false } }, hosts = ["a", "b"], "x.y" = 1 }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	tests := []struct {
+		path  string
+		value any
+		want  string
+	}{
+		{"server.port", 8080, `{ hosts = ["a", "b"], server = { port = 8080, tls = { enabled = false } }, "x.y" = 1 }`},
+		{"server.tls", map[string]any{"cert": "c.pem"}, `{ hosts = ["a", "b"], server = { port = 80, tls = { cert = "c.pem", enabled = false } }, "x.y" = 1 }`},
+		{"server", map[string]any{"port": 8080, "tls": map[string]any{"enabled": true}}, `{ hosts = ["a", "b"], server = { port = 8080, tls = { enabled = true } }, "x.y" = 1 }`},
+		{"server.port", map[string]any{"number": 8080}, `{ hosts = ["a", "b"], server = { port = { number = 8080 }, tls = { enabled = false } }, "x.y" = 1 }`},
+		{"hosts.1", "c", `{ hosts = ["a", "c"], server = { port = 80, tls = { enabled = false } }, "x.y" = 1 }`},
+		{`"x.y"`, nil, `{ hosts = ["a", "b"], server = { port = 80, tls = { enabled = false } }, "x.y" = null }`},
+		{`new."0"`, 1, `{ hosts = ["a", "b"], new = { "0" = 1 }, server = { port = 80, tls = { enabled = false } }, "x.y" = 1 }`},
+		{"new.deep.field", true, `{ hosts = ["a", "b"], new = { deep = { field = true } }, server = { port = 80, tls = { enabled = false } }, "x.y" = 1 }`},
+	}
+	for _, test := range tests {
+		got, err := expr.SetPath(test.path, test.value)
+		if err != nil {
+			t.Errorf("%s: set error: %v", test.path, err)
+			continue
+		}
+		if got.String() != test.want {
+			t.Errorf("%s: got %s, want %s", test.path, got, test.want)
+		}
+	}
+
+	replacement, err := EvalDeep(`'Replaced`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if got, err := expr.SetPath("server", replacement); err != nil || got.String() != `{ hosts = ["a", "b"], server = 'Replaced, "x.y" = 1 }` {
+		t.Errorf("unexpected result: %v (%v)", got, err)
+	}
+
+	if _, err := expr.SetPath("hosts.2", 1); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("expected an out of range error, got %v", err)
+	}
+	if _, err := expr.SetPath("new.0", 1); !errors.Is(err, ErrFieldNotFound) {
+		t.Errorf("expected a field not found error, got %v", err)
+	}
+	if _, err := expr.SetPath("server.port.x", 1); !errors.As(err, new(*KindError)) {
+		t.Errorf("expected a kind error, got %v", err)
+	}
+}
+
+func TestWithout(t *testing.T) {
+	expr, err := EvalShallow(`{
+		name = "x",
+		internal = std.fail_with "internal",
+		services = [{ name = "a", computed = 1 }, { name = "b", computed = 2 }, { name = "c" }],
+	}`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	pruned, err := expr.Without("internal", "services.*.computed", "services.1", "missing.field")
+	if err != nil {
+		t.Fatalf("prune error: %v", err)
+	}
+	if got := pruned.String(); got != `{ name = "x", services = [{ name = "a" }, { name = "c" }] }` {
+		t.Errorf("unexpected result: %s", got)
+	}
+
+	if _, err := expr.Without("services.*.computed"); err == nil {
+		t.Error("expected an error for a field that fails to evaluate")
+	}
+	if _, err := expr.Without(""); err == nil {
+		t.Error("expected an error for an empty path")
+	}
+}
+
+func TestPick(t *testing.T) {
+	expr, err := EvalShallow(`{ name = "x", port = 80, broken = std.fail_with "broken", "a.b" = 1 }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	picked, err := expr.Pick("port", "name", "a.b", "port")
+	if err != nil {
+		t.Fatalf("pick error: %v", err)
+	}
+	if got := picked.String(); got != `{ "a.b" = 1, name = "x", port = 80 }` {
+		t.Errorf("unexpected result: %s", got)
+	}
+
+	if _, err := expr.Pick("name", "missing"); !errors.Is(err, ErrFieldNotFound) {
+		t.Errorf("expected a missing field error, got %v", err)
+	}
+	if picked, err := expr.PickExisting("name", "missing"); err != nil || picked.String() != `{ name = "x" }` {
+		t.Errorf("unexpected result: %v (%v)", picked, err)
+	}
+	if _, err := expr.Pick("broken"); err == nil {
+		t.Error("expected an error for a field that fails to evaluate")
+	}
+}
+
+func TestArrayTransforms(t *testing.T) {
+	expr, err := EvalShallow(`[1, 2, 1 + 2, std.fail_with "broken"]`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	tail, err := EvalDeep(`["a", { b = null }]`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	// The failing element is dropped without being evaluated.
+	small, err := expr.Filter(func(value *Expr) (bool, error) {
+		if value.Kind() == KindThunk {
+			return false, nil
+		}
+		n, _ := value.ToInt64()
+		return n < 2, nil
+	})
+	if err != nil {
+		t.Fatalf("filter error: %v", err)
+	}
+	if got := small.String(); got != "[1]" {
+		t.Errorf("unexpected filter result: %s", got)
+	}
+
+	appended, err := small.Append(tail, small)
+	if err != nil {
+		t.Fatalf("append error: %v", err)
+	}
+	if got := appended.String(); got != `[1, ["a", { b = null }], [1]]` {
+		t.Errorf("unexpected append result: %s", got)
+	}
+
+	concatenated, err := small.Concat(tail)
+	if err != nil {
+		t.Fatalf("concat error: %v", err)
+	}
+	if got := concatenated.String(); got != `[1, "a", { b = null }]` {
+		t.Errorf("unexpected concat result: %s", got)
+	}
+
+	if _, err := expr.Append(small); err == nil {
+		t.Error("expected an error for an element that fails to evaluate")
+	}
+	record, err := EvalDeep("{}")
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	if _, err := small.Concat(record); !errors.As(err, new(*KindError)) {
+		t.Errorf("expected a kind error, got %v", err)
+	}
+}
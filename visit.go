@@ -0,0 +1,62 @@
+package nickel
+
+// Visitor has a method for each kind of Nickel value.
+//
+// Expr.Visit calls exactly one of these methods, depending on the kind of
+// the expression. Since an implementation of Visitor has to provide all of
+// them, the compiler checks that every kind of value is handled.
+type Visitor interface {
+	VisitNull() error
+	VisitBool(b bool) error
+	// VisitNumber receives the number expression itself, so that the visitor
+	// can choose how to convert it (see Expr.ToInt64 and Expr.ToFloat64).
+	VisitNumber(num *Expr) error
+	VisitString(s string) error
+	VisitEnumTag(tag string) error
+	// VisitEnumVariant receives the payload of the variant, which may not
+	// have been evaluated yet.
+	VisitEnumVariant(tag string, payload *Expr) error
+	// VisitRecord receives the fields of the record, as returned by
+	// Expr.ToRecord.
+	VisitRecord(fields map[string]*Expr) error
+	// VisitArray receives the elements of the array, as returned by
+	// Expr.ToArray.
+	VisitArray(elems []*Expr) error
+	// VisitThunk is called for expressions that are not values, either
+	// because they haven't been evaluated yet (KindThunk) or because they
+	// are functions (KindFunction).
+	VisitThunk(expr *Expr) error
+}
+
+// Visit calls the method of v corresponding to the kind of expr, and returns
+// its result.
+//
+// Visit doesn't recurse: it is up to the visitor to call Visit on the
+// contents of records, arrays, and enum variants if it wants to.
+func (expr *Expr) Visit(v Visitor) error {
+	switch expr.kind {
+	case KindNull:
+		return v.VisitNull()
+	case KindBool:
+		return v.VisitBool(expr.b)
+	case KindNumber:
+		return v.VisitNumber(expr)
+	case KindString:
+		s, _ := expr.ToString()
+		return v.VisitString(s)
+	case KindEnumTag:
+		tag, _ := expr.ToEnumTag()
+		return v.VisitEnumTag(tag)
+	case KindEnumVariant:
+		tag, payload, _ := expr.ToEnumVariant()
+		return v.VisitEnumVariant(tag, payload)
+	case KindRecord:
+		fields, _ := expr.ToRecord()
+		return v.VisitRecord(fields)
+	case KindArray:
+		elems, _ := expr.ToArray()
+		return v.VisitArray(elems)
+	default:
+		return v.VisitThunk(expr)
+	}
+}
@@ -0,0 +1,97 @@
+package nickel
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// WalkAction tells Walk how to continue after visiting a value.
+type WalkAction int
+
+const (
+	// WalkContinue goes on with the contents of the value, and then the
+	// rest of the walk.
+	WalkContinue WalkAction = iota
+	// WalkSkip goes on with the rest of the walk, without the contents of
+	// the value.
+	WalkSkip
+	// WalkStop ends the walk.
+	WalkStop
+	// WalkForce evaluates the value shallowly if it hasn't been evaluated
+	// yet, and visits its evaluated form, with the same path. For values
+	// that have been evaluated, it's the same as WalkContinue.
+	WalkForce
+)
+
+// WalkFunc is called by Walk for each value that it visits.
+//
+// The path is the sequence of record field names and array indices (in
+// decimal) leading to the value from the root of the walk, as accepted by
+// FormatPath. The function may keep it.
+type WalkFunc func(path []string, value *Expr) (WalkAction, error)
+
+// Walk calls fn on expr and everything that it contains, depth-first: record
+// fields (in order of their names, and leaving out fields without a value),
+// array elements, and the payloads of enum variants, which are visited with
+// the same path as the variant itself.
+//
+// Nothing is evaluated unless fn asks for it with WalkForce, so on the
+// result of a shallow evaluation the walk stops at the values that haven't
+// been evaluated yet. If fn returns an error, or forcing a value fails, the
+// walk stops and Walk returns the error.
+func Walk(expr *Expr, fn WalkFunc) error {
+	_, err := walk(nil, expr, fn)
+	return err
+}
+
+// walk implements Walk, returning false if the walk was stopped.
+func walk(path []string, expr *Expr, fn WalkFunc) (bool, error) {
+	action, err := fn(slices.Clone(path), expr)
+	if err != nil {
+		return false, err
+	}
+
+	switch action {
+	case WalkSkip:
+		return true, nil
+	case WalkStop:
+		return false, nil
+	case WalkForce:
+		if expr.kind == KindThunk {
+			forced, err := expr.EvalShallow()
+			if err != nil {
+				return false, fmt.Errorf("%s: %w", FormatPath(path), err)
+			}
+			// There's nothing more to see in functions.
+			if forced.kind == KindFunction {
+				return true, nil
+			}
+			return walk(path, forced, fn)
+		}
+	}
+
+	switch expr.kind {
+	case KindEnumVariant:
+		_, payload, _ := expr.ToEnumVariant()
+		return walk(path, payload, fn)
+	case KindRecord:
+		fields, _ := expr.ToRecord()
+		for _, name := range sortedKeys(fields) {
+			if fields[name] == nil {
+				continue
+			}
+			if ok, err := walk(append(path, name), fields[name], fn); !ok || err != nil {
+				return ok, err
+			}
+		}
+	case KindArray:
+		elems, _ := expr.ToArray()
+		for i, elem := range elems {
+			if ok, err := walk(append(path, strconv.Itoa(i)), elem, fn); !ok || err != nil {
+				return ok, err
+			}
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,85 @@
+package nickel
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	expr, err := EvalShallow(`{
+		db = { password = "hunter2", hosts = ["a", "b"] },
+		mode = 'Strict { level = 1 },
+		skipped = { password = "x" },
+		lazy = { password = 1 + 1 },
+	}`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+
+	var visited []string
+	err = Walk(expr, func(path []string, value *Expr) (WalkAction, error) {
+		visited = append(visited, FormatPath(path)+":"+value.Kind().String())
+		if slices.Equal(path, []string{"skipped"}) {
+			return WalkSkip, nil
+		}
+		return WalkForce, nil
+	})
+	if err != nil {
+		t.Fatalf("walk error: %v", err)
+	}
+	want := []string{
+		":record",
+		"db:thunk", "db:record",
+		"db.hosts:thunk", "db.hosts:array",
+		"db.hosts.0:thunk", "db.hosts.0:string",
+		"db.hosts.1:thunk", "db.hosts.1:string",
+		"db.password:thunk", "db.password:string",
+		"lazy:thunk", "lazy:record",
+		"lazy.password:thunk", "lazy.password:number",
+		"mode:thunk", "mode:enum variant",
+		"mode:thunk", "mode:record",
+		"mode.level:number",
+		"skipped:thunk",
+	}
+	if !slices.Equal(visited, want) {
+		t.Errorf("unexpected walk:\n%s", strings.Join(visited, "\n"))
+	}
+
+	// Without forcing, the walk stops at unevaluated values.
+	visited = nil
+	Walk(expr, func(path []string, value *Expr) (WalkAction, error) {
+		visited = append(visited, FormatPath(path))
+		return WalkContinue, nil
+	})
+	if !slices.Equal(visited, []string{"", "db", "lazy", "mode", "skipped"}) {
+		t.Errorf("unexpected walk: %v", visited)
+	}
+
+	visited = nil
+	Walk(expr, func(path []string, value *Expr) (WalkAction, error) {
+		visited = append(visited, FormatPath(path))
+		if len(path) > 0 {
+			return WalkStop, nil
+		}
+		return WalkContinue, nil
+	})
+	if !slices.Equal(visited, []string{"", "db"}) {
+		t.Errorf("unexpected walk: %v", visited)
+	}
+
+	failure := errors.New("failure")
+	if err := Walk(expr, func([]string, *Expr) (WalkAction, error) { return WalkContinue, failure }); !errors.Is(err, failure) {
+		t.Errorf("expected the callback error, got %v", err)
+	}
+
+	broken, err := EvalShallow(`{ a = { b = std.fail_with "broken" } }`)
+	if err != nil {
+		t.Fatalf("eval error: %v", err)
+	}
+	err = Walk(broken, func([]string, *Expr) (WalkAction, error) { return WalkForce, nil })
+	if err == nil || !strings.HasPrefix(err.Error(), "a.b: ") {
+		t.Errorf("expected an evaluation error at a.b, got %v", err)
+	}
+}